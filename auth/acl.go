@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+type aclRule struct {
+	allow   bool
+	network *net.IPNet
+	pattern string
+}
+
+// ACL is a list of allow/deny rules on the addresses of the clients, and the streams they access.
+// The rules are evaluated in the order they were added, the first one matching the client's address
+// and the stream decides; without any matching rule, the default applies (allow, unless changed).
+// It's safe for concurrent use.
+type ACL struct {
+	mutex        sync.RWMutex
+	rules        []aclRule
+	defaultAllow bool
+}
+
+// NewACL returns a new, empty ACL which allows everything
+func NewACL() *ACL {
+	return &ACL{defaultAllow: true}
+}
+
+// Allow adds a rule allowing the clients of the network (a CIDR like "10.0.0.0/8", or a single address)
+// to access the streams whose names match the pattern, which has the syntax of path.Match ("*" matches every stream)
+func (a *ACL) Allow(cidr, streamPattern string) error {
+	return a.addRule(true, cidr, streamPattern)
+}
+
+// Deny adds a rule denying the clients of the network to access the streams matching the pattern
+func (a *ACL) Deny(cidr, streamPattern string) error {
+	return a.addRule(false, cidr, streamPattern)
+}
+
+// SetDefault sets whether the clients which don't match any rule are allowed
+func (a *ACL) SetDefault(allow bool) {
+	a.mutex.Lock()
+	a.defaultAllow = allow
+	a.mutex.Unlock()
+}
+
+// Reset removes all the rules
+func (a *ACL) Reset() {
+	a.mutex.Lock()
+	a.rules = nil
+	a.mutex.Unlock()
+}
+
+func (a *ACL) addRule(allow bool, cidr, streamPattern string) error {
+	network, err := parseNetwork(cidr)
+	if err != nil {
+		return err
+	}
+	if streamPattern == "" {
+		streamPattern = "*"
+	}
+
+	a.mutex.Lock()
+	a.rules = append(a.rules, aclRule{allow: allow, network: network, pattern: streamPattern})
+	a.mutex.Unlock()
+	return nil
+}
+
+// CheckAddress is evaluated when a client connects, before we know which streams it wants:
+// it's refused only if the first rule matching its address denies every stream,
+// or if no rule could ever allow it
+func (a *ACL) CheckAddress(ip net.IP) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	for _, r := range a.rules {
+		if !r.network.Contains(ip) {
+			continue
+		}
+		if r.pattern == "*" {
+			return r.allow
+		}
+		if r.allow {
+			// (it may access some streams at least)
+			return true
+		}
+	}
+	return a.defaultAllow
+}
+
+// Check reports whether the client may access the stream
+func (a *ACL) Check(ip net.IP, streamName string) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	streamName = strings.Trim(streamName, "/")
+	for _, r := range a.rules {
+		if r.network.Contains(ip) && matchStream(r.pattern, streamName) {
+			return r.allow
+		}
+	}
+	return a.defaultAllow
+}
+
+// parseNetwork parses a CIDR, or a single IPv4/IPv6 address
+func parseNetwork(cidr string) (*net.IPNet, error) {
+	if strings.Contains(cidr, "/") {
+		_, network, err := net.ParseCIDR(cidr)
+		return network, err
+	}
+
+	ip := net.ParseIP(cidr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address: %s", cidr)
+	}
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
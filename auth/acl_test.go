@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"net"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	acl := NewACL()
+	acl.Deny("10.1.0.0/16", "*")
+	acl.Allow("10.0.0.0/8", "live/*")
+	acl.Allow("192.168.1.10", "*")
+	acl.Allow("::1", "*")
+	acl.SetDefault(false)
+	if err := acl.Allow("10.0.0.0/33", "*"); err == nil {
+		t.Error("failed")
+		return
+	}
+
+	cases := []struct {
+		ip         string
+		streamName string
+		connect    bool
+		allowed    bool
+	}{
+		{"10.1.2.3", "live/cam1", false, false},
+		{"10.2.3.4", "live/cam1", true, true},
+		{"10.2.3.4", "test.264", true, false},
+		{"192.168.1.10", "test.264", true, true},
+		{"192.168.1.11", "test.264", false, false},
+		{"::1", "/live/cam1/", true, true},
+	}
+	for _, c := range cases {
+		ip := net.ParseIP(c.ip)
+		if acl.CheckAddress(ip) != c.connect || acl.Check(ip, c.streamName) != c.allowed {
+			t.Errorf("failed: %s %s", c.ip, c.streamName)
+			return
+		}
+	}
+	t.Log("success")
+}
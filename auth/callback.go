@@ -0,0 +1,49 @@
+package auth
+
+// LookupFunc returns the password of username, ok is false if the user is unknown
+type LookupFunc func(username string) (password string, ok bool)
+
+// AuthorizeFunc reports whether the user may access the stream
+type AuthorizeFunc func(username, streamName string, permission Permission) bool
+
+// CallbackAuthenticator is an Authenticator which delegates to the functions of the application,
+// e.g. to integrate its own user store. The functions are called concurrently.
+type CallbackAuthenticator struct {
+	realm     string
+	lookup    LookupFunc
+	authorize AuthorizeFunc
+}
+
+// NewCallbackAuthenticator returns a new CallbackAuthenticator,
+// a nil authorize allows every authenticated user to access every stream
+func NewCallbackAuthenticator(realm string, lookup LookupFunc, authorize AuthorizeFunc) *CallbackAuthenticator {
+	if realm == "" {
+		realm = "dorsvr streaming server"
+	}
+	return &CallbackAuthenticator{
+		realm:     realm,
+		lookup:    lookup,
+		authorize: authorize,
+	}
+}
+
+// Realm returns the realm
+func (c *CallbackAuthenticator) Realm() string {
+	return c.realm
+}
+
+// LookupPassword calls the lookup function
+func (c *CallbackAuthenticator) LookupPassword(username string) (string, bool) {
+	if c.lookup == nil {
+		return "", false
+	}
+	return c.lookup(username)
+}
+
+// Authorize calls the authorize function
+func (c *CallbackAuthenticator) Authorize(username, streamName string, permission Permission) bool {
+	if c.authorize == nil {
+		return true
+	}
+	return c.authorize(username, streamName, permission)
+}
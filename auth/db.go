@@ -1,11 +1,62 @@
 package auth
 
-// Database stores username and password to implement access control
-type Database struct {
-	Realm    string
-	username string
+import (
+	"path"
+	"sync"
+)
+
+// Permission is the kind of access to a stream
+type Permission int
+
+const (
+	// PermissionRead allows to play a stream
+	PermissionRead Permission = 1 << iota
+	// PermissionPublish allows to publish (ANNOUNCE/RECORD) a stream
+	PermissionPublish
+	// PermissionAll allows everything
+	PermissionAll = PermissionRead | PermissionPublish
+)
+
+// String returns the name of the permission, as in a htpasswd file
+func (p Permission) String() string {
+	switch p {
+	case PermissionRead:
+		return "read"
+	case PermissionPublish:
+		return "publish"
+	case PermissionAll:
+		return "all"
+	}
+	return "none"
+}
+
+// Authenticator is consulted by the RTSP server to authenticate and authorize clients,
+// its methods are called concurrently by every client connection
+type Authenticator interface {
+	// Realm returns the realm sent to the clients in the "WWW-Authenticate:" header
+	Realm() string
+	// LookupPassword returns the password of username, ok is false if the user is unknown
+	LookupPassword(username string) (password string, ok bool)
+	// Authorize reports whether the (authenticated) user may access the stream
+	Authorize(username, streamName string, permission Permission) bool
+}
+
+type grant struct {
+	pattern    string
+	permission Permission
+}
+
+type userRecord struct {
 	password string
-	records  map[string]string
+	grants   []grant
+}
+
+// Database stores username and password to implement access control,
+// it's an in-memory Authenticator which is safe for concurrent use
+type Database struct {
+	realm   string
+	mutex   sync.RWMutex
+	records map[string]*userRecord
 }
 
 // NewAuthDatabase returns a pointer to a new instance of authorization database
@@ -14,32 +65,126 @@ func NewAuthDatabase(realm string) *Database {
 		realm = "dorsvr streaming server"
 	}
 	return &Database{
-		Realm: realm,
+		realm:   realm,
+		records: make(map[string]*userRecord),
 	}
 }
 
-// InsertUserRecord inserts user record, it contains username and password fields
+// Realm returns the realm of the database
+func (d *Database) Realm() string {
+	return d.realm
+}
+
+// InsertUserRecord inserts user record, it contains username and password fields,
+// the user is allowed to read all the streams
 func (d *Database) InsertUserRecord(username, password string) {
 	if username == "" || password == "" {
 		return
 	}
 
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
 	_, existed := d.records[username]
 	if !existed {
-		d.records[username] = password
+		d.records[username] = &userRecord{
+			password: password,
+			grants:   []grant{{pattern: "*", permission: PermissionRead}},
+		}
 	}
 }
 
 // RemoveUserRecord removes user record
 func (d *Database) RemoveUserRecord(username string) {
-	_, existed := d.records[username]
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.records, username)
+}
+
+// GrantPermission gives the user the permission on the streams whose names match the pattern,
+// the pattern has the syntax of path.Match, "*" matches every stream
+func (d *Database) GrantPermission(username, streamPattern string, permission Permission) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	record, existed := d.records[username]
+	if existed {
+		record.grants = append(record.grants, grant{pattern: streamPattern, permission: permission})
+	}
+}
+
+// SetPermissions replaces the permissions of the user by the ones of a htpasswd file,
+// e.g. "read,publish=live/*"
+func (d *Database) SetPermissions(username, permissions string) error {
+	grants, err := parseGrants(permissions)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	record, existed := d.records[username]
+	if existed {
+		record.grants = grants
+	}
+	return nil
+}
+
+// RevokePermissions removes all the permissions of the user
+func (d *Database) RevokePermissions(username string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	record, existed := d.records[username]
 	if existed {
-		delete(d.records, username)
+		record.grants = nil
 	}
 }
 
 // LookupPassword lookups the password by username
-func (d *Database) LookupPassword(username string) (password string) {
-	password, _ = d.records[username]
+func (d *Database) LookupPassword(username string) (password string, ok bool) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	record, ok := d.records[username]
+	if ok {
+		password = record.password
+	}
 	return
 }
+
+// Authorize reports whether the user has the permission on the stream
+func (d *Database) Authorize(username, streamName string, permission Permission) bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	record, existed := d.records[username]
+	if !existed {
+		return false
+	}
+
+	var granted Permission
+	for _, g := range record.grants {
+		if matchStream(g.pattern, streamName) {
+			granted |= g.permission
+		}
+	}
+	return granted&permission == permission
+}
+
+// replaceRecords swaps all the records at once, e.g. after reloading a password file
+func (d *Database) replaceRecords(records map[string]*userRecord) {
+	d.mutex.Lock()
+	d.records = records
+	d.mutex.Unlock()
+}
+
+func matchStream(pattern, streamName string) bool {
+	if pattern == "*" {
+		return true
+	}
+	matched, _ := path.Match(pattern, streamName)
+	return matched
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDatabase(t *testing.T) {
+	db := NewAuthDatabase("")
+	db.InsertUserRecord("viewer", "secret")
+	db.InsertUserRecord("alice", "secret2")
+	db.GrantPermission("alice", "live/*", PermissionPublish)
+
+	if password, ok := db.LookupPassword("viewer"); !ok || password != "secret" {
+		t.Error("failed")
+		return
+	}
+	if !db.Authorize("viewer", "test.264", PermissionRead) ||
+		db.Authorize("viewer", "live/cam1", PermissionPublish) ||
+		!db.Authorize("alice", "live/cam1", PermissionPublish) ||
+		db.Authorize("alice", "test.264", PermissionPublish) {
+		t.Error("failed")
+		return
+	}
+
+	db.RemoveUserRecord("viewer")
+	if _, ok := db.LookupPassword("viewer"); ok {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestHtpasswdFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "auth")
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "users.htpasswd")
+	ioutil.WriteFile(filename, []byte("# users\nviewer:secret\nalice:secret2:read=live/*,publish=live/*\n"), 0600)
+
+	f, err := NewHtpasswdFile("", filename, 10*time.Millisecond)
+	if err != nil {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+	defer f.Close()
+
+	if !f.Authorize("viewer", "test.264", PermissionRead) ||
+		!f.Authorize("alice", "live/cam1", PermissionAll) ||
+		f.Authorize("alice", "test.264", PermissionRead) {
+		t.Error("failed")
+		return
+	}
+
+	// the modified file is reloaded
+	ioutil.WriteFile(filename, []byte("bob:secret3\n"), 0600)
+	os.Chtimes(filename, time.Now(), time.Now().Add(time.Second))
+	for i := 0; i < 100; i++ {
+		if _, ok := f.LookupPassword("bob"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := f.LookupPassword("bob"); !ok {
+		t.Error("failed")
+		return
+	}
+	if _, ok := f.LookupPassword("viewer"); ok {
+		t.Error("failed")
+		return
+	}
+
+	// a bad file keeps the previous users
+	ioutil.WriteFile(filename, []byte("carol:secret4:write\n"), 0600)
+	if err = f.Reload(); err == nil {
+		t.Error("failed")
+		return
+	}
+	if _, ok := f.LookupPassword("bob"); !ok {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -2,24 +2,43 @@ package auth
 
 import (
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"strings"
-	sys "syscall"
+	"sync"
+	"sync/atomic"
 )
 
-var counter = 0
+// The digest algorithms of RFC 7616
+const (
+	AlgorithmMD5    = "MD5"
+	AlgorithmSHA256 = "SHA-256"
+)
+
+var counter int64
 
 // Digest is a struct used for digest authentication.
-// The "realm", and "nonce" fields are supplied by the server
+// The "realm", "nonce", "algorithm", "qop" and "opaque" fields are supplied by the server
 // (in a "401 Unauthorized" response).
 // The "username" and "password" fields are supplied by the client.
+// Its Credentials may be used concurrently.
 type Digest struct {
-	Realm    string
-	Nonce    string
-	Username string
-	Password string
+	Realm     string
+	Nonce     string
+	Username  string
+	Password  string
+	Algorithm string // empty means MD5
+	QOP       string // "auth", or empty for the legacy RFC 2069 digest
+	Opaque    string
+	CNonce    string
+	nc        uint32
+	ncNonce   string     // the nonce that nc counts the requests of
+	mutex     sync.Mutex // guards nc, ncNonce and CNonce
 }
 
 // NewDigest returns a pointer to a new instance of authorization digest
@@ -29,93 +48,286 @@ func NewDigest() *Digest {
 
 // RandomNonce returns a random nonce
 func (d *Digest) RandomNonce() {
-	var timeNow sys.Timeval
-	sys.Gettimeofday(&timeNow)
+	d.Nonce = randomHex(16)
+}
 
-	counter++
-	seedData := fmt.Sprintf("%d.%06d%d", timeNow.Sec, timeNow.Usec, counter)
+// SetChallenge takes the parameters of a "WWW-Authenticate: Digest" challenge,
+// a new nonce restarts the nonce count
+func (d *Digest) SetChallenge(c *Challenge) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 
-	// Use MD5 to compute a 'random' nonce from this seed data:
-	h := md5.New()
-	io.WriteString(h, seedData)
-	d.Nonce = hex.EncodeToString(h.Sum(nil))
+	d.Realm = c.Realm
+	d.Nonce = c.Nonce
+	d.Algorithm = c.Algorithm
+	d.Opaque = c.Opaque
+	d.QOP = ""
+	if c.supportsQOPAuth() {
+		d.QOP = "auth"
+	}
 }
 
 // ComputeResponse represents generating the response using cmd and url value
 func (d *Digest) ComputeResponse(cmd, url string) string {
-	ha1Data := fmt.Sprintf("%s:%s:%s", d.Username, d.Realm, d.Password)
-	ha2Data := fmt.Sprintf("%s:%s", cmd, url)
+	return computeDigestResponse(d.Algorithm, d.Username, d.Realm, d.Password,
+		d.Nonce, d.ncValue(), d.CNonce, d.QOP, cmd, url)
+}
 
-	h1 := md5.New()
-	h2 := md5.New()
-	io.WriteString(h1, ha1Data)
-	io.WriteString(h2, ha2Data)
+// Credentials returns the value of an "Authorization:" header for the request,
+// it counts the request if "qop=auth" is used. (A new nonce, however it was set,
+// restarts the nonce count with a new client nonce.)
+func (d *Digest) Credentials(cmd, url string) string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 
-	digestData := fmt.Sprintf("%s:%s:%s", hex.EncodeToString(h1.Sum(nil)), d.Nonce, hex.EncodeToString(h2.Sum(nil)))
+	if d.Nonce != d.ncNonce {
+		d.nc, d.CNonce, d.ncNonce = 0, "", d.Nonce
+	}
+	if d.QOP != "" {
+		d.nc++
+		if d.CNonce == "" {
+			d.CNonce = randomHex(8)
+		}
+	}
 
-	h3 := md5.New()
-	io.WriteString(h3, digestData)
+	s := fmt.Sprintf("Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\", response=\"%s\"",
+		d.Username, d.Realm, d.Nonce, url, d.ComputeResponse(cmd, url))
+	if d.Algorithm != "" {
+		s += ", algorithm=" + d.Algorithm
+	}
+	if d.QOP != "" {
+		s += fmt.Sprintf(", qop=%s, nc=%s, cnonce=\"%s\"", d.QOP, d.ncValue(), d.CNonce)
+	}
+	if d.Opaque != "" {
+		s += fmt.Sprintf(", opaque=\"%s\"", d.Opaque)
+	}
+	return s
+}
+
+func (d *Digest) ncValue() string {
+	if d.QOP == "" {
+		return ""
+	}
+	return fmt.Sprintf("%08x", d.nc)
+}
 
-	return hex.EncodeToString(h3.Sum(nil))
+// newDigestHash returns the hash function of the algorithm, or nil if it isn't supported
+func newDigestHash(algorithm string) hash.Hash {
+	switch strings.ToUpper(algorithm) {
+	case "", AlgorithmMD5:
+		return md5.New()
+	case AlgorithmSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+func digestHash(algorithm, data string) string {
+	h := newDigestHash(algorithm)
+	io.WriteString(h, data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// computeDigestResponse computes the response of RFC 7616 (or of RFC 2069, without qop)
+func computeDigestResponse(algorithm, username, realm, password, nonce, nc, cnonce, qop, method, uri string) string {
+	ha1 := digestHash(algorithm, fmt.Sprintf("%s:%s:%s", username, realm, password))
+	ha2 := digestHash(algorithm, fmt.Sprintf("%s:%s", method, uri))
+
+	if qop == "" {
+		return digestHash(algorithm, fmt.Sprintf("%s:%s:%s", ha1, nonce, ha2))
+	}
+	return digestHash(algorithm, fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, nonce, nc, cnonce, qop, ha2))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// fall back to a counter, which is still unique
+		return fmt.Sprintf("%0*x", 2*n, atomic.AddInt64(&counter, 1))
+	}
+	return hex.EncodeToString(b)
 }
 
 // AuthorizationHeader is a struct stored the infomation of parsing "Authorization:" line
 type AuthorizationHeader struct {
-	URI      string
-	Realm    string
-	Nonce    string
-	Username string
-	Response string
+	URI       string
+	Realm     string
+	Nonce     string
+	Username  string
+	Response  string
+	Algorithm string
+	QOP       string
+	NC        string
+	CNonce    string
+	Opaque    string
 }
 
 // ParseAuthorizationHeader represents the parsing of "Authorization:" line,
 // Authorization Header contains uri, realm, nonce, Username, response fields
 func ParseAuthorizationHeader(buf string) *AuthorizationHeader {
-	if buf == "" {
+	value := findHeader(buf, "Authorization:")
+	if value == "" {
 		return nil
 	}
 
-	// First, find "Authorization:"
-	index := strings.Index(buf, "Authorization: Digest ")
-	if -1 == index {
+	scheme, params := parseAuthParams(value)
+	if !strings.EqualFold(scheme, "Digest") {
 		return nil
 	}
 
-	// Then, run through each of the fields, looking for ones we handle:
-	var n1, n2 int
-	var parameter, value, username, realm, nonce, uri, response string
-	fields := buf[index+22:]
-	for {
-		n1, _ = fmt.Sscanf(fields, "%[^=]=\"%[^\"]\"", &parameter, &value)
-		n2, _ = fmt.Sscanf(fields, "%[^=]=\"\"", &parameter)
-		if n1 != 2 && n2 != 1 {
-			break
+	return &AuthorizationHeader{
+		URI:       params["uri"],
+		Realm:     params["realm"],
+		Nonce:     params["nonce"],
+		Username:  params["username"],
+		Response:  params["response"],
+		Algorithm: params["algorithm"],
+		QOP:       params["qop"],
+		NC:        params["nc"],
+		CNonce:    params["cnonce"],
+		Opaque:    params["opaque"],
+	}
+}
+
+// Challenge is a struct stored the infomation of parsing "WWW-Authenticate:" line
+type Challenge struct {
+	Scheme    string
+	Realm     string
+	Nonce     string
+	Algorithm string
+	QOP       string
+	Opaque    string
+	Stale     bool
+}
+
+// ParseChallenge parses the value of a "WWW-Authenticate:" header,
+// it returns nil if the scheme or the algorithm isn't supported
+func ParseChallenge(value string) *Challenge {
+	scheme, params := parseAuthParams(value)
+
+	c := &Challenge{
+		Realm:     params["realm"],
+		Nonce:     params["nonce"],
+		Algorithm: params["algorithm"],
+		QOP:       params["qop"],
+		Opaque:    params["opaque"],
+		Stale:     strings.EqualFold(params["stale"], "true"),
+	}
+
+	switch {
+	case strings.EqualFold(scheme, "Digest"):
+		c.Scheme = "Digest"
+		if c.Nonce == "" || newDigestHash(c.Algorithm) == nil {
+			return nil
+		}
+	case strings.EqualFold(scheme, "Basic"):
+		c.Scheme = "Basic"
+	default:
+		return nil
+	}
+	return c
+}
+
+// Strength ranks the challenges, so that a client picks the strongest one it was offered
+func (c *Challenge) Strength() int {
+	if c.Scheme == "Basic" {
+		return 1
+	}
+	if strings.EqualFold(c.Algorithm, AlgorithmSHA256) {
+		return 3
+	}
+	return 2
+}
+
+func (c *Challenge) supportsQOPAuth() bool {
+	for _, qop := range strings.Split(c.QOP, ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			return true
 		}
-		if strings.EqualFold(parameter, "username") {
-			username = value
-		} else if strings.EqualFold(parameter, "realm") {
-			realm = value
-		} else if strings.EqualFold(parameter, "nonce") {
-			nonce = value
-		} else if strings.EqualFold(parameter, "uri") {
-			uri = value
-		} else if strings.EqualFold(parameter, "response") {
-			response = value
+	}
+	return false
+}
+
+// findHeader returns the value of the header in the request, or an empty string
+func findHeader(buf, name string) string {
+	for _, line := range strings.Split(buf, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) > len(name) && strings.EqualFold(line[:len(name)], name) {
+			return strings.TrimSpace(line[len(name):])
 		}
-		fields = fields[len(parameter)+2+len(value)+1:]
-		for fields[0] == ' ' || fields[0] == ',' {
-			fields = fields[1:]
+	}
+	return ""
+}
+
+// parseAuthParams parses "<scheme> name=value, name="quoted value", ...",
+// the names are returned in lower case
+func parseAuthParams(s string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i != -1 {
+		scheme, s = s[:i], s[i+1:]
+	} else {
+		return s, params
+	}
+
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			break
 		}
-		if fields == "" || fields[0] == '\r' || fields[0] == '\n' {
+
+		i := strings.Index(s, "=")
+		if i == -1 {
 			break
 		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " \t")
+
+		var value string
+		if s != "" && s[0] == '"' {
+			// a quoted-string, with backslash escapes
+			var b strings.Builder
+			i = 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			value = b.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			// a token
+			i = strings.IndexAny(s, ", \t")
+			if i == -1 {
+				i = len(s)
+			}
+			value, s = s[:i], s[i:]
+		}
+		params[name] = value
 	}
+	return
+}
 
-	return &AuthorizationHeader{
-		URI:      uri,
-		Realm:    realm,
-		Nonce:    nonce,
-		Username: username,
-		Response: response,
+// ParseBasicCredentials returns the username and password of an "Authorization: Basic" header
+func ParseBasicCredentials(buf string) (username, password string, ok bool) {
+	value := findHeader(buf, "Authorization:")
+	if len(value) < 6 || !strings.EqualFold(value[:6], "Basic ") {
+		return
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[6:]))
+	if err != nil {
+		return
+	}
+
+	i := strings.Index(string(decoded), ":")
+	if i == -1 {
+		return
 	}
+	return string(decoded[:i]), string(decoded[i+1:]), true
 }
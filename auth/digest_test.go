@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigestResponse(t *testing.T) {
+	// the example of RFC 7616, section 3.9.1
+	nonce := "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"
+	cnonce := "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	expected := map[string]string{
+		AlgorithmMD5:    "8ca523f5e9506fed4657c9700eebdbec",
+		AlgorithmSHA256: "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	}
+	for algorithm, response := range expected {
+		r := computeDigestResponse(algorithm, "Mufasa", "http-auth@example.org", "Circle of Life",
+			nonce, "00000001", cnonce, "auth", "GET", "/dir/index.html")
+		if r != response {
+			fmt.Println(algorithm, r)
+			t.Error("failed")
+			return
+		}
+	}
+	t.Log("success")
+}
+
+func TestParseAuthorizationHeader(t *testing.T) {
+	req := "DESCRIBE rtsp://127.0.0.1/test.264 RTSP/1.0\r\n" +
+		"CSeq: 3\r\n" +
+		"authorization: Digest username=\"Mufasa\",realm=\"a \\\"quoted\\\" realm\", nonce=\"abc\", " +
+		"uri=\"rtsp://127.0.0.1/test.264\", algorithm=SHA-256, qop=auth, nc=00000001, cnonce=\"xyz\", response=\"0123\"\r\n\r\n"
+
+	header := ParseAuthorizationHeader(req)
+	if header == nil || header.Username != "Mufasa" || header.Realm != "a \"quoted\" realm" ||
+		header.Algorithm != AlgorithmSHA256 || header.QOP != "auth" || header.NC != "00000001" ||
+		header.CNonce != "xyz" || header.Response != "0123" || header.URI != "rtsp://127.0.0.1/test.264" {
+		fmt.Printf("%+v\n", header)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestDigestVerifier(t *testing.T) {
+	v := NewDigestVerifier(time.Minute)
+	url := "rtsp://127.0.0.1/test.264"
+
+	for _, legacy := range []bool{false, true} {
+		lines := strings.Split(v.Challenge("dorsvr", false), "\r\n")
+		challenge := ParseChallenge(lines[0][len("WWW-Authenticate: "):])
+		if challenge == nil || challenge.Strength() != 3 {
+			t.Error("failed")
+			return
+		}
+
+		d := NewDigest()
+		d.Username, d.Password = "alice", "secret"
+		d.SetChallenge(challenge)
+		if legacy {
+			d.Algorithm, d.QOP = "", ""
+		}
+
+		credentials := "Authorization: " + d.Credentials("DESCRIBE", url) + "\r\n"
+		header := ParseAuthorizationHeader(credentials)
+		if ok, _ := v.Verify(header, "DESCRIBE", url, "secret", "conn1"); !ok {
+			fmt.Println(credentials)
+			t.Error("failed")
+			return
+		}
+		if ok, _ := v.Verify(header, "DESCRIBE", url, "wrong", "conn1"); ok {
+			t.Error("failed")
+			return
+		}
+
+		// a replayed response is refused; (with the legacy digest, the nonce is good on its connection,
+		// but stale on another one)
+		if ok, stale := v.Verify(header, "DESCRIBE", url, "secret", "conn1"); ok == !legacy || stale {
+			t.Error("failed")
+			return
+		}
+		if ok, stale := v.Verify(header, "DESCRIBE", url, "secret", "conn2"); ok || stale != legacy {
+			t.Error("failed")
+			return
+		}
+
+		// the response is for the URL of the request, (or of its session)
+		header = ParseAuthorizationHeader("Authorization: " + d.Credentials("SETUP", url) + "\r\n")
+		if ok, _ := v.Verify(header, "SETUP", "rtsp://127.0.0.1/other.264", "secret", "conn1"); ok {
+			t.Error("failed")
+			return
+		}
+		if ok, _ := v.Verify(header, "SETUP", url+"/track1", "secret", "conn1"); !ok {
+			t.Error("failed")
+			return
+		}
+	}
+
+	// a forged nonce is refused, an expired one is stale
+	d := NewDigest()
+	d.Username, d.Password, d.Realm, d.QOP = "alice", "secret", "dorsvr", "auth"
+	d.Nonce = "00" + v.NewNonce()[2:]
+	if ok, stale := v.Verify(ParseAuthorizationHeader("Authorization: "+d.Credentials("PLAY", url)), "PLAY", url, "secret", "conn1"); ok || stale {
+		t.Error("failed")
+		return
+	}
+	v.ttl = -time.Second
+	d.Nonce = v.NewNonce()
+	if ok, stale := v.Verify(ParseAuthorizationHeader("Authorization: "+d.Credentials("PLAY", url)), "PLAY", url, "secret", "conn1"); ok || !stale {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestDigestURIMatches(t *testing.T) {
+	for _, test := range []struct {
+		digestURI, requestURI string
+		matches               bool
+	}{
+		{"rtsp://127.0.0.1/test.264", "rtsp://127.0.0.1/test.264", true},
+		{"rtsp://127.0.0.1/test.264", "rtsp://127.0.0.1/test.264/track1", true},
+		{"rtsp://127.0.0.1/test.264/", "rtsp://127.0.0.1/test.264/track1", true},
+		{"rtsp://127.0.0.1/test.264?token=abc", "rtsp://127.0.0.1/test.264", true},
+		{"/test.264", "rtsp://127.0.0.1/test.264", true},
+		{"*", "*", true},
+		{"rtsp://127.0.0.1/test.264", "rtsp://127.0.0.1/test.2645", false},
+		{"rtsp://127.0.0.1/test.264", "rtsp://127.0.0.1/other.264", false},
+		{"rtsp://127.0.0.1/test.264/track1", "rtsp://127.0.0.1/test.264", false},
+		{"rtsp://127.0.0.2/test.264", "rtsp://127.0.0.1/test.264", false},
+		{"rtsp://127.0.0.1", "rtsp://127.0.0.1/test.264", false},
+		{"", "rtsp://127.0.0.1/test.264", false},
+	} {
+		if digestURIMatches(test.digestURI, test.requestURI) != test.matches {
+			fmt.Println(test.digestURI, test.requestURI)
+			t.Error("failed")
+			return
+		}
+	}
+	t.Log("success")
+}
+
+func TestDigestChallengeOrder(t *testing.T) {
+	v := NewDigestVerifier(time.Minute)
+
+	// (live555's clients take the realm and the nonce, then "stale", as the first parameters)
+	for _, line := range strings.Split(strings.TrimSpace(v.Challenge("dorsvr", true)), "\r\n") {
+		var realm, nonce, rest string
+		n, _ := fmt.Sscanf(line, "WWW-Authenticate: Digest realm=%q, nonce=%q, stale=true%s", &realm, &nonce, &rest)
+		if n != 3 || realm != "dorsvr" || nonce == "" || !strings.HasPrefix(rest, ",") {
+			fmt.Println(line)
+			t.Error("failed")
+			return
+		}
+	}
+	t.Log("success")
+}
+
+func TestDigestNonceCount(t *testing.T) {
+	d := NewDigest()
+	d.Username, d.Password, d.Realm, d.QOP, d.Nonce = "alice", "secret", "dorsvr", "auth", "nonce1"
+	d.Credentials("DESCRIBE", "rtsp://127.0.0.1/test.264")
+	first := ParseAuthorizationHeader("Authorization: " + d.Credentials("SETUP", "rtsp://127.0.0.1/test.264") + "\r\n")
+
+	// a new nonce restarts the count, with a new client nonce
+	d.Nonce = "nonce2"
+	second := ParseAuthorizationHeader("Authorization: " + d.Credentials("PLAY", "rtsp://127.0.0.1/test.264") + "\r\n")
+	if first.NC != "00000002" || second.NC != "00000001" || second.CNonce == first.CNonce {
+		fmt.Printf("%+v %+v\n", first, second)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djwackey/gitea/log"
+)
+
+// HtpasswdFile is an Authenticator which loads its users from a htpasswd-style file,
+// and reloads it whenever the file is modified.
+//
+// Every line of the file has the form:
+//
+//	username:password[:permissions]
+//
+// where the optional permissions are a comma separated list of "read", "publish" or "all",
+// each one followed by "=<stream pattern>" to limit it to the matching streams,
+// e.g. "alice:secret:read,publish=live/*". Without permissions, the user can read every stream.
+// Empty lines and lines starting with '#' are ignored.
+type HtpasswdFile struct {
+	*Database
+	filename string
+	modTime  time.Time
+	mutex    sync.Mutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewHtpasswdFile loads the file, and checks it for modifications every reloadInterval,
+// (a zero reloadInterval disables the hot reload)
+func NewHtpasswdFile(realm, filename string, reloadInterval time.Duration) (*HtpasswdFile, error) {
+	f := &HtpasswdFile{
+		Database: NewAuthDatabase(realm),
+		filename: filename,
+		stop:     make(chan struct{}),
+	}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	if reloadInterval > 0 {
+		go f.watch(reloadInterval)
+	}
+	return f, nil
+}
+
+// Reload reads the file again, the previous users are kept if the file can't be loaded
+func (f *HtpasswdFile) Reload() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.filename)
+	if err != nil {
+		return err
+	}
+
+	records, err := loadHtpasswd(f.filename)
+	if err != nil {
+		return err
+	}
+
+	f.Database.replaceRecords(records)
+	f.modTime = info.ModTime()
+	return nil
+}
+
+// Close stops watching the file
+func (f *HtpasswdFile) Close() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
+func (f *HtpasswdFile) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastModTime time.Time
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(f.filename)
+		if err != nil {
+			continue
+		}
+
+		f.mutex.Lock()
+		modified := !info.ModTime().Equal(f.modTime)
+		f.mutex.Unlock()
+
+		// wait until the file stops changing, so that we don't read it half written
+		settled := info.ModTime().Equal(lastModTime)
+		lastModTime = info.ModTime()
+		if !modified || !settled {
+			continue
+		}
+
+		if err = f.Reload(); err != nil {
+			log.Warn("failed to reload %s: %v", f.filename, err)
+		} else {
+			log.Info("reloaded %s", f.filename)
+		}
+	}
+}
+
+func loadHtpasswd(filename string) (map[string]*userRecord, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make(map[string]*userRecord)
+
+	lineNum := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("%s:%d: bad user record", filename, lineNum)
+		}
+
+		record := &userRecord{password: fields[1]}
+		if len(fields) == 3 {
+			record.grants, err = parseGrants(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", filename, lineNum, err)
+			}
+		} else {
+			record.grants = []grant{{pattern: "*", permission: PermissionRead}}
+		}
+		records[fields[0]] = record
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// parseGrants parses the permissions like "read,publish=live/*"
+func parseGrants(s string) ([]grant, error) {
+	var grants []grant
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pattern := "*"
+		if i := strings.Index(item, "="); i != -1 {
+			item, pattern = item[:i], item[i+1:]
+		}
+
+		var permission Permission
+		switch item {
+		case "read":
+			permission = PermissionRead
+		case "publish":
+			permission = PermissionPublish
+		case "all":
+			permission = PermissionAll
+		default:
+			return nil, fmt.Errorf("unknown permission \"%s\"", item)
+		}
+		grants = append(grants, grant{pattern: pattern, permission: permission})
+	}
+	return grants, nil
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultNonceTTL is how long a nonce of a DigestVerifier stays valid
+const DefaultNonceTTL = 2 * time.Minute
+
+const (
+	nonceTimeLength   = 8
+	nonceRandomLength = 8
+	nonceMACLength    = 16
+)
+
+// DigestVerifier issues stateless nonces and verifies the digest responses of the clients,
+// the nonces are signed with a HMAC, so they are valid on every connection until they expire.
+// With "qop=auth", the nonce count of every nonce has to increase, so a captured response
+// can't be replayed; without it (RFC 2069, e.g. live555's clients, VLC or ffmpeg, which use
+// their nonce for every request of a session), a nonce is only good on the connection that
+// used it first. It's safe for concurrent use.
+type DigestVerifier struct {
+	secret      []byte
+	ttl         time.Duration
+	mutex       sync.Mutex
+	nonceCounts map[string]uint32
+	nonceConns  map[string]string // the connections of the nonces used without qop
+	lastPrune   time.Time
+}
+
+// NewDigestVerifier returns a new DigestVerifier whose nonces expire after ttl,
+// (DefaultNonceTTL if it's zero)
+func NewDigestVerifier(ttl time.Duration) *DigestVerifier {
+	if ttl <= 0 {
+		ttl = DefaultNonceTTL
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	return &DigestVerifier{
+		secret:      secret,
+		ttl:         ttl,
+		nonceCounts: make(map[string]uint32),
+		nonceConns:  make(map[string]string),
+		lastPrune:   time.Now(),
+	}
+}
+
+// NewNonce returns a new nonce, it contains the time it was issued at, some random bytes and the HMAC of both
+func (v *DigestVerifier) NewNonce() string {
+	b := make([]byte, nonceTimeLength+nonceRandomLength, nonceTimeLength+nonceRandomLength+nonceMACLength)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	rand.Read(b[nonceTimeLength:])
+
+	return hex.EncodeToString(append(b, v.mac(b)...))
+}
+
+// Challenge returns the "WWW-Authenticate:" header lines of a "401 Unauthorized" response,
+// the SHA-256 challenge comes first, then the MD5 one for the older clients. (The parameters
+// are in the order of RFC 7616, which live555's clients expect: realm, nonce, stale, algorithm and qop.)
+func (v *DigestVerifier) Challenge(realm string, stale bool) string {
+	nonce := v.NewNonce()
+
+	var s string
+	for _, algorithm := range []string{AlgorithmSHA256, AlgorithmMD5} {
+		s += fmt.Sprintf("WWW-Authenticate: Digest realm=\"%s\", nonce=\"%s\"", realm, nonce)
+		if stale {
+			s += ", stale=true"
+		}
+		s += fmt.Sprintf(", algorithm=%s, qop=\"auth\"\r\n", algorithm)
+	}
+	return s
+}
+
+// Verify checks the digest response of the "Authorization:" header against the password,
+// for a request of the method on requestURI, which came on the connection (e.g. its remote address);
+// stale is true if the response is right but its nonce expired (or, without qop, was used on another
+// connection), so the client should retry with a new one
+func (v *DigestVerifier) Verify(header *AuthorizationHeader, method, requestURI, password, conn string) (ok, stale bool) {
+	if newDigestHash(header.Algorithm) == nil || !digestURIMatches(header.URI, requestURI) {
+		return false, false
+	}
+
+	issued, valid := v.checkNonce(header.Nonce)
+	if !valid {
+		return false, false
+	}
+
+	switch header.QOP {
+	case "":
+	case "auth":
+		if header.NC == "" || header.CNonce == "" {
+			return false, false
+		}
+	default:
+		return false, false
+	}
+
+	response := computeDigestResponse(header.Algorithm, header.Username, header.Realm, password,
+		header.Nonce, header.NC, header.CNonce, header.QOP, method, header.URI)
+	if subtle.ConstantTimeCompare([]byte(response), []byte(strings.ToLower(header.Response))) != 1 {
+		return false, false
+	}
+
+	if time.Since(issued) > v.ttl {
+		return false, true
+	}
+
+	if header.QOP == "" {
+		// without a nonce count, a response can't be told from a replay of it, (but on its connection)
+		if !v.bindNonce(header.Nonce, conn) {
+			return false, true
+		}
+	} else if !v.checkNonceCount(header.Nonce, header.NC) {
+		return false, false
+	}
+	return true, false
+}
+
+// digestURIMatches checks the "uri" of a digest response against the URI of the request:
+// they're the same, or the request is on a track of the session at "uri" (as live555's clients
+// answer with the URL of the session for all of its requests). The queries don't matter.
+func digestURIMatches(digestURI, requestURI string) bool {
+	if digestURI == requestURI {
+		return true
+	}
+
+	d, err := url.Parse(digestURI)
+	if err != nil {
+		return false
+	}
+	r, err := url.Parse(requestURI)
+	if err != nil {
+		return false
+	}
+	if d.Host != "" && r.Host != "" && !strings.EqualFold(d.Host, r.Host) {
+		return false
+	}
+
+	sessionPath := strings.TrimSuffix(d.Path, "/")
+	if sessionPath == "" {
+		return d.Path == r.Path
+	}
+	return r.Path == sessionPath || strings.HasPrefix(r.Path, sessionPath+"/")
+}
+
+func (v *DigestVerifier) mac(data []byte) []byte {
+	h := hmac.New(sha256.New, v.secret)
+	h.Write(data)
+	return h.Sum(nil)[:nonceMACLength]
+}
+
+// checkNonce checks that we issued the nonce, and returns when
+func (v *DigestVerifier) checkNonce(nonce string) (issued time.Time, valid bool) {
+	b, err := hex.DecodeString(nonce)
+	if err != nil || len(b) != nonceTimeLength+nonceRandomLength+nonceMACLength {
+		return
+	}
+
+	data, mac := b[:nonceTimeLength+nonceRandomLength], b[nonceTimeLength+nonceRandomLength:]
+	if !hmac.Equal(mac, v.mac(data)) {
+		return
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data))), true
+}
+
+// checkNonceCount checks that the nonce count is higher than the last one of the nonce
+func (v *DigestVerifier) checkNonceCount(nonce, nc string) bool {
+	count, err := strconv.ParseUint(nc, 16, 32)
+	if err != nil || count == 0 {
+		return false
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.pruneNonceCounts()
+
+	if _, bound := v.nonceConns[nonce]; bound || uint32(count) <= v.nonceCounts[nonce] {
+		return false
+	}
+	v.nonceCounts[nonce] = uint32(count)
+	return true
+}
+
+// bindNonce binds the nonce to the connection that uses it first,
+// and checks that it isn't used on another one
+func (v *DigestVerifier) bindNonce(nonce, conn string) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.pruneNonceCounts()
+
+	if _, counted := v.nonceCounts[nonce]; counted {
+		// (it was used with qop already)
+		return false
+	}
+	if boundConn, bound := v.nonceConns[nonce]; bound {
+		return boundConn == conn
+	}
+	v.nonceConns[nonce] = conn
+	return true
+}
+
+// pruneNonceCounts forgets the expired nonces, (which are refused anyway)
+func (v *DigestVerifier) pruneNonceCounts() {
+	now := time.Now()
+	if now.Sub(v.lastPrune) < v.ttl {
+		return
+	}
+	v.lastPrune = now
+
+	for nonce := range v.nonceCounts {
+		if issued, _ := v.checkNonce(nonce); now.Sub(issued) > v.ttl {
+			delete(v.nonceCounts, nonce)
+		}
+	}
+	for nonce := range v.nonceConns {
+		if issued, _ := v.checkNonce(nonce); now.Sub(issued) > v.ttl {
+			delete(v.nonceConns, nonce)
+		}
+	}
+}
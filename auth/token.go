@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errTokenMissing = errors.New("no token in the URL")
+	errTokenExpired = errors.New("the token expired")
+	errTokenInvalid = errors.New("invalid token")
+)
+
+// TokenSigner signs time-limited URLs of the streams, e.g. handed out by a web portal:
+//
+//	rtsp://host/live/cam1?token=<hex HMAC-SHA256>&expires=<unix time>
+//
+// The token is bound to the stream name and, optionally, to the IP address of the client.
+// A valid token allows to read the stream without any other credentials.
+type TokenSigner struct {
+	key []byte
+}
+
+// NewTokenSigner returns a new TokenSigner, the key is shared with whoever hands out the URLs
+func NewTokenSigner(key []byte) *TokenSigner {
+	return &TokenSigner{key: key}
+}
+
+// Token returns the token of the stream, an empty clientIP lets any client use it
+func (s *TokenSigner) Token(streamName, clientIP string, expires int64) string {
+	h := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(h, "%s\n%d\n%s", streamName, expires, clientIP)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SignURL adds the "token" and "expires" query parameters to the RTSP URL,
+// the stream name is the path of the URL
+func (s *TokenSigner) SignURL(rawURL, clientIP string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	streamName := strings.Trim(u.Path, "/")
+	query := u.Query()
+	query.Set("token", s.Token(streamName, clientIP, expires.Unix()))
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Verify checks the token of the query string of a request for the stream, coming from clientIP
+func (s *TokenSigner) Verify(streamName, clientIP, rawQuery string) error {
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return err
+	}
+
+	token, expiresStr := query.Get("token"), query.Get("expires")
+	if token == "" || expiresStr == "" {
+		return errTokenMissing
+	}
+
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return errTokenInvalid
+	}
+
+	// the token may be bound to the client's address, or not
+	streamName = strings.Trim(streamName, "/")
+	if !hmac.Equal([]byte(token), []byte(s.Token(streamName, clientIP, expires))) &&
+		!hmac.Equal([]byte(token), []byte(s.Token(streamName, "", expires))) {
+		return errTokenInvalid
+	}
+
+	if time.Now().Unix() > expires {
+		return errTokenExpired
+	}
+	return nil
+}
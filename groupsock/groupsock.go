@@ -1,19 +1,20 @@
 package groupsock
 
 import (
-	"fmt"
 	"net"
 	"strconv"
-	"strings"
+	"sync"
+	"time"
 )
 
 // GroupSock is used to both send and receive packets.
 // As the name suggests, it was originally designed to send/receive
 // multicast, but it can send/receive unicast as well.
 type GroupSock struct {
-	portNum uint
-	udpConn *net.UDPConn
-	dests   []*destRecord
+	portNum   uint
+	udpConn   *net.UDPConn
+	dests     []*destRecord
+	destMutex sync.Mutex // (a destination may change while we send, see ChangeDestination)
 }
 
 // NewGroupSock returns a source-independent multicast group
@@ -32,7 +33,10 @@ func NewGroupSock(addrStr string, portNum uint) *GroupSock {
 func (g *GroupSock) Output(buffer []byte, bufferSize uint) bool {
 	var err error
 	var writeSuccess bool
-	for _, dest := range g.dests {
+	g.destMutex.Lock()
+	dests := append([]*destRecord(nil), g.dests...)
+	g.destMutex.Unlock()
+	for _, dest := range dests {
 		if _, err = g.write(dest.addrStr, dest.portNum, buffer, bufferSize); err == nil {
 			writeSuccess = true
 		}
@@ -40,8 +44,14 @@ func (g *GroupSock) Output(buffer []byte, bufferSize uint) bool {
 	return writeSuccess
 }
 
+// OutputTo does the datagram send, to a single address (rather than to our destinations).
+func (g *GroupSock) OutputTo(addr string, port uint, buffer []byte) error {
+	_, err := g.write(addr, port, buffer, uint(len(buffer)))
+	return err
+}
+
 func (g *GroupSock) write(destAddr string, portNum uint, buffer []byte, bufferSize uint) (int, error) {
-	addr := fmt.Sprintf("%s:%d", destAddr, portNum)
+	addr := net.JoinHostPort(destAddr, strconv.Itoa(int(portNum)))
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return 0, err
@@ -65,13 +75,21 @@ func (g *GroupSock) HandleRead(buffer []byte) (int, error) {
 	return numBytes, err
 }
 
+// HandleReadFrom reads data from client connection, and returns the address it came from.
+func (g *GroupSock) HandleReadFrom(buffer []byte) (int, *net.UDPAddr, error) {
+	return g.udpConn.ReadFromUDP(buffer)
+}
+
+// SetReadDeadline sets the deadline of our reads, (a zero time means none)
+func (g *GroupSock) SetReadDeadline(t time.Time) error {
+	return g.udpConn.SetReadDeadline(t)
+}
+
 // GetSourcePort returns the source port of system allocation.
 func (g *GroupSock) GetSourcePort() uint {
 	if g.udpConn != nil {
-		localAddr := strings.Split(g.udpConn.LocalAddr().String(), ":")
-		sourcePort, err := strconv.Atoi(localAddr[len(localAddr) - 1])
-		if err == nil {
-			return uint(sourcePort)
+		if localAddr, ok := g.udpConn.LocalAddr().(*net.UDPAddr); ok {
+			return uint(localAddr.Port)
 		}
 	}
 	return 0
@@ -80,7 +98,23 @@ func (g *GroupSock) GetSourcePort() uint {
 // AddDestination can add multiple destinations (addresses & ports)
 // This can be used to implement multi-unicast.
 func (g *GroupSock) AddDestination(addr string, port uint) {
+	g.destMutex.Lock()
 	g.dests = append(g.dests, newDestRecord(addr, port))
+	g.destMutex.Unlock()
+}
+
+// ChangeDestination sends what we sent to a destination to another one instead,
+// and returns false if there's no such destination.
+func (g *GroupSock) ChangeDestination(addr string, port uint, newAddr string, newPort uint) bool {
+	g.destMutex.Lock()
+	defer g.destMutex.Unlock()
+	for i, dest := range g.dests {
+		if dest.addrStr == addr && dest.portNum == port {
+			g.dests[i] = newDestRecord(newAddr, newPort)
+			return true
+		}
+	}
+	return false
 }
 
 // DelDestination can remove the destinations.
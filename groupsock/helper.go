@@ -3,18 +3,26 @@ package groupsock
 import (
 	"fmt"
 	"net"
+	"strconv"
 )
 
 // SetupDatagramSocket returns a udp connection of Listening to the specified port.
+// An empty address listens on all of our addresses, IPv4 and IPv6 alike;
+// a multicast address (e.g. "232.1.1.1" or "ff15::1") joins that group.
 func SetupDatagramSocket(address string, port uint) *net.UDPConn {
-	addr := fmt.Sprintf("%s:%d", address, port)
+	addr := net.JoinHostPort(address, strconv.Itoa(int(port)))
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		fmt.Println("Failed to resolve UDP address.", err)
 		return nil
 	}
 
-	udpConn, err := net.ListenUDP("udp", udpAddr)
+	var udpConn *net.UDPConn
+	if udpAddr.IP.IsMulticast() {
+		udpConn, err = net.ListenMulticastUDP(udpNetwork(udpAddr.IP), nil, udpAddr)
+	} else {
+		udpConn, err = net.ListenUDP("udp", udpAddr)
+	}
 	if err != nil {
 		fmt.Println("Failed to listen UDP address.", err)
 		return nil
@@ -37,7 +45,7 @@ func SetupDatagramSocket(address string, port uint) *net.UDPConn {
 }
 
 func setupStreamSocket(address string, port uint) *net.TCPConn {
-	addr := fmt.Sprintf("%s:%d", address, port)
+	addr := net.JoinHostPort(address, strconv.Itoa(int(port)))
 	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		fmt.Println("Failed to resolve TCP address.", err)
@@ -59,3 +67,11 @@ func ReadSocket(conn net.Conn, buffer []byte) (int, error) {
 func writeSocket(conn net.Conn, buffer []byte) (int, error) {
 	return conn.Write(buffer)
 }
+
+// udpNetwork returns the network name ("udp4" or "udp6") for an address of ip's family.
+func udpNetwork(ip net.IP) string {
+	if ip.To4() != nil {
+		return "udp4"
+	}
+	return "udp6"
+}
@@ -40,7 +40,34 @@ func Ntohl(packet []byte) (uint32, error) {
 	return value, nil
 }
 
+// OurIPAddress returns one of our (non-loopback) IPv4 addresses,
+// or, if we have none, one of our global IPv6 addresses.
 func OurIPAddress() (string, error) {
+	ip, err := ourIPAddress(false)
+	if err != nil {
+		return ourIPAddress(true)
+	}
+	return ip, err
+}
+
+// OurIPv6Address returns one of our global IPv6 addresses.
+func OurIPv6Address() (string, error) {
+	return ourIPAddress(true)
+}
+
+// IsIPv6Address reports whether addr is a literal IPv6 address (as opposed to IPv4, or a name).
+func IsIPv6Address(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() == nil
+}
+
+// IsMulticastAddress reports whether addr is a literal IPv4 or IPv6 multicast address.
+func IsMulticastAddress(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsMulticast()
+}
+
+func ourIPAddress(ipv6 bool) (string, error) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		fmt.Printf("Failed to get InterfaceAddrs.%s\n", err.Error())
@@ -51,9 +78,11 @@ func OurIPAddress() (string, error) {
 	err = errors.New("ip address not found")
 	for _, address := range addrs {
 		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
+			if !ipv6 && ipnet.IP.To4() != nil {
 				ip, err = ipnet.IP.String(), nil
 				//break
+			} else if ipv6 && ipnet.IP.To4() == nil && ipnet.IP.IsGlobalUnicast() {
+				ip, err = ipnet.IP.String(), nil
 			}
 		}
 	}
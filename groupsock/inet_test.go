@@ -32,3 +32,12 @@ func TestOurIPAddress(t *testing.T) {
 		t.Error("failed")
 	}
 }
+
+func TestIsIPv6Address(t *testing.T) {
+	if IsIPv6Address("fe80::1") && !IsIPv6Address("192.168.1.105") && !IsIPv6Address("example.com") &&
+		IsMulticastAddress("ff15::101") && IsMulticastAddress("232.1.1.1") && !IsMulticastAddress("::1") {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
@@ -70,9 +70,7 @@ func (s *ByteStreamFileSource) doReadFromFile() error {
 			sys.Gettimeofday(&s.presentationTime)
 		} else {
 			// Increment by the play time of the previous data:
-			uSeconds := s.presentationTime.Usec + int32(s.lastPlayTime)
-			s.presentationTime.Sec += uSeconds / 1000000
-			s.presentationTime.Usec = uSeconds % 1000000
+			addMicroseconds(&s.presentationTime, int64(s.lastPlayTime))
 		}
 
 		// Remember the play time of this data:
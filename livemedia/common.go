@@ -2,6 +2,7 @@ package livemedia
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -27,7 +28,9 @@ type RTSPRequestInfo struct {
 	SessionIDStr  string
 	UrlPreSuffix  string
 	UrlSuffix     string
+	UrlQuery      string // e.g. "token=...&expires=..." of a signed URL
 	ContentLength string
+	URL           *RTSPURL // the request-URI, nil if it isn't an absolute URL
 }
 
 type HTTPRequestInfo struct {
@@ -47,6 +50,8 @@ type TransportHeader struct {
 	DestinationTTL    uint
 	DestinationAddr   string
 	StreamingModeStr  string
+	IsSecure          bool // "RTP/SAVP": SRTP, keyed by the "a=crypto:" line of our SDP
+	RTCPMux           bool // "rtcp-mux": RTCP on the RTP ports, (RFC 5761)
 }
 
 type RangeHeader struct {
@@ -74,59 +79,28 @@ func ParseRTSPRequestString(reqStr string, reqStrSize int) (*RTSPRequestInfo, bo
 		return nil, false // parse failed
 	}
 
-	// skip over any additional white space
+	// The request-URI follows, (after any additional white space) up to the next white space,
+	// and the headers start after the end of the line:
 	j := i + 1
 	for ; j < reqStrSize && (reqStr[j] == ' ' || reqStr[j] == '\t'); j++ {
 	}
-	for ; j < reqStrSize-8; j++ {
-		if (reqStr[j+0] == 'r' || reqStr[j+0] == 'R') &&
-			(reqStr[j+1] == 't' || reqStr[j+1] == 'T') &&
-			(reqStr[j+2] == 's' || reqStr[j+2] == 'S') &&
-			(reqStr[j+3] == 'p' || reqStr[j+3] == 'P') &&
-			reqStr[j+4] == ':' && reqStr[j+5] == '/' {
-			j += 6
-			if reqStr[j] == '/' {
-				j++
-				for ; j < reqStrSize && reqStr[j] != '/' && reqStr[j] != ' '; j++ {
-				}
-			} else {
-				j--
-			}
-			i = j
-			break
-		}
+	for i = j; i < reqStrSize && reqStr[i] != '\r' && reqStr[i] != '\n'; i++ {
+	}
+	requestURI := reqStr[j:i]
+	if n := strings.IndexAny(requestURI, " \t"); n != -1 {
+		requestURI = requestURI[:n]
 	}
 
-	// Look for the URL suffix
-	for k := i + 1; k < reqStrSize-5; k++ {
-		if reqStr[k+0] == 'R' &&
-			reqStr[k+1] == 'T' &&
-			reqStr[k+2] == 'S' &&
-			reqStr[k+3] == 'P' &&
-			reqStr[k+4] == '/' {
-			for k--; k >= i && reqStr[k] == ' '; k-- {
-			}
-			k1 := k
-			for ; k1 > i && reqStr[k1] != '/'; k1-- {
-			}
-
-			k2 := k1 + 1
-			if i <= k {
-				for ; k2 <= k; k2++ {
-					reqInfo.UrlSuffix += string(reqStr[k2])
-				}
-			}
-			k2 = i + 1
-			if i <= k {
-				for ; k2 <= k1-1; k2++ {
-					reqInfo.UrlPreSuffix += string(reqStr[k2])
-				}
-			}
-
-			i = k + 7
-			break
-		}
+	// An absolute URL (e.g. "rtsp://[::1]:8554/live/cam1/track1?token=abc", or "rtsps://..." on a RTSPS
+	// connection) is parsed as such; anything else (e.g. "*") is taken as the path:
+	urlPath := requestURI
+	if rtspURL, err := ParseRTSPURL(requestURI); err == nil {
+		reqInfo.URL = rtspURL
+		urlPath, reqInfo.UrlQuery = rtspURL.RawPath, rtspURL.RawQuery
+	} else if n := strings.Index(urlPath, "?"); n != -1 {
+		urlPath, reqInfo.UrlQuery = urlPath[:n], urlPath[n+1:]
 	}
+	reqInfo.UrlPreSuffix, reqInfo.UrlSuffix = splitURLPath(urlPath)
 
 	// Look for "CSeq:"
 	for j = i; j < reqStrSize-5; j++ {
@@ -167,6 +141,25 @@ func ParseRTSPRequestString(reqStr string, reqStrSize int) (*RTSPRequestInfo, bo
 	return reqInfo, true
 }
 
+// splitURLPath splits an escaped path at its last "/", e.g. "/live/cam1/track1" into "live/cam1"
+// and "track1", and unescapes both parts.
+func splitURLPath(escapedPath string) (preSuffix, suffix string) {
+	escapedPath = strings.TrimPrefix(escapedPath, "/")
+	if n := strings.LastIndex(escapedPath, "/"); n != -1 {
+		preSuffix, suffix = escapedPath[:n], escapedPath[n+1:]
+	} else {
+		suffix = escapedPath
+	}
+
+	if unescaped, err := url.PathUnescape(preSuffix); err == nil {
+		preSuffix = unescaped
+	}
+	if unescaped, err := url.PathUnescape(suffix); err == nil {
+		suffix = unescaped
+	}
+	return preSuffix, suffix
+}
+
 func ParseHTTPRequestString(reqStr string, reqStrSize int) (*HTTPRequestInfo, bool) {
 	var cmdName, acceptStr, sessionCookie string
 
@@ -239,6 +232,9 @@ func ParseTransportHeader(reqStr string) *TransportHeader {
 		var p1, p2, rtpCid, rtcpCid, ttl uint
 
 		tranStr := reqStr[index+10:]
+		if end := strings.Index(tranStr, "\r\n"); end != -1 {
+			tranStr = tranStr[:end]
+		}
 		fields := strings.Split(tranStr, ";")
 
 		for _, field := range fields {
@@ -246,12 +242,18 @@ func ParseTransportHeader(reqStr string) *TransportHeader {
 
 			if strings.EqualFold(field, "RTP/AVP/TCP") {
 				header.StreamingMode = RTP_TCP
+			} else if strings.EqualFold(field, "RTP/SAVP") {
+				header.IsSecure = true
+			} else if strings.EqualFold(field, "RTP/SAVP/TCP") {
+				header.StreamingMode = RTP_TCP
+				header.IsSecure = true
 			} else if strings.EqualFold(field, "RAW/RAW/UDP") ||
 				strings.EqualFold(field, "MP2T/H2221/UDP") {
 				header.StreamingMode = RAW_UDP
 				header.StreamingModeStr = field
-			} else if strings.Index(field, "destination=") != -1 {
-				header.DestinationAddr = field[12:]
+			} else if strings.HasPrefix(field, "destination=") {
+				// (an IPv6 address may come in brackets)
+				header.DestinationAddr = strings.Trim(field[12:], "[]")
 			} else if n, _ = fmt.Sscanf(field, "ttl%d", &ttl); n == 1 {
 				header.DestinationTTL = ttl
 			} else if n, _ = fmt.Sscanf(field, "client_port=%d-%d", &p1, &p2); n == 2 {
@@ -271,6 +273,8 @@ func ParseTransportHeader(reqStr string) *TransportHeader {
 			} else if n, _ = fmt.Sscanf(field, "interleaved=%d-%d", &rtpCid, &rtcpCid); n == 2 {
 				header.RTPChannelID = rtpCid
 				header.RTCPChannelID = rtcpCid
+			} else if strings.EqualFold(field, "rtcp-mux") {
+				header.RTCPMux = true
 			}
 		}
 		break
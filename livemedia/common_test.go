@@ -79,3 +79,51 @@ func TestParseRTSPRequestString(t *testing.T) {
 		t.Error("failed")
 	}
 }
+
+func TestParseRTSPRequestQuery(t *testing.T) {
+	req := "SETUP rtsp://192.168.1.105:8554/live/cam1/track1?token=abc&expires=123 RTSP/1.0\r\n" +
+		"CSeq: 3\r\n\r\n"
+	reqStr, ok := ParseRTSPRequestString(req, len(req))
+	if !ok || reqStr.UrlPreSuffix != "live/cam1" || reqStr.UrlSuffix != "track1" ||
+		reqStr.UrlQuery != "token=abc&expires=123" {
+		fmt.Printf("%+v\n", reqStr)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestParseRTSPRequestURL(t *testing.T) {
+	req := "DESCRIBE rtsps://[fe80::1]:8555/live/my%20cam?token=abc RTSP/1.0\r\nCSeq: 2\r\n\r\n"
+	reqStr, ok := ParseRTSPRequestString(req, len(req))
+	if !ok || reqStr.URL == nil || reqStr.URL.Host != "fe80::1" || reqStr.URL.Port != 8555 ||
+		reqStr.UrlPreSuffix != "live" || reqStr.UrlSuffix != "my cam" || reqStr.UrlQuery != "token=abc" ||
+		reqStr.Cseq != "2" {
+		fmt.Printf("%+v\n", reqStr)
+		t.Error("failed")
+		return
+	}
+
+	// (a request-URI that isn't an absolute URL)
+	req = "OPTIONS * RTSP/1.0\r\nCSeq: 1\r\n\r\n"
+	reqStr, ok = ParseRTSPRequestString(req, len(req))
+	if !ok || reqStr.URL != nil || reqStr.UrlPreSuffix != "" || reqStr.UrlSuffix != "*" || reqStr.Cseq != "1" {
+		fmt.Printf("%+v\n", reqStr)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestParseTransportHeaderRTCPMux(t *testing.T) {
+	muxed := ParseTransportHeader("SETUP rtsp://192.168.1.105:8554/test.264/track1 RTSP/1.0\r\n" +
+		"Transport: RTP/AVP;unicast;client_port=37175-37176;rtcp-mux\r\n" +
+		"CSeq: 3\r\n\r\n")
+	plain := ParseTransportHeader(setupRequest)
+	if !muxed.RTCPMux || muxed.ClientRTPPortNum != 37175 || muxed.ClientRTCPPortNum != 37176 || plain.RTCPMux {
+		fmt.Printf("%+v %+v\n", muxed, plain)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -0,0 +1,464 @@
+package livemedia
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+)
+
+// the FEC schemes
+const (
+	FECSchemeULPFEC  = "ulpfec"  // RFC 5109, in a stream of its own
+	FECSchemeFlexFEC = "flexfec" // RFC 8627, with a fixed (row and column) mask
+)
+
+// FEC is the forward error correction of our streams: parity packets over the rows (and columns)
+// of blocks of their packets, from which a receiver recovers a packet it lost without asking for it again,
+// (e.g. on a one-way multicast link)
+type FEC struct {
+	// FECSchemeULPFEC or FECSchemeFlexFEC, no FEC if empty
+	Scheme string
+	// the packets of a row, protected by a parity packet
+	Columns int
+	// the rows of a block, whose columns are protected by a parity packet each too; 0 (or 1) for the rows only
+	Rows int
+}
+
+// the longest mask of ULPFEC, (its parity packets protect packets at most 48 sequence numbers apart)
+const ulpfecMaxMaskLength = 48
+
+// how long (in microseconds) our receivers wait for a lost packet to be recovered, see ReorderingPacketBuffer
+const fecRepairWindow = 100000
+
+// Validate checks that the receivers can recover the packets: a block is at most 256 packets,
+// (and the parity packets of ULPFEC protect packets at most 48 sequence numbers apart)
+func (f FEC) Validate() error {
+	switch f.Scheme {
+	case "":
+		return nil
+	case FECSchemeULPFEC, FECSchemeFlexFEC:
+	default:
+		return fmt.Errorf("unknown FEC scheme: %s", f.Scheme)
+	}
+
+	if f.Columns < 1 || f.Rows < 0 {
+		return errors.New("FEC needs a column at least, and no negative rows")
+	}
+	if f.Columns > 255 || f.Columns*f.rows() > packetHistorySize {
+		return fmt.Errorf("FEC blocks of more than %d packets", packetHistorySize)
+	}
+	if f.Scheme == FECSchemeULPFEC && f.span() > ulpfecMaxMaskLength {
+		return fmt.Errorf("ULPFEC parity packets over more than %d packets", ulpfecMaxMaskLength)
+	}
+	return nil
+}
+
+func (f FEC) rows() int {
+	if f.Rows > 1 {
+		return f.Rows
+	}
+	return 1
+}
+
+// span returns the most sequence numbers that a parity packet spans, that of a column (or a row)
+func (f FEC) span() int {
+	if f.Rows > 1 {
+		return (f.Rows-1)*f.Columns + 1
+	}
+	return f.Columns
+}
+
+// payloadType returns the payload type of the FEC stream of a stream, 0 if it has none
+// (the RTX stream of a stream is 16 after it, see RTCPFeedback)
+func (f FEC) payloadType(rtpPayloadType uint32) uint32 {
+	if f.Scheme == "" || rtpPayloadType < 96 || rtpPayloadType+24 > 127 {
+		return 0
+	}
+	return rtpPayloadType + 24
+}
+
+// sdpLines returns the "a=rtpmap" (and "a=fmtp") lines of the FEC stream of a stream
+func (f FEC) sdpLines(rtpPayloadType, timestampFrequency uint32) (lines string) {
+	fecPayloadType := f.payloadType(rtpPayloadType)
+	if fecPayloadType == 0 {
+		return ""
+	}
+
+	lines = fmt.Sprintf("a=rtpmap:%d %s/%d\r\n", fecPayloadType, f.Scheme, timestampFrequency)
+	if f.Scheme == FECSchemeFlexFEC {
+		lines += fmt.Sprintf("a=fmtp:%d repair-window=%d; L=%d; D=%d\r\n",
+			fecPayloadType, fecRepairWindow, f.Columns, f.Rows)
+	}
+	return
+}
+
+// fecRepair is the parity of a group of packets, from which the one packet of the group we lost is recovered
+type fecRepair struct {
+	seqNos []uint16
+	// the XOR of the first 8 bytes of their RTP headers, (but their sequence numbers)
+	header [8]byte
+	// the XOR of their lengths after the fixed RTP header
+	length uint16
+	// the XOR of their packets after the fixed RTP header, padded with zeros
+	payload []byte
+}
+
+// xor adds a packet to the parity
+func (r *fecRepair) xor(packet []byte) {
+	r.header[0] ^= packet[0]
+	r.header[1] ^= packet[1]
+	for i := 4; i < 8; i++ {
+		r.header[i] ^= packet[i]
+	}
+
+	body := packet[rtpHeaderSize:]
+	r.length ^= uint16(len(body))
+	if len(body) > len(r.payload) {
+		r.payload = append(r.payload, make([]byte, len(body)-len(r.payload))...)
+	}
+	for i, b := range body {
+		r.payload[i] ^= b
+	}
+}
+
+// recover returns the packet of the sequence number, from the other packets of the group
+func (r *fecRepair) recover(seqNo uint16, packets [][]byte, ssrc uint32) []byte {
+	parity := fecRepair{header: r.header, length: r.length, payload: append([]byte(nil), r.payload...)}
+	for _, packet := range packets {
+		parity.xor(packet)
+	}
+	if int(parity.length) > len(parity.payload) {
+		return nil
+	}
+
+	packet := make([]byte, rtpHeaderSize, int(rtpHeaderSize)+int(parity.length))
+	packet[0] = 0x80 | parity.header[0]&0x3F
+	packet[1] = parity.header[1]
+	binary.BigEndian.PutUint16(packet[2:], seqNo)
+	copy(packet[4:8], parity.header[4:8])
+	binary.BigEndian.PutUint32(packet[8:], ssrc)
+	return append(packet, parity.payload[:parity.length]...)
+}
+
+// parseFECRepair parses a parity packet, of the FEC scheme
+func parseFECRepair(scheme string, packet []byte) (*fecRepair, error) {
+	headerLength, ok := rtpHeaderLength(packet)
+	if !ok {
+		return nil, errors.New("bad RTP packet")
+	}
+	body := packet[headerLength:]
+	repair := new(fecRepair)
+
+	switch scheme {
+	case FECSchemeFlexFEC:
+		if len(body) < 12 {
+			return nil, errors.New("FlexFEC header too short")
+		}
+		if body[0]&0xC0 != 0x40 {
+			return nil, errors.New("FlexFEC retransmission or flexible mask, only a fixed one is supported")
+		}
+		repair.header[0], repair.header[1] = body[0]&0x3F, body[1]
+		repair.length = binary.BigEndian.Uint16(body[2:])
+		copy(repair.header[4:8], body[4:8])
+		snBase, columns, rows := binary.BigEndian.Uint16(body[8:]), int(body[10]), int(body[11])
+		if columns == 0 {
+			return nil, errors.New("FlexFEC mask of no columns")
+		}
+		if rows <= 1 {
+			// a row
+			for i := 0; i < columns; i++ {
+				repair.seqNos = append(repair.seqNos, snBase+uint16(i))
+			}
+		} else {
+			// a column
+			for i := 0; i < rows; i++ {
+				repair.seqNos = append(repair.seqNos, snBase+uint16(i*columns))
+			}
+		}
+		repair.payload = body[12:]
+	case FECSchemeULPFEC:
+		if len(body) < 14 {
+			return nil, errors.New("ULPFEC header too short")
+		}
+		if body[0]&0x80 != 0 {
+			return nil, errors.New("ULPFEC extension flag, it isn't supported")
+		}
+		maskLength := 2
+		if body[0]&0x40 != 0 {
+			maskLength = 6
+		}
+		repair.header[0], repair.header[1] = body[0]&0x3F, body[1]
+		snBase := binary.BigEndian.Uint16(body[2:])
+		copy(repair.header[4:8], body[4:8])
+		repair.length = binary.BigEndian.Uint16(body[8:])
+
+		// (the level 0 header, we only use that level)
+		protectionLength := int(binary.BigEndian.Uint16(body[10:]))
+		if len(body) < 12+maskLength+protectionLength {
+			return nil, errors.New("ULPFEC packet too short")
+		}
+		mask := body[12 : 12+maskLength]
+		for i := 0; i < 8*maskLength; i++ {
+			if mask[i/8]&(0x80>>uint(i%8)) != 0 {
+				repair.seqNos = append(repair.seqNos, snBase+uint16(i))
+			}
+		}
+		repair.payload = body[12+maskLength : 12+maskLength+protectionLength]
+	default:
+		return nil, fmt.Errorf("unknown FEC scheme: %s", scheme)
+	}
+
+	if len(repair.seqNos) == 0 {
+		return nil, errors.New("FEC packet protecting no packet")
+	}
+	repair.payload = append([]byte(nil), repair.payload...)
+	return repair, nil
+}
+
+// fecEncoder makes the parity packets of the packets we send, in a FEC stream of their own
+type fecEncoder struct {
+	fec         FEC
+	payloadType uint32
+	ssrc        uint32
+	seqNo       uint16
+	// the packets of the current block
+	block [][]byte
+}
+
+func newFECEncoder(fec FEC, payloadType uint32) *fecEncoder {
+	return &fecEncoder{
+		fec:         fec,
+		payloadType: payloadType,
+		ssrc:        gs.OurRandom32(),
+		seqNo:       uint16(gs.OurRandom16()),
+	}
+}
+
+// protect adds a packet we sent to the current block, and returns the parity packets
+// of the row (and columns) it completes
+func (e *fecEncoder) protect(packet []byte) (parityPackets [][]byte) {
+	e.block = append(e.block, append([]byte(nil), packet...))
+
+	numPackets := len(e.block)
+	if numPackets%e.fec.Columns == 0 {
+		parityPackets = append(parityPackets, e.parityPacket(e.block[numPackets-e.fec.Columns:], 1))
+	}
+	if numPackets == e.fec.Columns*e.fec.rows() {
+		if e.fec.Rows > 1 {
+			for column := 0; column < e.fec.Columns; column++ {
+				parityPackets = append(parityPackets, e.parityPacket(e.block[column:], e.fec.Columns))
+			}
+		}
+		e.block = e.block[:0]
+	}
+	return
+}
+
+// parityPacket returns the parity packet of every stride-th packet, (1 for a row, Columns for a column)
+func (e *fecEncoder) parityPacket(packets [][]byte, stride int) []byte {
+	var repair fecRepair
+	var numPackets int
+	for i := 0; i < len(packets); i += stride {
+		repair.xor(packets[i])
+		numPackets++
+	}
+	first, last := packets[0], packets[(numPackets-1)*stride]
+	snBase := binary.BigEndian.Uint16(first[2:])
+
+	// (the timestamp of the last packet it protects)
+	packet := make([]byte, rtpHeaderSize, int(rtpHeaderSize)+24+len(repair.payload))
+	packet[0] = 0x80
+	packet[1] = byte(e.payloadType)
+	binary.BigEndian.PutUint16(packet[2:], e.seqNo)
+	copy(packet[4:8], last[4:8])
+	binary.BigEndian.PutUint32(packet[8:], e.ssrc)
+	e.seqNo++
+
+	switch e.fec.Scheme {
+	case FECSchemeFlexFEC:
+		// the SSRC of the protected stream is our CSRC
+		packet[0] |= 1
+		packet = append(packet, first[8:12]...)
+
+		var rows byte
+		if stride > 1 {
+			rows = byte(numPackets)
+		}
+		packet = append(packet, 0x40|repair.header[0]&0x3F, repair.header[1])
+		packet = binary.BigEndian.AppendUint16(packet, repair.length)
+		packet = append(packet, repair.header[4:8]...)
+		packet = binary.BigEndian.AppendUint16(packet, snBase)
+		packet = append(packet, byte(e.fec.Columns), rows)
+	case FECSchemeULPFEC:
+		mask := make([]byte, 2)
+		if (numPackets-1)*stride >= 16 {
+			mask = make([]byte, 6)
+		}
+		for i := 0; i < numPackets; i++ {
+			mask[i*stride/8] |= 0x80 >> uint(i*stride%8)
+		}
+
+		b := repair.header[0] & 0x3F
+		if len(mask) > 2 {
+			b |= 0x40
+		}
+		packet = append(packet, b, repair.header[1])
+		packet = binary.BigEndian.AppendUint16(packet, snBase)
+		packet = append(packet, repair.header[4:8]...)
+		packet = binary.BigEndian.AppendUint16(packet, repair.length)
+		packet = binary.BigEndian.AppendUint16(packet, uint16(len(repair.payload)))
+		packet = append(packet, mask...)
+	}
+	return append(packet, repair.payload...)
+}
+
+// the most parity packets we keep, waiting for the packets they may recover
+const maxFECRepairs = 128
+
+// fecDecoder recovers the packets we lose from the parity packets of a FEC stream
+type fecDecoder struct {
+	scheme      string
+	payloadType uint32
+	// the last packets we received (or recovered)
+	received *packetHistory
+	// the parity packets that may still recover a packet
+	repairs []*fecRepair
+}
+
+func newFECDecoder(scheme string, payloadType uint32) *fecDecoder {
+	return &fecDecoder{
+		scheme:      scheme,
+		payloadType: payloadType,
+		received:    new(packetHistory),
+	}
+}
+
+// reset forgets the packets, e.g. of a previous SSRC
+func (d *fecDecoder) reset() {
+	d.received = new(packetHistory)
+	d.repairs = nil
+}
+
+// addPacket notes a packet we received, and returns the packets it lets us recover
+func (d *fecDecoder) addPacket(packet []byte, ssrc uint32) [][]byte {
+	d.received.add(packet)
+	return d.recover(ssrc)
+}
+
+// addParity notes a parity packet we received, and returns the packets it lets us recover
+func (d *fecDecoder) addParity(packet []byte, ssrc uint32) [][]byte {
+	repair, err := parseFECRepair(d.scheme, packet)
+	if err != nil {
+		rtpLog.Debug("bad FEC packet", "err", err)
+		return nil
+	}
+
+	d.repairs = append(d.repairs, repair)
+	if len(d.repairs) > maxFECRepairs {
+		d.repairs = d.repairs[1:]
+	}
+	return d.recover(ssrc)
+}
+
+// recover recovers the packet of each parity packet that lacks only one, (a recovered packet
+// may complete another parity packet, e.g. of its column after its row)
+func (d *fecDecoder) recover(ssrc uint32) (recovered [][]byte) {
+	for progress := true; progress; {
+		progress = false
+
+		repairs := d.repairs[:0]
+		for _, repair := range d.repairs {
+			var packets [][]byte
+			var missing []uint16
+			for _, seqNo := range repair.seqNos {
+				if packet := d.received.lookup(seqNo); packet != nil {
+					packets = append(packets, packet)
+				} else {
+					missing = append(missing, seqNo)
+				}
+			}
+
+			switch len(missing) {
+			case 0:
+				// we have them all
+			case 1:
+				if packet := repair.recover(missing[0], packets, ssrc); packet != nil {
+					d.received.add(packet)
+					recovered = append(recovered, packet)
+					progress = true
+				}
+			default:
+				repairs = append(repairs, repair)
+			}
+		}
+		d.repairs = repairs
+	}
+
+	if len(recovered) > 0 {
+		rtpLog.Debug("recovered packets", "ssrc", ssrc, "packets", len(recovered))
+	}
+	return
+}
+
+// enableFEC makes us send the parity packets of the FEC, in a FEC stream of the payload type
+func (s *MultiFramedRTPSink) enableFEC(fec FEC, fecPayloadType uint32) {
+	s.fec = newFECEncoder(fec, fecPayloadType)
+}
+
+// sendParityPackets sends the parity packets of the row (and columns) that a packet we sent completes,
+// (nothing without FEC)
+func (s *MultiFramedRTPSink) sendParityPackets(packet []byte) {
+	if s.fec == nil {
+		return
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	for _, parityPacket := range s.fec.protect(packet) {
+		s.rtpInterface.sendPacket(parityPacket, uint(len(parityPacket)))
+	}
+}
+
+// fillInBytes fills in a packet we didn't read, e.g. one we recovered
+func (p *BufferedPacket) fillInBytes(data []byte) {
+	p.tail += uint32(copy(p.buffer[p.tail:], data))
+}
+
+// fillInRecoveredPacket fills in the next packet that we recovered with the FEC, if any,
+// to be taken as if we had received it
+func (s *MultiFramedRTPSource) fillInRecoveredPacket(packet IBufferedPacket) bool {
+	if len(s.recoveredPackets) == 0 {
+		return false
+	}
+	packet.fillInBytes(s.recoveredPackets[0])
+	s.recoveredPackets = s.recoveredPackets[1:]
+	return true
+}
+
+// fecRawPacket returns a copy of the whole packet we read, for the FEC, nil without FEC
+func (s *MultiFramedRTPSource) fecRawPacket(packet IBufferedPacket) []byte {
+	if s.fec == nil {
+		return nil
+	}
+	return append([]byte(nil), packet.data()[:packet.dataSize()]...)
+}
+
+// addParityPacket takes a packet of the FEC stream, which may let us recover the packets we lost;
+// false if it's of another payload type
+func (s *MultiFramedRTPSource) addParityPacket(rtpPayloadFormat uint32, rawPacket []byte) bool {
+	if s.fec == nil || rtpPayloadFormat != s.fec.payloadType {
+		return false
+	}
+	s.recoveredPackets = append(s.recoveredPackets, s.fec.addParity(rawPacket, s.lastReceivedSSRC)...)
+	return true
+}
+
+// addProtectedPacket takes a packet of our stream that the FEC protects, (nothing for a nil one)
+func (s *MultiFramedRTPSource) addProtectedPacket(rawPacket []byte, ssrc uint32) {
+	if rawPacket != nil {
+		s.recoveredPackets = append(s.recoveredPackets, s.fec.addPacket(rawPacket, ssrc)...)
+	}
+}
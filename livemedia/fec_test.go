@@ -0,0 +1,77 @@
+package livemedia
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestFEC(t *testing.T) {
+	// (three packets of the first row lost, which their columns recover, one of them only
+	// after the row of another lost packet of its column recovers that one)
+	lostInBlock := map[uint16]bool{65534: true, 65535: true, 0: true, 7: true}
+	for _, test := range []struct {
+		fec  FEC
+		lost map[uint16]bool
+	}{
+		{FEC{Scheme: FECSchemeFlexFEC, Columns: 4, Rows: 3}, lostInBlock},
+		{FEC{Scheme: FECSchemeULPFEC, Columns: 4, Rows: 3}, lostInBlock},
+		{FEC{Scheme: FECSchemeULPFEC, Columns: 20}, map[uint16]bool{7: true}},
+	} {
+		fec, lost := test.fec, test.lost
+		if err := fec.Validate(); err != nil {
+			fmt.Println(err)
+			t.Error("failed")
+			return
+		}
+
+		encoder := newFECEncoder(fec, 120)
+		decoder := newFECDecoder(fec.Scheme, 120)
+
+		sent := make(map[uint16][]byte)
+		recovered := make(map[uint16][]byte)
+		for i := 0; i < 24; i++ {
+			seqNo := uint16(65534 + i)
+			packet := make([]byte, rtpHeaderSize, 100)
+			packet[0], packet[1] = 0x80, 96
+			if i%3 == 0 {
+				packet[1] |= 0x80
+			}
+			binary.BigEndian.PutUint16(packet[2:], seqNo)
+			binary.BigEndian.PutUint32(packet[4:], uint32(3000*(i/3)))
+			binary.BigEndian.PutUint32(packet[8:], 0x1234)
+			for j := 0; j < 10+i*3; j++ {
+				packet = append(packet, byte(i+j))
+			}
+			sent[seqNo] = packet
+
+			parityPackets := encoder.protect(packet)
+			if !lost[seqNo] {
+				for _, p := range decoder.addPacket(packet, 0x1234) {
+					recovered[binary.BigEndian.Uint16(p[2:])] = p
+				}
+			}
+			for _, parityPacket := range parityPackets {
+				for _, p := range decoder.addParity(parityPacket, 0x1234) {
+					recovered[binary.BigEndian.Uint16(p[2:])] = p
+				}
+			}
+		}
+
+		for seqNo := range lost {
+			if !bytes.Equal(recovered[seqNo], sent[seqNo]) {
+				fmt.Println(fec, seqNo, recovered[seqNo], sent[seqNo])
+				t.Error("failed")
+				return
+			}
+		}
+	}
+
+	if (FEC{Scheme: FECSchemeULPFEC, Columns: 10, Rows: 10}).Validate() == nil ||
+		(FEC{Scheme: "raptor", Columns: 10}).Validate() == nil {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
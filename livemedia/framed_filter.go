@@ -8,3 +8,8 @@ type FramedFilter struct {
 func (f *FramedFilter) initFramedFilter(inputSource IFramedSource) {
 	f.inputSource = inputSource
 }
+
+// InputSource returns the source the filter reads from
+func (f *FramedFilter) InputSource() IFramedSource {
+	return f.inputSource
+}
@@ -19,6 +19,10 @@ func NewH264FileMediaSubsession(fileName string) *H264FileMediaSubsession {
 	return subsession
 }
 
+func (s *H264FileMediaSubsession) Codec() string {
+	return "H264"
+}
+
 func (s *H264FileMediaSubsession) createNewStreamSource() IFramedSource {
 	//estBitrate = 500 // kbps, estimate
 
@@ -46,9 +50,17 @@ func (s *H264FileMediaSubsession) getAuxSDPLine(rtpSink IMediaSink, inputSource
 		s.dummyRTPSink = rtpSink
 
 		// start reading the file
-		go s.dummyRTPSink.StartPlaying(inputSource, s.afterPlayingDummy)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.dummyRTPSink.StartPlaying(inputSource, s.afterPlayingDummy)
+		}()
 
 		s.checkForAuxSDPLine()
+
+		// (our caller destroys the source, once the goroutine is done with it)
+		s.dummyRTPSink.StopPlaying()
+		<-done
 	}
 	return s.auxSDPLine
 }
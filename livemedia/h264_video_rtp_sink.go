@@ -31,7 +31,7 @@ func (s *H264VideoRTPSink) destroy() {
 
 func (s *H264VideoRTPSink) ContinuePlaying() {
 	if s.ourFragmenter == nil {
-		s.ourFragmenter = newH264FUAFragmenter(s.Source, OutPacketBufferMaxSize, s.ourMaxPacketSize-12)
+		s.ourFragmenter = newH264FUAFragmenter(s.Source, s.outBuf.totalBufferSize(), s.ourMaxPacketSize-12)
 	} else {
 		// reassign input source
 		s.ourFragmenter.initFramedFilter(s.Source)
@@ -42,6 +42,9 @@ func (s *H264VideoRTPSink) ContinuePlaying() {
 }
 
 func (s *H264VideoRTPSink) AuxSDPLine() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if len(s.sps) == 0 || len(s.pps) == 0 {
 		if s.ourFragmenter == nil {
 			return ""
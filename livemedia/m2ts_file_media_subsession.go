@@ -16,6 +16,10 @@ func NewM2TSFileMediaSubsession(fileName string) *M2TSFileMediaSubsession {
 	return subsession
 }
 
+func (s *M2TSFileMediaSubsession) Codec() string {
+	return "MP2T"
+}
+
 func (s *M2TSFileMediaSubsession) createNewStreamSource() IFramedSource {
 	//inputDataChunkSize := TRANSPORT_PACKETS_PER_NETWORK_PACKET * TRANSPORT_PACKET_SIZE
 
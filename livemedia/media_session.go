@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	gs "github.com/djwackey/dorsvr/groupsock"
 )
@@ -91,7 +92,7 @@ func (s *MediaSession) initWithSDP(sdpLine string) bool {
 	}
 
 	var payloadFormat uint32
-	var n1, n2, n3, n4, n5 int
+	var n1, n2, n3, n4, n5, n6 int
 	var mediumName, protocolName string
 	for {
 		subsession := NewMediaSubsession(s)
@@ -110,9 +111,15 @@ func (s *MediaSession) initWithSDP(sdpLine string) bool {
 			&subsession.clientPortNum, &payloadFormat)
 		n5, _ = fmt.Sscanf(thisSDPLine, "m=%s %d RAW/RAW/UDP %d", &mediumName,
 			&subsession.clientPortNum, &payloadFormat)
+		n6, _ = fmt.Sscanf(thisSDPLine, "m=%s %d RTP/SAVP %d", &mediumName,
+			&subsession.clientPortNum, &payloadFormat)
 
 		if (n1 == 3 || n2 == 3) && payloadFormat <= 127 {
 			protocolName = "RTP"
+		} else if n6 == 3 && payloadFormat <= 127 {
+			// SRTP, keyed by a "a=crypto:" line
+			protocolName = "RTP"
+			subsession.isSecureProfile = true
 		} else if (n3 == 3 || n4 == 3 || n5 == 3) && payloadFormat <= 127 {
 			// This is a RAW UDP source
 			protocolName = "UDP"
@@ -157,12 +164,24 @@ func (s *MediaSession) initWithSDP(sdpLine string) bool {
 			if subsession.parseSDPAttributeRtpmap(thisSDPLine) {
 				continue
 			}
+			if subsession.parseSDPAttributeRtcpFb(thisSDPLine) {
+				continue
+			}
+			if subsession.parseSDPAttributeRtcpXr(thisSDPLine) {
+				continue
+			}
+			if subsession.parseSDPAttributeRtcpMux(thisSDPLine) {
+				continue
+			}
 			if subsession.parseSDPAttributeControl(thisSDPLine) {
 				continue
 			}
 			if subsession.parseSDPAttributeRange(thisSDPLine) {
 				continue
 			}
+			if subsession.parseSDPAttributeCrypto(thisSDPLine) {
+				continue
+			}
 			if subsession.parseSDPAttributeFmtp(thisSDPLine) {
 				continue
 			}
@@ -222,6 +241,34 @@ func (s *MediaSession) Subsession() *MediaSubsession {
 	return s.mediaSubsessions[s.subsessionIndex-1]
 }
 
+// DeliverInterleavedPacket hands a RTP or RTCP packet that arrived interleaved
+// on the RTSP connection to the subsession that owns its stream channel id.
+func (s *MediaSession) DeliverInterleavedPacket(channelID uint, packet []byte) bool {
+	for i := 0; i < s.subsessionNum; i++ {
+		if s.mediaSubsessions[i].deliverInterleavedPacket(channelID, packet) {
+			return true
+		}
+	}
+	return false
+}
+
+// SendRTCPReports sends a RTCP report for each subsession right away,
+// instead of waiting for the next scheduled one.
+func (s *MediaSession) SendRTCPReports() {
+	for i := 0; i < s.subsessionNum; i++ {
+		if rtcp := s.mediaSubsessions[i].rtcpInstance; rtcp != nil {
+			rtcp.sendReport()
+		}
+	}
+}
+
+// Close releases the sockets and RTCP instances of all of our subsessions.
+func (s *MediaSession) Close() {
+	for i := 0; i < s.subsessionNum; i++ {
+		s.mediaSubsessions[i].deInitiate()
+	}
+}
+
 func (s *MediaSession) parseSDPLine(inputLine string) (nextLine, thisLine string, result bool) {
 	inputLen := len(inputLine)
 
@@ -244,8 +291,20 @@ func (s *MediaSession) parseSDPLine(inputLine string) (nextLine, thisLine string
 	return
 }
 
+// parseCLine returns the address of a "c=IN IP4 <address>[/<ttl>[/<numAddresses>]]"
+// or "c=IN IP6 <address>[/<numAddresses>]" line.
 func parseCLine(sdpLine string) (result string) {
-	fmt.Sscanf(sdpLine, "c=IN IP4 %s", &result)
+	var addrType string
+	if n, _ := fmt.Sscanf(sdpLine, "c=IN %s %s", &addrType, &result); n != 2 {
+		return ""
+	}
+	if addrType != "IP4" && addrType != "IP6" {
+		return ""
+	}
+
+	if index := strings.Index(result, "/"); index != -1 {
+		result = result[:index]
+	}
 	return
 }
 
@@ -275,6 +334,7 @@ func (s *MediaSession) parseSDPLineI(sdpLine string) bool {
 
 // Check for "c=IN IP4 <connection-endpoint>"
 // or "c=IN IP4 <connection-endpoint>/<ttl+numAddresses>"
+// or "c=IN IP6 <connection-endpoint>[/<numAddresses>]"
 // (Later, do something with <ttl+numAddresses> also #####)
 func (s *MediaSession) parseSDPLineC(sdpLine string) bool {
 	connectionEndpointName := parseCLine(sdpLine)
@@ -358,14 +418,18 @@ func (s *MediaSession) parseSDPAttributeRange(sdpLine string) bool {
 	return parseSuccess
 }
 
-// Check for a "a=source-filter:incl IN IP4 <something> <source>" line.
+// Check for a "a=source-filter:incl IN IP4|IP6 <something> <source>" line.
 // Note: At present, we don't check that <something> really matches
 // one of our multicast addresses.  We also don't support more than
 // one <source> #####
 func parseSourceFilterAttribute(sdpLine string) bool {
-	var sourceName string
-	n, _ := fmt.Sscanf(sdpLine, "a=source-filter: incl IN IP4 %*s %s", &sourceName)
-	return (n == 1)
+	if !strings.HasPrefix(sdpLine, "a=source-filter:") {
+		return false
+	}
+
+	fields := strings.Fields(sdpLine[16:])
+	return len(fields) == 5 && fields[0] == "incl" && fields[1] == "IN" &&
+		(fields[2] == "IP4" || fields[2] == "IP6")
 }
 
 func (s *MediaSession) parseSDPAttributeSourceFilter(sdpLine string) bool {
@@ -471,6 +535,8 @@ type MediaSubsession struct {
 	rtpChannelID           uint
 	rtcpChannelID          uint
 	rtpPayloadFormat       uint32
+	rtxPayloadFormat       uint32
+	fecPayloadFormat       uint32
 	rtpTimestampFrequency  uint32
 	clientPortNum          uint
 	serverPortNum          uint
@@ -486,6 +552,16 @@ type MediaSubsession struct {
 	absStartTime           string
 	absEndTime             string
 	connectionEndpointName string
+	fecScheme              string
+	srtpMasterKey          []byte
+	srtpProfile            SRTPProfile
+	isSecureProfile        bool
+	feedbackNACK           bool
+	feedbackPLI            bool
+	feedbackFIR            bool
+	extendedReports        bool
+	rtcpMuxOffered         bool // by an "a=rtcp-mux" line
+	rtcpMux                bool // our RTCP goes over our RTP socket, as the server accepted in its SETUP response
 	playStartTime          float64
 	playEndTime            float64
 	videoFPS               float32
@@ -518,12 +594,21 @@ func (s *MediaSubsession) Initiate() bool {
 		return false
 	}
 
+	// For a multicast session, we join the group on the port from the "m=" line;
+	// otherwise we receive on any of our addresses (IPv4 or IPv6), on a port we choose.
 	tempAddr := s.ConnectionEndpointName()
+	isMulticast := gs.IsMulticastAddress(tempAddr)
+	var rtpPortNum uint
+	if isMulticast {
+		rtpPortNum = s.clientPortNum
+	} else {
+		tempAddr = ""
+	}
 
 	var success bool
 	for {
 		// create new socket
-		s.rtpSocket = gs.NewGroupSock(tempAddr, 0)
+		s.rtpSocket = gs.NewGroupSock(tempAddr, rtpPortNum)
 		if s.rtpSocket == nil {
 			fmt.Println("Unable to create RTP socket")
 			break
@@ -535,14 +620,14 @@ func (s *MediaSubsession) Initiate() bool {
 			break
 		}
 
-		if clientPortNum&1 != 0 {
+		if clientPortNum&1 != 0 && !isMulticast {
 			s.rtpSocket.Close()
 			continue
 		}
 
 		s.clientPortNum = clientPortNum
 
-		rtcpPortNum := clientPortNum | 1
+		rtcpPortNum := clientPortNum + 1
 		s.rtcpSocket = gs.NewGroupSock(tempAddr, rtcpPortNum)
 		if s.rtcpSocket == nil {
 			fmt.Println("Unable to create RTCP socket")
@@ -580,9 +665,31 @@ func (s *MediaSubsession) Initiate() bool {
 	}
 
 	s.rtcpInstance = newRTCPInstance(s.rtcpSocket, totSessionBandwidth, s.parent.cname, nil, s.RTPSource)
+	s.rtcpInstance.extendedReports.Store(s.extendedReports)
+	if s.RTPSource != nil {
+		s.enableRTCPFeedback()
+		if s.fecPayloadFormat != 0 {
+			// recover the packets we lose, from the parity packets of the FEC stream
+			s.RTPSource.fec = newFECDecoder(s.fecScheme, s.fecPayloadFormat)
+		}
+	}
 	return true
 }
 
+// enableRTCPFeedback makes our source NACK the packets it misses, and ask for a keyframe when it has
+// given up on some, as (and if) the "a=rtcp-fb" lines of the SDP description offer, (RFC 4585)
+func (s *MediaSubsession) enableRTCPFeedback() {
+	if s.feedbackNACK {
+		s.RTPSource.lostPacketsHandler = s.rtcpInstance.sendNACK
+		s.RTPSource.rtxPayloadFormat = s.rtxPayloadFormat
+	}
+	if s.feedbackPLI {
+		s.RTPSource.packetLossHandler = s.rtcpInstance.sendPLI
+	} else if s.feedbackFIR {
+		s.RTPSource.packetLossHandler = s.rtcpInstance.sendFIR
+	}
+}
+
 func (s *MediaSubsession) Scale() float32 {
 	return s.scale
 }
@@ -611,7 +718,25 @@ func (s *MediaSubsession) SessionID() string {
 	return s.sessionID
 }
 
-func (subsession *MediaSubsession) deInitiate() {
+func (s *MediaSubsession) deInitiate() {
+	if s.rtcpInstance != nil {
+		s.rtcpInstance.destroy()
+		s.rtcpInstance = nil
+	}
+	if s.RTPSource != nil {
+		s.RTPSource.rtpInterface.stopNetworkReading()
+	}
+	if s.rtpSocket != nil {
+		s.rtpSocket.Close()
+		s.rtpSocket = nil
+	}
+	if s.rtcpSocket != nil {
+		s.rtcpSocket.Close()
+		s.rtcpSocket = nil
+	}
+	s.rtcpMux = false
+	s.readSource = nil
+	s.RTPSource = nil
 }
 
 func (s *MediaSubsession) AbsStartTime() string {
@@ -658,12 +783,154 @@ func (s *MediaSubsession) RtcpInstance() *RTCPInstance {
 	return s.rtcpInstance
 }
 
+// LastSenderReport returns the last RTCP SR of the stream we receive, if one has arrived yet
+func (s *MediaSubsession) LastSenderReport() (SenderReport, bool) {
+	if s.RTPSource == nil {
+		return SenderReport{}, false
+	}
+	return s.RTPSource.receptionStatsDB.lastSenderReport()
+}
+
+// RoundTripTime returns the round-trip time to the server, as of its last answer to our RTCP XRs,
+// (0 if it doesn't answer them)
+func (s *MediaSubsession) RoundTripTime() time.Duration {
+	if s.rtcpInstance == nil {
+		return 0
+	}
+	return s.rtcpInstance.RoundTripTime()
+}
+
+// PresentationTime returns the wallclock time of a RTP timestamp of the stream we receive,
+// as mapped by the last SR of its sender, (so the presentation times of the subsessions are
+// synchronized, e.g. audio and video for lip-sync), or false if no SR has arrived yet
+func (s *MediaSubsession) PresentationTime(rtpTimestamp uint32) (time.Time, bool) {
+	report, ok := s.LastSenderReport()
+	if !ok {
+		return time.Time{}, false
+	}
+	return report.NTPTime.Add(timestampDuration(rtpTimestamp-report.RTPTime, s.rtpTimestampFrequency)), true
+}
+
+// HasBeenSynchronizedUsingRTCP reports whether the presentation time of the current frame
+// is synchronized by a SR, (until then it's only our own wallclock time at its arrival)
+func (s *MediaSubsession) HasBeenSynchronizedUsingRTCP() bool {
+	return s.RTPSource != nil && s.RTPSource.curPacketSyncUsingRTCP
+}
+
+// SetDestinations sends our RTCP reports (and feedback) to the server, at the port after
+// its RTP port, (or at its RTP port, with rtcp-mux), unless the session is multicast
 func (s *MediaSubsession) SetDestinations(destAddress string) {
+	if s.serverPortNum == 0 || gs.IsMulticastAddress(s.ConnectionEndpointName()) {
+		return
+	}
+	if s.rtcpMux {
+		s.rtpSocket.AddDestination(destAddress, s.serverPortNum)
+	} else if s.rtcpSocket != nil {
+		s.rtcpSocket.AddDestination(destAddress, s.serverPortNum+1)
+	}
+}
+
+// SendPunchPackets sends a packet from our RTP port (and from our RTCP port, unless it's muxed)
+// to the server's, so that a NAT in front of us lets the server's packets in, and the server
+// learns the ports the NAT gives us, (if it does symmetric RTP, see StreamSettings.SymmetricRTPWindow)
+func (s *MediaSubsession) SendPunchPackets(destAddress string) {
+	if s.serverPortNum == 0 || gs.IsMulticastAddress(s.ConnectionEndpointName()) {
+		return
+	}
+	// (twice, in case one is lost)
+	for i := 0; i < 2; i++ {
+		if s.rtpSocket != nil {
+			s.rtpSocket.OutputTo(destAddress, s.serverPortNum, punchPacket)
+		}
+		if s.rtcpSocket != nil && !s.rtcpMux {
+			s.rtcpSocket.OutputTo(destAddress, s.serverPortNum+1, punchPacket)
+		}
+	}
+}
+
+// OffersRTCPMux returns whether the SDP description offers RTCP on the RTP ports, (RFC 5761)
+// which we then ask for, in our SETUP request
+func (s *MediaSubsession) OffersRTCPMux() bool {
+	return s.rtcpMuxOffered
+}
+
+// SetRTCPMux makes the subsession send and receive its RTCP on its RTP socket, as the server
+// accepted in its SETUP response, and frees its RTCP socket; (if the server didn't, we keep using both)
+func (s *MediaSubsession) SetRTCPMux() {
+	if s.rtcpMux || s.rtcpInstance == nil || s.rtpSocket == nil {
+		return
+	}
+
+	var rtpInterface *RTPInterface
+	if s.RTPSource != nil {
+		rtpInterface = s.RTPSource.rtpInterface
+	}
+	// (which closes our RTCP socket)
+	s.rtcpInstance.netInterface.setRTCPMux(s.rtpSocket, rtpInterface)
+	s.rtcpSocket = nil
+	s.rtcpMux = true
+}
+
+// IsRTCPMux returns whether the RTCP of the subsession goes over its RTP socket
+func (s *MediaSubsession) IsRTCPMux() bool {
+	return s.rtcpMux
+}
+
+// SetStreamSocket makes the subsession receive its RTP and RTCP packets
+// interleaved on the RTSP connection, using the channel ids from the SETUP response.
+func (s *MediaSubsession) SetStreamSocket() {
+	if s.RTPSource != nil {
+		s.RTPSource.SetStreamSocket()
+	}
+	if s.rtcpInstance != nil {
+		s.rtcpInstance.netInterface.setStreamSocket()
+	}
+}
+
+func (s *MediaSubsession) deliverInterleavedPacket(channelID uint, packet []byte) bool {
+	switch channelID {
+	case s.rtpChannelID:
+		if s.RTPSource != nil {
+			return s.RTPSource.rtpInterface.deliverStreamPacket(packet)
+		}
+	case s.rtcpChannelID:
+		if s.rtcpInstance != nil {
+			return s.rtcpInstance.netInterface.deliverStreamPacket(packet)
+		}
+	}
+	return false
+}
+
+// ConnectionEndpointName returns the address from our "c=" line (or the session's),
+// or the "source=" of the SETUP response, once we have one.
+// IsSecure returns whether the subsession is offered as SRTP ("RTP/SAVP"), with a key we understand.
+func (s *MediaSubsession) IsSecure() bool {
+	return s.isSecureProfile && s.srtpMasterKey != nil
+}
+
+// EnableSRTP makes the subsession's source expect SRTP and SRTCP, once it has been set up
+// with a "RTP/SAVP" (or "RTP/SAVP/TCP") transport; (the "RTP/AVP/TCP" streams of a RTSPS
+// connection aren't SRTP).
+func (s *MediaSubsession) EnableSRTP() bool {
+	if !s.IsSecure() || s.RTPSource == nil {
+		return false
+	}
+
+	if err := s.RTPSource.enableSRTP(s.srtpProfile, s.srtpMasterKey); err != nil {
+		fmt.Println("failed to enable SRTP.", err)
+		return false
+	}
+	if s.rtcpInstance != nil {
+		s.rtcpInstance.enableSRTP(s.RTPSource.srtpContext())
+	}
+	return true
 }
 
 func (s *MediaSubsession) ConnectionEndpointName() (name string) {
 	name = s.connectionEndpointName
-	name, _ = gs.OurIPAddress()
+	if name == "" {
+		name = s.parent.connectionEndpointName
+	}
 	return
 }
 
@@ -680,8 +947,10 @@ func (s *MediaSubsession) createSourceObject() bool {
 	} else {
 		switch s.codecName {
 		case "H264":
-			s.readSource = newH264VideoRTPSource(s.rtpSocket,
+			source := newH264VideoRTPSource(s.rtpSocket,
 				s.rtpPayloadFormat, s.rtpTimestampFrequency)
+			s.readSource = source
+			s.RTPSource = &source.RTPSource
 		}
 	}
 	return true
@@ -694,6 +963,7 @@ func (s *MediaSubsession) parseSDPLineB(sdpLine string) bool {
 
 // Check for "c=IN IP4 <connection-endpoint>"
 // or "c=IN IP4 <connection-endpoint>/<ttl+numAddresses>"
+// or "c=IN IP6 <connection-endpoint>[/<numAddresses>]"
 // (Later, do something with <ttl+numAddresses> also #####)
 func (s *MediaSubsession) parseSDPLineC(sdpLine string) bool {
 	connectionEndpointName := parseCLine(sdpLine)
@@ -725,9 +995,23 @@ func (s *MediaSubsession) parseSDPAttributeRtpmap(sdpLine string) bool {
 		if err != nil {
 			break
 		}
-		s.rtpPayloadFormat = uint32(rtpPayloadFormat)
 
 		value := strings.Split(fields[1], "/")
+		if strings.EqualFold(value[0], "rtx") {
+			// the RTX stream of the retransmitted packets (RFC 4588), not our codec
+			s.rtxPayloadFormat = uint32(rtpPayloadFormat)
+			parseSuccess = true
+			break
+		}
+		if strings.EqualFold(value[0], FECSchemeULPFEC) || strings.EqualFold(value[0], FECSchemeFlexFEC) {
+			// the FEC stream of the parity packets, (RFC 5109 or 8627)
+			s.fecPayloadFormat = uint32(rtpPayloadFormat)
+			s.fecScheme = strings.ToLower(value[0])
+			parseSuccess = true
+			break
+		}
+		s.rtpPayloadFormat = uint32(rtpPayloadFormat)
+
 		if len(value) == 2 {
 			s.codecName = value[0]
 
@@ -789,6 +1073,45 @@ func (s *MediaSubsession) parseSDPAttributeRange(sdpLine string) bool {
 	return parseSuccess
 }
 
+// Check for a "a=rtcp-fb:<payload type> nack|nack pli|ccm fir" line:
+func (s *MediaSubsession) parseSDPAttributeRtcpFb(sdpLine string) bool {
+	if !strings.HasPrefix(sdpLine, "a=rtcp-fb:") {
+		return false
+	}
+
+	fields := strings.Fields(sdpLine[10:])
+	if len(fields) < 2 || (fields[0] != "*" && fields[0] != strconv.Itoa(int(s.rtpPayloadFormat))) {
+		return true
+	}
+	switch strings.ToLower(strings.Join(fields[1:], " ")) {
+	case "nack":
+		s.feedbackNACK = true
+	case "nack pli":
+		s.feedbackPLI = true
+	case "ccm fir":
+		s.feedbackFIR = true
+	}
+	return true
+}
+
+// Check for a "a=rtcp-xr" line, (we send the XR blocks we have to any sender that takes some)
+func (s *MediaSubsession) parseSDPAttributeRtcpXr(sdpLine string) bool {
+	if !strings.HasPrefix(sdpLine, "a=rtcp-xr") {
+		return false
+	}
+	s.extendedReports = true
+	return true
+}
+
+// Check for a "a=rtcp-mux" line, (the server takes RTCP on its RTP port, if we ask for it in our SETUP)
+func (s *MediaSubsession) parseSDPAttributeRtcpMux(sdpLine string) bool {
+	if strings.TrimSpace(sdpLine) != "a=rtcp-mux" {
+		return false
+	}
+	s.rtcpMuxOffered = true
+	return true
+}
+
 func (s *MediaSubsession) parseSDPAttributeFmtp(sdpLine string) bool {
 	return true
 }
@@ -833,3 +1156,18 @@ func (s *MediaSubsession) parseSDPAttributeFrameRate(sdpLine string) bool {
 
 	return parseSuccess
 }
+
+// Check for a "a=crypto:<tag> <crypto-suite> inline:<key-params>" line:
+func (s *MediaSubsession) parseSDPAttributeCrypto(sdpLine string) bool {
+	if !strings.HasPrefix(sdpLine, "a=crypto:") {
+		return false
+	}
+
+	// use the first offer that we support
+	if s.srtpMasterKey == nil {
+		if profile, masterKey, ok := parseSRTPCryptoAttribute(sdpLine); ok {
+			s.srtpProfile, s.srtpMasterKey = profile, masterKey
+		}
+	}
+	return true
+}
@@ -48,3 +48,28 @@ func TestConnectionEndpointName(t *testing.T) {
 	//fmt.Println("Connection Endpoint Name:", endPointName)
 	t.Log("success")
 }
+
+func TestParseCLine(t *testing.T) {
+	lines := map[string]string{
+		"c=IN IP4 0.0.0.0":         "0.0.0.0",
+		"c=IN IP4 232.1.1.1/127":   "232.1.1.1",
+		"c=IN IP6 ::":              "::",
+		"c=IN IP6 ff15::101/3":     "ff15::101",
+		"c=IN IP6 2001:db8::1":     "2001:db8::1",
+		"c=IN ATM 47.0005.80.ffe1": "",
+	}
+
+	var verify bool = true
+	for line, expected := range lines {
+		if endpoint := parseCLine(line); endpoint != expected {
+			fmt.Println("parse c= line error", line, endpoint)
+			verify = false
+		}
+	}
+
+	if verify {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
@@ -3,13 +3,15 @@ package livemedia
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"net"
+	"sync"
 	sys "syscall"
 
 	"github.com/djwackey/gitea/log"
 )
 
-// default
+// default, see StreamSettings.OutPacketBufferMaxSize
 var OutPacketBufferMaxSize uint = 2000000
 
 //////// OutPacketBuffer ////////
@@ -26,8 +28,8 @@ type OutPacketBuffer struct {
 	overflowPresentationTime       sys.Timeval
 }
 
-func newOutPacketBuffer(preferredPacketSize, maxPacketSize uint) *OutPacketBuffer {
-	maxNumPackets := (OutPacketBufferMaxSize + (maxPacketSize - 1)) / maxPacketSize
+func newOutPacketBuffer(preferredPacketSize, maxPacketSize, maxBufferSize uint) *OutPacketBuffer {
+	maxNumPackets := (maxBufferSize + (maxPacketSize - 1)) / maxPacketSize
 	limit := maxNumPackets * maxPacketSize
 
 	b := &OutPacketBuffer{
@@ -204,7 +206,7 @@ type IMediaSink interface {
 	rtpmapLine() string
 	sdpMediaType() string
 	enableRTCPReports() bool
-	nextTimestampHasBeenPreset() bool
+	senderReportTimestamp(tv sys.Timeval) (uint32, bool)
 	StartPlaying(source IFramedSource, afterFunc interface{}) bool
 	StopPlaying()
 	ContinuePlaying()
@@ -217,6 +219,13 @@ type IMediaSink interface {
 	presetNextTimestamp() uint32
 	convertToRTPTimestamp(tv sys.Timeval) uint32
 	transmissionStatsDB() *RTPTransmissionStatsDB
+	timestampFrequency() uint32
+	srtpContext() *srtpContext
+	enableSRTP(profile SRTPProfile, masterKey []byte) error
+	enableRetransmission(rtxPayloadType uint32)
+	retransmit(seqNos []uint16)
+	enableFEC(fec FEC, fecPayloadType uint32)
+	setOutPacketBufferMaxSize(size uint)
 	addStreamSocket(socketNum net.Conn, streamChannelID uint)
 	delStreamSocket(socketNum net.Conn, streamChannelID uint)
 	setServerRequestAlternativeByteHandler(socketNum net.Conn, handler interface{})
@@ -229,13 +238,21 @@ type MediaSink struct {
 	Source    IFramedSource
 	rtpSink   IMediaSink
 	afterFunc interface{}
+	// held by the goroutine that plays the sink, (see StartPlaying) but while it waits for its next packet,
+	// and taken by the other goroutines which stop the sink or read its state
+	mutex sync.Mutex
 }
 
 func (s *MediaSink) InitMediaSink(rtpSink IMediaSink) {
 	s.rtpSink = rtpSink
 }
 
+// StartPlaying plays the sink from source, and returns as it stops, unless the sink
+// gets its frames asynchronously (e.g. from a network source)
 func (s *MediaSink) StartPlaying(source IFramedSource, afterFunc interface{}) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if s.Source != nil {
 		log.Error(1, "This sink is already being played")
 		return false
@@ -253,6 +270,9 @@ func (s *MediaSink) StartPlaying(source IFramedSource, afterFunc interface{}) bo
 }
 
 func (s *MediaSink) StopPlaying() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	// First, tell the source that we're no longer interested:
 	if s.Source != nil {
 		s.Source.stopGettingFrames()
@@ -277,7 +297,6 @@ func (s *MediaSink) doSpecialFrameHandling(fragmentationOffset, numBytesInFrame,
 }
 func (s *MediaSink) setServerRequestAlternativeByteHandler(socketNum net.Conn, handler interface{}) {}
 
-func (s *MediaSink) nextTimestampHasBeenPreset() bool             { return true }
 func (s *MediaSink) enableRTCPReports() bool                      { return true }
 func (s *MediaSink) AuxSDPLine() string                           { return "" }
 func (s *MediaSink) rtpmapLine() string                           { return "" }
@@ -285,9 +304,21 @@ func (s *MediaSink) sdpMediaType() string                         { return "" }
 func (s *MediaSink) presetNextTimestamp() uint32                  { return 0 }
 func (s *MediaSink) convertToRTPTimestamp(tv sys.Timeval) uint32  { return 0 }
 func (s *MediaSink) transmissionStatsDB() *RTPTransmissionStatsDB { return nil }
+func (s *MediaSink) timestampFrequency() uint32                   { return 0 }
 func (s *MediaSink) rtpPayloadType() uint32                       { return 0 }
 func (s *MediaSink) currentSeqNo() uint32                         { return 0 }
 func (s *MediaSink) packetCount() uint                            { return 0 }
 func (s *MediaSink) octetCount() uint                             { return 0 }
 func (s *MediaSink) ssrc() uint32                                 { return 0 }
 func (s *MediaSink) destroy()                                     {}
+func (s *MediaSink) srtpContext() *srtpContext                    { return nil }
+func (s *MediaSink) enableRetransmission(rtxPayloadType uint32)   {}
+func (s *MediaSink) retransmit(seqNos []uint16)                   {}
+func (s *MediaSink) enableFEC(fec FEC, fecPayloadType uint32)     {}
+func (s *MediaSink) setOutPacketBufferMaxSize(size uint)          {}
+func (s *MediaSink) enableSRTP(profile SRTPProfile, masterKey []byte) error {
+	return errors.New("SRTP is only supported by RTP sinks")
+}
+func (s *MediaSink) senderReportTimestamp(tv sys.Timeval) (uint32, bool) {
+	return 0, false
+}
@@ -67,12 +67,7 @@ func (f *MPEGVideoStreamFramer) computePresentationTime(numAdditionalPictures ui
 	pictureFractionOfSecond := pictureTime - pictureSeconds
 
 	f.presentationTime = f.presentationTimeBase
-	f.presentationTime.Sec += int32(tcSecs + pictureSeconds)
-	f.presentationTime.Usec += int32(pictureFractionOfSecond * 1000000.0)
-	if f.presentationTime.Usec >= 1000000 {
-		f.presentationTime.Usec -= 1000000
-		f.presentationTime.Sec++
-	}
+	addMicroseconds(&f.presentationTime, int64(tcSecs+pictureSeconds)*1000000+int64(pictureFractionOfSecond*1000000.0))
 }
 
 func (f *MPEGVideoStreamFramer) doGetNextFrame() error {
@@ -1,6 +1,7 @@
 package livemedia
 
 import (
+	"sync"
 	sys "syscall"
 	"time"
 
@@ -27,6 +28,12 @@ type MultiFramedRTPSink struct {
 	curFrameSpecificHeaderPosition  uint
 	previousFrameEndedFragmentation bool
 	onSendErrorFunc                 interface{}
+	history                         *packetHistory // of the packets we sent, to retransmit them (nil without NACK)
+	rtxPayloadType                  uint32         // of our RTX stream, 0 to retransmit the packets as they were
+	rtxSSRC                         uint32
+	rtxSeqNo                        uint16
+	fec                             *fecEncoder // of the parity packets of our FEC stream, nil without FEC
+	sendMutex                       sync.Mutex  // (the retransmissions are sent by our RTCP instance)
 }
 
 func (s *MultiFramedRTPSink) InitMultiFramedRTPSink(rtpSink IMediaSink,
@@ -38,10 +45,18 @@ func (s *MultiFramedRTPSink) InitMultiFramedRTPSink(rtpSink IMediaSink,
 }
 
 func (s *MultiFramedRTPSink) setPacketSizes(preferredPacketSize, maxPacketSize uint) {
-	s.outBuf = newOutPacketBuffer(preferredPacketSize, maxPacketSize)
+	s.outBuf = newOutPacketBuffer(preferredPacketSize, maxPacketSize, OutPacketBufferMaxSize)
 	s.ourMaxPacketSize = maxPacketSize
 }
 
+// setOutPacketBufferMaxSize replaces our buffer, (before we start playing) with one of the size
+func (s *MultiFramedRTPSink) setOutPacketBufferMaxSize(size uint) {
+	if size == 0 || size == OutPacketBufferMaxSize {
+		return
+	}
+	s.outBuf = newOutPacketBuffer(s.outBuf.preferred, s.outBuf.maxPacketSize, size)
+}
+
 func (s *MultiFramedRTPSink) multiFramedPlaying() {
 	s.buildAndSendPacket(true)
 }
@@ -52,7 +67,7 @@ func (s *MultiFramedRTPSink) buildAndSendPacket(isFirstPacket bool) {
 	// Set up the RTP header:
 	var rtpHdr uint32 = 0x80000000
 	rtpHdr |= s._rtpPayloadType << 16
-	rtpHdr |= s.seqNo
+	rtpHdr |= s.seqNo & 0xFFFF
 	s.outBuf.enqueueWord(rtpHdr)
 
 	s.timestampPosition = s.outBuf.curPacketSize()
@@ -100,6 +115,12 @@ func (s *MultiFramedRTPSink) packFrame() {
 }
 
 func (s *MultiFramedRTPSink) afterGettingFrame(frameSize, durationInMicroseconds uint, presentationTime sys.Timeval) {
+	if s.Source == nil {
+		// we were stopped while we waited for our next packet, and our source
+		// delivers the frame it had read ahead still, as we return from our wait
+		return
+	}
+
 	if s.isFirstPacket {
 		// Record the fact that we're starting to play now:
 		sys.Gettimeofday(&s.nextSendTime)
@@ -156,9 +177,7 @@ func (s *MultiFramedRTPSink) afterGettingFrame(frameSize, durationInMicroseconds
 		// However, if this frame has overflow data remaining, then don't
 		// count its duration yet.
 		if overflowBytes == 0 {
-			s.nextSendTime.Usec += int64(durationInMicroseconds)
-			s.nextSendTime.Sec += s.nextSendTime.Usec / 1000000
-			s.nextSendTime.Usec %= 1000000
+			addMicroseconds(&s.nextSendTime, int64(durationInMicroseconds))
 		}
 
 		// Send our packet now if (i) it's already at our preferred size, or
@@ -187,15 +206,19 @@ func (s *MultiFramedRTPSink) isTooBigForAPacket(numBytes uint) bool {
 
 func (s *MultiFramedRTPSink) sendPacketIfNecessary() {
 	if s.numFramesUsedSoFar > 0 {
-		if !s.rtpInterface.sendPacket(s.outBuf.packet(), s.outBuf.curPacketSize()) {
+		s.sendMutex.Lock()
+		sent := s.rtpInterface.sendPacket(s.outBuf.packet(), s.outBuf.curPacketSize())
+		s.sendMutex.Unlock()
+		if !sent {
 			// if failure handler has been specified, call it
 			if s.onSendErrorFunc != nil {
 			}
 		}
+		s.keepSentPacket(s.outBuf.packet()[:s.outBuf.curPacketSize()])
+		s.sendParityPackets(s.outBuf.packet()[:s.outBuf.curPacketSize()])
 
-		s._packetCount++
-		s.totalOctetCount += s.outBuf.curPacketSize()
-		s._octetCount += s.outBuf.curPacketSize() - rtpHeaderSize - s.specialHeaderSize - s.totalFrameSpecificHeaderSizes
+		s.notePacketSent(s.outBuf.curPacketSize(),
+			s.outBuf.curPacketSize()-rtpHeaderSize-s.specialHeaderSize-s.totalFrameSpecificHeaderSizes)
 
 		s.seqNo++ // for next time
 	}
@@ -217,8 +240,10 @@ func (s *MultiFramedRTPSink) sendPacketIfNecessary() {
 	s.numFramesUsedSoFar = 0
 
 	if s.noFramesLeft {
-		// We're done:
+		// We're done: (our afterFunc may stop us, which takes our mutex)
+		s.mutex.Unlock()
 		s.OnSourceClosure()
+		s.mutex.Lock()
 	} else {
 		// We have more frames left to send.  Figure out when the next frame
 		// is due to start playing, then make sure that we wait this long before
@@ -233,7 +258,13 @@ func (s *MultiFramedRTPSink) sendPacketIfNecessary() {
 
 		// Delay this amount of time:
 		//log.Debug("[MultiFramedRTPSink::sendPacketIfNecessary] uSecondsToGo: %d", uSecondsToGo)
+		// (letting the other goroutines stop us, or read our state meanwhile)
+		s.mutex.Unlock()
 		time.Sleep(time.Duration(uSecondsToGo) * time.Microsecond)
+		s.mutex.Lock()
+		if s.Source == nil {
+			return // we were stopped
+		}
 		s.sendNext()
 	}
 }
@@ -3,6 +3,7 @@ package livemedia
 import (
 	"fmt"
 	sys "syscall"
+	"time"
 
 	gs "github.com/djwackey/dorsvr/groupsock"
 )
@@ -18,6 +19,8 @@ type MultiFramedRTPSource struct {
 	reOrderingBuffer            *ReorderingPacketBuffer
 	specialHeaderHandler        interface{}
 	videoRTPSource              interface{}
+	nacked                      *nackHistory // (see noteMissingPackets)
+	recoveredPackets            [][]byte     // by FEC, to be taken as if we had received them
 }
 
 func (s *MultiFramedRTPSource) initMultiFramedRTPSource(source IFramedSource,
@@ -58,6 +61,11 @@ func (s *MultiFramedRTPSource) doGetNextFrame1() {
 			break
 		}
 
+		// we've given up on the packets before this one, (e.g. ask for a keyframe, to recover)
+		if packetLossPrecededThis && !nextPacket.isFirstPacket() {
+			s.notePacketLoss()
+		}
+
 		s.needDelivery = false
 
 		if nextPacket.UseCount() == 0 {
@@ -134,9 +142,15 @@ func (s *MultiFramedRTPSource) networkReadHandler() {
 		}
 
 		for {
-			err := packet.fillInData(s.rtpInterface)
+			var err error
+			recovered := s.fillInRecoveredPacket(packet)
+			if !recovered {
+				err = packet.fillInData(s.rtpInterface)
+			}
 			if err != nil {
-				break
+				// our socket has been closed, so there's nothing more to read
+				fmt.Println("failed to read RTP packet.", err)
+				return
 			}
 
 			// Check for the 12-byte RTP header:
@@ -144,6 +158,9 @@ func (s *MultiFramedRTPSource) networkReadHandler() {
 				break
 			}
 
+			// (the whole packet, for the FEC)
+			rawPacket := s.fecRawPacket(packet)
+
 			rtpHdr, _ := gs.Ntohl(packet.data())
 			packet.skip(4)
 
@@ -203,8 +220,23 @@ func (s *MultiFramedRTPSource) networkReadHandler() {
 				packet.removePadding(numPaddingBytes)
 			}
 
+			usableInJitterCalculation := !recovered
+
 			// Check the Payload Type.
-			if (rtpHdr&0x007F0000)>>16 != s.rtpPayloadFormat {
+			rtpPayloadFormat := (rtpHdr & 0x007F0000) >> 16
+			if s.rtxPayloadFormat != 0 && rtpPayloadFormat == s.rtxPayloadFormat {
+				// (we note a retransmitted packet as a packet of the original stream)
+				var ok bool
+				if rtpHdr, ok = unwrapRTXPacket(packet, rtpHdr); !ok {
+					break
+				}
+				rtpSSRC = s.lastReceivedSSRC
+				usableInJitterCalculation = false
+				// (the FEC protects the packets as they were first sent)
+				rawPacket = nil
+			} else if s.addParityPacket(rtpPayloadFormat, rawPacket) {
+				break
+			} else if rtpPayloadFormat != s.rtpPayloadFormat {
 				fmt.Println("error RTP Payload format.")
 				break
 			}
@@ -213,11 +245,15 @@ func (s *MultiFramedRTPSource) networkReadHandler() {
 			if rtpSSRC != s.lastReceivedSSRC {
 				s.lastReceivedSSRC = rtpSSRC
 				s.reOrderingBuffer.resetHaveSeenFirstPacket()
+				if s.fec != nil {
+					s.fec.reset()
+				}
 			}
 
 			rtpSeqNo := rtpHdr & 0xFFFF
 
-			usableInJitterCalculation := s.packetIsUsableInJitterCalculation(packet.data(), packet.dataSize())
+			usableInJitterCalculation = usableInJitterCalculation &&
+				s.packetIsUsableInJitterCalculation(packet.data(), packet.dataSize())
 
 			presentationTime, hasBeenSyncedUsingRTCP :=
 				s.receptionStatsDB.noteIncomingPacket(rtpSSRC, rtpSeqNo, rtpTimestamp,
@@ -231,9 +267,17 @@ func (s *MultiFramedRTPSource) networkReadHandler() {
 
 			if !s.reOrderingBuffer.storePacket(packet) {
 				fmt.Println("failed to store packet.")
+				s.receptionStatsDB.noteDiscardedPacket(rtpSSRC, s.reOrderingBuffer.rejectedDuplicate)
 				break
 			}
 
+			// (e.g. NACK the packets missing before this one)
+			s.noteMissingPackets(rtpSSRC)
+
+			if !recovered {
+				s.addProtectedPacket(rawPacket, rtpSSRC)
+			}
+
 			break
 		}
 
@@ -260,6 +304,7 @@ type IBufferedPacket interface {
 	use(buff []byte, size uint32) *PacketInfo
 	setNextPacket(nextPacket IBufferedPacket)
 	fillInData(rtpInterface *RTPInterface) error
+	fillInBytes(data []byte)
 	assignMiscParams(rtpSeqNo, rtpTimestamp uint32,
 		presentationTime, timeReceived sys.Timeval,
 		hasBeenSyncedUsingRTCP, rtpMarkerBit bool)
@@ -331,11 +376,7 @@ func (p *BufferedPacket) use(buff []byte, size uint32) (info *PacketInfo) {
 	}
 
 	// Update "presentationTime" for the next enclosed frame (if any):
-	p.presentationTime.Usec += int64(frameDurationInMicroseconds)
-	if p.presentationTime.Usec >= 1000000 {
-		p.presentationTime.Sec += p.presentationTime.Usec / 1000000
-		p.presentationTime.Usec = p.presentationTime.Usec % 1000000
-	}
+	addMicroseconds(&p.presentationTime, int64(frameDurationInMicroseconds))
 
 	return info
 }
@@ -454,11 +495,18 @@ type ReorderingPacketBuffer struct {
 	savedPacketFree     bool
 	haveSeenFirstPacket bool
 	nextExpectedSeqNo   uint
+	// the packets missing before the one we stored last, (at most maxMissingPackets)
+	missingPackets []uint16
+	// the last packet we didn't store was one we have already, (rather than one too late)
+	rejectedDuplicate bool
 }
 
+// the time we wait for a missing packet, before we give up on it
+const defaultReorderingThreshold = 100 * time.Millisecond
+
 func newReorderingPacketBuffer(packetFactory IBufferedPacketFactory) *ReorderingPacketBuffer {
 	packetBuffer := new(ReorderingPacketBuffer)
-	packetBuffer.thresholdTime = 100000 /* default reordering threshold: 100 ms */
+	packetBuffer.thresholdTime = int64(defaultReorderingThreshold / time.Microsecond)
 	if packetFactory == nil {
 		packetBuffer.packetFactory = newBufferedPacketFactory()
 	} else {
@@ -502,8 +550,7 @@ func (b *ReorderingPacketBuffer) getNextCompletedPacket() (IBufferedPacket, bool
 		sys.Gettimeofday(&timeNow)
 
 		timeReceived := b.headPacket.TimeReceived()
-		uSecondsSinceReceived := (timeNow.Sec-timeReceived.Sec)*1000000 +
-			(timeNow.Usec - timeReceived.Usec)
+		uSecondsSinceReceived := microseconds(timeNow) - microseconds(timeReceived)
 		timeThresholdHasBeenExceeded = uSecondsSinceReceived > b.thresholdTime
 	}
 
@@ -518,10 +565,10 @@ func (b *ReorderingPacketBuffer) getNextCompletedPacket() (IBufferedPacket, bool
 }
 
 func (b *ReorderingPacketBuffer) releaseUsedPacket(packet IBufferedPacket) {
-	b.nextExpectedSeqNo++
+	b.nextExpectedSeqNo = (b.nextExpectedSeqNo + 1) & 0xFFFF
 
 	b.headPacket = b.headPacket.NextPacket()
-	if b.headPacket != nil {
+	if b.headPacket == nil {
 		b.tailPacket = nil
 	}
 	packet.setNextPacket(nil)
@@ -533,6 +580,8 @@ func (b *ReorderingPacketBuffer) resetHaveSeenFirstPacket() {
 
 func (b *ReorderingPacketBuffer) storePacket(packet IBufferedPacket) bool {
 	rtpSeqNo := packet.rtpSeqNo()
+	b.missingPackets = b.missingPackets[:0]
+	b.rejectedDuplicate = false
 
 	if !b.haveSeenFirstPacket {
 		b.nextExpectedSeqNo = rtpSeqNo
@@ -547,6 +596,7 @@ func (b *ReorderingPacketBuffer) storePacket(packet IBufferedPacket) bool {
 	}
 
 	if b.tailPacket == nil {
+		b.noteMissingPackets(b.nextExpectedSeqNo, rtpSeqNo)
 		packet.setNextPacket(nil)
 		b.headPacket = packet
 		b.tailPacket = packet
@@ -556,6 +606,7 @@ func (b *ReorderingPacketBuffer) storePacket(packet IBufferedPacket) bool {
 	tailPacketRTPSeqNo := int(b.tailPacket.rtpSeqNo())
 
 	if seqNumLT(tailPacketRTPSeqNo, int(rtpSeqNo)) {
+		b.noteMissingPackets(uint(tailPacketRTPSeqNo)+1, rtpSeqNo)
 		packet.setNextPacket(nil)
 		b.tailPacket.setNextPacket(packet)
 		b.tailPacket = packet
@@ -564,6 +615,7 @@ func (b *ReorderingPacketBuffer) storePacket(packet IBufferedPacket) bool {
 
 	if int(rtpSeqNo) == tailPacketRTPSeqNo {
 		fmt.Printf("rtpSeqNo[%d] unequal to tailPacketRTPSeqNo[%d]\n", rtpSeqNo, tailPacketRTPSeqNo)
+		b.rejectedDuplicate = true
 		return false
 	}
 
@@ -578,6 +630,7 @@ func (b *ReorderingPacketBuffer) storePacket(packet IBufferedPacket) bool {
 
 		if rtpSeqNo == afterPtr.rtpSeqNo() {
 			fmt.Println("This is a duplicate packet - ignore it", rtpSeqNo, afterPtr.rtpSeqNo())
+			b.rejectedDuplicate = true
 			return false
 		}
 
@@ -4,19 +4,28 @@ import (
 	"fmt"
 	"net"
 	"os"
+	sys "syscall"
 
 	gs "github.com/djwackey/dorsvr/groupsock"
 )
 
 type OnDemandServerMediaSubsession struct {
 	ServerMediaSubsession
-	cname            string
-	sdpLines         string
-	portNumForSDP    int
-	initialPortNum   uint
-	reuseFirstSource bool
-	lastStreamToken  *StreamState
-	destinations     map[string]*Destinations
+	cname                string
+	sdpLines             string
+	sdpAddressFamily     int
+	sdpIsSecure          bool
+	sdpFeedback          RTCPFeedback
+	sdpFEC               FEC
+	srtpMasterKey        []byte
+	srtpProfile          SRTPProfile
+	portNumForSDP        int
+	reuseFirstSource     bool
+	lastStreamToken      *StreamState
+	lastSecureToken      *StreamState
+	lastMuxedToken       *StreamState // (of the clients that take RTCP on the RTP port, RFC 5761)
+	lastSecureMuxedToken *StreamState
+	destinations         map[string]*Destinations
 }
 
 type StreamParameter struct {
@@ -31,14 +40,18 @@ type StreamParameter struct {
 }
 
 func (s *OnDemandServerMediaSubsession) initOnDemandServerMediaSubsession(isubsession IServerMediaSubsession) {
-	s.initialPortNum = 6970
 	s.cname, _ = os.Hostname()
 	s.destinations = make(map[string]*Destinations)
 	s.initBaseClass(isubsession)
 }
 
-func (s *OnDemandServerMediaSubsession) SDPLines() string {
-	if s.sdpLines == "" {
+// SDPLines returns our "m=" section of the SDP description, for clients that
+// reach us over addressFamily (sys.AF_INET or sys.AF_INET6), and (if isSecure)
+// want SRTP.
+func (s *OnDemandServerMediaSubsession) SDPLines(addressFamily int, isSecure bool) string {
+	settings := s.streamSettings()
+	if s.sdpLines == "" || s.sdpAddressFamily != addressFamily || s.sdpIsSecure != isSecure ||
+		s.sdpFeedback != settings.RTCPFeedback || s.sdpFEC != settings.FEC {
 		rtpPayloadType := 96 + s.TrackNumber() - 1
 
 		var dummyAddr string
@@ -46,7 +59,7 @@ func (s *OnDemandServerMediaSubsession) SDPLines() string {
 		dummyRTPSink := s.isubsession.createNewRTPSink(dummyGroupSock, rtpPayloadType)
 		inputSource := s.isubsession.createNewStreamSource()
 
-		s.setSDPLinesFromRTPSink(dummyRTPSink, inputSource, 500)
+		s.setSDPLinesFromRTPSink(dummyRTPSink, inputSource, 500, addressFamily, isSecure)
 		dummyRTPSink.destroy()
 		inputSource.destroy()
 	}
@@ -55,17 +68,35 @@ func (s *OnDemandServerMediaSubsession) SDPLines() string {
 }
 
 func (s *OnDemandServerMediaSubsession) GetStreamParameters(tcpSocketNum net.Conn, destAddr,
-	clientSessionID string, clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID uint) *StreamParameter {
+	clientSessionID string, clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID uint,
+	isSecure, rtcpMux bool) *StreamParameter {
 	var streamBitrate uint = 500
 
 	sp := new(StreamParameter)
+	settings := s.streamSettings()
+
+	// SRTP clients share a stream of their own, which is protected with our master key,
+	// and so do the clients that take RTCP on the RTP port, (which the stream has a single one of)
+	lastStreamToken := &s.lastStreamToken
+	switch {
+	case isSecure && rtcpMux:
+		lastStreamToken = &s.lastSecureMuxedToken
+	case isSecure:
+		lastStreamToken = &s.lastSecureToken
+	case rtcpMux:
+		lastStreamToken = &s.lastMuxedToken
+	}
+	if rtcpMux {
+		// (the client gets its RTCP on its RTP port too)
+		clientRTCPPort = clientRTPPort
+	}
 
-	if s.lastStreamToken != nil {
-		streamState := s.lastStreamToken
+	if *lastStreamToken != nil {
+		streamState := *lastStreamToken
 		sp.ServerRTPPort = streamState.ServerRTPPort()
 		sp.ServerRTCPPort = streamState.ServerRTCPPort()
 
-		sp.StreamToken = s.lastStreamToken
+		sp.StreamToken = *lastStreamToken
 	} else {
 		mediaSource := s.isubsession.createNewStreamSource()
 
@@ -74,21 +105,39 @@ func (s *OnDemandServerMediaSubsession) GetStreamParameters(tcpSocketNum net.Con
 		var udpSink *BasicUDPSink
 		var rtpGroupSock, rtcpGroupSock *gs.GroupSock
 
-		sp.ServerRTPPort = s.initialPortNum
-		if clientRTCPPort == 0 {
-			// We're streaming raw UDP (not RTP). Create a single groupsock:
+		minPortNum, maxPortNum := settings.RTPPortMin, settings.RTPPortMax
+		sp.ServerRTPPort = minPortNum
+		if clientRTCPPort == 0 || rtcpMux {
+			// We're streaming raw UDP (not RTP), or RTP with RTCP on the same port. Create a single groupsock:
 			for {
+				if maxPortNum != 0 && sp.ServerRTPPort > maxPortNum {
+					rtpLog.Error("no free UDP port", "min", minPortNum, "max", maxPortNum)
+					mediaSource.destroy()
+					return nil
+				}
 				rtpGroupSock = gs.NewGroupSock(dummyAddr, sp.ServerRTPPort)
 				if rtpGroupSock != nil {
 					break
 				}
 				sp.ServerRTPPort++
 			}
+		}
+		if clientRTCPPort == 0 {
 			udpSink = NewBasicUDPSink(rtpGroupSock)
 		} else {
-			// Normal case: We're streaming RTP (over UDP or TCP).  Create a pair of
-			// groupsocks (RTP and RTCP), with adjacent port numbers (RTP port number even):
-			for {
+			if rtcpMux {
+				sp.ServerRTCPPort, rtcpGroupSock = sp.ServerRTPPort, rtpGroupSock
+			} else {
+				// Normal case: We're streaming RTP (over UDP or TCP).  Create a pair of
+				// groupsocks (RTP and RTCP), with adjacent port numbers (RTP port number even):
+				sp.ServerRTPPort &^= 1
+			}
+			for rtcpGroupSock == nil {
+				if maxPortNum != 0 && sp.ServerRTPPort+1 > maxPortNum {
+					rtpLog.Error("no free UDP port pair", "min", minPortNum, "max", maxPortNum)
+					mediaSource.destroy()
+					return nil
+				}
 				rtpGroupSock = gs.NewGroupSock(dummyAddr, sp.ServerRTPPort)
 				if rtpGroupSock == nil {
 					sp.ServerRTPPort += 2
@@ -98,6 +147,7 @@ func (s *OnDemandServerMediaSubsession) GetStreamParameters(tcpSocketNum net.Con
 				sp.ServerRTCPPort = sp.ServerRTPPort + 1
 				rtcpGroupSock = gs.NewGroupSock(dummyAddr, sp.ServerRTCPPort)
 				if rtcpGroupSock == nil {
+					rtpGroupSock.Close()
 					sp.ServerRTPPort += 2
 					continue
 				}
@@ -105,10 +155,23 @@ func (s *OnDemandServerMediaSubsession) GetStreamParameters(tcpSocketNum net.Con
 			}
 			rtpPayloadType := 96 + s.TrackNumber() - 1
 			rtpSink = s.isubsession.createNewRTPSink(rtpGroupSock, rtpPayloadType)
+			rtpSink.setOutPacketBufferMaxSize(settings.OutPacketBufferMaxSize)
+			if feedback := settings.RTCPFeedback; feedback.NACK && rtpSink.sdpMediaType() == "video" {
+				rtpSink.enableRetransmission(feedback.rtxPayloadType(rtpSink.sdpMediaType(), rtpSink.rtpPayloadType()))
+			}
+			if fec := settings.FEC; fec.payloadType(rtpSink.rtpPayloadType()) != 0 {
+				rtpSink.enableFEC(fec, fec.payloadType(rtpSink.rtpPayloadType()))
+			}
+			if isSecure {
+				masterKey := s.masterKey()
+				if masterKey == nil || rtpSink.enableSRTP(s.srtpProfile, masterKey) != nil {
+					return nil
+				}
+			}
 		}
 
 		// Set up the state of the stream.  The stream will get started later:
-		s.lastStreamToken = newStreamState(s.isubsession,
+		*lastStreamToken = newStreamState(s.isubsession,
 			sp.ServerRTPPort,
 			sp.ServerRTCPPort,
 			rtpSink,
@@ -117,12 +180,16 @@ func (s *OnDemandServerMediaSubsession) GetStreamParameters(tcpSocketNum net.Con
 			mediaSource,
 			rtpGroupSock,
 			rtcpGroupSock)
-		sp.StreamToken = s.lastStreamToken
+		(*lastStreamToken).keyframeRequests = settings.RTCPFeedback.KeyframeRequests
+		sp.StreamToken = *lastStreamToken
 	}
 
 	// Record these destinations as being for this client session id:
 	dests := newDestinations(tcpSocketNum, destAddr, clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID)
 	s.destinations[clientSessionID] = dests
+	if window := settings.SymmetricRTPWindow; window > 0 && tcpSocketNum == nil {
+		sp.StreamToken.latchDestinations(dests, window)
+	}
 
 	return sp
 }
@@ -135,7 +202,8 @@ func (s *OnDemandServerMediaSubsession) getAuxSDPLine(rtpSink IMediaSink, inputS
 	return rtpSink.AuxSDPLine()
 }
 
-func (s *OnDemandServerMediaSubsession) setSDPLinesFromRTPSink(rtpSink IMediaSink, inputSource IFramedSource, estBitrate uint) {
+func (s *OnDemandServerMediaSubsession) setSDPLinesFromRTPSink(rtpSink IMediaSink, inputSource IFramedSource,
+	estBitrate uint, addressFamily int, isSecure bool) {
 	if rtpSink == nil {
 		return
 	}
@@ -150,25 +218,81 @@ func (s *OnDemandServerMediaSubsession) setSDPLinesFromRTPSink(rtpSink IMediaSin
 		auxSDPLine = ""
 	}
 
-	ipAddr := "0.0.0.0"
-	sdpFmt := "m=%s %d RTP/AVP %d\r\n" +
-		"c=IN IP4 %s\r\n" +
+	addrType, ipAddr := "IP4", "0.0.0.0"
+	if addressFamily == sys.AF_INET6 {
+		addrType, ipAddr = "IP6", "::"
+	}
+	// (the RTCP feedback is offered in the "RTP/AVP" profile, rather than "RTP/AVPF", which few clients take)
+	settings := s.streamSettings()
+	feedback := settings.RTCPFeedback
+	feedbackLines := feedback.sdpLines(mediaType, rtpPayloadType, rtpSink.timestampFrequency())
+	payloadTypes := fmt.Sprint(rtpPayloadType)
+	if rtxPayloadType := feedback.rtxPayloadType(mediaType, rtpPayloadType); rtxPayloadType != 0 {
+		payloadTypes += fmt.Sprintf(" %d", rtxPayloadType)
+	}
+	fec := settings.FEC
+	fecLines := fec.sdpLines(rtpPayloadType, rtpSink.timestampFrequency())
+	if fecPayloadType := fec.payloadType(rtpPayloadType); fecPayloadType != 0 {
+		payloadTypes += fmt.Sprintf(" %d", fecPayloadType)
+	}
+
+	profile, cryptoLine := "RTP/AVP", ""
+	if isSecure {
+		masterKey := s.masterKey()
+		if masterKey == nil {
+			return
+		}
+		profile, cryptoLine = "RTP/SAVP", srtpCryptoAttribute(1, s.srtpProfile, masterKey)
+	}
+	sdpFmt := "m=%s %d %s %s\r\n" +
+		"c=IN %s %s\r\n" +
 		"b=AS:%d\r\n" +
 		"%s" +
 		"%s" +
 		"%s" +
+		"%s" +
+		"%s" +
+		"%s" +
+		"%s" +
+		"%s" +
 		"a=control:%s\r\n"
 
 	s.sdpLines = fmt.Sprintf(sdpFmt,
 		mediaType,
 		s.portNumForSDP,
-		rtpPayloadType,
+		profile,
+		payloadTypes,
+		addrType,
 		ipAddr,
 		estBitrate,
 		rtpmapLine,
+		feedbackLines,
+		rtcpXRSDPLine,
+		rtcpMuxSDPLine,
+		fecLines,
 		rangeLine,
 		auxSDPLine,
+		cryptoLine,
 		s.TrackID())
+	s.sdpAddressFamily = addressFamily
+	s.sdpIsSecure = isSecure
+	s.sdpFeedback = feedback
+	s.sdpFEC = fec
+}
+
+// masterKey returns the SRTP master key (and salt) of our secure stream, which
+// we offer in the SDP descriptions that we give to RTSPS clients.
+// (The crypto suite is DefaultSRTPProfile, as it was when the key was made.)
+func (s *OnDemandServerMediaSubsession) masterKey() []byte {
+	if s.srtpMasterKey == nil {
+		masterKey, err := newSRTPMasterKey(DefaultSRTPProfile)
+		if err != nil {
+			rtpLog.Error("failed to create a SRTP master key", "err", err)
+			return nil
+		}
+		s.srtpMasterKey, s.srtpProfile = masterKey, DefaultSRTPProfile
+	}
+	return s.srtpMasterKey
 }
 
 func (s *OnDemandServerMediaSubsession) CNAME() string {
@@ -178,12 +302,21 @@ func (s *OnDemandServerMediaSubsession) CNAME() string {
 func (s *OnDemandServerMediaSubsession) StartStream(clientSessionID string, streamState *StreamState,
 	rtcpRRHandler, serverRequestAlternativeByteHandler interface{}) (rtpSeqNum, rtpTimestamp uint32) {
 	destinations, _ := s.destinations[clientSessionID]
-	go streamState.startPlaying(destinations, rtcpRRHandler, serverRequestAlternativeByteHandler)
 
+	// (before we start playing, for the first packet to have them)
 	if streamState.RtpSink() != nil {
 		rtpSeqNum = streamState.RtpSink().currentSeqNo()
 		rtpTimestamp = streamState.RtpSink().presetNextTimestamp()
 	}
+
+	streamState.playing.Add(1)
+	go func() {
+		defer streamState.playing.Done()
+		streamState.startPlaying(destinations, rtcpRRHandler, serverRequestAlternativeByteHandler)
+	}()
+
+	rtpLog.Debug("started a stream", "session_id", clientSessionID, "track", s.TrackID(),
+		"seq", rtpSeqNum, "rtptime", rtpTimestamp)
 	return
 }
 
@@ -206,6 +339,7 @@ func (s *OnDemandServerMediaSubsession) DeleteStream(sessionID string, streamSta
 	if streamState != nil {
 		streamState.reclaim()
 	}
+	rtpLog.Debug("deleted a stream", "session_id", sessionID, "track", s.TrackID())
 }
 
 //////// Destinations ////////
@@ -0,0 +1,480 @@
+package livemedia
+
+import (
+	"sync"
+	"sync/atomic"
+	sys "syscall"
+	"time"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+	"github.com/djwackey/dorsvr/rtcp"
+)
+
+const (
+	// overhead (bytes) of IP and UDP hdrs
+	IP_UDP_HDR_SIZE = 28
+
+	PACKET_UNKNOWN_TYPE = 0
+	PAKCET_RTP          = 1
+	PACKET_RTCP_REPORT  = 2
+	PACKET_BYE          = 3
+)
+
+// bytes, (1500, minus some allowance for IP, UDP, UMTP headers)
+const maxRTCPPacketSize uint = 1450
+
+type RTCPInstance struct {
+	typeOfEvent          uint
+	lastSentSize         uint
+	totSessionBW         uint
+	numMembers           uint
+	lastPacketSentSize   uint
+	avgRTCPSize          float64
+	haveJustSentPacket   bool
+	isDestroyed          atomic.Bool // (our timer checks it)
+	prevReportTime       float64     // in seconds
+	nextReportTime       float64
+	inBuf                []byte
+	CNAME                string
+	Sink                 IMediaSink
+	Source               *RTPSource
+	netInterface         *RTPInterface
+	byeHandlerTask       interface{}
+	SRHandlerTask        interface{}
+	RRHandlerTask        interface{}
+	byeHandlerClientData interface{}
+	keyframeRequestTask  interface{} // func(), on a PLI or FIR of our stream
+	lastKeyframeRequest  time.Time   // that we passed on, or sent
+	firSeqNo             uint8
+	extendedReports      atomic.Bool   // we receive, and send XRs too (RFC 3611), as the SDP description offered
+	roundTripTime        atomic.Int64  // (a time.Duration) from the DLRR of the sender, in answer to our XRs
+	sendMutex            sync.Mutex    // (feedback is sent by the RTP source, as it notes a loss)
+	stopped              chan struct{} // closed by destroy, which cancels the next report
+}
+
+// dTimeNow returns the current time in seconds, (with its fraction)
+func dTimeNow() float64 {
+	return float64(time.Now().UnixNano()) / 1e9
+}
+
+func newRTCPInstance(rtcpGS *gs.GroupSock, totSessionBW uint, cname string,
+	sink IMediaSink, source *RTPSource) *RTCPInstance {
+	reportTime := dTimeNow()
+	instance := &RTCPInstance{
+		typeOfEvent:    eventReport,
+		totSessionBW:   totSessionBW,
+		prevReportTime: reportTime,
+		nextReportTime: reportTime,
+		CNAME:          cname,
+		inBuf:          make([]byte, maxRTCPPacketSize),
+		Sink:           sink,
+		Source:         source,
+		stopped:        make(chan struct{}),
+	}
+
+	if instance.totSessionBW == 0 {
+		rtcpLog.Warn("the total session bandwidth can't be zero")
+		instance.totSessionBW = 1
+	}
+
+	instance.netInterface = newRTPInterface(instance, rtcpGS)
+	// (if our stream is SRTP, our reports are SRTCP, with the same master key)
+	if sink != nil {
+		instance.enableSRTP(sink.srtpContext())
+	} else if source != nil {
+		instance.enableSRTP(source.srtpContext())
+	}
+	instance.netInterface.startNetworkReading(instance.incomingReportHandler)
+
+	instance.onExpire()
+	return instance
+}
+
+func (r *RTCPInstance) NumMembers() uint {
+	return r.numMembers
+}
+
+func (r *RTCPInstance) sentPacketSize() uint {
+	r.sendMutex.Lock()
+	defer r.sendMutex.Unlock()
+	return r.lastSentSize
+}
+
+// setSpecificRRHandler calls the handler on each RR, (the server notes the liveness of the client session)
+func (r *RTCPInstance) setSpecificRRHandler(handlerTask interface{}) {
+	r.RRHandlerTask = handlerTask
+}
+
+func (r *RTCPInstance) SetByeHandler(handlerTask interface{}, clientData interface{}) {
+	r.byeHandlerTask = handlerTask
+	r.byeHandlerClientData = clientData
+}
+
+func (r *RTCPInstance) setSRHandler(handlerTask interface{}, clientData interface{}) {
+	r.SRHandlerTask = handlerTask
+}
+
+func (r *RTCPInstance) setRRHandler(handlerTask interface{}, clientData interface{}) {
+	r.RRHandlerTask = handlerTask
+}
+
+func (r *RTCPInstance) incomingReportHandler() {
+	for {
+		readBytes, err := r.netInterface.handleRead(r.inBuf)
+		if err != nil {
+			// (e.g. the interface was closed by destroy)
+			rtcpLog.Debug("stopped reading the RTCP reports", "err", err)
+			break
+		}
+
+		packetSize := uint(readBytes)
+
+		r.processIncomingReport(packetSize)
+	}
+}
+
+func (r *RTCPInstance) processIncomingReport(packetSize uint) {
+	var fromAddress string
+	var callByeHandler bool
+	if from := r.netInterface.lastReceivedFrom; from != nil {
+		fromAddress = from.String()
+	}
+
+	totPacketSize := IP_UDP_HDR_SIZE + packetSize
+
+	packets, err := rtcp.Unmarshal(r.inBuf[:packetSize])
+	if err != nil {
+		rtcpLog.Warn("rejected a bad RTCP packet", "size", packetSize, "err", err)
+		return
+	}
+
+	typeOfPacket := PACKET_UNKNOWN_TYPE
+	var reportSenderSSRC uint32
+	for _, packet := range packets {
+		switch p := packet.(type) {
+		case *rtcp.SenderReport:
+			reportSenderSSRC = p.SSRC
+			if r.Source != nil {
+				r.Source.receptionStatsDB.noteIncomingSR(p.SSRC, p.NTPTime, p.RTPTime)
+			}
+
+			// If a 'SR handler' was set, call it now:
+			if r.SRHandlerTask != nil {
+				r.SRHandlerTask.(func())()
+			}
+
+			r.noteReceptionReports(fromAddress, p.SSRC, p.Reports)
+			typeOfPacket = PACKET_RTCP_REPORT
+		case *rtcp.ReceiverReport:
+			reportSenderSSRC = p.SSRC
+			r.noteReceptionReports(fromAddress, p.SSRC, p.Reports)
+
+			rtcpLog.Debug("received a RTCP receiver report", "ssrc", p.SSRC)
+			if r.RRHandlerTask != nil {
+				r.RRHandlerTask.(func())()
+			}
+			typeOfPacket = PACKET_RTCP_REPORT
+		case *rtcp.TransportLayerFeedback:
+			r.noteTransportLayerFeedback(p)
+		case *rtcp.PayloadSpecificFeedback:
+			r.notePayloadSpecificFeedback(p)
+		case *rtcp.ExtendedReport:
+			r.noteExtendedReport(fromAddress, p)
+		case *rtcp.Goodbye:
+			rtcpLog.Debug("received a RTCP BYE", "sources", p.Sources, "reason", p.Reason)
+			callByeHandler = true
+			typeOfPacket = PACKET_BYE
+		}
+	}
+
+	r.onReceive(typeOfPacket, totPacketSize, uint(reportSenderSSRC))
+
+	if callByeHandler && r.byeHandlerTask != nil {
+		r.byeHandlerTask.(func(subsession *MediaSubsession))(r.byeHandlerClientData.(*MediaSubsession))
+	}
+}
+
+// noteReceptionReports updates the stats about our transmissions with the reports of a receiver,
+// (we care only about reports about our own transmission, not others')
+func (r *RTCPInstance) noteReceptionReports(fromAddress string, reportSenderSSRC uint32, reports []rtcp.ReceptionReport) {
+	if r.Sink == nil {
+		return
+	}
+
+	transmissionStats := r.Sink.transmissionStatsDB()
+	for _, report := range reports {
+		if report.SSRC != r.Sink.ssrc() {
+			continue
+		}
+		lossStats := uint32(report.FractionLost)<<24 | uint32(report.TotalLost)&0xFFFFFF
+		transmissionStats.noteIncomingRR(fromAddress, reportSenderSSRC,
+			lossStats, report.LastSequenceNumber, report.Jitter, report.LastSenderReport, report.Delay)
+	}
+}
+
+// noteExtendedReport notes a XR: from a receiver, of its statistics (and its time, which we answer),
+// or from the sender, of its answer to our last XR, which tells our round-trip time
+func (r *RTCPInstance) noteExtendedReport(fromAddress string, xr *rtcp.ExtendedReport) {
+	rtcpLog.Debug("received a RTCP XR", "ssrc", xr.SSRC, "blocks", len(xr.Blocks))
+	if r.Sink != nil {
+		r.Sink.transmissionStatsDB().noteIncomingXR(fromAddress, xr)
+		return
+	}
+
+	now := time.Now()
+	for _, block := range xr.Blocks {
+		dlrr, ok := block.(*rtcp.DLRR)
+		if !ok {
+			continue
+		}
+		for _, report := range dlrr.Reports {
+			if report.SSRC != r.ssrc() {
+				continue
+			}
+			if rtt, ok := rtcp.RoundTripTime(report.LastReceiverReport, report.Delay, now); ok {
+				r.roundTripTime.Store(int64(rtt))
+			}
+		}
+	}
+}
+
+// RoundTripTime returns the round-trip time to the sender of our source, as of its last answer to our XRs,
+// (0 if it hasn't answered)
+func (r *RTCPInstance) RoundTripTime() time.Duration {
+	return time.Duration(r.roundTripTime.Load())
+}
+
+func (r *RTCPInstance) onReceive(typeOfPacket int, totPacketSize, ssrc uint) {
+	OnReceive()
+}
+
+func (r *RTCPInstance) sendReport() {
+	rtcpLog.Debug("sending a RTCP report")
+	// Begin by including a SR and/or RR report, (none if we can't yet):
+	report := r.report(false)
+	if report == nil {
+		return
+	}
+
+	// Then, include a SDES, and a XR if we have one:
+	packets := []rtcp.Packet{report, rtcp.NewCNAME(r.ssrc(), r.CNAME)}
+	if xr := r.extendedReport(); xr != nil {
+		packets = append(packets, xr)
+	}
+	r.sendCompound(packets...)
+}
+
+// extendedReport returns our XR, or nil if we have none: the DLRR of the receivers that sent us
+// their time (if we send), or our time and statistics (if we receive, and the sender takes them)
+func (r *RTCPInstance) extendedReport() *rtcp.ExtendedReport {
+	if r.Sink != nil {
+		reports := r.Sink.transmissionStatsDB().dlrrReports()
+		if len(reports) == 0 {
+			return nil
+		}
+		return &rtcp.ExtendedReport{SSRC: r.ssrc(), Blocks: []rtcp.XRBlock{&rtcp.DLRR{Reports: reports}}}
+	}
+
+	if r.Source == nil || !r.extendedReports.Load() {
+		return nil
+	}
+	blocks := []rtcp.XRBlock{&rtcp.ReceiverReferenceTime{NTPTime: rtcp.NTPTime(time.Now())}}
+	blocks = append(blocks, r.Source.receptionStatsDB.extendedReportBlocks(r.RoundTripTime())...)
+	return &rtcp.ExtendedReport{SSRC: r.ssrc(), Blocks: blocks}
+}
+
+func (r *RTCPInstance) sendBye() {
+	r.sendCompound(r.report(true), rtcp.NewCNAME(r.ssrc(), r.CNAME), &rtcp.Goodbye{Sources: []uint32{r.ssrc()}})
+}
+
+func (r *RTCPInstance) sendCompound(packets ...rtcp.Packet) {
+	r.sendMutex.Lock()
+	defer r.sendMutex.Unlock()
+
+	packet, err := rtcp.Marshal(packets...)
+	if err != nil {
+		rtcpLog.Warn("failed to build a RTCP packet", "err", err)
+		return
+	}
+	reportSize := uint(len(packet))
+	r.netInterface.sendPacket(packet, reportSize)
+
+	r.lastSentSize = uint(IP_UDP_HDR_SIZE) + reportSize
+	r.haveJustSentPacket = true
+	r.lastPacketSentSize = reportSize
+}
+
+func (r *RTCPInstance) enableSRTP(context *srtpContext) {
+	r.netInterface.setSRTP(context, true)
+}
+
+// ssrc returns the SSRC of our reports
+func (r *RTCPInstance) ssrc() uint32 {
+	if r.Source != nil {
+		return r.Source.ssrc
+	} else if r.Sink != nil {
+		return r.Sink.ssrc()
+	}
+	return 0
+}
+
+// report returns our SR (if we send) or RR (if we receive), or nil if a SR can't be made yet,
+// unless always, which makes an empty RR instead
+func (r *RTCPInstance) report(always bool) rtcp.Packet {
+	if r.Sink != nil {
+		if r.Sink.enableRTCPReports() {
+			if report := r.senderReport(); report != nil {
+				return report
+			}
+		}
+	} else if r.Source != nil {
+		return &rtcp.ReceiverReport{SSRC: r.Source.ssrc, Reports: r.receptionReports()}
+	}
+
+	if always {
+		return &rtcp.ReceiverReport{SSRC: r.ssrc()}
+	}
+	return nil
+}
+
+// senderReport returns our SR, or nil while our sink's next timestamp is preset
+func (r *RTCPInstance) senderReport() *rtcp.SenderReport {
+	// Insert the NTP and RTP timestamps for the 'wallclock time', (of the same instant,
+	// as the RTP timestamps of our packets are of the presentation times of their frames):
+	now := time.Now()
+	rtpTime, ok := r.Sink.senderReportTimestamp(sys.NsecToTimeval(now.UnixNano()))
+	if !ok {
+		return nil
+	}
+	return &rtcp.SenderReport{
+		SSRC:        r.Sink.ssrc(),
+		NTPTime:     rtcp.NTPTime(now),
+		RTPTime:     rtpTime,
+		PacketCount: uint32(r.Sink.packetCount()),
+		OctetCount:  uint32(r.Sink.octetCount()),
+		Reports:     r.receptionReports(),
+	}
+}
+
+func (r *RTCPInstance) schedule(nextTime float64) {
+	r.nextReportTime = nextTime
+	secondsToDelay := nextTime - dTimeNow()
+	if secondsToDelay < 0 {
+		secondsToDelay = 0
+	}
+	delay := time.Duration(secondsToDelay * float64(time.Second))
+
+	// (wait in a goroutine of our own, so that neither our caller, e.g. the streaming, nor destroy have to wait)
+	go func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			r.onExpire()
+		case <-r.stopped:
+		}
+	}()
+}
+
+func (r *RTCPInstance) onExpire() {
+	if r.isDestroyed.Load() {
+		return
+	}
+
+	// Note: totsessionbw is kbits per second
+	rtcpBW := (0.05 * float64(r.totSessionBW) * 1024 / 8)
+
+	var senders float64
+	if r.Sink != nil {
+		senders = 1
+	}
+
+	OnExpire(r, float64(r.NumMembers()), senders, senders, rtcpBW, r.avgRTCPSize, dTimeNow(), r.prevReportTime)
+}
+
+func (r *RTCPInstance) unsetSpecificRRHandler() {
+	r.RRHandlerTask = nil
+}
+
+// receptionReports returns the report blocks of the sources we receive, (at most 31)
+func (r *RTCPInstance) receptionReports() []rtcp.ReceptionReport {
+	if r.Source == nil {
+		return nil
+	}
+
+	db := r.Source.receptionStatsDB
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var reports []rtcp.ReceptionReport
+	for _, stats := range db.table {
+		if len(reports) == 31 {
+			break
+		}
+		if !stats.haveSeenInitialSequenceNumber {
+			// (we have only its SR)
+			continue
+		}
+		reports = append(reports, receptionReport(stats))
+		// because we have just generated a report
+		stats.reset()
+	}
+	return reports
+}
+
+func receptionReport(stats *RTPReceptionStats) rtcp.ReceptionReport {
+	highestExtSeqNumReceived := stats.highestExtSeqNumReceived
+
+	totNumExpected := highestExtSeqNumReceived - stats.baseExtSeqNumReceived + 1
+	totNumLost := int32(totNumExpected - stats.totNumPacketsReceived)
+
+	numExpectedSinceLastReset := highestExtSeqNumReceived - stats.lastResetExtSeqNumReceived
+	numLostSinceLastReset := int32(numExpectedSinceLastReset - stats.numPacketsReceivedSinceLastReset)
+	var lossFraction uint32
+	if numExpectedSinceLastReset == 0 || numLostSinceLastReset < 0 {
+		lossFraction = 0
+	} else {
+		lossFraction = (uint32(numLostSinceLastReset) << 8) / numExpectedSinceLastReset
+	}
+
+	ntpMsw := stats.lastReceivedSRNTPmsw
+	ntpLsw := stats.lastReceivedSRNTPlsw
+	lsr := ((ntpMsw & 0xFFFF) << 16) | (ntpLsw >> 16) // middle 32 bits
+
+	// Figure out how long has elapsed since the last SR rcvd from this src:
+	lsrTime := stats.lastReceivedSRTime // "last SR"
+	var timeSinceLSR, timeNow sys.Timeval
+	sys.Gettimeofday(&timeNow)
+	if timeNow.Usec < lsrTime.Usec {
+		timeNow.Usec += 1000000
+		timeNow.Sec -= 1
+	}
+	timeSinceLSR.Sec = timeNow.Sec - lsrTime.Sec
+	timeSinceLSR.Usec = timeNow.Usec - lsrTime.Usec
+	// The delay is in units of 1/65536 seconds.
+	// (Note that 65536/1000000 == 1024/15625)
+	var dlsr int64
+	if lsr != 0 {
+		dlsr = (int64(timeSinceLSR.Sec) << 16) | ((((int64(timeSinceLSR.Usec) << 11) + 15625) / 31250) & 0xFFFF)
+	}
+
+	return rtcp.ReceptionReport{
+		SSRC:               stats.ssrc,
+		FractionLost:       uint8(lossFraction),
+		TotalLost:          totNumLost,
+		LastSequenceNumber: highestExtSeqNumReceived,
+		Jitter:             uint32(stats.jitter),
+		LastSenderReport:   lsr,
+		Delay:              uint32(dlsr),
+	}
+}
+
+func (r *RTCPInstance) destroy() {
+	if !r.isDestroyed.CompareAndSwap(false, true) {
+		return
+	}
+	r.sendBye()
+	r.netInterface.stopNetworkReading()
+	close(r.stopped)
+}
@@ -0,0 +1,299 @@
+package livemedia
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+	"github.com/djwackey/dorsvr/rtcp"
+)
+
+// RTCPFeedback is the RTCP feedback (RFC 4585) that our video streams offer,
+// in the "a=rtcp-fb" lines of their SDP descriptions
+type RTCPFeedback struct {
+	// retransmit the packets that a receiver reports lost, in a generic NACK
+	NACK bool
+	// retransmit them in a RTX stream (RFC 4588), of a payload type of its own, rather than as they were
+	RTX bool
+	// pass the PLI and FIR of the receivers on to the sources that can force a keyframe, see KeyframeRequester
+	KeyframeRequests bool
+}
+
+// rtxPayloadType returns the payload type of the RTX stream of a stream, 0 if it has none
+func (f RTCPFeedback) rtxPayloadType(mediaType string, rtpPayloadType uint32) uint32 {
+	if !f.NACK || !f.RTX || mediaType != "video" || rtpPayloadType < 96 || rtpPayloadType+16 > 127 {
+		return 0
+	}
+	return rtpPayloadType + 16
+}
+
+// sdpLines returns the "a=rtcp-fb" lines of a stream, (and the "a=rtpmap" and "a=fmtp" lines of its RTX stream)
+func (f RTCPFeedback) sdpLines(mediaType string, rtpPayloadType, timestampFrequency uint32) (lines string) {
+	if mediaType != "video" {
+		return ""
+	}
+
+	if f.NACK {
+		lines += fmt.Sprintf("a=rtcp-fb:%d nack\r\n", rtpPayloadType)
+	}
+	if f.KeyframeRequests {
+		lines += fmt.Sprintf("a=rtcp-fb:%d nack pli\r\n", rtpPayloadType)
+		lines += fmt.Sprintf("a=rtcp-fb:%d ccm fir\r\n", rtpPayloadType)
+	}
+	if rtxPayloadType := f.rtxPayloadType(mediaType, rtpPayloadType); rtxPayloadType != 0 {
+		lines += fmt.Sprintf("a=rtpmap:%d rtx/%d\r\n", rtxPayloadType, timestampFrequency)
+		lines += fmt.Sprintf("a=fmtp:%d apt=%d\r\n", rtxPayloadType, rtpPayloadType)
+	}
+	return
+}
+
+// KeyframeRequester is a (live) source that can make its next frame a keyframe,
+// when a receiver lost a part of a picture (and sent a PLI or FIR)
+type KeyframeRequester interface {
+	RequestKeyframe()
+}
+
+// requestKeyframe passes a keyframe request on to the first source that can force a keyframe,
+// from the source of a stream down the input sources of its filters; false if there's none
+func requestKeyframe(source IFramedSource) bool {
+	for source != nil {
+		if requester, ok := source.(KeyframeRequester); ok {
+			requester.RequestKeyframe()
+			return true
+		}
+		filter, ok := source.(interface{ InputSource() IFramedSource })
+		if !ok {
+			break
+		}
+		source = filter.InputSource()
+	}
+	return false
+}
+
+// the number of the packets we sent that we keep, to retransmit those a receiver NACKs
+const packetHistorySize = 256
+
+// packetHistory is the last packets we sent, by sequence number
+type packetHistory struct {
+	mutex   sync.Mutex
+	packets [packetHistorySize][]byte
+}
+
+func (h *packetHistory) add(packet []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	seqNo := uint16(packet[2])<<8 | uint16(packet[3])
+	slot := &h.packets[seqNo%packetHistorySize]
+	*slot = append((*slot)[:0], packet...)
+}
+
+// lookup returns a copy of the packet of the sequence number, nil if we no longer have it
+func (h *packetHistory) lookup(seqNo uint16) []byte {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	packet := h.packets[seqNo%packetHistorySize]
+	if len(packet) < 12 || uint16(packet[2])<<8|uint16(packet[3]) != seqNo {
+		return nil
+	}
+	return append([]byte(nil), packet...)
+}
+
+// the minimum time between our keyframe requests, (a lost frame is usually followed by more losses)
+const minKeyframeRequestInterval = 500 * time.Millisecond
+
+// enableRetransmission makes us keep the packets we send, to retransmit those that a receiver NACKs,
+// in a RTX stream of the payload type (RFC 4588), or as they were if it's 0
+func (s *MultiFramedRTPSink) enableRetransmission(rtxPayloadType uint32) {
+	s.history = new(packetHistory)
+	s.rtxPayloadType = rtxPayloadType
+	s.rtxSSRC = gs.OurRandom32()
+	s.rtxSeqNo = uint16(gs.OurRandom16())
+}
+
+// keepSentPacket keeps a packet we sent, to retransmit it, (nothing without NACK)
+func (s *MultiFramedRTPSink) keepSentPacket(packet []byte) {
+	if s.history != nil {
+		s.history.add(packet)
+	}
+}
+
+// retransmit sends again the packets of the sequence numbers, those we still have
+func (s *MultiFramedRTPSink) retransmit(seqNos []uint16) {
+	if s.history == nil {
+		return
+	}
+	if s.rtxPayloadType == 0 && s.rtpInterface.srtp != nil {
+		// (SRTP can't protect a sequence number twice, only in a RTX stream of its own)
+		return
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	var numSent int
+	for _, seqNo := range seqNos {
+		packet := s.history.lookup(seqNo)
+		if packet == nil {
+			continue
+		}
+		if s.rtxPayloadType != 0 {
+			packet = s.rtxPacket(packet)
+		}
+		if s.rtpInterface.sendPacket(packet, uint(len(packet))) {
+			numSent++
+		}
+	}
+	rtpLog.Debug("retransmitted packets", "ssrc", s._ssrc, "nacked", len(seqNos), "sent", numSent)
+}
+
+// rtxPacket returns a packet of our RTX stream, of the payload type, sequence number and SSRC of the stream,
+// with the original sequence number before the payload
+func (s *MultiFramedRTPSink) rtxPacket(packet []byte) []byte {
+	rtx := make([]byte, rtpHeaderSize, len(packet)+2)
+	copy(rtx, packet)
+	rtx[1] = rtx[1]&0x80 | byte(s.rtxPayloadType)
+	binary.BigEndian.PutUint16(rtx[2:], s.rtxSeqNo)
+	binary.BigEndian.PutUint32(rtx[8:], s.rtxSSRC)
+	s.rtxSeqNo++
+
+	rtx = append(rtx, packet[2:4]...)
+	return append(rtx, packet[rtpHeaderSize:]...)
+}
+
+// unwrapRTXPacket takes the original sequence number from the start of the payload of a packet
+// retransmitted in a RTX stream (RFC 4588), and returns the RTP header with it; false if it's too short
+func unwrapRTXPacket(packet IBufferedPacket, rtpHdr uint32) (uint32, bool) {
+	if packet.dataSize() < 2 {
+		return rtpHdr, false
+	}
+	originalSeqNo := binary.BigEndian.Uint16(packet.data())
+	packet.skip(2)
+	return rtpHdr&0xFFFF0000 | uint32(originalSeqNo), true
+}
+
+// the minimum time before we NACK a sequence number again, (its retransmission may be on its way still)
+const minNACKInterval = 200 * time.Millisecond
+
+// nackHistory is when we NACKed the last sequence numbers, by sequence number
+type nackHistory [packetHistorySize]struct {
+	seqNo uint16
+	at    time.Time
+}
+
+// filter returns those of the sequence numbers that we didn't NACK within minNACKInterval of now,
+// and notes them as NACKed now
+func (h *nackHistory) filter(seqNos []uint16, now time.Time) (toNACK []uint16) {
+	for _, seqNo := range seqNos {
+		slot := &h[seqNo%packetHistorySize]
+		if slot.seqNo == seqNo && !slot.at.IsZero() && now.Sub(slot.at) < minNACKInterval {
+			continue
+		}
+		slot.seqNo, slot.at = seqNo, now
+		toNACK = append(toNACK, seqNo)
+	}
+	return
+}
+
+// noteMissingPackets passes on the packets missing before the one we stored last, e.g. to NACK them,
+// but those we just passed on
+func (s *MultiFramedRTPSource) noteMissingPackets(ssrc uint32) {
+	missing := s.reOrderingBuffer.missingPackets
+	if len(missing) == 0 || s.lostPacketsHandler == nil {
+		return
+	}
+	if s.nacked == nil {
+		s.nacked = new(nackHistory)
+	}
+	if toNACK := s.nacked.filter(missing, time.Now()); len(toNACK) > 0 {
+		s.lostPacketsHandler.(func(ssrc uint32, seqNos []uint16))(ssrc, toNACK)
+	}
+}
+
+// notePacketLoss passes on that we've given up on some packets, e.g. to ask for a keyframe, to recover
+func (s *MultiFramedRTPSource) notePacketLoss() {
+	if s.packetLossHandler != nil {
+		s.packetLossHandler.(func(ssrc uint32))(s.lastReceivedSSRC)
+	}
+}
+
+// the most packets we note as missing before a packet, (we consider more as a loss, rather than to NACK them)
+const maxMissingPackets = 64
+
+// noteMissingPackets notes the packets from the sequence number up to (but not including) another one
+func (b *ReorderingPacketBuffer) noteMissingPackets(fromSeqNo, toSeqNo uint) {
+	numMissing := (toSeqNo - fromSeqNo) & 0xFFFF
+	if numMissing > maxMissingPackets {
+		return
+	}
+	for i := uint(0); i < numMissing; i++ {
+		b.missingPackets = append(b.missingPackets, uint16(fromSeqNo+i))
+	}
+}
+
+// setKeyframeRequestHandler calls the handler on the PLI and FIR of our stream,
+// (at most once per minKeyframeRequestInterval)
+func (r *RTCPInstance) setKeyframeRequestHandler(handlerTask interface{}) {
+	r.keyframeRequestTask = handlerTask
+}
+
+// noteTransportLayerFeedback retransmits the packets of our stream that a NACK reports lost
+func (r *RTCPInstance) noteTransportLayerFeedback(p *rtcp.TransportLayerFeedback) {
+	if r.Sink != nil && p.Format == rtcp.FormatNACK && p.MediaSSRC == r.Sink.ssrc() {
+		rtcpLog.Debug("received a RTCP NACK", "ssrc", p.SenderSSRC)
+		r.Sink.retransmit(p.LostPackets())
+	}
+}
+
+// notePayloadSpecificFeedback passes on the PLI and FIR of our stream
+func (r *RTCPInstance) notePayloadSpecificFeedback(p *rtcp.PayloadSpecificFeedback) {
+	if r.Sink != nil && p.RequestsKeyframe(r.Sink.ssrc()) {
+		rtcpLog.Debug("received a RTCP keyframe request", "ssrc", p.SenderSSRC, "format", p.Format)
+		r.noteKeyframeRequest()
+	}
+}
+
+// noteKeyframeRequest passes a keyframe request on to the handler, unless we just did
+func (r *RTCPInstance) noteKeyframeRequest() {
+	if r.keyframeRequestTask == nil || time.Since(r.lastKeyframeRequest) < minKeyframeRequestInterval {
+		return
+	}
+	r.lastKeyframeRequest = time.Now()
+	r.keyframeRequestTask.(func())()
+}
+
+// sendFeedback sends a feedback packet in a compound of its own, after an empty RR and our SDES: (RFC 4585)
+// a report of ours would reset the statistics of the next one, which the receivers take as of a whole interval
+func (r *RTCPInstance) sendFeedback(feedback rtcp.Packet) {
+	r.sendCompound(&rtcp.ReceiverReport{SSRC: r.ssrc()}, rtcp.NewCNAME(r.ssrc(), r.CNAME), feedback)
+}
+
+// sendNACK asks the sender of the media source to retransmit the packets of the sequence numbers
+func (r *RTCPInstance) sendNACK(mediaSSRC uint32, seqNos []uint16) {
+	rtcpLog.Debug("sending a RTCP NACK", "ssrc", mediaSSRC, "lost", len(seqNos))
+	r.sendFeedback(rtcp.NewNACK(r.ssrc(), mediaSSRC, seqNos))
+}
+
+// sendPLI asks the sender of the media source for a keyframe, unless we just did
+func (r *RTCPInstance) sendPLI(mediaSSRC uint32) {
+	if time.Since(r.lastKeyframeRequest) < minKeyframeRequestInterval {
+		return
+	}
+	r.lastKeyframeRequest = time.Now()
+	rtcpLog.Debug("sending a RTCP PLI", "ssrc", mediaSSRC)
+	r.sendFeedback(rtcp.NewPLI(r.ssrc(), mediaSSRC))
+}
+
+// sendFIR asks the sender of the media source for a keyframe, for the senders that take a FIR (but no PLI)
+func (r *RTCPInstance) sendFIR(mediaSSRC uint32) {
+	if time.Since(r.lastKeyframeRequest) < minKeyframeRequestInterval {
+		return
+	}
+	r.lastKeyframeRequest = time.Now()
+	r.firSeqNo++
+	rtcpLog.Debug("sending a RTCP FIR", "ssrc", mediaSSRC)
+	r.sendFeedback(rtcp.NewFIR(r.ssrc(), mediaSSRC, r.firSeqNo))
+}
@@ -0,0 +1,118 @@
+package livemedia
+
+import (
+	"fmt"
+	"reflect"
+	sys "syscall"
+	"testing"
+	"time"
+)
+
+func TestRTCPFeedbackSDP(t *testing.T) {
+	feedback := RTCPFeedback{NACK: true, RTX: true, KeyframeRequests: true}
+	desc := "v=0\r\n" +
+		"s=test\r\n" +
+		"t=0 0\r\n" +
+		"m=video 0 RTP/AVP 96 112\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		feedback.sdpLines("video", 96, 90000) +
+		"a=control:track1\r\n"
+
+	session := NewMediaSession(desc)
+	if session == nil {
+		t.Error("failed")
+		return
+	}
+	subsession := session.Subsession()
+	if subsession.codecName != "H264" || subsession.rtpPayloadFormat != 96 || subsession.rtxPayloadFormat != 112 ||
+		!subsession.feedbackNACK || !subsession.feedbackPLI || !subsession.feedbackFIR {
+		fmt.Printf("%+v\n", subsession)
+		t.Error("failed")
+		return
+	}
+	if feedback.sdpLines("audio", 97, 8000) != "" {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestMissingPackets(t *testing.T) {
+	buffer := newReorderingPacketBuffer(nil)
+	store := func(seqNo uint32) []uint16 {
+		packet := newBufferedPacket()
+		var timeNow sys.Timeval
+		sys.Gettimeofday(&timeNow)
+		packet.assignMiscParams(seqNo, 0, timeNow, timeNow, false, false)
+		buffer.storePacket(packet)
+		return append([]uint16(nil), buffer.missingPackets...)
+	}
+	deliver := func() (seqNos []uint) {
+		for {
+			packet, _ := buffer.getNextCompletedPacket()
+			if packet == nil {
+				return
+			}
+			seqNos = append(seqNos, packet.rtpSeqNo())
+			buffer.releaseUsedPacket(packet)
+		}
+	}
+
+	// 65533 and 65534 are delivered, then 65535 and 0 are missing (across the wrap-around)
+	store(65533)
+	store(65534)
+	if seqNos := deliver(); !reflect.DeepEqual(seqNos, []uint{65533, 65534}) {
+		fmt.Println(seqNos)
+		t.Error("failed")
+		return
+	}
+	if missing := store(1); !reflect.DeepEqual(missing, []uint16{65535, 0}) {
+		fmt.Println(missing)
+		t.Error("failed")
+		return
+	}
+	if missing := store(2); len(missing) != 0 || len(deliver()) != 0 {
+		t.Error("failed")
+		return
+	}
+
+	// once retransmitted, they're delivered in order
+	store(0)
+	store(65535)
+	if seqNos := deliver(); !reflect.DeepEqual(seqNos, []uint{65535, 0, 1, 2}) {
+		fmt.Println(seqNos)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestNACKHistory(t *testing.T) {
+	var history nackHistory
+	now := time.Now()
+
+	// a sequence number is NACKed again only after minNACKInterval
+	if toNACK := history.filter([]uint16{10, 11}, now); !reflect.DeepEqual(toNACK, []uint16{10, 11}) {
+		fmt.Println(toNACK)
+		t.Error("failed")
+		return
+	}
+	if toNACK := history.filter([]uint16{11, 12}, now.Add(minNACKInterval/2)); !reflect.DeepEqual(toNACK, []uint16{12}) {
+		fmt.Println(toNACK)
+		t.Error("failed")
+		return
+	}
+	if toNACK := history.filter([]uint16{10, 11, 12}, now.Add(minNACKInterval)); !reflect.DeepEqual(toNACK, []uint16{10, 11}) {
+		fmt.Println(toNACK)
+		t.Error("failed")
+		return
+	}
+
+	// (a sequence number of the same slot is another one)
+	if toNACK := history.filter([]uint16{10 + packetHistorySize}, now.Add(minNACKInterval)); len(toNACK) != 1 {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -1,6 +1,6 @@
 package livemedia
 
-import gs "github.com/djwackey/dorsvr/groupsock"
+import "math/rand"
 
 const (
 	eventUnknown = 0
@@ -8,8 +8,9 @@ const (
 	eventBye     = 2
 )
 
-func drand48() int32 {
-	return gs.OurRandom()
+// drand48 returns a random number in [0, 1)
+func drand48() float64 {
+	return rand.Float64()
 }
 
 func rtcpInterval(members, senders, weSent, rtcpBW, avgRtcpSize float64) float64 {
@@ -36,7 +37,7 @@ func rtcpInterval(members, senders, weSent, rtcpBW, avgRtcpSize float64) float64
 		t = rtcpMinTime
 	}
 
-	t = t * (float64(drand48()) + 0.5)
+	t = t * (drand48() + 0.5)
 	t = t / COMPENSATION
 	return t
 }
@@ -53,11 +54,13 @@ func OnExpire(instance *RTCPInstance, members, senders, weSent, rtcpBW, avgRTCPS
 		if tn <= tc {
 			instance.sendReport()
 			avgRTCPSize = (1./16.)*float64(instance.sentPacketSize()) + (15./16.)*avgRTCPSize
+			instance.avgRTCPSize = avgRTCPSize
+			instance.prevReportTime = tc
 
 			t := rtcpInterval(members, senders, weSent, rtcpBW, avgRTCPSize)
-			instance.schedule(int64(t + tc))
+			instance.schedule(t + tc)
 		} else {
-			instance.schedule(int64(tn))
+			instance.schedule(tn)
 		}
 	}
 }
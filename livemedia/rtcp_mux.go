@@ -0,0 +1,10 @@
+package livemedia
+
+// the "a=rtcp-mux" line of our SDP descriptions, (we take RTCP on the RTP port of a stream, RFC 5761)
+const rtcpMuxSDPLine = "a=rtcp-mux\r\n"
+
+// isRTCPPacket reports whether a packet that arrived on a port shared by RTP and RTCP is RTCP,
+// from its second byte, (the RTCP packet types 192-223 are never RTP payload types, with the marker bit)
+func isRTCPPacket(packet []byte) bool {
+	return len(packet) >= 2 && packet[1] >= 192 && packet[1] <= 223
+}
@@ -0,0 +1,160 @@
+package livemedia
+
+import (
+	"math"
+	"time"
+
+	"github.com/djwackey/dorsvr/rtcp"
+)
+
+// the "a=rtcp-xr" line of our SDP descriptions, (the XR blocks we answer and take, RFC 3611)
+const rtcpXRSDPLine = "a=rtcp-xr:rcvr-rtt=all stat-summary=loss,dup,jitt voip-metrics\r\n"
+
+// the number of reports of a receiver that we keep the quality of, (5 minutes, at one report every 5 seconds)
+const qualityHistorySize = 60
+
+// XRStats are the statistics of the last RTCP XR of a receiver of our stream
+type XRStats struct {
+	// of the packets received since its previous XR
+	LostPackets      uint32
+	DuplicatePackets uint32
+	MinJitter        time.Duration
+	MaxJitter        time.Duration
+	MeanJitter       time.Duration
+	DevJitter        time.Duration
+	// of the packets it expected, lost and discarded (e.g. as they arrived too late), 0 to 1
+	LossRate    float64
+	DiscardRate float64
+	// the round-trip time that the receiver measured, (from our answers to its XRs)
+	RoundTripDelay time.Duration
+	ReceivedAt     time.Time
+}
+
+// QualitySample is the quality of our stream at a receiver, as of one of its reports
+type QualitySample struct {
+	Time         time.Time
+	FractionLost float64 // of the packets since the previous report, 0 to 1
+	Jitter       time.Duration
+	RTT          time.Duration // 0 if unknown
+}
+
+// noteXRBlocks updates the statistics of a receiver with the blocks of its XR about our stream
+func (x *XRStats) noteXRBlocks(blocks []rtcp.XRBlock, ssrc, timestampFrequency uint32, receivedAt time.Time) {
+	toDuration := func(jitter uint32) time.Duration {
+		if timestampFrequency == 0 {
+			return 0
+		}
+		return time.Duration(jitter) * time.Second / time.Duration(timestampFrequency)
+	}
+
+	for _, block := range blocks {
+		switch b := block.(type) {
+		case *rtcp.StatisticsSummary:
+			if b.SSRC != ssrc {
+				continue
+			}
+			x.LostPackets, x.DuplicatePackets = b.LostPackets, b.DuplicatePackets
+			x.MinJitter, x.MaxJitter = toDuration(b.MinJitter), toDuration(b.MaxJitter)
+			x.MeanJitter, x.DevJitter = toDuration(b.MeanJitter), toDuration(b.DevJitter)
+			x.ReceivedAt = receivedAt
+		case *rtcp.VoIPMetrics:
+			if b.SSRC != ssrc {
+				continue
+			}
+			x.LossRate, x.DiscardRate = float64(b.LossRate)/256, float64(b.DiscardRate)/256
+			x.RoundTripDelay = time.Duration(b.RoundTripDelay) * time.Millisecond
+			x.ReceivedAt = receivedAt
+		}
+	}
+}
+
+// compactNTPDuration returns a duration in 1/65536 seconds, as the delays of the RTCP reports
+func compactNTPDuration(d time.Duration) uint32 {
+	return uint32(d << 16 / time.Second)
+}
+
+// noteXRTransit notes the difference between the transit times of a packet and the one before it,
+// of the interval of our next XR statistics summary
+func (s *RTPReceptionStats) noteXRTransit(d uint32) {
+	if s.xrNumTransits == 0 || d < s.xrMinTransit {
+		s.xrMinTransit = d
+	}
+	if d > s.xrMaxTransit {
+		s.xrMaxTransit = d
+	}
+	s.xrNumTransits++
+	s.xrTransitSum += float64(d)
+	s.xrTransitSquares += float64(d) * float64(d)
+}
+
+// extendedReportBlocks returns the XR statistics summary and VoIP metrics of the packets of the source
+// received since the previous ones, and begins the next interval
+func (s *RTPReceptionStats) extendedReportBlocks(roundTripTime time.Duration) []rtcp.XRBlock {
+	expected := s.highestExtSeqNumReceived - s.xrBaseExtSeqNum
+	var lost uint32
+	if received := s.xrNumReceived - s.xrNumDuplicates; expected > received {
+		lost = expected - received
+	}
+
+	summary := &rtcp.StatisticsSummary{
+		HasLoss:          true,
+		HasDuplicates:    true,
+		HasJitter:        true,
+		SSRC:             s.ssrc,
+		BeginSeq:         uint16(s.xrBaseExtSeqNum + 1),
+		EndSeq:           uint16(s.highestExtSeqNumReceived + 1),
+		LostPackets:      lost,
+		DuplicatePackets: s.xrNumDuplicates,
+	}
+	if s.xrNumTransits > 0 {
+		mean := s.xrTransitSum / float64(s.xrNumTransits)
+		summary.MinJitter, summary.MaxJitter = s.xrMinTransit, s.xrMaxTransit
+		summary.MeanJitter = uint32(mean)
+		summary.DevJitter = uint32(math.Sqrt(math.Max(s.xrTransitSquares/float64(s.xrNumTransits)-mean*mean, 0)))
+	}
+
+	rate := func(count uint32) uint8 {
+		if expected == 0 {
+			return 0
+		}
+		return uint8(min(uint64(count)<<8/uint64(expected), 255))
+	}
+	// (the reordering buffer of our source is the only jitter buffer we know of)
+	jitterBuffer := uint16(defaultReorderingThreshold / time.Millisecond)
+	metrics := &rtcp.VoIPMetrics{
+		SSRC:           s.ssrc,
+		LossRate:       rate(lost),
+		DiscardRate:    rate(s.xrNumDiscarded),
+		RoundTripDelay: uint16(min(roundTripTime/time.Millisecond, math.MaxUint16)),
+		// (we measure neither the bursts, nor the levels nor the call quality)
+		SignalLevel: rtcp.VoIPUnavailable,
+		NoiseLevel:  rtcp.VoIPUnavailable,
+		RERL:        rtcp.VoIPUnavailable,
+		Gmin:        16,
+		RFactor:     rtcp.VoIPUnavailable,
+		ExtRFactor:  rtcp.VoIPUnavailable,
+		MOSLQ:       rtcp.VoIPUnavailable,
+		MOSCQ:       rtcp.VoIPUnavailable,
+		// (a non-adaptive jitter buffer)
+		RXConfig:     2 << 4,
+		JBNominal:    jitterBuffer,
+		JBMaximum:    jitterBuffer,
+		JBAbsMaximum: jitterBuffer,
+	}
+
+	s.resetXR()
+	return []rtcp.XRBlock{summary, metrics}
+}
+
+// resetXR begins the interval of the next XR statistics summary
+func (s *RTPReceptionStats) resetXR() {
+	s.xrBaseExtSeqNum = s.highestExtSeqNumReceived
+	s.xrNumReceived = 0
+	s.xrNumDuplicates = 0
+	s.xrNumDiscarded = 0
+	s.xrNumTransits = 0
+	s.xrMinTransit = 0
+	s.xrMaxTransit = 0
+	s.xrTransitSum = 0
+	s.xrTransitSquares = 0
+}
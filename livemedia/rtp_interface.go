@@ -1,10 +1,18 @@
 package livemedia
 
 import (
+	"io"
+	"log/slog"
 	"net"
+	"sync"
 
 	gs "github.com/djwackey/dorsvr/groupsock"
-	"github.com/djwackey/gitea/log"
+	"github.com/djwackey/dorsvr/logging"
+)
+
+var (
+	rtpLog  = logging.Logger(logging.RTP)
+	rtcpLog = logging.Logger(logging.RTCP)
 )
 
 type RTPInterface struct {
@@ -16,8 +24,20 @@ type RTPInterface struct {
 	tcpStreams                 *tcpStreamRecord
 	nextTCPReadSize            uint
 	nextTCPReadStreamChannelID uint
+	streamPackets              chan []byte
+	streamClosed               chan struct{}
+	srtp                       *srtpContext
+	isRTCP                     bool
+	lastReceivedFrom           *net.UDPAddr  // of the last packet read from our 'groupsock'
+	rtcpMux                    bool          // (a RTCP interface) our 'groupsock' is shared with RTP, RFC 5761
+	muxedRTP                   *RTPInterface // that we pass the RTP packets of the shared 'groupsock' on to
+	readFromHandler            interface{}   // func(from *net.UDPAddr), see setReadFromHandler
+	gsMutex                    sync.Mutex    // (the 'groupsock' of a RTCP interface is replaced by setRTCPMux)
 }
 
+// the number of interleaved packets that may wait for the reader before we start dropping them
+const streamPacketQueueSize = 100
+
 func newRTPInterface(owner interface{}, gs *gs.GroupSock) *RTPInterface {
 	return &RTPInterface{
 		gs:                gs,
@@ -31,7 +51,16 @@ func (i *RTPInterface) startNetworkReading(handlerProc interface{}) {
 }
 
 func (i *RTPInterface) stopNetworkReading() {
-	i.gs.Close()
+	if groupSock := i.groupSock(); groupSock != nil {
+		groupSock.Close()
+	}
+	if i.streamPackets != nil {
+		select {
+		case <-i.streamClosed:
+		default:
+			close(i.streamClosed)
+		}
+	}
 }
 
 func (i *RTPInterface) setServerRequestAlternativeByteHandler(socketNum net.Conn, handler interface{}) {
@@ -61,21 +90,46 @@ func (i *RTPInterface) addStreamSocket(socketNum net.Conn, streamChannelID uint)
 }
 
 func (i *RTPInterface) delStreamSocket(socketNum net.Conn, streamChannelID uint) {
-	var streams *tcpStreamRecord
-	for streams = i.tcpStreams; streams != nil; streams = streams.next {
-		if streams.streamSocketNum == socketNum && streams.streamChannelID == streamChannelID {
+	for streams := &i.tcpStreams; *streams != nil; {
+		if (*streams).streamSocketNum == socketNum && (*streams).streamChannelID == streamChannelID {
 			i.deregisterSocket(socketNum, streamChannelID)
 
-			next := streams.next
-			streams.next = nil
-			streams = next
+			// unlink the record
+			*streams = (*streams).next
+			continue
 		}
+		streams = &(*streams).next
 	}
 }
 
+// setSRTP makes us send SRTP (or, for a RTCP interface, SRTCP) packets, and accept only
+// SRTP (or SRTCP) packets that are authentic and not replayed, whether they go over UDP
+// or are interleaved in a RTSP connection. A nil context turns the protection off.
+func (i *RTPInterface) setSRTP(context *srtpContext, isRTCP bool) {
+	i.srtp = context
+	i.isRTCP = isRTCP
+}
+
+// logger returns the logger of the packets of the interface, RTP or RTCP
+func (i *RTPInterface) logger() *slog.Logger {
+	if i.isRTCP {
+		return rtcpLog
+	}
+	return rtpLog
+}
+
 // normal case: send as a UDP packet, also, send over each of our TCP sockets
 func (i *RTPInterface) sendPacket(packet []byte, packetSize uint) bool {
-	success := i.gs.Output(packet, packetSize)
+	if i.srtp != nil {
+		protected, err := i.srtp.protect(packet[:packetSize], i.isRTCP)
+		if err != nil {
+			i.logger().Warn("failed to protect a packet", "err", err)
+			return false
+		}
+		packet, packetSize = protected, uint(len(protected))
+	}
+
+	success := i.groupSock().Output(packet, packetSize)
 
 	var streams *tcpStreamRecord
 	for streams = i.tcpStreams; streams != nil; streams = streams.next {
@@ -86,7 +140,137 @@ func (i *RTPInterface) sendPacket(packet []byte, packetSize uint) bool {
 }
 
 func (i *RTPInterface) handleRead(buffer []byte) (int, error) {
-	return i.gs.HandleRead(buffer)
+	for {
+		numBytes, err := i.readPacket(buffer)
+		if err != nil || i.srtp == nil {
+			return numBytes, err
+		}
+
+		numBytes, err = i.srtp.unprotect(buffer[:numBytes], i.isRTCP)
+		if err == nil {
+			return numBytes, nil
+		}
+		// drop the packet, and wait for the next one
+		i.logger().Warn("failed to unprotect a packet", "err", err)
+	}
+}
+
+func (i *RTPInterface) readPacket(buffer []byte) (int, error) {
+	if i.streamPackets != nil {
+		select {
+		case packet := <-i.streamPackets:
+			return copy(buffer, packet), nil
+		case <-i.streamClosed:
+			return 0, io.EOF
+		}
+	}
+
+	for {
+		groupSock := i.groupSock()
+		numBytes, from, err := groupSock.HandleReadFrom(buffer)
+		i.lastReceivedFrom = from
+		if err != nil && i.streamPackets != nil {
+			// we were switched over to the RTSP connection while we waited
+			return i.readPacket(buffer)
+		}
+		if err != nil && i.groupSock() != groupSock {
+			// we were switched over to the 'groupsock' we share with RTP while we waited
+			continue
+		}
+		if err != nil {
+			return numBytes, err
+		}
+
+		i.gsMutex.Lock()
+		rtcpMux, muxedRTP, readFromHandler := i.rtcpMux, i.muxedRTP, i.readFromHandler
+		i.gsMutex.Unlock()
+		if readFromHandler != nil {
+			readFromHandler.(func(from *net.UDPAddr))(from)
+		}
+		if isPunchPacket(buffer[:numBytes]) {
+			continue
+		}
+		if rtcpMux && !isRTCPPacket(buffer[:numBytes]) {
+			if muxedRTP != nil {
+				muxedRTP.deliverStreamPacket(append([]byte(nil), buffer[:numBytes]...))
+			}
+			continue
+		}
+		return numBytes, nil
+	}
+}
+
+// setReadFromHandler calls the handler with the address of each packet we read from our 'groupsock',
+// (the server latches its clients to them, see StreamSettings.SymmetricRTPWindow)
+func (i *RTPInterface) setReadFromHandler(handler interface{}) {
+	i.gsMutex.Lock()
+	i.readFromHandler = handler
+	i.gsMutex.Unlock()
+}
+
+// groupSock returns the 'groupsock' we send and receive on
+func (i *RTPInterface) groupSock() *gs.GroupSock {
+	i.gsMutex.Lock()
+	defer i.gsMutex.Unlock()
+	return i.gs
+}
+
+// setStreamSocket makes us receive our packets from the RTSP connection
+// (see deliverStreamPacket), instead of from our 'groupsock'.
+func (i *RTPInterface) setStreamSocket() {
+	if i.streamPackets != nil {
+		return
+	}
+
+	i.queueStreamPackets()
+	if groupSock := i.groupSock(); groupSock != nil {
+		// wake up any reader that's still waiting on the UDP socket
+		groupSock.Close()
+	}
+}
+
+// queueStreamPackets makes us receive our packets from deliverStreamPacket
+func (i *RTPInterface) queueStreamPackets() {
+	if i.streamPackets != nil {
+		return
+	}
+	i.streamClosed = make(chan struct{})
+	i.streamPackets = make(chan []byte, streamPacketQueueSize)
+}
+
+// setRTCPMux makes us (a RTCP interface) send and receive on rtpGS, the 'groupsock' of a RTP stream,
+// (RFC 5761 rtcp-mux): we read its packets, and pass those that are RTP on to rtpInterface, if it
+// reads them, (the RTP interface of a sink doesn't)
+func (i *RTPInterface) setRTCPMux(rtpGS *gs.GroupSock, rtpInterface *RTPInterface) {
+	if rtpInterface != nil {
+		rtpInterface.queueStreamPackets()
+	}
+
+	i.gsMutex.Lock()
+	oldGS := i.gs
+	i.gs, i.rtcpMux, i.muxedRTP = rtpGS, true, rtpInterface
+	i.gsMutex.Unlock()
+	if oldGS != nil && oldGS != rtpGS {
+		// (which wakes up our reader, if it's waiting on it)
+		oldGS.Close()
+	}
+}
+
+// deliverStreamPacket queues a packet that the RTSP client read from its connection.
+func (i *RTPInterface) deliverStreamPacket(packet []byte) bool {
+	if i.streamPackets == nil {
+		return false
+	}
+
+	select {
+	case i.streamPackets <- packet:
+		return true
+	case <-i.streamClosed:
+		return false
+	default:
+		// the reader has fallen behind; drop the packet, as UDP would
+		return false
+	}
 }
 
 func (i *RTPInterface) lookupSocketDescriptor(socketNum net.Conn) *SocketDescriptor {
@@ -130,35 +314,17 @@ func newTCPStreamRecord(streamSocketNum net.Conn, streamChannelID uint, next *tc
 ///////////// Help Functions ///////////////
 
 // Send RTP over TCP, using the encoding defined RFC 2326, section 10.12:
+// (in a single write, so that a TLS connection sends it as a single record)
 func sendRTPOverTCP(socketNum net.Conn, packet []byte, packetSize, streamChannelID uint) error {
-	var err error
-
-	dollar := []byte{'$'}
-	_, err = socketNum.Write(dollar)
-	if err != nil {
-		return err
-	}
-
-	channelID := []byte{byte(streamChannelID)}
-	_, err = socketNum.Write(channelID)
-	if err != nil {
-		return err
-	}
-
-	netPacketSize := make([]byte, 2)
-	netPacketSize[0] = byte((packetSize & 0xFF00) >> 8)
-	netPacketSize[1] = byte(packetSize & 0xFF)
-	_, err = socketNum.Write(netPacketSize)
-	if err != nil {
-		return err
-	}
-
-	_, err = socketNum.Write(packet[:packetSize])
-	if err != nil {
-		return err
-	}
-
-	return nil
+	framed := make([]byte, 4+packetSize)
+	framed[0] = '$'
+	framed[1] = byte(streamChannelID)
+	framed[2] = byte((packetSize & 0xFF00) >> 8)
+	framed[3] = byte(packetSize & 0xFF)
+	copy(framed[4:], packet[:packetSize])
+
+	_, err := socketNum.Write(framed)
+	return err
 }
 
 const (
@@ -223,7 +389,6 @@ func (s *SocketDescriptor) tcpReadHandler(rtpInterface *RTPInterface) {
 	switch s.tcpReadingState {
 	case awaitingDollar:
 		if buffer[0] == '$' {
-			log.Debug("[SocketDescriptor::tcpReadHandler] Saw '$'")
 			s.tcpReadingState = awaitingStreamChannelID
 		} else {
 			// This character is part of a RTSP request or command, which is handled separately:
@@ -1,6 +1,12 @@
 package livemedia
 
-import sys "syscall"
+import (
+	"sync"
+	sys "syscall"
+	"time"
+
+	"github.com/djwackey/dorsvr/rtcp"
+)
 
 ////////// RTPReceptionStats //////////
 const Million = 1000000
@@ -12,16 +18,26 @@ type RTPReceptionStats struct {
 	totBytesReceivedLo               uint32
 	lastReceivedSRNTPmsw             uint32
 	lastReceivedSRNTPlsw             uint32
+	lastReceivedSRRTPTimestamp       uint32
 	baseExtSeqNumReceived            uint32
 	totNumPacketsReceived            uint32 // for all SSRCs
 	highestExtSeqNumReceived         uint32
 	previousPacketRTPTimestamp       uint32
 	lastResetExtSeqNumReceived       uint32
 	numPacketsReceivedSinceLastReset uint32
+	xrBaseExtSeqNum                  uint32 // the interval of our next XR statistics summary begins after it
+	xrNumReceived                    uint32 // (of the interval)
+	xrNumDuplicates                  uint32
+	xrNumDiscarded                   uint32
+	xrNumTransits                    uint32
+	xrMinTransit                     uint32 // the differences of the transit times, in timestamp units
+	xrMaxTransit                     uint32
+	xrTransitSum                     float64
+	xrTransitSquares                 float64
 	minInterPacketGapUS              int64
 	maxInterPacketGapUS              int64
 	lastTransit                      int64
-	syncTime                         sys.Timeval
+	syncTime                         time.Time
 	lastReceivedSRTime               sys.Timeval
 	lastPacketReceptionTime          sys.Timeval
 	totalInterPacketGaps             sys.Timeval
@@ -48,6 +64,7 @@ func (s *RTPReceptionStats) init(ssrc uint32) {
 	s.jitter = 0.0
 	s.lastReceivedSRNTPmsw = 0
 	s.lastReceivedSRNTPlsw = 0
+	s.lastReceivedSRRTPTimestamp = 0
 	s.lastReceivedSRTime.Sec = 0
 	s.lastReceivedSRTime.Usec = 0
 	s.lastPacketReceptionTime.Sec = 0
@@ -57,8 +74,7 @@ func (s *RTPReceptionStats) init(ssrc uint32) {
 	s.totalInterPacketGaps.Sec = 0
 	s.totalInterPacketGaps.Usec = 0
 	s.hasBeenSynchronized = false
-	s.syncTime.Sec = 0
-	s.syncTime.Usec = 0
+	s.syncTime = time.Time{}
 	s.reset()
 }
 
@@ -71,6 +87,9 @@ func (s *RTPReceptionStats) initSeqNum(initialSeqNum uint32) {
 	s.baseExtSeqNumReceived = 0x10000 | initialSeqNum
 	s.highestExtSeqNumReceived = 0x10000 | initialSeqNum
 	s.haveSeenInitialSequenceNumber = true
+	// (the intervals of our reports begin before the first packet)
+	s.lastResetExtSeqNumReceived = s.baseExtSeqNumReceived - 1
+	s.xrBaseExtSeqNum = s.baseExtSeqNumReceived - 1
 }
 
 func (s *RTPReceptionStats) noteIncomingPacket(seqNum, rtpTimestamp, timestampFrequency, packetSize uint32,
@@ -81,6 +100,7 @@ func (s *RTPReceptionStats) noteIncomingPacket(seqNum, rtpTimestamp, timestampFr
 
 	s.numPacketsReceivedSinceLastReset++
 	s.totNumPacketsReceived++
+	s.xrNumReceived++
 
 	prevTotBytesReceivedLo := s.totBytesReceivedLo
 	s.totBytesReceivedLo += packetSize
@@ -125,19 +145,14 @@ func (s *RTPReceptionStats) noteIncomingPacket(seqNum, rtpTimestamp, timestampFr
 	sys.Gettimeofday(&timeNow)
 	if s.lastPacketReceptionTime.Sec != 0 ||
 		s.lastPacketReceptionTime.Usec != 0 {
-		gap := (timeNow.Sec-s.lastPacketReceptionTime.Sec)*Million +
-			timeNow.Usec - s.lastPacketReceptionTime.Usec
+		gap := microseconds(timeNow) - microseconds(s.lastPacketReceptionTime)
 		if gap > s.maxInterPacketGapUS {
 			s.maxInterPacketGapUS = gap
 		}
 		if gap < s.minInterPacketGapUS {
 			s.minInterPacketGapUS = gap
 		}
-		s.totalInterPacketGaps.Usec += gap
-		if s.totalInterPacketGaps.Usec >= Million {
-			s.totalInterPacketGaps.Sec++
-			s.totalInterPacketGaps.Usec -= Million
-		}
+		addMicroseconds(&s.totalInterPacketGaps, gap)
 	}
 	s.lastPacketReceptionTime = timeNow
 
@@ -148,8 +163,8 @@ func (s *RTPReceptionStats) noteIncomingPacket(seqNum, rtpTimestamp, timestampFr
 	// the same as that of the previous packet (this indicates a multi-packet
 	// fragment), or if we've been explicitly told not to use this packet.
 	if useForJitterCalculation && rtpTimestamp != s.previousPacketRTPTimestamp {
-		arrival := int64(timestampFrequency) * timeNow.Sec
-		arrival += ((2.0*int64(timestampFrequency)*timeNow.Usec + 1000000.0) / 2000000)
+		arrival := int64(timestampFrequency) * int64(timeNow.Sec)
+		arrival += ((2.0*int64(timestampFrequency)*int64(timeNow.Usec) + 1000000.0) / 2000000)
 		// note: rounding
 		transit := arrival - int64(rtpTimestamp)
 		if s.lastTransit == -1 {
@@ -161,72 +176,60 @@ func (s *RTPReceptionStats) noteIncomingPacket(seqNum, rtpTimestamp, timestampFr
 			d = -d
 		}
 		s.jitter += (1.0 / 16.0) * (float32(d) - s.jitter)
+		s.noteXRTransit(uint32(d))
 	}
 
 	// Return the 'presentation time' that corresponds to "rtpTimestamp":
-	if s.syncTime.Sec == 0 && s.syncTime.Usec == 0 {
+	if s.syncTime.IsZero() {
 		// This is the first timestamp that we've seen, so use the current
 		// 'wall clock' time as the synchronization time.  (This will be
 		// corrected later when we receive RTCP SRs.)
 		s.syncTimestamp = rtpTimestamp
-		s.syncTime = timeNow
+		s.syncTime = time.Unix(timeNow.Unix())
 	}
 
-	timestampDiff := rtpTimestamp - s.syncTimestamp
-	// Note: This works even if the timestamp wraps around
-	// (as long as "int" is 32 bits)
-
-	// Divide this by the timestamp frequency to get real time:
-	timeDiff := float32(timestampDiff) / float32(timestampFrequency)
-
-	// Add this to the 'sync time' to get our result:
-	var million float32 = 1000000
-	var seconds, uSeconds int64
-	if timeDiff >= 0.0 {
-		seconds = s.syncTime.Sec + int64(timeDiff)
-		uSeconds = s.syncTime.Usec + int64((timeDiff-float32(int64(timeDiff)))*million)
-		if uSeconds >= int64(million) {
-			uSeconds -= int64(million)
-			seconds++
-		}
-	} else {
-		timeDiff = -timeDiff
-		seconds = s.syncTime.Sec - int64(timeDiff)
-		uSeconds = s.syncTime.Usec - int64((timeDiff-float32(int64(timeDiff)))*million)
-		if uSeconds < 0 {
-			uSeconds += int64(million)
-			seconds--
-		}
-	}
-
-	resultPresentationTime.Sec = int64(seconds)
-	resultPresentationTime.Usec = int64(uSeconds)
+	// Add the time since the 'sync timestamp' to the 'sync time' to get our result:
+	presentationTime := s.syncTime.Add(timestampDuration(rtpTimestamp-s.syncTimestamp, timestampFrequency))
+	resultPresentationTime = sys.NsecToTimeval(presentationTime.UnixNano())
 	resultHasBeenSyncedUsingRTCP = s.hasBeenSynchronized
 
 	// Save these as the new synchronization timestamp & time:
 	s.syncTimestamp = rtpTimestamp
-	s.syncTime = resultPresentationTime
+	s.syncTime = presentationTime
 
 	s.previousPacketRTPTimestamp = rtpTimestamp
 	return
 }
 
-func (s *RTPReceptionStats) noteIncomingSR(ntpTimestampMSW, ntpTimestampLSW, rtpTimestamp uint32) {
-	s.lastReceivedSRNTPmsw = ntpTimestampMSW
-	s.lastReceivedSRNTPlsw = ntpTimestampLSW
+func (s *RTPReceptionStats) noteIncomingSR(ntpTime uint64, rtpTimestamp uint32) {
+	s.lastReceivedSRNTPmsw = uint32(ntpTime >> 32)
+	s.lastReceivedSRNTPlsw = uint32(ntpTime)
+	s.lastReceivedSRRTPTimestamp = rtpTimestamp
 
 	sys.Gettimeofday(&s.lastReceivedSRTime)
 
 	// Use this SR to update time synchronization information:
 	s.syncTimestamp = rtpTimestamp
-	s.syncTime.Sec = int64(ntpTimestampMSW - 0x83AA7E80)              // 1/1/1900 -> 1/1/1970
-	microseconds := float32((ntpTimestampLSW * 15625.0) / 0x04000000) // 10^6/2^32
-	s.syncTime.Usec = int64(microseconds + 0.5)
+	s.syncTime = rtcp.Time(ntpTime)
 	s.hasBeenSynchronized = true
 }
 
+// senderReport returns the last SR of the source, if one has arrived yet
+func (s *RTPReceptionStats) senderReport() (SenderReport, bool) {
+	if !s.hasBeenSynchronized {
+		return SenderReport{}, false
+	}
+	return SenderReport{
+		SSRC:       s.ssrc,
+		NTPTime:    rtcp.Time(uint64(s.lastReceivedSRNTPmsw)<<32 | uint64(s.lastReceivedSRNTPlsw)),
+		RTPTime:    s.lastReceivedSRRTPTimestamp,
+		ReceivedAt: time.Unix(s.lastReceivedSRTime.Unix()),
+	}, true
+}
+
 ////////// RTPReceptionStatsDB //////////
 type RTPReceptionStatsDB struct {
+	mutex                          sync.Mutex
 	table                          map[uint32]*RTPReceptionStats
 	totNumPacketsReceived          uint32
 	numActiveSourcesSinceLastReset uint32
@@ -250,6 +253,9 @@ func (d *RTPReceptionStatsDB) lookup(ssrc uint32) *RTPReceptionStats {
 func (d *RTPReceptionStatsDB) noteIncomingPacket(ssrc, seqNum,
 	rtpTimestamp, timestampFrequency, packetSize uint32,
 	useForJitterCalculation bool) (presentationTime sys.Timeval, hasBeenSyncedUsingRTCP bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
 	d.totNumPacketsReceived++
 
 	s := d.lookup(ssrc)
@@ -271,14 +277,61 @@ func (d *RTPReceptionStatsDB) noteIncomingPacket(ssrc, seqNum,
 	return
 }
 
-func (d *RTPReceptionStatsDB) noteIncomingSR(ssrc, ntpTimestampMSW, ntpTimestampLSW, rtpTimestamp uint32) {
+func (d *RTPReceptionStatsDB) noteIncomingSR(ssrc uint32, ntpTime uint64, rtpTimestamp uint32) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
 	s := d.lookup(ssrc)
 	if s == nil {
-		s = newRTPReceptionStats(ssrc, 0)
-		if s == nil {
-			return
+		// (we haven't received a packet of the source yet, so its sequence numbers are unknown)
+		s = new(RTPReceptionStats)
+		s.init(ssrc)
+		d.add(ssrc, s)
+	}
+	s.noteIncomingSR(ntpTime, rtpTimestamp)
+}
+
+// noteDiscardedPacket notes a packet of the source that we received but couldn't use,
+// as we had it already, or it arrived too late
+func (d *RTPReceptionStatsDB) noteDiscardedPacket(ssrc uint32, duplicate bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	s := d.lookup(ssrc)
+	if s == nil {
+		return
+	}
+	if duplicate {
+		s.xrNumDuplicates++
+	} else {
+		s.xrNumDiscarded++
+	}
+}
+
+// extendedReportBlocks returns the XR blocks about the sources, (see RTPReceptionStats.extendedReportBlocks)
+func (d *RTPReceptionStatsDB) extendedReportBlocks(roundTripTime time.Duration) []rtcp.XRBlock {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var blocks []rtcp.XRBlock
+	for _, s := range d.table {
+		if !s.haveSeenInitialSequenceNumber {
+			continue
+		}
+		blocks = append(blocks, s.extendedReportBlocks(roundTripTime)...)
+	}
+	return blocks
+}
+
+// lastSenderReport returns the last SR of the sources, if one has arrived yet
+func (d *RTPReceptionStatsDB) lastSenderReport() (last SenderReport, ok bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, s := range d.table {
+		if report, synced := s.senderReport(); synced && (!ok || report.ReceivedAt.After(last.ReceivedAt)) {
+			last, ok = report, true
 		}
-		d.table[ssrc] = s
 	}
-	s.noteIncomingSR(ntpTimestampMSW, ntpTimestampLSW, rtpTimestamp)
+	return
 }
@@ -0,0 +1,51 @@
+package livemedia
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/djwackey/dorsvr/rtcp"
+)
+
+func TestSenderReportSync(t *testing.T) {
+	source := &RTPSource{receptionStatsDB: newRTPReceptionStatsDB()}
+	subsession := &MediaSubsession{RTPSource: source, rtpTimestampFrequency: 90000}
+	db := source.receptionStatsDB
+
+	// before any SR, the presentation times are only our own
+	if _, synced := db.noteIncomingPacket(0x1234, 1, 4000000000, 90000, 1000, true); synced {
+		t.Error("failed")
+		return
+	}
+	if _, ok := subsession.PresentationTime(4000000000); ok {
+		t.Error("failed")
+		return
+	}
+
+	// the SR maps the RTP timestamp 4294960000 to the wallclock time of the sender,
+	// and the next packet is 0.1 second after it (with the timestamp wrapping around)
+	ntpTime := time.Unix(1700000000, 500000000)
+	db.noteIncomingSR(0x1234, rtcp.NTPTime(ntpTime), 4294960000)
+
+	presentationTime, synced := db.noteIncomingPacket(0x1234, 2, 1704, 90000, 1000, true)
+	expected := ntpTime.Add(100 * time.Millisecond)
+	if d := time.Unix(presentationTime.Unix()).Sub(expected); !synced || d < -time.Microsecond || d > time.Microsecond {
+		fmt.Println(presentationTime, synced)
+		t.Error("failed")
+		return
+	}
+
+	report, ok := subsession.LastSenderReport()
+	if !ok || report.SSRC != 0x1234 || report.RTPTime != 4294960000 {
+		fmt.Printf("%+v\n", report)
+		t.Error("failed")
+		return
+	}
+	if pt, ok := subsession.PresentationTime(4294960000 - 45000); !ok || pt.Sub(ntpTime.Add(-500*time.Millisecond)).Abs() > time.Microsecond {
+		fmt.Println(pt)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
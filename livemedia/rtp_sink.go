@@ -3,6 +3,7 @@ package livemedia
 import (
 	"fmt"
 	"net"
+	"sync/atomic"
 	sys "syscall"
 
 	gs "github.com/djwackey/dorsvr/groupsock"
@@ -14,9 +15,9 @@ type RTPSink struct {
 	MediaSink
 	seqNo                       uint32
 	_ssrc                       uint32
-	_octetCount                 uint
-	_packetCount                uint // incl RTP hdr
-	totalOctetCount             uint
+	_octetCount                 atomic.Uint64
+	_packetCount                atomic.Uint64 // incl RTP hdr
+	totalOctetCount             atomic.Uint64
 	timestampBase               uint32
 	_rtpPayloadType             uint32
 	rtpTimestampFrequency       uint32
@@ -52,6 +53,8 @@ func (s *RTPSink) delStreamSocket(socketNum net.Conn, streamChannelID uint) {
 }
 
 func (s *RTPSink) currentSeqNo() uint32 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.seqNo
 }
 
@@ -79,26 +82,61 @@ func (s *RTPSink) ssrc() uint32 {
 }
 
 func (s *RTPSink) octetCount() uint {
-	return s._octetCount
+	return uint(s._octetCount.Load())
 }
 
 func (s *RTPSink) packetCount() uint {
-	return s._packetCount
+	return uint(s._packetCount.Load())
+}
+
+// notePacketSent counts a packet we sent, (the counts are read by other goroutines, e.g. for the metrics)
+func (s *RTPSink) notePacketSent(packetSize, payloadSize uint) {
+	s._packetCount.Add(1)
+	s.totalOctetCount.Add(uint64(packetSize))
+	s._octetCount.Add(uint64(payloadSize))
 }
 
 func (s *RTPSink) enableRTCPReports() bool {
 	return s._enableRTCPReports
 }
 
-func (s *RTPSink) nextTimestampHasBeenPreset() bool {
-	return s._nextTimestampHasBeenPreset
+// senderReportTimestamp returns the RTP timestamp of tv for a RTCP SR, which can't be made (false)
+// while the timestamp of our next packet is preset still
+func (s *RTPSink) senderReportTimestamp(tv sys.Timeval) (uint32, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s._nextTimestampHasBeenPreset {
+		return 0, false
+	}
+	return s.convertToRTPTimestamp(tv), true
 }
 
 func (s *RTPSink) transmissionStatsDB() *RTPTransmissionStatsDB {
 	return s._transmissionStatsDB
 }
 
+func (s *RTPSink) timestampFrequency() uint32 {
+	return s.rtpTimestampFrequency
+}
+
+func (s *RTPSink) srtpContext() *srtpContext {
+	return s.rtpInterface.srtp
+}
+
+// enableSRTP makes the sink send SRTP (and its RTCP instance send SRTCP), using masterKey.
+func (s *RTPSink) enableSRTP(profile SRTPProfile, masterKey []byte) error {
+	context, err := newSRTPContext(profile, masterKey)
+	if err != nil {
+		return err
+	}
+	s.rtpInterface.setSRTP(context, false)
+	return nil
+}
+
 func (s *RTPSink) presetNextTimestamp() uint32 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	var timeNow sys.Timeval
 	sys.Gettimeofday(&timeNow)
 
@@ -111,8 +149,9 @@ func (s *RTPSink) presetNextTimestamp() uint32 {
 
 func (s *RTPSink) convertToRTPTimestamp(tv sys.Timeval) uint32 {
 	// Begin by converting from "struct timeval" units to RTP timestamp units:
-	timestampIncrement := s.rtpTimestampFrequency * uint32(tv.Sec)
-	timestampIncrement += (2.0*s.rtpTimestampFrequency*uint32(tv.Usec) + 1000000.0) / 2000000
+	// (in 64 bits, which the product of the frequency and the microseconds overflows)
+	frequency := uint64(s.rtpTimestampFrequency)
+	timestampIncrement := uint32(frequency*uint64(tv.Sec) + (2*frequency*uint64(tv.Usec)+1000000)/2000000)
 
 	// Then add this to our 'timestamp base':
 	if s._nextTimestampHasBeenPreset {
@@ -1,6 +1,10 @@
 package livemedia
 
-import gs "github.com/djwackey/dorsvr/groupsock"
+import (
+	"time"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+)
 
 type RTPSource struct {
 	FramedSource
@@ -12,10 +16,23 @@ type RTPSource struct {
 	curPacketRTPTimestamp  uint32
 	curPacketSyncUsingRTCP bool
 	curPacketMarkerBit     bool
+	rtxPayloadFormat       uint32      // of the RTX stream of our retransmitted packets, 0 if there's none
+	lostPacketsHandler     interface{} // func(ssrc uint32, seqNos []uint16), e.g. sends a NACK
+	packetLossHandler      interface{} // func(ssrc uint32), e.g. sends a PLI
+	fec                    *fecDecoder // of the FEC stream that protects ours, nil if there's none
 	receptionStatsDB       *RTPReceptionStatsDB
 	rtpInterface           *RTPInterface
 }
 
+// SenderReport is the last RTCP sender report of the sender of a stream we receive,
+// which maps its RTP timestamps to its wallclock time
+type SenderReport struct {
+	SSRC       uint32
+	NTPTime    time.Time // the wallclock time of the sender
+	RTPTime    uint32    // the RTP timestamp of the same instant
+	ReceivedAt time.Time
+}
+
 func newRTPSource() *RTPSource {
 	return &RTPSource{}
 }
@@ -32,5 +49,30 @@ func (s *RTPSource) initRTPSouce(isource IFramedSource, RTPgs *gs.GroupSock,
 	s.initFramedSource(isource)
 }
 
+// SetStreamSocket makes the source read its packets from the RTSP connection (RTP-over-TCP).
 func (s *RTPSource) SetStreamSocket() {
+	s.rtpInterface.setStreamSocket()
+}
+
+func (s *RTPSource) srtpContext() *srtpContext {
+	return s.rtpInterface.srtp
+}
+
+// enableSRTP makes the source expect SRTP, using masterKey.
+func (s *RTPSource) enableSRTP(profile SRTPProfile, masterKey []byte) error {
+	context, err := newSRTPContext(profile, masterKey)
+	if err != nil {
+		return err
+	}
+	s.rtpInterface.setSRTP(context, false)
+	return nil
+}
+
+// timestampDuration returns the time of a difference of RTP timestamps,
+// (which works even if the timestamp wraps around, as long as they're less than 2^31 apart)
+func timestampDuration(timestampDiff, timestampFrequency uint32) time.Duration {
+	if timestampFrequency == 0 {
+		return 0
+	}
+	return time.Duration(int32(timestampDiff)) * time.Second / time.Duration(timestampFrequency)
 }
@@ -1,15 +1,33 @@
 package livemedia
 
 import (
+	"sync"
 	sys "syscall"
+	"time"
+
+	"github.com/djwackey/dorsvr/rtcp"
 )
 
 //////// RTPTransmissionStatsDB ////////
 type RTPTransmissionStatsDB struct {
 	sink  *RTPSink
+	mutex sync.Mutex
 	table map[uint32]*RTPTransmissionStats
 }
 
+// ReceiverReport is the last RTCP receiver report of a receiver of our stream
+type ReceiverReport struct {
+	SSRC         uint32
+	FromAddress  string
+	FractionLost float64 // of the packets since the previous report, 0 to 1
+	PacketsLost  uint32  // cumulative
+	Jitter       time.Duration
+	RTT          time.Duration // 0 if unknown
+	ReceivedAt   time.Time
+	// the statistics of its last XR, if it sends them
+	XR *XRStats
+}
+
 func newRTPTransmissionStatsDB(sink *RTPSink) *RTPTransmissionStatsDB {
 	return &RTPTransmissionStatsDB{
 		sink:  sink,
@@ -28,6 +46,9 @@ func (d *RTPTransmissionStatsDB) lookup(ssrc uint32) *RTPTransmissionStats {
 
 func (d *RTPTransmissionStatsDB) noteIncomingRR(lastFromAddress string,
 	ssrc, lossStats, lastPacketNumReceived, jitter, lastSRTime, diffSRRRTime uint32) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
 	stats := d.lookup(ssrc)
 	if stats == nil {
 		// This is the first time we've heard of this SSRC.
@@ -44,6 +65,80 @@ func (d *RTPTransmissionStatsDB) noteIncomingRR(lastFromAddress string,
 		lastSRTime, diffSRRRTime)
 }
 
+// noteIncomingXR updates the stats about our transmissions with a XR of a receiver
+func (d *RTPTransmissionStatsDB) noteIncomingXR(lastFromAddress string, xr *rtcp.ExtendedReport) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stats := d.lookup(xr.SSRC)
+	if stats == nil {
+		stats = newRTPTransmissionStats(d.sink, xr.SSRC)
+		d.add(xr.SSRC, stats)
+	}
+	stats.noteIncomingXR(lastFromAddress, xr.Blocks)
+}
+
+// dlrrReports returns our answers to the last receiver reference times of the receivers, (at most 31)
+func (d *RTPTransmissionStatsDB) dlrrReports() []rtcp.DLRRReport {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var reports []rtcp.DLRRReport
+	for _, stats := range d.table {
+		if len(reports) == 31 {
+			break
+		}
+		if stats.lastRRTime == 0 {
+			continue
+		}
+		reports = append(reports, rtcp.DLRRReport{
+			SSRC:               stats.ssrc,
+			LastReceiverReport: stats.lastRRTime,
+			Delay:              compactNTPDuration(time.Since(stats.lastRRTimeReceived)),
+		})
+	}
+	return reports
+}
+
+// qualityHistory returns the quality of our stream at a receiver, as of its last reports (the oldest first)
+func (d *RTPTransmissionStatsDB) qualityHistory(ssrc uint32) []QualitySample {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stats := d.lookup(ssrc)
+	if stats == nil {
+		return nil
+	}
+	return append([]QualitySample(nil), stats.history...)
+}
+
+// receiverReports returns the last reports of the receivers, (the jitter in seconds needs the timestamp frequency)
+func (d *RTPTransmissionStatsDB) receiverReports(timestampFrequency uint32) []ReceiverReport {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	reports := make([]ReceiverReport, 0, len(d.table))
+	for _, stats := range d.table {
+		report := ReceiverReport{
+			SSRC:         stats.ssrc,
+			FromAddress:  stats.lastFromAddress,
+			FractionLost: float64(stats.packetLossRatio) / 256,
+			PacketsLost:  stats.totNumPacketsLost,
+			RTT:          stats.rtt,
+			ReceivedAt:   time.Unix(int64(stats.timeReceived.Sec), int64(stats.timeReceived.Usec)*1000),
+		}
+		if timestampFrequency > 0 {
+			report.Jitter = time.Duration(stats.jitter) * time.Second / time.Duration(timestampFrequency)
+		}
+		if !stats.xr.ReceivedAt.IsZero() {
+			xr := stats.xr
+			report.XR = &xr
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
 //////// RTPTransmissionStats ////////
 type RTPTransmissionStats struct {
 	sink                          *RTPSink
@@ -63,11 +158,16 @@ type RTPTransmissionStats struct {
 	firstPacketNumReported        uint32
 	oldLastPacketNumReceived      uint32
 	oldTotNumPacketsLost          uint32
+	lastRRTime                    uint32 // the middle 32 bits of the last receiver reference time of its XRs
 	lastFromAddress               string
 	atLeastTwoRRsHaveBeenReceived bool
 	firstPacket                   bool
 	timeCreated                   sys.Timeval
 	timeReceived                  sys.Timeval
+	lastRRTimeReceived            time.Time
+	rtt                           time.Duration
+	xr                            XRStats
+	history                       []QualitySample // of its last reports, (at most qualityHistorySize)
 }
 
 func newRTPTransmissionStats(sink *RTPSink, ssrc uint32) *RTPTransmissionStats {
@@ -97,6 +197,20 @@ func (s *RTPTransmissionStats) noteIncomingRR(lastFromAddress string,
 	s.lastSRTime = lastSRTime
 	s.diffSRRRTime = diffSRRRTime
 
+	// (the receiver tells when our last SR arrived, and how long it waited since)
+	now := time.Unix(int64(s.timeReceived.Sec), int64(s.timeReceived.Usec)*1000)
+	if rtt, ok := rtcp.RoundTripTime(lastSRTime, diffSRRRTime, now); ok {
+		s.rtt = rtt
+	}
+	sample := QualitySample{Time: now, FractionLost: float64(s.packetLossRatio) / 256, RTT: s.rtt}
+	if timestampFrequency := s.sink.timestampFrequency(); timestampFrequency > 0 {
+		sample.Jitter = time.Duration(jitter) * time.Second / time.Duration(timestampFrequency)
+	}
+	if len(s.history) == qualityHistorySize {
+		s.history = append(s.history[:0], s.history[1:]...)
+	}
+	s.history = append(s.history, sample)
+
 	// Update our counts of the total number of octets and packets sent towards
 	// this receiver:
 	newOctetCount := uint32(s.sink.octetCount())
@@ -117,3 +231,16 @@ func (s *RTPTransmissionStats) noteIncomingRR(lastFromAddress string,
 		s.totalPacketCountHi++
 	}
 }
+
+func (s *RTPTransmissionStats) noteIncomingXR(lastFromAddress string, blocks []rtcp.XRBlock) {
+	now := time.Now()
+	s.lastFromAddress = lastFromAddress
+	for _, block := range blocks {
+		if b, ok := block.(*rtcp.ReceiverReferenceTime); ok {
+			// (which we answer in a DLRR of our next report)
+			s.lastRRTime = rtcp.MiddleNTPTime(b.NTPTime)
+			s.lastRRTimeReceived = now
+		}
+	}
+	s.xr.noteXRBlocks(blocks, s.sink.ssrc(), s.sink.timestampFrequency(), now)
+}
@@ -0,0 +1,73 @@
+package livemedia
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/djwackey/dorsvr/rtcp"
+)
+
+func TestReceiverReports(t *testing.T) {
+	sink := &RTPSink{rtpTimestampFrequency: 90000}
+	db := newRTPTransmissionStatsDB(sink)
+
+	// a quarter of the packets lost since the previous report, 12 in all, and a jitter of 900 (at 90 kHz)
+	db.noteIncomingRR("192.168.1.105", 0x1234, 64<<24|12, 1000, 900, 0, 0)
+
+	reports := db.receiverReports(sink.timestampFrequency())
+	if len(reports) != 1 {
+		t.Error("failed")
+		return
+	}
+
+	report := reports[0]
+	fmt.Printf("%+v\n", report)
+	if report.SSRC != 0x1234 || report.FromAddress != "192.168.1.105" ||
+		report.FractionLost != 0.25 || report.PacketsLost != 12 || report.Jitter != 10*time.Millisecond {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestQualityHistory(t *testing.T) {
+	sink := &RTPSink{rtpTimestampFrequency: 90000, _ssrc: 0x5678}
+	db := newRTPTransmissionStatsDB(sink)
+
+	// (our last SR was sent 300 ms ago, and the receiver answered 100 ms after it arrived)
+	lastSR := rtcp.MiddleNTPTime(rtcp.NTPTime(time.Now().Add(-300 * time.Millisecond)))
+	for i := 0; i < qualityHistorySize+5; i++ {
+		db.noteIncomingRR("192.168.1.105:6971", 0x1234, uint32(i)<<24, 1000, 90, lastSR, compactNTPDuration(100*time.Millisecond))
+	}
+	db.noteIncomingXR("192.168.1.105:6971", &rtcp.ExtendedReport{SSRC: 0x1234, Blocks: []rtcp.XRBlock{
+		&rtcp.ReceiverReferenceTime{NTPTime: rtcp.NTPTime(time.Now())},
+		&rtcp.StatisticsSummary{SSRC: 0x5678, LostPackets: 3, MeanJitter: 900},
+		&rtcp.VoIPMetrics{SSRC: 0x5678, LossRate: 64, RoundTripDelay: 40},
+	}})
+
+	history := db.qualityHistory(0x1234)
+	reports := db.receiverReports(sink.timestampFrequency())
+	if len(history) != qualityHistorySize || len(reports) != 1 {
+		t.Error("failed")
+		return
+	}
+	last, report := history[len(history)-1], reports[0]
+	fmt.Printf("%+v %+v %+v\n", last, report, report.XR)
+	if last.FractionLost != float64(qualityHistorySize+4)/256 || last.Jitter != time.Millisecond ||
+		last.RTT < 190*time.Millisecond || last.RTT > 210*time.Millisecond || report.RTT != last.RTT {
+		t.Error("failed")
+		return
+	}
+	if report.XR == nil || report.XR.LostPackets != 3 || report.XR.MeanJitter != 10*time.Millisecond ||
+		report.XR.LossRate != 0.25 || report.XR.RoundTripDelay != 40*time.Millisecond {
+		t.Error("failed")
+		return
+	}
+	if dlrr := db.dlrrReports(); len(dlrr) != 1 || dlrr[0].SSRC != 0x1234 || dlrr[0].LastReceiverReport == 0 {
+		fmt.Println(dlrr)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -0,0 +1,125 @@
+package livemedia
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// default ports of the URL schemes we understand
+var defaultPortNums = map[string]int{
+	"rtsp":  554,
+	"rtsps": 322,
+	"rtspu": 554,
+	"http":  80, // RTSP-over-HTTP tunneling
+}
+
+// RTSPURL is a parsed "rtsp://", "rtsps://" or "rtspu://" URL
+// ("http://" is accepted too, for RTSP-over-HTTP tunneling).
+// Username, Password and Path are percent-decoded.
+type RTSPURL struct {
+	Scheme   string
+	Username string
+	Password string
+	Host     string // a name, an IPv4 address, or an IPv6 address without brackets
+	Port     int
+	Path     string
+	RawPath  string // the percent-encoded form of Path
+	RawQuery string
+}
+
+// ParseRTSPURL parses a URL of the form
+// "<scheme>://[<username>[:<password>]@]<server-address-or-name>[:<port>][/<path>][?<query>]",
+// where an IPv6 address is written in brackets, e.g. "rtsp://[fe80::1]:8554/live".
+// A missing port is the default for the scheme.
+func ParseRTSPURL(rawURL string) (*RTSPURL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	defaultPortNum, ok := defaultPortNums[scheme]
+	if !ok {
+		return nil, errors.New("URL is not of the form \"rtsp://\", \"rtsps://\" or \"rtspu://\": " + rawURL)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return nil, errors.New("URL has no server address: " + rawURL)
+	}
+
+	portNum := defaultPortNum
+	if portStr := u.Port(); portStr != "" {
+		portNum, err = strconv.Atoi(portStr)
+		if err != nil || portNum < 1 || portNum > 65535 {
+			return nil, errors.New("bad port number in URL: " + rawURL)
+		}
+	}
+
+	rtspURL := &RTSPURL{
+		Scheme:   scheme,
+		Host:     host,
+		Port:     portNum,
+		Path:     u.Path,
+		RawPath:  u.EscapedPath(),
+		RawQuery: u.RawQuery,
+	}
+	if u.User != nil {
+		rtspURL.Username = u.User.Username()
+		rtspURL.Password, _ = u.User.Password()
+	}
+	return rtspURL, nil
+}
+
+// Address returns "<host>:<port>", with an IPv6 host in brackets, for dialing.
+func (u *RTSPURL) Address() string {
+	return net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+}
+
+// escapedPath returns RawPath, or the percent-encoded form of Path if it's empty
+func (u *RTSPURL) escapedPath() string {
+	if u.RawPath == "" && u.Path != "" {
+		return (&url.URL{Path: u.Path}).EscapedPath()
+	}
+	return u.RawPath
+}
+
+// RequestURI returns the encoded path and query, e.g. "/live/stream1?token=abc".
+func (u *RTSPURL) RequestURI() string {
+	uri := u.escapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	if u.RawQuery != "" {
+		uri += "?" + u.RawQuery
+	}
+	return uri
+}
+
+// String returns the URL, with its (percent-encoded) credentials if any, and without a default port.
+// (A client clears the credentials first, as they belong in an "Authorization:" header, not in requests.)
+func (u *RTSPURL) String() string {
+	host := u.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if u.Port != defaultPortNums[u.Scheme] {
+		host += ":" + strconv.Itoa(u.Port)
+	}
+
+	var userInfo string
+	if u.Password != "" {
+		userInfo = url.UserPassword(u.Username, u.Password).String() + "@"
+	} else if u.Username != "" {
+		userInfo = url.User(u.Username).String() + "@"
+	}
+
+	s := u.Scheme + "://" + userInfo + host + u.escapedPath()
+	if u.RawQuery != "" {
+		s += "?" + u.RawQuery
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package livemedia
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseRTSPURL(t *testing.T) {
+	var verify bool = true
+
+	urlList := []string{
+		"rtsp://192.168.1.105:8554/test.264",
+		"rtsp://admin:p%40ss@[fe80::1]/live/stream1?token=abc",
+		"rtsps://example.com/live",
+		"rtspu://[::1]:9554/",
+	}
+	expected := []RTSPURL{
+		{Scheme: "rtsp", Host: "192.168.1.105", Port: 8554, Path: "/test.264"},
+		{Scheme: "rtsp", Username: "admin", Password: "p@ss", Host: "fe80::1", Port: 554, Path: "/live/stream1", RawQuery: "token=abc"},
+		{Scheme: "rtsps", Host: "example.com", Port: 322, Path: "/live"},
+		{Scheme: "rtspu", Host: "::1", Port: 9554, Path: "/"},
+	}
+	for i, rawURL := range urlList {
+		rtspURL, err := ParseRTSPURL(rawURL)
+		if err != nil {
+			fmt.Println("parse url error", rawURL, err)
+			verify = false
+			break
+		}
+
+		e := expected[i]
+		if rtspURL.Scheme != e.Scheme || rtspURL.Username != e.Username || rtspURL.Password != e.Password ||
+			rtspURL.Host != e.Host || rtspURL.Port != e.Port || rtspURL.Path != e.Path || rtspURL.RawQuery != e.RawQuery {
+			fmt.Printf("parse url error: %+v\n", rtspURL)
+			verify = false
+			break
+		}
+	}
+
+	rtspURL, _ := ParseRTSPURL("rtsp://user:p%40ss@[fe80::1]:8554/live%20one")
+	if rtspURL == nil || rtspURL.String() != "rtsp://user:p%40ss@[fe80::1]:8554/live%20one" ||
+		rtspURL.Address() != "[fe80::1]:8554" {
+		fmt.Println("format url error", rtspURL)
+		verify = false
+	}
+
+	// (without RawPath, the path is encoded from Path)
+	rtspURL = &RTSPURL{Scheme: "rtsp", Host: "example.com", Port: 554, Path: "/live one"}
+	if rtspURL.String() != "rtsp://example.com/live%20one" || rtspURL.RequestURI() != "/live%20one" {
+		fmt.Println("format url error", rtspURL)
+		verify = false
+	}
+
+	for _, rawURL := range []string{"http//host/", "ftp://host/", "rtsp://host:70000/", "rtsp:///live"} {
+		if _, err := ParseRTSPURL(rawURL); err == nil {
+			fmt.Println("accepted bad url", rawURL)
+			verify = false
+		}
+	}
+
+	if verify {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
@@ -2,6 +2,7 @@ package livemedia
 
 import (
 	"fmt"
+	"sync"
 	sys "syscall"
 
 	gs "github.com/djwackey/dorsvr/groupsock"
@@ -13,7 +14,9 @@ var libVersionStr string = mediaServerVersion
 type ServerMediaSession struct {
 	isSSM             bool
 	ipAddr            string
+	ipv6Addr          string
 	streamName        string
+	description       string
 	descSDPStr        string
 	infoSDPStr        string
 	miscSDPLines      string
@@ -21,25 +24,38 @@ type ServerMediaSession struct {
 	SubsessionCounter int
 	creationTime      sys.Timeval
 	Subsessions       []IServerMediaSubsession
+	settings          StreamSettings
+	settingsMutex     sync.Mutex // (the server changes the settings as it reloads its configuration)
 }
 
 func NewServerMediaSession(description, streamName string) *ServerMediaSession {
 	session := new(ServerMediaSession)
+	session.description = description
 	session.descSDPStr = description + ", streamed by the Dor Media Server"
 	session.infoSDPStr = streamName
 	session.streamName = streamName
 	session.Subsessions = make([]IServerMediaSubsession, 1024)
 	session.ipAddr, _ = gs.OurIPAddress()
+	session.ipv6Addr, _ = gs.OurIPv6Address()
+	session.settings = DefaultStreamSettings()
 
 	sys.Gettimeofday(&session.creationTime)
 	return session
 }
 
-func (s *ServerMediaSession) GenerateSDPDescription() string {
+// GenerateSDPDescription returns the SDP description of the session, for clients
+// that reach us over addressFamily (sys.AF_INET or sys.AF_INET6). Clients on a
+// secure (RTSPS) connection are offered SRTP ("RTP/SAVP") streams, with their keys.
+func (s *ServerMediaSession) GenerateSDPDescription(addressFamily int, isSecure bool) string {
+	addrType, ipAddr := "IP4", s.ipAddr
+	if addressFamily == sys.AF_INET6 && s.ipv6Addr != "" {
+		addrType, ipAddr = "IP6", s.ipv6Addr
+	}
+
 	var sourceFilterLine string
 	if s.isSSM {
-		sourceFilterLine = fmt.Sprintf("a=source-filter: incl IN IP4 * %s\r\n"+
-			"a=rtcp-unicast: reflection\r\n", s.ipAddr)
+		sourceFilterLine = fmt.Sprintf("a=source-filter: incl IN %s * %s\r\n"+
+			"a=rtcp-unicast: reflection\r\n", addrType, ipAddr)
 	} else {
 		sourceFilterLine = ""
 	}
@@ -53,7 +69,7 @@ func (s *ServerMediaSession) GenerateSDPDescription() string {
 	}
 
 	sdpPrefixFmt := "v=0\r\n" +
-		"o=- %d%06d %d IN IP4 %s\r\n" +
+		"o=- %d%06d %d IN %s %s\r\n" +
 		"s=%s\r\n" +
 		"i=%s\r\n" +
 		"t=0 0\r\n" +
@@ -70,7 +86,8 @@ func (s *ServerMediaSession) GenerateSDPDescription() string {
 		s.creationTime.Sec,
 		s.creationTime.Usec,
 		1,
-		s.ipAddr,
+		addrType,
+		ipAddr,
 		s.descSDPStr,
 		s.infoSDPStr,
 		libNameStr, libVersionStr,
@@ -82,17 +99,36 @@ func (s *ServerMediaSession) GenerateSDPDescription() string {
 
 	// Then, add the (media-level) lines for each subsession:
 	for i := 0; i < s.SubsessionCounter; i++ {
-		sdpLines := s.Subsessions[i].SDPLines()
+		sdpLines := s.Subsessions[i].SDPLines(addressFamily, isSecure)
 		sdp += sdpLines
 	}
 
 	return sdp
 }
 
+// SetStreamSettings sets the settings of the streams of our subsessions, (DefaultStreamSettings by default)
+func (s *ServerMediaSession) SetStreamSettings(settings StreamSettings) {
+	s.settingsMutex.Lock()
+	s.settings = settings
+	s.settingsMutex.Unlock()
+}
+
+// StreamSettings returns the settings of the streams of our subsessions
+func (s *ServerMediaSession) StreamSettings() StreamSettings {
+	s.settingsMutex.Lock()
+	defer s.settingsMutex.Unlock()
+	return s.settings
+}
+
 func (s *ServerMediaSession) StreamName() string {
 	return s.streamName
 }
 
+// Description returns the description of the session, e.g. "H.264 Video"
+func (s *ServerMediaSession) Description() string {
+	return s.description
+}
+
 func (s *ServerMediaSession) AddSubsession(subsession IServerMediaSubsession) {
 	s.Subsessions[s.SubsessionCounter] = subsession
 	s.SubsessionCounter++
@@ -13,12 +13,13 @@ type IServerMediaSubsession interface {
 	createNewStreamSource() IFramedSource
 	createNewRTPSink(rtpGroupSock *gs.GroupSock, rtpPayloadType uint) IMediaSink
 	GetStreamParameters(tcpSocketNum net.Conn, destAddr, clientSessionID string,
-		clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID uint) *StreamParameter
+		clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID uint, isSecure, rtcpMux bool) *StreamParameter
 	TestScaleFactor(scale float32) float32
 	//Duration() float32
 	IncrTrackNumber()
 	TrackID() string
-	SDPLines() string
+	Codec() string
+	SDPLines(addressFamily int, isSecure bool) string
 	CNAME() string
 	StartStream(clientSessionID string, streamState *StreamState,
 		rtcpRRHandler, serverRequestAlternativeByteHandler interface{}) (uint32, uint32)
@@ -42,6 +43,14 @@ func (s *ServerMediaSubsession) setParentSession(parentSession *ServerMediaSessi
 	s.parentSession = parentSession
 }
 
+// streamSettings returns the settings of the streams of our session
+func (s *ServerMediaSubsession) streamSettings() StreamSettings {
+	if s.parentSession == nil {
+		return DefaultStreamSettings()
+	}
+	return s.parentSession.StreamSettings()
+}
+
 func (s *ServerMediaSubsession) TrackID() string {
 	if s.trackID == "" {
 		s.trackID = fmt.Sprintf("track%d", s.trackNumber)
@@ -49,6 +58,11 @@ func (s *ServerMediaSubsession) TrackID() string {
 	return s.trackID
 }
 
+// Codec returns the encoding name of the track, like in its "a=rtpmap:" line (e.g. "H264")
+func (s *ServerMediaSubsession) Codec() string {
+	return ""
+}
+
 func (s *ServerMediaSubsession) TrackNumber() uint {
 	return s.trackNumber
 }
@@ -0,0 +1,538 @@
+package livemedia
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SRTP and SRTCP (RFC 3711, and RFC 7714 for AES-GCM), with keys exchanged in
+// "a=crypto:" SDP attributes (RFC 4568). MIKEY key exchange ("a=key-mgmt:mikey")
+// is not supported.
+
+// SRTPProfile is a SRTP crypto suite.
+type SRTPProfile int
+
+const (
+	SRTP_AES_CM_128_HMAC_SHA1_80 SRTPProfile = iota
+	SRTP_AES_CM_128_HMAC_SHA1_32
+	SRTP_AEAD_AES_128_GCM
+)
+
+// default; you can change it to offer RTSPS clients another crypto suite
+var DefaultSRTPProfile = SRTP_AES_CM_128_HMAC_SHA1_80
+
+// the crypto suite names of "a=crypto:" lines
+var srtpProfileNames = map[SRTPProfile]string{
+	SRTP_AES_CM_128_HMAC_SHA1_80: "AES_CM_128_HMAC_SHA1_80",
+	SRTP_AES_CM_128_HMAC_SHA1_32: "AES_CM_128_HMAC_SHA1_32",
+	SRTP_AEAD_AES_128_GCM:        "AEAD_AES_128_GCM",
+}
+
+func (p SRTPProfile) String() string {
+	return srtpProfileNames[p]
+}
+
+// masterKeyLength returns the length of the master key and of the master salt.
+func (p SRTPProfile) masterKeyLength() (keyLength, saltLength int) {
+	if p == SRTP_AEAD_AES_128_GCM {
+		return 16, 12
+	}
+	return 16, 14
+}
+
+// authTagLength returns the length of the authentication tag of SRTP (and of SRTCP)
+// packets; for AES-GCM, it's the length of the tag at the end of the ciphertext.
+func (p SRTPProfile) authTagLength(isRTCP bool) int {
+	switch {
+	case p == SRTP_AEAD_AES_128_GCM:
+		return 16
+	case p == SRTP_AES_CM_128_HMAC_SHA1_32 && !isRTCP:
+		return 4
+	default:
+		return 10
+	}
+}
+
+const (
+	srtpAuthKeyLength = 20
+	srtcpIndexLength  = 4
+	replayWindowSize  = 64
+)
+
+// key derivation labels (RFC 3711, section 4.3.2)
+const (
+	labelRTPEncryption  = 0x00
+	labelRTPAuthTag     = 0x01
+	labelRTPSalt        = 0x02
+	labelRTCPEncryption = 0x03
+	labelRTCPAuthTag    = 0x04
+	labelRTCPSalt       = 0x05
+)
+
+var (
+	errSRTPAuthFailed = errors.New("SRTP authentication failed")
+	errSRTPReplayed   = errors.New("SRTP packet replayed")
+)
+
+// srtpContext protects the packets that we send, and unprotects the packets that
+// we receive, for the RTP and RTCP interfaces of a stream that uses one master key.
+// The rollover counter and the replay window are kept for each SSRC.
+type srtpContext struct {
+	profile    SRTPProfile
+	rtpKeys    srtpSessionKeys
+	rtcpKeys   srtpSessionKeys
+	streams    map[uint32]*srtpStream
+	mutex      sync.Mutex
+	rtpBuffer  []byte
+	rtcpBuffer []byte
+}
+
+type srtpSessionKeys struct {
+	block   cipher.Block
+	aead    cipher.AEAD
+	salt    []byte
+	authKey []byte
+}
+
+type srtpStream struct {
+	roc         uint32 // rollover counter
+	lastSeqNo   uint16
+	haveSeenSeq bool
+	srtcpIndex  uint32 // of the last SRTCP packet that we sent
+	rtpReplay   replayWindow
+	rtcpReplay  replayWindow
+}
+
+// replayWindow remembers which of the latest packet indexes we've already accepted
+// (RFC 3711, section 3.3.2).
+type replayWindow struct {
+	highest     uint64
+	bitmap      uint64 // bit n: we've seen "highest - n"
+	initialized bool
+}
+
+func (w *replayWindow) check(index uint64) bool {
+	if !w.initialized || index > w.highest {
+		return true
+	}
+
+	delta := w.highest - index
+	return delta < replayWindowSize && w.bitmap&(1<<delta) == 0
+}
+
+func (w *replayWindow) update(index uint64) {
+	switch {
+	case !w.initialized:
+		w.highest, w.bitmap, w.initialized = index, 1, true
+	case index > w.highest:
+		delta := index - w.highest
+		if delta < replayWindowSize {
+			w.bitmap = w.bitmap<<delta | 1
+		} else {
+			w.bitmap = 1
+		}
+		w.highest = index
+	default:
+		w.bitmap |= 1 << (w.highest - index)
+	}
+}
+
+// newSRTPMasterKey returns a random master key and salt, concatenated.
+func newSRTPMasterKey(profile SRTPProfile) ([]byte, error) {
+	keyLength, saltLength := profile.masterKeyLength()
+	masterKey := make([]byte, keyLength+saltLength)
+	if _, err := rand.Read(masterKey); err != nil {
+		return nil, err
+	}
+	return masterKey, nil
+}
+
+// srtpCryptoAttribute returns the "a=crypto:" SDP line that offers masterKey.
+func srtpCryptoAttribute(tag int, profile SRTPProfile, masterKey []byte) string {
+	return fmt.Sprintf("a=crypto:%d %s inline:%s\r\n", tag, profile,
+		base64.StdEncoding.EncodeToString(masterKey))
+}
+
+// parseSRTPCryptoAttribute parses a "a=crypto:<tag> <suite> inline:<key||salt>[|<lifetime>][|<MKI>:<length>]" line.
+func parseSRTPCryptoAttribute(sdpLine string) (profile SRTPProfile, masterKey []byte, ok bool) {
+	var tag int
+	var suite, keyParams string
+	if n, _ := fmt.Sscanf(sdpLine, "a=crypto:%d %s %s", &tag, &suite, &keyParams); n != 3 {
+		return
+	}
+	if !strings.HasPrefix(keyParams, "inline:") {
+		return
+	}
+
+	var found bool
+	for p, name := range srtpProfileNames {
+		if name == suite {
+			profile, found = p, true
+		}
+	}
+	if !found {
+		return
+	}
+
+	keyParams = keyParams[7:]
+	if index := strings.Index(keyParams, "|"); index != -1 {
+		keyParams = keyParams[:index]
+	}
+
+	keyLength, saltLength := profile.masterKeyLength()
+	masterKey, err := base64.StdEncoding.DecodeString(keyParams)
+	if err != nil || len(masterKey) != keyLength+saltLength {
+		return profile, nil, false
+	}
+	return profile, masterKey, true
+}
+
+func newSRTPContext(profile SRTPProfile, masterKey []byte) (*srtpContext, error) {
+	keyLength, saltLength := profile.masterKeyLength()
+	if len(masterKey) != keyLength+saltLength {
+		return nil, errors.New("bad SRTP master key length")
+	}
+	key, salt := masterKey[:keyLength], masterKey[keyLength:]
+
+	prf, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &srtpContext{
+		profile: profile,
+		streams: make(map[uint32]*srtpStream),
+	}
+	c.rtpKeys, err = deriveSessionKeys(profile, prf, salt, labelRTPEncryption, labelRTPAuthTag, labelRTPSalt)
+	if err != nil {
+		return nil, err
+	}
+	c.rtcpKeys, err = deriveSessionKeys(profile, prf, salt, labelRTCPEncryption, labelRTCPAuthTag, labelRTCPSalt)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// deriveSessionKeys derives the encryption key, the authentication key and the salt of RTP (or RTCP).
+func deriveSessionKeys(profile SRTPProfile, prf cipher.Block, masterSalt []byte,
+	encryptionLabel, authLabel, saltLabel byte) (keys srtpSessionKeys, err error) {
+	keyLength, saltLength := profile.masterKeyLength()
+
+	keys.block, err = aes.NewCipher(deriveSRTPKey(prf, masterSalt, encryptionLabel, keyLength))
+	if err != nil {
+		return
+	}
+	keys.salt = deriveSRTPKey(prf, masterSalt, saltLabel, saltLength)
+
+	if profile == SRTP_AEAD_AES_128_GCM {
+		keys.aead, err = cipher.NewGCM(keys.block)
+	} else {
+		keys.authKey = deriveSRTPKey(prf, masterSalt, authLabel, srtpAuthKeyLength)
+	}
+	return
+}
+
+// deriveSRTPKey runs the AES-CM key derivation function
+// (with a key derivation rate of zero) for one label.
+func deriveSRTPKey(prf cipher.Block, masterSalt []byte, label byte, length int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, masterSalt)
+	iv[7] ^= label
+
+	out := make([]byte, length)
+	cipher.NewCTR(prf, iv).XORKeyStream(out, out)
+	return out
+}
+
+// counterModeIV returns the AES-CM IV for a packet: (salt * 2^16) XOR (SSRC * 2^64) XOR (index * 2^16).
+func counterModeIV(salt []byte, ssrc uint32, index uint64) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, salt)
+
+	var ssrcBytes [4]byte
+	binary.BigEndian.PutUint32(ssrcBytes[:], ssrc)
+	for i := 0; i < 4; i++ {
+		iv[4+i] ^= ssrcBytes[i]
+	}
+
+	var indexBytes [8]byte
+	binary.BigEndian.PutUint64(indexBytes[:], index<<16)
+	for i := 0; i < 8; i++ {
+		iv[8+i] ^= indexBytes[i]
+	}
+	return iv
+}
+
+// gcmIV returns the AES-GCM IV for a packet (RFC 7714, sections 8.1 and 9.1):
+// (00 00 || SSRC || ROC || SEQ) XOR salt, or (00 00 || SSRC || 00 00 || SRTCP index) XOR salt;
+// either way, that's the 48-bit packet index after the SSRC.
+func gcmIV(salt []byte, ssrc uint32, index uint64) []byte {
+	iv := make([]byte, 12)
+	binary.BigEndian.PutUint32(iv[2:], ssrc)
+	binary.BigEndian.PutUint16(iv[6:], uint16(index>>32))
+	binary.BigEndian.PutUint32(iv[8:], uint32(index))
+	for i := range iv {
+		iv[i] ^= salt[i]
+	}
+	return iv
+}
+
+func authTag(authKey []byte, length int, data ...[]byte) []byte {
+	mac := hmac.New(sha1.New, authKey)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)[:length]
+}
+
+// rtpHeaderLength returns the length of a RTP packet's header, including any CSRCs and extension.
+func rtpHeaderLength(packet []byte) (int, bool) {
+	if len(packet) < 12 {
+		return 0, false
+	}
+
+	length := 12 + 4*int(packet[0]&0x0F)
+	if packet[0]&0x10 != 0 {
+		if len(packet) < length+4 {
+			return 0, false
+		}
+		length += 4 + 4*int(binary.BigEndian.Uint16(packet[length+2:]))
+	}
+	if len(packet) < length {
+		return 0, false
+	}
+	return length, true
+}
+
+func (c *srtpContext) lookupStream(ssrc uint32) *srtpStream {
+	stream, existed := c.streams[ssrc]
+	if !existed {
+		stream = new(srtpStream)
+		c.streams[ssrc] = stream
+	}
+	return stream
+}
+
+// protect returns the SRTP (or SRTCP) form of a packet that we're sending.
+// The result is only valid until the next call for the same kind of packet.
+func (c *srtpContext) protect(packet []byte, isRTCP bool) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if isRTCP {
+		return c.protectRTCP(packet)
+	}
+	return c.protectRTP(packet)
+}
+
+// unprotect authenticates and decrypts a SRTP (or SRTCP) packet that we received,
+// in place, returning the length of the resulting packet.
+func (c *srtpContext) unprotect(packet []byte, isRTCP bool) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if isRTCP {
+		return c.unprotectRTCP(packet)
+	}
+	return c.unprotectRTP(packet)
+}
+
+func (c *srtpContext) protectRTP(packet []byte) ([]byte, error) {
+	headerLength, ok := rtpHeaderLength(packet)
+	if !ok {
+		return nil, errors.New("bad RTP packet")
+	}
+
+	seqNo := binary.BigEndian.Uint16(packet[2:])
+	ssrc := binary.BigEndian.Uint32(packet[8:])
+	stream := c.lookupStream(ssrc)
+
+	// the sequence number has wrapped around
+	if stream.haveSeenSeq && seqNo < stream.lastSeqNo && stream.lastSeqNo-seqNo > 0x8000 {
+		stream.roc++
+	}
+	stream.lastSeqNo, stream.haveSeenSeq = seqNo, true
+	index := uint64(stream.roc)<<16 | uint64(seqNo)
+
+	tagLength := c.profile.authTagLength(false)
+	size := len(packet) + tagLength
+	if cap(c.rtpBuffer) < size {
+		c.rtpBuffer = make([]byte, size)
+	}
+	out := c.rtpBuffer[:headerLength]
+	copy(out, packet[:headerLength])
+
+	if c.profile == SRTP_AEAD_AES_128_GCM {
+		// (the header is authenticated, but not encrypted)
+		iv := gcmIV(c.rtpKeys.salt, ssrc, index)
+		return c.rtpKeys.aead.Seal(out, iv, packet[headerLength:], packet[:headerLength]), nil
+	}
+
+	out = out[:len(packet)]
+	cipher.NewCTR(c.rtpKeys.block, counterModeIV(c.rtpKeys.salt, ssrc, index)).
+		XORKeyStream(out[headerLength:], packet[headerLength:])
+
+	var roc [4]byte
+	binary.BigEndian.PutUint32(roc[:], stream.roc)
+	return append(out, authTag(c.rtpKeys.authKey, tagLength, out, roc[:])...), nil
+}
+
+func (c *srtpContext) unprotectRTP(packet []byte) (int, error) {
+	tagLength := c.profile.authTagLength(false)
+	if len(packet) < 12+tagLength {
+		return 0, errors.New("SRTP packet is too short")
+	}
+
+	headerLength, ok := rtpHeaderLength(packet[:len(packet)-tagLength])
+	if !ok {
+		return 0, errors.New("bad SRTP packet")
+	}
+
+	seqNo := binary.BigEndian.Uint16(packet[2:])
+	ssrc := binary.BigEndian.Uint32(packet[8:])
+	stream := c.lookupStream(ssrc)
+
+	// Estimate the packet's index (RFC 3711, appendix A):
+	v := stream.roc
+	if stream.haveSeenSeq {
+		if stream.lastSeqNo < 0x8000 {
+			if int(seqNo)-int(stream.lastSeqNo) > 0x8000 {
+				v = stream.roc - 1
+			}
+		} else if int(stream.lastSeqNo)-0x8000 > int(seqNo) {
+			v = stream.roc + 1
+		}
+	}
+	index := uint64(v)<<16 | uint64(seqNo)
+
+	if !stream.rtpReplay.check(index) {
+		return 0, errSRTPReplayed
+	}
+
+	var rtpLength int
+	if c.profile == SRTP_AEAD_AES_128_GCM {
+		iv := gcmIV(c.rtpKeys.salt, ssrc, index)
+		payload, err := c.rtpKeys.aead.Open(packet[headerLength:headerLength], iv,
+			packet[headerLength:], packet[:headerLength])
+		if err != nil {
+			return 0, errSRTPAuthFailed
+		}
+		rtpLength = headerLength + len(payload)
+	} else {
+		authenticated := packet[:len(packet)-tagLength]
+
+		var roc [4]byte
+		binary.BigEndian.PutUint32(roc[:], v)
+		if !hmac.Equal(authTag(c.rtpKeys.authKey, tagLength, authenticated, roc[:]), packet[len(authenticated):]) {
+			return 0, errSRTPAuthFailed
+		}
+
+		cipher.NewCTR(c.rtpKeys.block, counterModeIV(c.rtpKeys.salt, ssrc, index)).
+			XORKeyStream(authenticated[headerLength:], authenticated[headerLength:])
+		rtpLength = len(authenticated)
+	}
+
+	// Update our rollover counter, highest sequence number, and replay window:
+	if !stream.haveSeenSeq || v == stream.roc+1 || (v == stream.roc && seqNo > stream.lastSeqNo) {
+		stream.roc, stream.lastSeqNo, stream.haveSeenSeq = v, seqNo, true
+	}
+	stream.rtpReplay.update(index)
+	return rtpLength, nil
+}
+
+func (c *srtpContext) protectRTCP(packet []byte) ([]byte, error) {
+	if len(packet) < 8 {
+		return nil, errors.New("bad RTCP packet")
+	}
+
+	ssrc := binary.BigEndian.Uint32(packet[4:])
+	stream := c.lookupStream(ssrc)
+	stream.srtcpIndex = (stream.srtcpIndex + 1) & 0x7FFFFFFF
+
+	// the 'E' flag (the packet is encrypted), and the SRTCP index
+	var eIndex [4]byte
+	binary.BigEndian.PutUint32(eIndex[:], 0x80000000|stream.srtcpIndex)
+
+	tagLength := c.profile.authTagLength(true)
+	size := len(packet) + srtcpIndexLength + tagLength
+	if cap(c.rtcpBuffer) < size {
+		c.rtcpBuffer = make([]byte, size)
+	}
+	out := c.rtcpBuffer[:8]
+	copy(out, packet[:8])
+
+	if c.profile == SRTP_AEAD_AES_128_GCM {
+		iv := gcmIV(c.rtcpKeys.salt, ssrc, uint64(stream.srtcpIndex))
+		aad := append(append(make([]byte, 0, 12), packet[:8]...), eIndex[:]...)
+		out = c.rtcpKeys.aead.Seal(out, iv, packet[8:], aad)
+		return append(out, eIndex[:]...), nil
+	}
+
+	out = out[:len(packet)]
+	cipher.NewCTR(c.rtcpKeys.block, counterModeIV(c.rtcpKeys.salt, ssrc, uint64(stream.srtcpIndex))).
+		XORKeyStream(out[8:], packet[8:])
+
+	out = append(out, eIndex[:]...)
+	return append(out, authTag(c.rtcpKeys.authKey, tagLength, out)...), nil
+}
+
+func (c *srtpContext) unprotectRTCP(packet []byte) (int, error) {
+	isGCM := c.profile == SRTP_AEAD_AES_128_GCM
+	tagLength := c.profile.authTagLength(true)
+	if len(packet) < 8+srtcpIndexLength+tagLength {
+		return 0, errors.New("SRTCP packet is too short")
+	}
+
+	// With AES-CM, the SRTCP index comes before the authentication tag;
+	// with AES-GCM, it comes after the ciphertext (and its tag).
+	indexOffset := len(packet) - srtcpIndexLength
+	if !isGCM {
+		indexOffset -= tagLength
+	}
+	eIndex := binary.BigEndian.Uint32(packet[indexOffset:])
+	index := uint64(eIndex & 0x7FFFFFFF)
+
+	ssrc := binary.BigEndian.Uint32(packet[4:])
+	stream := c.lookupStream(ssrc)
+	if !stream.rtcpReplay.check(index) {
+		return 0, errSRTPReplayed
+	}
+
+	var rtcpLength int
+	if isGCM {
+		aad := append(append(make([]byte, 0, 12), packet[:8]...), packet[indexOffset:]...)
+		iv := gcmIV(c.rtcpKeys.salt, ssrc, index)
+		if eIndex&0x80000000 == 0 {
+			return 0, errors.New("unencrypted SRTCP is not supported with AES-GCM")
+		}
+		payload, err := c.rtcpKeys.aead.Open(packet[8:8], iv, packet[8:indexOffset], aad)
+		if err != nil {
+			return 0, errSRTPAuthFailed
+		}
+		rtcpLength = 8 + len(payload)
+	} else {
+		authenticated := packet[:len(packet)-tagLength]
+		if !hmac.Equal(authTag(c.rtcpKeys.authKey, tagLength, authenticated), packet[len(authenticated):]) {
+			return 0, errSRTPAuthFailed
+		}
+
+		rtcpLength = indexOffset
+		if eIndex&0x80000000 != 0 {
+			cipher.NewCTR(c.rtcpKeys.block, counterModeIV(c.rtcpKeys.salt, ssrc, index)).
+				XORKeyStream(packet[8:rtcpLength], packet[8:rtcpLength])
+		}
+	}
+
+	stream.rtcpReplay.update(index)
+	return rtcpLength, nil
+}
@@ -0,0 +1,127 @@
+package livemedia
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSRTPKeyDerivation(t *testing.T) {
+	// the test vectors of RFC 3711, appendix B.3
+	masterKey, _ := hex.DecodeString("E1F97A0D3E018BE0D64FA32C06DE4139")
+	masterSalt, _ := hex.DecodeString("0EC675AD498AFEEBB6960B3AABE6")
+
+	prf, _ := aes.NewCipher(masterKey)
+	cipherKey := deriveSRTPKey(prf, masterSalt, labelRTPEncryption, 16)
+	cipherSalt := deriveSRTPKey(prf, masterSalt, labelRTPSalt, 14)
+	authKey := deriveSRTPKey(prf, masterSalt, labelRTPAuthTag, srtpAuthKeyLength)
+
+	if hex.EncodeToString(cipherKey) != "c61e7a93744f39ee10734afe3ff7a087" ||
+		hex.EncodeToString(cipherSalt) != "30cbbc08863d8c85d49db34a9ae1" ||
+		hex.EncodeToString(authKey) != "cebe321f6ff7716b6fd4ab49af256a156d38baa4" {
+		fmt.Printf("derived keys: %x %x %x\n", cipherKey, cipherSalt, authKey)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestSRTPProtect(t *testing.T) {
+	profiles := []SRTPProfile{
+		SRTP_AES_CM_128_HMAC_SHA1_80,
+		SRTP_AES_CM_128_HMAC_SHA1_32,
+		SRTP_AEAD_AES_128_GCM,
+	}
+	for _, profile := range profiles {
+		if err := checkSRTPProfile(profile); err != nil {
+			fmt.Println(profile, err)
+			t.Error("failed")
+			return
+		}
+	}
+	t.Log("success")
+}
+
+func checkSRTPProfile(profile SRTPProfile) error {
+	masterKey, _ := newSRTPMasterKey(profile)
+	sender, _ := newSRTPContext(profile, masterKey)
+	receiver, _ := newSRTPContext(profile, masterKey)
+
+	rtp := []byte{0x80, 0x60, 0xFF, 0xFF, 0, 0, 0, 1, 0xDE, 0xAD, 0xBE, 0xEF, 'f', 'r', 'a', 'm', 'e'}
+	for i := 0; i < 2; i++ {
+		protected, err := sender.protect(rtp, false)
+		if err != nil || bytes.Contains(protected, []byte("frame")) {
+			return fmt.Errorf("protect RTP error: %v", err)
+		}
+
+		packet := append([]byte{}, protected...)
+		length, err := receiver.unprotect(packet, false)
+		if err != nil || !bytes.Equal(packet[:length], rtp) {
+			return fmt.Errorf("unprotect RTP error: %v", err)
+		}
+
+		// a replayed packet is rejected
+		packet = append([]byte{}, protected...)
+		if _, err = receiver.unprotect(packet, false); err != errSRTPReplayed {
+			return fmt.Errorf("replayed packet: %v", err)
+		}
+
+		// the next packet's sequence number wraps around
+		rtp[2], rtp[3] = 0, 0
+	}
+	if receiver.streams[0xDEADBEEF].roc != 1 {
+		return fmt.Errorf("bad rollover counter")
+	}
+
+	rtp[3] = 1
+	protected, _ := sender.protect(rtp, false)
+	protected[len(protected)-1] ^= 0xFF
+	if _, err := receiver.unprotect(protected, false); err != errSRTPAuthFailed {
+		return fmt.Errorf("tampered packet: %v", err)
+	}
+
+	rtcp := []byte{0x81, 0xC9, 0x00, 0x01, 0xDE, 0xAD, 0xBE, 0xEF}
+	protected, _ = sender.protect(rtcp, true)
+	packet := append([]byte{}, protected...)
+	if length, err := receiver.unprotect(packet, true); err != nil || !bytes.Equal(packet[:length], rtcp) {
+		return fmt.Errorf("unprotect RTCP error: %v", err)
+	}
+	if _, err := receiver.unprotect(append([]byte{}, protected...), true); err != errSRTPReplayed {
+		return fmt.Errorf("replayed RTCP packet: %v", err)
+	}
+
+	cryptoLine := strings.TrimSpace(srtpCryptoAttribute(1, profile, masterKey)) + "|2^31"
+	p, key, ok := parseSRTPCryptoAttribute(cryptoLine)
+	if !ok || p != profile || !bytes.Equal(key, masterKey) {
+		return fmt.Errorf("bad crypto attribute: %s", cryptoLine)
+	}
+	return nil
+}
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	for _, index := range []uint64{100, 102, 101, 200, 150} {
+		if !w.check(index) {
+			t.Error("failed")
+			return
+		}
+		w.update(index)
+	}
+
+	// seen, or too old
+	for _, index := range []uint64{200, 150, 102, 136} {
+		if w.check(index) {
+			fmt.Println("accepted", index)
+			t.Error("failed")
+			return
+		}
+	}
+	if !w.check(137) || !w.check(199) || !w.check(201) {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
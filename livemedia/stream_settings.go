@@ -0,0 +1,32 @@
+package livemedia
+
+import "time"
+
+// StreamSettings are the settings of the on-demand streams of a ServerMediaSession,
+// see ServerMediaSession.SetStreamSettings. They apply to the streams created afterwards.
+type StreamSettings struct {
+	// the range of our UDP ports (RTP on an even port and RTCP on the next one), a zero max means no upper bound
+	RTPPortMin uint
+	RTPPortMax uint
+	// the size of the buffer of the outgoing frames, (large enough for the biggest frames of the media)
+	OutPacketBufferMaxSize uint
+	// the RTCP feedback of the video streams
+	RTCPFeedback RTCPFeedback
+	// the forward error correction, none if its scheme is empty
+	FEC FEC
+	// the window after SETUP in which the unicast UDP streams latch to the ports that the first packets
+	// of a client come from, on our RTP (and RTCP) port, instead of its client_port; off if it's zero.
+	// (symmetric RTP, for the clients behind a NAT; the packets have to come from the host of the RTSP
+	// connection of the client)
+	SymmetricRTPWindow time.Duration
+}
+
+// DefaultStreamSettings returns the settings of a new ServerMediaSession: UDP ports from 6970,
+// NACK and keyframe requests, no FEC and no symmetric RTP.
+func DefaultStreamSettings() StreamSettings {
+	return StreamSettings{
+		RTPPortMin:             6970,
+		OutPacketBufferMaxSize: OutPacketBufferMaxSize,
+		RTCPFeedback:           RTCPFeedback{NACK: true, KeyframeRequests: true},
+	}
+}
@@ -1,6 +1,10 @@
 package livemedia
 
 import (
+	"net"
+	"sync"
+	"sync/atomic"
+
 	gs "github.com/djwackey/dorsvr/groupsock"
 )
 
@@ -16,7 +20,13 @@ type StreamState struct {
 	serverRTPPort       uint
 	serverRTCPPort      uint
 	totalBW             uint
-	areCurrentlyPlaying bool
+	areCurrentlyPlaying atomic.Bool // (the streaming goroutine sets it, and a PAUSE resets it)
+	playing             sync.WaitGroup
+	reclaimOnce         sync.Once
+	latches             []*latch   // the destinations that wait for the first packets of their clients
+	readingRTPPort      bool       // (for the latches)
+	latchesMutex        sync.Mutex // (the latches change the ports of the destinations)
+	keyframeRequests    bool       // pass the PLI and FIR of the receivers on to our source, see RTCPFeedback
 }
 
 func newStreamState(master IServerMediaSubsession, serverRTPPort, serverRTCPPort uint,
@@ -45,6 +55,16 @@ func (s *StreamState) startPlaying(dests *Destinations,
 		// Note: This starts RTCP running automatically
 		// Create (and start) a 'RTCP instance' for this RTP sink:
 		s.rtcpInstance = newRTCPInstance(s.rtcpGS, s.totalBW, s.master.CNAME(), s.rtpSink, nil)
+		if s.isRTCPMux() {
+			// (the RTP of our stream only goes out)
+			s.rtcpInstance.netInterface.setRTCPMux(s.rtpGS, nil)
+		}
+		s.rtcpInstance.netInterface.setReadFromHandler(func(from *net.UDPAddr) {
+			s.noteReceivedFrom(from, true)
+		})
+		if s.keyframeRequests {
+			s.rtcpInstance.setKeyframeRequestHandler(s.requestKeyframe)
+		}
 	}
 
 	if dests.isTCP {
@@ -57,13 +77,15 @@ func (s *StreamState) startPlaying(dests *Destinations,
 		}
 	} else {
 		// Tell the RTP and RTCP 'groupsocks' about this destination
-		// (in case they don't already have it):
+		// (in case they don't already have it), with the ports we latched to, if any:
+		s.latchesMutex.Lock()
 		if s.rtpGS != nil {
 			s.rtpGS.AddDestination(dests.addrStr, dests.rtpPort)
 		}
-		if s.rtcpGS != nil {
+		if s.rtcpGS != nil && !s.isRTCPMux() {
 			s.rtcpGS.AddDestination(dests.addrStr, dests.rtcpPort)
 		}
+		s.latchesMutex.Unlock()
 		if s.rtcpInstance != nil {
 			s.rtcpInstance.setSpecificRRHandler(rtcpRRHandler)
 		}
@@ -75,12 +97,10 @@ func (s *StreamState) startPlaying(dests *Destinations,
 		s.rtcpInstance.sendReport()
 	}
 
-	if !s.areCurrentlyPlaying && s.mediaSource != nil {
+	if s.mediaSource != nil && (s.rtpSink != nil || s.udpSink != nil) && s.areCurrentlyPlaying.CompareAndSwap(false, true) {
 		if s.rtpSink != nil {
 			s.rtpSink.StartPlaying(s.mediaSource, s.afterPlayingStreamState)
-			s.areCurrentlyPlaying = true
-		} else if s.udpSink != nil {
-			s.areCurrentlyPlaying = true
+		} else {
 			s.udpSink.StartPlaying(s.mediaSource, s.afterPlayingStreamState)
 		}
 	}
@@ -93,7 +113,7 @@ func (s *StreamState) pause() {
 	if s.udpSink != nil {
 		s.udpSink.StopPlaying()
 	}
-	s.areCurrentlyPlaying = false
+	s.areCurrentlyPlaying.Store(false)
 }
 
 func (s *StreamState) endPlaying(dests *Destinations) {
@@ -112,6 +132,13 @@ func (s *StreamState) endPlaying(dests *Destinations) {
 	}
 }
 
+// requestKeyframe passes a PLI or FIR of a receiver on to our source, if it can force a keyframe
+func (s *StreamState) requestKeyframe() {
+	if !requestKeyframe(s.mediaSource) {
+		rtcpLog.Debug("the source can't force a keyframe")
+	}
+}
+
 func (s *StreamState) ServerRTPPort() uint {
 	return s.serverRTPPort
 }
@@ -120,12 +147,53 @@ func (s *StreamState) ServerRTCPPort() uint {
 	return s.serverRTCPPort
 }
 
+// isRTCPMux returns whether the RTCP of the stream goes over its RTP port, (RFC 5761)
+func (s *StreamState) isRTCPMux() bool {
+	return s.rtpGS != nil && s.rtcpGS == s.rtpGS
+}
+
 func (s *StreamState) afterPlayingStreamState() {
 	s.reclaim()
 }
 
+// reclaim stops the streaming, and sends a RTCP BYE; (a stream which was only SETUP has no RTCP instance yet)
 func (s *StreamState) reclaim() {
-	s.rtcpInstance.destroy()
+	s.reclaimOnce.Do(func() {
+		s.pause()
+		if s.rtcpInstance != nil {
+			s.rtcpInstance.destroy()
+		}
+	})
+}
+
+// Wait blocks until the streaming goroutines of the stream have returned, after it's deleted
+func (s *StreamState) Wait() {
+	s.playing.Wait()
+}
+
+// SentCounts returns the number of RTP packets we sent, and the number of their payload bytes
+func (s *StreamState) SentCounts() (packets, octets uint) {
+	if s.rtpSink == nil {
+		return 0, 0
+	}
+	return s.rtpSink.packetCount(), s.rtpSink.octetCount()
+}
+
+// ReceiverReports returns the last RTCP receiver report of each receiver of the stream
+func (s *StreamState) ReceiverReports() []ReceiverReport {
+	if s.rtpSink == nil || s.rtpSink.transmissionStatsDB() == nil {
+		return nil
+	}
+	return s.rtpSink.transmissionStatsDB().receiverReports(s.rtpSink.timestampFrequency())
+}
+
+// QualityHistory returns the quality of the stream at a receiver (of the SSRC of its ReceiverReport),
+// as of its last reports, the oldest first
+func (s *StreamState) QualityHistory(ssrc uint32) []QualitySample {
+	if s.rtpSink == nil || s.rtpSink.transmissionStatsDB() == nil {
+		return nil
+	}
+	return s.rtpSink.transmissionStatsDB().qualityHistory(ssrc)
 }
 
 func (s *StreamState) RtpSink() IMediaSink {
@@ -0,0 +1,151 @@
+package livemedia
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// the packet that a client sends from its RTP and RTCP ports to ours after SETUP, to open the way
+// for our packets through its NAT, (it's neither RTP nor RTCP, and we drop it once we've noted it)
+var punchPacket = []byte{0xFE, 0xED, 0xFA, 0xCE}
+
+func isPunchPacket(packet []byte) bool {
+	return bytes.Equal(packet, punchPacket)
+}
+
+// latch is the destination of a client that waits for the first packets of the client
+type latch struct {
+	dests       *Destinations
+	deadline    time.Time
+	rtpLatched  bool
+	rtcpLatched bool // (or there's no RTCP port of its own)
+}
+
+// latchDestinations makes the RTP and RTCP ports of dests those that the first packets of its client come
+// from, within the window, see StreamSettings.SymmetricRTPWindow
+func (s *StreamState) latchDestinations(dests *Destinations, window time.Duration) {
+	s.latchesMutex.Lock()
+	defer s.latchesMutex.Unlock()
+
+	s.latches = append(s.latches, &latch{
+		dests:       dests,
+		deadline:    time.Now().Add(window),
+		rtcpLatched: s.rtcpGS == nil || s.isRTCPMux(),
+	})
+	// (a RTCP instance reads our RTCP port, and our RTP port too, with rtcp-mux)
+	if !s.readingRTPPort && s.rtpGS != nil && !s.isRTCPMux() {
+		s.readingRTPPort = true
+		go s.readRTPPort()
+	}
+}
+
+// readRTPPort reads our RTP port while clients wait to be latched to, (we only send on it otherwise)
+func (s *StreamState) readRTPPort() {
+	buffer := make([]byte, maxRTCPPacketSize)
+	for {
+		deadline, ok := s.rtpLatchDeadline()
+		if !ok {
+			s.rtpGS.SetReadDeadline(time.Time{})
+			return
+		}
+
+		s.rtpGS.SetReadDeadline(deadline)
+		_, from, err := s.rtpGS.HandleReadFrom(buffer)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			// (the stream was reclaimed)
+			s.latchesMutex.Lock()
+			s.readingRTPPort = false
+			s.latchesMutex.Unlock()
+			return
+		}
+		s.noteReceivedFrom(from, false)
+	}
+}
+
+// rtpLatchDeadline returns the last deadline of the clients that wait to be latched to, on our RTP port,
+// or false if none does (any more), and so we stop reading it
+func (s *StreamState) rtpLatchDeadline() (deadline time.Time, ok bool) {
+	s.latchesMutex.Lock()
+	defer s.latchesMutex.Unlock()
+
+	s.pruneLatches(time.Now())
+	for _, l := range s.latches {
+		if !l.rtpLatched && l.deadline.After(deadline) {
+			deadline, ok = l.deadline, true
+		}
+	}
+	if !ok {
+		s.readingRTPPort = false
+	}
+	return
+}
+
+// pruneLatches forgets the clients whose window has passed, and those that we've latched to
+func (s *StreamState) pruneLatches(now time.Time) {
+	latches := s.latches[:0]
+	for _, l := range s.latches {
+		if now.Before(l.deadline) && !(l.rtpLatched && l.rtcpLatched) {
+			latches = append(latches, l)
+		}
+	}
+	s.latches = latches
+}
+
+// noteReceivedFrom latches the destination of a client to the port of a packet that arrived on our RTP
+// port (or, if isRTCP, on our RTCP port), if the client waits for one from that host. Of the clients
+// of the same host (e.g. behind the same NAT), it has to be the only one, or come from its client_port.
+func (s *StreamState) noteReceivedFrom(from *net.UDPAddr, isRTCP bool) {
+	if from == nil {
+		return
+	}
+	isRTCP = isRTCP && !s.isRTCPMux()
+
+	s.latchesMutex.Lock()
+	defer s.latchesMutex.Unlock()
+
+	s.pruneLatches(time.Now())
+	var candidates []*latch
+	for _, l := range s.latches {
+		latched, port := l.rtpLatched, l.dests.rtpPort
+		if isRTCP {
+			latched, port = l.rtcpLatched, l.dests.rtcpPort
+		}
+		if latched || !from.IP.Equal(net.ParseIP(l.dests.addrStr)) {
+			continue
+		}
+		if port == uint(from.Port) {
+			candidates = []*latch{l}
+			break
+		}
+		candidates = append(candidates, l)
+	}
+	if len(candidates) != 1 {
+		if len(candidates) > 1 {
+			rtpLog.Debug("can't tell which client a packet comes from", "from", from, "clients", len(candidates))
+		}
+		return
+	}
+
+	l := candidates[0]
+	dests, port := l.dests, uint(from.Port)
+	if isRTCP {
+		if s.rtcpGS != nil {
+			s.rtcpGS.ChangeDestination(dests.addrStr, dests.rtcpPort, dests.addrStr, port)
+		}
+		rtpLog.Debug("latched a client", "addr", dests.addrStr, "rtcp_port", dests.rtcpPort, "to", port)
+		dests.rtcpPort, l.rtcpLatched = port, true
+		return
+	}
+	s.rtpGS.ChangeDestination(dests.addrStr, dests.rtpPort, dests.addrStr, port)
+	rtpLog.Debug("latched a client", "addr", dests.addrStr, "rtp_port", dests.rtpPort, "to", port)
+	dests.rtpPort, l.rtpLatched = port, true
+	if s.isRTCPMux() {
+		dests.rtcpPort = port
+	}
+}
@@ -0,0 +1,43 @@
+package livemedia
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+)
+
+func TestSymmetricRTP(t *testing.T) {
+	rtpGS := gs.NewGroupSock("", 0)
+	client := gs.NewGroupSock("", 0)
+	if rtpGS == nil || client == nil {
+		t.Error("failed")
+		return
+	}
+	defer rtpGS.Close()
+	defer client.Close()
+
+	state := newStreamState(nil, rtpGS.GetSourcePort(), 0, nil, nil, 500, nil, rtpGS, nil)
+	dests := newDestinations(nil, "127.0.0.1", 5000, 5001, 0, 0)
+	state.latchDestinations(dests, time.Second)
+
+	// a packet of another host doesn't latch the client
+	state.noteReceivedFrom(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 7000}, false)
+	client.OutputTo("127.0.0.1", rtpGS.GetSourcePort(), punchPacket)
+
+	var rtpPort uint
+	for i := 0; i < 100 && rtpPort != client.GetSourcePort(); i++ {
+		time.Sleep(10 * time.Millisecond)
+		state.latchesMutex.Lock()
+		rtpPort = dests.rtpPort
+		state.latchesMutex.Unlock()
+	}
+	if rtpPort != client.GetSourcePort() || dests.rtcpPort != 5001 {
+		fmt.Println(rtpPort, client.GetSourcePort(), dests.rtcpPort)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -0,0 +1,18 @@
+package livemedia
+
+import (
+	sys "syscall"
+)
+
+// The fields of a sys.Timeval are int32 on some architectures (e.g. 386) and int64 on the others,
+// so we do the arithmetic of the Timevals in int64 microseconds.
+
+// microseconds returns tv in microseconds
+func microseconds(tv sys.Timeval) int64 {
+	return tv.Nano() / 1000
+}
+
+// addMicroseconds adds usec to tv, (which it keeps normalized)
+func addMicroseconds(tv *sys.Timeval, usec int64) {
+	*tv = sys.NsecToTimeval((microseconds(*tv) + usec) * 1000)
+}
@@ -0,0 +1,139 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// the subsystems, with a level of their own
+const (
+	RTSP = "rtsp"
+	RTP  = "rtp"
+	RTCP = "rtcp"
+	Auth = "auth"
+)
+
+// Subsystems are the subsystems which log, see SetLevel
+var Subsystems = []string{RTSP, RTP, RTCP, Auth}
+
+var (
+	// the handler all the subsystems write to, logfmt on stderr by default
+	output atomic.Pointer[slog.Handler]
+	// the level of each subsystem, slog.LevelInfo by default
+	levels      = make(map[string]*slog.LevelVar)
+	levelsMutex sync.Mutex
+)
+
+func init() {
+	SetOutput(os.Stderr, "logfmt")
+}
+
+// NewHandler returns a handler writing to w in the format, "json" or "logfmt" (the default)
+func NewHandler(w io.Writer, format string) (slog.Handler, error) {
+	// (every record is filtered by the level of its subsystem first)
+	options := &slog.HandlerOptions{Level: slog.LevelDebug - 4}
+	switch strings.ToLower(format) {
+	case "", "logfmt", "text":
+		return slog.NewTextHandler(w, options), nil
+	case "json":
+		return slog.NewJSONHandler(w, options), nil
+	}
+	return nil, fmt.Errorf("unknown log format: %s", format)
+}
+
+// SetOutput makes all the subsystems write to w in the format, "json" or "logfmt"
+func SetOutput(w io.Writer, format string) error {
+	handler, err := NewHandler(w, format)
+	if err != nil {
+		return err
+	}
+	SetHandler(handler)
+	return nil
+}
+
+// SetHandler makes all the subsystems write to the handler, e.g. to add attributes of our own
+func SetHandler(handler slog.Handler) {
+	output.Store(&handler)
+}
+
+// ParseLevel parses a level, "debug", "info", "warn" or "error"
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+func levelVar(subsystem string) *slog.LevelVar {
+	levelsMutex.Lock()
+	defer levelsMutex.Unlock()
+	level, ok := levels[subsystem]
+	if !ok {
+		level = new(slog.LevelVar)
+		levels[subsystem] = level
+	}
+	return level
+}
+
+// SetLevel sets the minimum level of the records of a subsystem, e.g. slog.LevelDebug for RTCP
+func SetLevel(subsystem string, level slog.Level) {
+	levelVar(subsystem).Set(level)
+}
+
+// Level returns the minimum level of the records of a subsystem
+func Level(subsystem string) slog.Level {
+	return levelVar(subsystem).Level()
+}
+
+// Logger returns the logger of a subsystem; its records have a "subsystem" attribute,
+// and follow the changes of SetOutput and SetLevel
+func Logger(subsystem string) *slog.Logger {
+	return slog.New(&subsystemHandler{level: levelVar(subsystem)}).With("subsystem", subsystem)
+}
+
+// subsystemHandler filters the records of a subsystem by its level, and passes them on to the current output,
+// with the attributes and groups of the logger
+type subsystemHandler struct {
+	level *slog.LevelVar
+	// the WithAttrs and WithGroup of the logger, in order
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h *subsystemHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, record slog.Record) error {
+	handler := *output.Load()
+	for _, with := range h.with {
+		handler = with(handler)
+	}
+	return handler.Handle(ctx, record)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.and(func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.and(func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})
+}
+
+func (h *subsystemHandler) and(with func(slog.Handler) slog.Handler) *subsystemHandler {
+	return &subsystemHandler{
+		level: h.level,
+		with:  append(h.with[:len(h.with):len(h.with)], with),
+	}
+}
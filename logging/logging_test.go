@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := SetOutput(&buffer, "json"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetOutput(os.Stderr, "logfmt")
+	defer SetLevel(RTCP, slog.LevelInfo)
+
+	SetLevel(RTCP, slog.LevelDebug)
+	Logger(RTCP).With("ssrc", 1234).Debug("received a RTCP receiver report")
+	Logger(RTP).Debug("started a stream")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	fmt.Println(lines)
+	if len(lines) != 1 {
+		t.Error("failed")
+		return
+	}
+	var record map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &record)
+	if record["subsystem"] != "rtcp" || record["ssrc"] != 1234.0 || record["level"] != "DEBUG" {
+		t.Error("failed")
+		return
+	}
+
+	if err := SetOutput(&buffer, "xml"); err == nil {
+		t.Error("failed")
+		return
+	}
+	if level, err := ParseLevel("warn"); err != nil || level != slog.LevelWarn {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -1,42 +1,70 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	//"github.com/djwackey/dorsvr/auth"
 	"github.com/djwackey/dorsvr/rtspserver"
 	"github.com/djwackey/gitea/log"
 )
 
 func main() {
-	// open a logger writer of console or file mode.
-	mode := "console"
-	config := `{"level":1,"filename":"test.log"}`
-	log.NewLogger(0, mode, config)
-
-	// to implement client access control to the RTSP server, do the following:
-	// var realm string
-	// authdb = auth.NewAuthDatabase(realm)
+	configFile := flag.String("config", "", "the configuration file (.yaml, .json or .toml)")
+	flag.Parse()
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// open a logger writer of console or file mode,
+	// and set up the structured logs (the access log, RTP, RTCP and auth)
+	log.NewLogger(0, config.Log.Mode, config.LoggerConfig())
+	logOutput, err := config.SetupLogging()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// the access control, the media root, the RTP ports and the timeouts all come from the
+	// configuration; to set them in code instead, do the following:
+	// authdb := auth.NewAuthDatabase(realm)
 	// authdb.InsertUserRecord("username1", "password1")
-	// repeat the above with each <username>, <password> that you wish to allow
-	// access to the server.
+	// server := rtspserver.New(rtspserver.WithAuthenticator(authdb), rtspserver.WithMediaRoot("/var/media"))
+	opts, err := config.Options()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	// create a rtsp server
-	server := rtspserver.New(nil)
+	server := rtspserver.New(opts...)
 
-	portNum := 8554
-	err := server.Listen(portNum)
+	portNum := config.Listen.RTSPPort
+	err = server.Listen(portNum)
 	if err != nil {
 		fmt.Printf("Failed to bind port: %d\n", portNum)
 		return
 	}
 
 	// also, attempt to create a HTTP server for RTSP-over-HTTP tunneling.
-	// Try first with the default HTTP port (80), and then with the alternative HTTP
-	// port numbers (8000 and 8080).
-	if !server.SetupTunnelingOverHTTP(80) ||
-		!server.SetupTunnelingOverHTTP(8000) ||
-		!server.SetupTunnelingOverHTTP(8080) {
+	// Try each of the configured ports in turn (by default 80, and then the alternative
+	// HTTP port numbers 8000 and 8080).
+	tunneling := false
+	for _, httpPort := range config.Listen.HTTPPorts {
+		if tunneling = server.SetupTunnelingOverHTTP(httpPort); tunneling {
+			break
+		}
+	}
+	if tunneling {
 		fmt.Printf("We use port %d for optional RTSP-over-HTTP tunneling, "+
 			"or for HTTP live streaming (for indexed Transport Stream files only).\n",
 			server.HTTPServerPortNum())
@@ -44,11 +72,73 @@ func main() {
 		fmt.Println("(RTSP-over-HTTP tunneling is not available.)")
 	}
 
+	// also accept RTSPS ("rtsps://") connections, if configured
+	if config.Listen.RTSPSPort != 0 {
+		cert, err := tls.LoadX509KeyPair(config.Listen.TLSCert, config.Listen.TLSKey)
+		if err != nil || !server.SetupTLS(config.Listen.RTSPSPort, &tls.Config{Certificates: []tls.Certificate{cert}}) {
+			fmt.Println("(RTSPS is not available.)", err)
+		}
+	}
+
 	urlPrefix := server.RtspURLPrefix()
 	fmt.Println("This server's URL: " + urlPrefix + "<filename>.")
 
 	server.Start()
 
-	// do event loop
-	select {}
+	// reload the configuration (but the listeners) on SIGHUP,
+	// and shut down gracefully on SIGINT or SIGTERM
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if *configFile == "" {
+			continue
+		}
+		logOutput = reload(server, *configFile, logOutput)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Timeouts.Shutdown))
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Println("Failed to shut down gracefully:", err)
+	}
+}
+
+func loadConfig(filename string) (*rtspserver.Config, error) {
+	if filename == "" {
+		return rtspserver.DefaultConfig(), nil
+	}
+	return rtspserver.LoadConfig(filename)
+}
+
+// reload returns the output of the structured logs, the new one if it changed
+func reload(server *rtspserver.RTSPServer, filename string, logOutput io.Closer) io.Closer {
+	config, err := rtspserver.LoadConfig(filename)
+	if err != nil {
+		log.Error(0, "failed to reload the configuration: %v", err)
+		return logOutput
+	}
+
+	opts, err := config.Options()
+	if err != nil {
+		log.Error(0, "failed to reload the configuration: %v", err)
+		return logOutput
+	}
+
+	newLogOutput, err := config.SetupLogging()
+	if err != nil {
+		rtspserver.DiscardOptions(opts...)
+		log.Error(0, "failed to reload the configuration: %v", err)
+		return logOutput
+	}
+	if logOutput != nil {
+		logOutput.Close()
+	}
+
+	log.NewLogger(0, config.Log.Mode, config.LoggerConfig())
+	server.ApplyOptions(opts...)
+	log.Info("reloaded the configuration from %s", filename)
+	return newLogOutput
 }
@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+// Registry is a set of metrics, written in the text format of Prometheus (version 0.0.4).
+// It's safe for concurrent use.
+type Registry struct {
+	mutex        sync.Mutex
+	families     []*Family
+	collects     []func()
+	collectMutex sync.Mutex
+}
+
+// Family is a metric with labels, i.e. a set of series, one per combination of the label values
+type Family struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	mutex      sync.Mutex
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// NewRegistry returns a new, empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter adds a counter, a value which only goes up (or is reset with its series)
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Family {
+	return r.register(name, help, typeCounter, labelNames)
+}
+
+// NewGauge adds a gauge, a value which goes up and down
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Family {
+	return r.register(name, help, typeGauge, labelNames)
+}
+
+func (r *Registry) register(name, help, metricType string, labelNames []string) *Family {
+	f := &Family{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		series:     make(map[string]*series),
+	}
+
+	r.mutex.Lock()
+	r.families = append(r.families, f)
+	r.mutex.Unlock()
+	return f
+}
+
+// OnCollect adds a function called before the metrics are written,
+// e.g. to set the gauges which are computed from the state of the server
+func (r *Registry) OnCollect(collect func()) {
+	r.mutex.Lock()
+	r.collects = append(r.collects, collect)
+	r.mutex.Unlock()
+}
+
+// WriteTo writes the metrics, in the text format of Prometheus
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.Lock()
+	families := append([]*Family(nil), r.families...)
+	collects := append([]func(){}, r.collects...)
+	r.mutex.Unlock()
+
+	// (two concurrent scrapes would reset the series of each other)
+	r.collectMutex.Lock()
+	defer r.collectMutex.Unlock()
+	for _, collect := range collects {
+		collect()
+	}
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.writeTo(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics, e.g. on "/metrics"
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// Add adds delta to the series of the label values
+func (f *Family) Add(delta float64, labelValues ...string) {
+	f.mutex.Lock()
+	f.lookup(labelValues).value += delta
+	f.mutex.Unlock()
+}
+
+// Inc adds 1 to the series of the label values
+func (f *Family) Inc(labelValues ...string) {
+	f.Add(1, labelValues...)
+}
+
+// Dec subtracts 1 from the series of the label values, (for gauges)
+func (f *Family) Dec(labelValues ...string) {
+	f.Add(-1, labelValues...)
+}
+
+// Set sets the value of the series of the label values
+func (f *Family) Set(value float64, labelValues ...string) {
+	f.mutex.Lock()
+	f.lookup(labelValues).value = value
+	f.mutex.Unlock()
+}
+
+// Value returns the value of the series of the label values, (0 if there is none)
+func (f *Family) Value(labelValues ...string) float64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if s, existed := f.series[seriesKey(labelValues)]; existed {
+		return s.value
+	}
+	return 0
+}
+
+// Reset removes all the series
+func (f *Family) Reset() {
+	f.mutex.Lock()
+	f.series = make(map[string]*series)
+	f.mutex.Unlock()
+}
+
+// lookup returns the series of the label values, (the missing ones are empty)
+func (f *Family) lookup(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		values := make([]string, len(f.labelNames))
+		copy(values, labelValues)
+		labelValues = values
+	}
+
+	key := seriesKey(labelValues)
+	s, existed := f.series[key]
+	if !existed {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *Family) writeTo(w io.Writer) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escape(f.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.metricType)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		io.WriteString(w, f.name)
+		if len(f.labelNames) > 0 {
+			labels := make([]string, len(f.labelNames))
+			for i, name := range f.labelNames {
+				labels[i] = fmt.Sprintf("%s=\"%s\"", name, escape(s.labelValues[i], true))
+			}
+			fmt.Fprintf(w, "{%s}", strings.Join(labels, ","))
+		}
+		fmt.Fprintf(w, " %s\n", formatValue(s.value))
+	}
+}
+
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+// escape escapes the backslashes and the line feeds, (and the double quotes of the label values)
+func escape(s string, isLabelValue bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if isLabelValue {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
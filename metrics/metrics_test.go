@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounter("dorsvr_rtsp_requests_total", "The RTSP requests.", "method", "status")
+	sessions := registry.NewGauge("dorsvr_sessions", "The client sessions.", "stream")
+	connections := registry.NewGauge("dorsvr_connections", "The connections.")
+
+	requests.Inc("PLAY", "200")
+	requests.Inc("PLAY", "200")
+	requests.Inc("DESCRIBE", "401")
+	connections.Inc()
+	connections.Inc()
+	connections.Dec()
+	registry.OnCollect(func() {
+		sessions.Reset()
+		sessions.Set(3, `live/"cam"`)
+	})
+
+	var buffer bytes.Buffer
+	if _, err := registry.WriteTo(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP dorsvr_rtsp_requests_total The RTSP requests.
+# TYPE dorsvr_rtsp_requests_total counter
+dorsvr_rtsp_requests_total{method="DESCRIBE",status="401"} 1
+dorsvr_rtsp_requests_total{method="PLAY",status="200"} 2
+# HELP dorsvr_sessions The client sessions.
+# TYPE dorsvr_sessions gauge
+dorsvr_sessions{stream="live/\"cam\""} 3
+# HELP dorsvr_connections The connections.
+# TYPE dorsvr_connections gauge
+dorsvr_connections 1
+`
+	if buffer.String() != expected {
+		fmt.Println(buffer.String())
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -0,0 +1,45 @@
+package rtcp
+
+import "encoding/binary"
+
+// ApplicationDefined is an APP packet, for an application (or experiment) of its own
+type ApplicationDefined struct {
+	Subtype uint8
+	SSRC    uint32
+	// the name of the application, 4 ASCII characters
+	Name string
+	// the data of the application, a multiple of 4 bytes
+	Data []byte
+}
+
+// Marshal returns the packet
+func (p *ApplicationDefined) Marshal() ([]byte, error) {
+	if len(p.Name) != 4 {
+		return nil, ErrBadName
+	}
+	if p.Subtype > maxCount || len(p.Data)%4 != 0 {
+		return nil, ErrBadLength
+	}
+
+	data := newHeader(TypeAPP, int(p.Subtype), 8+len(p.Data))
+	data = binary.BigEndian.AppendUint32(data, p.SSRC)
+	data = append(data, p.Name...)
+	return append(data, p.Data...), nil
+}
+
+// Unmarshal parses the packet
+func (p *ApplicationDefined) Unmarshal(data []byte) error {
+	header, body, err := unmarshalHeader(data, TypeAPP)
+	if err != nil {
+		return err
+	}
+	if len(body) < 8 {
+		return ErrPacketTooShort
+	}
+
+	p.Subtype = header.Count
+	p.SSRC = binary.BigEndian.Uint32(body)
+	p.Name = string(body[4:8])
+	p.Data = append([]byte(nil), body[8:]...)
+	return nil
+}
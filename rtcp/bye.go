@@ -0,0 +1,60 @@
+package rtcp
+
+import "encoding/binary"
+
+// Goodbye is a BYE packet, of the sources leaving the session
+type Goodbye struct {
+	Sources []uint32
+	// why they leave, if they tell
+	Reason string
+}
+
+// Marshal returns the packet
+func (p *Goodbye) Marshal() ([]byte, error) {
+	if len(p.Sources) > maxCount {
+		return nil, ErrTooManyItems
+	}
+	if len(p.Reason) > maxTextLength {
+		return nil, ErrItemTooLong
+	}
+
+	var body []byte
+	for _, source := range p.Sources {
+		body = binary.BigEndian.AppendUint32(body, source)
+	}
+	if p.Reason != "" {
+		body = append(body, byte(len(p.Reason)))
+		body = append(body, p.Reason...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	return append(newHeader(TypeBYE, len(p.Sources), len(body)), body...), nil
+}
+
+// Unmarshal parses the packet
+func (p *Goodbye) Unmarshal(data []byte) error {
+	header, body, err := unmarshalHeader(data, TypeBYE)
+	if err != nil {
+		return err
+	}
+	if len(body) < 4*int(header.Count) {
+		return ErrPacketTooShort
+	}
+
+	p.Sources = make([]uint32, header.Count)
+	for i := range p.Sources {
+		p.Sources[i] = binary.BigEndian.Uint32(body[4*i:])
+	}
+
+	p.Reason = ""
+	body = body[4*int(header.Count):]
+	if len(body) > 0 {
+		length := int(body[0])
+		if 1+length > len(body) {
+			return ErrBadLength
+		}
+		p.Reason = string(body[1 : 1+length])
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package rtcp
+
+import "encoding/binary"
+
+// the formats of the feedback packets (RFC 4585, RFC 5104)
+const (
+	// of a TransportLayerFeedback
+	FormatNACK = 1 // generic NACK
+	// of a PayloadSpecificFeedback
+	FormatPLI  = 1  // picture loss indication
+	FormatSLI  = 2  // slice loss indication
+	FormatRPSI = 3  // reference picture selection indication
+	FormatFIR  = 4  // full intra request
+	FormatAFB  = 15 // application layer feedback
+)
+
+// feedback is the format common to the feedback packets (RFC 4585, section 6.1)
+type feedback struct {
+	Format     uint8
+	SenderSSRC uint32
+	MediaSSRC  uint32
+	// the feedback control information, which depends on the format, a multiple of 4 bytes
+	FCI []byte
+}
+
+func (p *feedback) marshal(packetType uint8) ([]byte, error) {
+	if p.Format > maxCount || len(p.FCI)%4 != 0 {
+		return nil, ErrBadLength
+	}
+
+	data := newHeader(packetType, int(p.Format), 8+len(p.FCI))
+	data = binary.BigEndian.AppendUint32(data, p.SenderSSRC)
+	data = binary.BigEndian.AppendUint32(data, p.MediaSSRC)
+	return append(data, p.FCI...), nil
+}
+
+func (p *feedback) unmarshal(data []byte, packetType uint8) error {
+	header, body, err := unmarshalHeader(data, packetType)
+	if err != nil {
+		return err
+	}
+	if len(body) < 8 {
+		return ErrPacketTooShort
+	}
+
+	p.Format = header.Count
+	p.SenderSSRC = binary.BigEndian.Uint32(body)
+	p.MediaSSRC = binary.BigEndian.Uint32(body[4:])
+	p.FCI = append([]byte(nil), body[8:]...)
+	return nil
+}
+
+// TransportLayerFeedback is a RTPFB packet, e.g. a generic NACK
+type TransportLayerFeedback feedback
+
+// Marshal returns the packet
+func (p *TransportLayerFeedback) Marshal() ([]byte, error) {
+	return (*feedback)(p).marshal(TypeRTPFB)
+}
+
+// Unmarshal parses the packet
+func (p *TransportLayerFeedback) Unmarshal(data []byte) error {
+	return (*feedback)(p).unmarshal(data, TypeRTPFB)
+}
+
+// PayloadSpecificFeedback is a PSFB packet, e.g. a PLI or FIR
+type PayloadSpecificFeedback feedback
+
+// Marshal returns the packet
+func (p *PayloadSpecificFeedback) Marshal() ([]byte, error) {
+	return (*feedback)(p).marshal(TypePSFB)
+}
+
+// Unmarshal parses the packet
+func (p *PayloadSpecificFeedback) Unmarshal(data []byte) error {
+	return (*feedback)(p).unmarshal(data, TypePSFB)
+}
+
+// NewNACK returns a generic NACK of the packets of the media source that were lost (their sequence numbers, in order)
+func NewNACK(senderSSRC, mediaSSRC uint32, lost []uint16) *TransportLayerFeedback {
+	p := &TransportLayerFeedback{Format: FormatNACK, SenderSSRC: senderSSRC, MediaSSRC: mediaSSRC}
+	// (each FCI entry is a packet ID, and a bitmask of the lost packets of the 16 following it)
+	for i := 0; i < len(lost); {
+		pid, blp := lost[i], uint16(0)
+		for i++; i < len(lost); i++ {
+			diff := lost[i] - pid
+			if diff == 0 || diff > 16 {
+				break
+			}
+			blp |= 1 << (diff - 1)
+		}
+		p.FCI = binary.BigEndian.AppendUint16(p.FCI, pid)
+		p.FCI = binary.BigEndian.AppendUint16(p.FCI, blp)
+	}
+	return p
+}
+
+// LostPackets returns the sequence numbers of the packets a generic NACK reports lost
+func (p *TransportLayerFeedback) LostPackets() []uint16 {
+	if p.Format != FormatNACK {
+		return nil
+	}
+
+	var lost []uint16
+	for fci := p.FCI; len(fci) >= 4; fci = fci[4:] {
+		pid, blp := binary.BigEndian.Uint16(fci), binary.BigEndian.Uint16(fci[2:])
+		lost = append(lost, pid)
+		for i := uint16(0); i < 16; i++ {
+			if blp&(1<<i) != 0 {
+				lost = append(lost, pid+i+1)
+			}
+		}
+	}
+	return lost
+}
+
+// NewPLI returns a picture loss indication, which asks the media source for a keyframe
+func NewPLI(senderSSRC, mediaSSRC uint32) *PayloadSpecificFeedback {
+	return &PayloadSpecificFeedback{Format: FormatPLI, SenderSSRC: senderSSRC, MediaSSRC: mediaSSRC}
+}
+
+// NewFIR returns a full intra request of the media source (RFC 5104), seqNo tells the requests apart
+func NewFIR(senderSSRC, mediaSSRC uint32, seqNo uint8) *PayloadSpecificFeedback {
+	// (the media source is in the FCI entry, the media SSRC of the packet isn't used)
+	fci := binary.BigEndian.AppendUint32(nil, mediaSSRC)
+	fci = append(fci, seqNo, 0, 0, 0)
+	return &PayloadSpecificFeedback{Format: FormatFIR, SenderSSRC: senderSSRC, FCI: fci}
+}
+
+// RequestsKeyframe reports whether the packet is a PLI or FIR of the media source
+func (p *PayloadSpecificFeedback) RequestsKeyframe(mediaSSRC uint32) bool {
+	switch p.Format {
+	case FormatPLI:
+		return p.MediaSSRC == mediaSSRC
+	case FormatFIR:
+		for fci := p.FCI; len(fci) >= 8; fci = fci[8:] {
+			if binary.BigEndian.Uint32(fci) == mediaSSRC {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// the packet types
+const (
+	TypeSR    = 200 // sender report
+	TypeRR    = 201 // receiver report
+	TypeSDES  = 202 // source description
+	TypeBYE   = 203 // goodbye
+	TypeAPP   = 204 // application-defined
+	TypeRTPFB = 205 // transport layer feedback (RFC 4585)
+	TypePSFB  = 206 // payload-specific feedback (RFC 4585)
+	TypeXR    = 207 // extended report (RFC 3611)
+)
+
+const (
+	version      = 2
+	headerLength = 4
+	// the maximum count of a header, e.g. of the reception reports of a SR or RR
+	maxCount = 0x1F
+)
+
+var (
+	ErrPacketTooShort = errors.New("rtcp: packet too short")
+	ErrBadVersion     = errors.New("rtcp: bad version")
+	ErrBadLength      = errors.New("rtcp: bad length")
+	ErrBadPadding     = errors.New("rtcp: bad padding")
+	ErrWrongType      = errors.New("rtcp: wrong packet type")
+	ErrFirstPacket    = errors.New("rtcp: the first packet of a compound packet isn't a SR or RR")
+	ErrEmptyCompound  = errors.New("rtcp: empty compound packet")
+	ErrTooManyItems   = errors.New("rtcp: too many reports, chunks or sources")
+	ErrItemTooLong    = errors.New("rtcp: SDES item or BYE reason too long")
+	ErrBadSDES        = errors.New("rtcp: bad SDES chunk")
+	ErrBadName        = errors.New("rtcp: the name of an APP packet isn't 4 characters")
+)
+
+// Packet is a RTCP packet, of a compound packet
+type Packet interface {
+	// Marshal returns the packet, with its header
+	Marshal() ([]byte, error)
+	// Unmarshal parses the packet, with its header (but without any padding)
+	Unmarshal(data []byte) error
+}
+
+// Header is the header common to all the packets
+type Header struct {
+	// the packet is followed by padding, (only the last packet of a compound packet)
+	Padding bool
+	// the count of reports, chunks or sources, or the format of a feedback packet (5 bits)
+	Count uint8
+	Type  uint8
+	// the length of the packet in 32-bit words, minus one
+	Length uint16
+}
+
+// Marshal returns the header
+func (h *Header) Marshal() []byte {
+	data := make([]byte, headerLength)
+	data[0] = version<<6 | h.Count&maxCount
+	if h.Padding {
+		data[0] |= 0x20
+	}
+	data[1] = h.Type
+	binary.BigEndian.PutUint16(data[2:], h.Length)
+	return data
+}
+
+// Unmarshal parses the header
+func (h *Header) Unmarshal(data []byte) error {
+	if len(data) < headerLength {
+		return ErrPacketTooShort
+	}
+	if data[0]>>6 != version {
+		return ErrBadVersion
+	}
+	h.Padding = data[0]&0x20 != 0
+	h.Count = data[0] & maxCount
+	h.Type = data[1]
+	h.Length = binary.BigEndian.Uint16(data[2:])
+	return nil
+}
+
+// newHeader returns the header of a packet of the type, with a body of bodyLength bytes (a multiple of 4)
+func newHeader(packetType uint8, count int, bodyLength int) []byte {
+	header := Header{
+		Count:  uint8(count),
+		Type:   packetType,
+		Length: uint16((headerLength+bodyLength)/4 - 1),
+	}
+	return header.Marshal()
+}
+
+// unmarshalHeader parses the header of a packet of the type, and returns its body
+func unmarshalHeader(data []byte, packetType uint8) (Header, []byte, error) {
+	var header Header
+	if err := header.Unmarshal(data); err != nil {
+		return header, nil, err
+	}
+	if header.Type != packetType {
+		return header, nil, ErrWrongType
+	}
+	return header, data[headerLength:], nil
+}
+
+// RawPacket is a packet of a type we don't know, kept as it is
+type RawPacket struct {
+	Header Header
+	// the packet after its header
+	Body []byte
+}
+
+// Marshal returns the packet
+func (p *RawPacket) Marshal() ([]byte, error) {
+	if len(p.Body)%4 != 0 {
+		return nil, ErrBadLength
+	}
+	header := p.Header
+	header.Padding = false
+	header.Length = uint16(len(p.Body) / 4)
+	return append(header.Marshal(), p.Body...), nil
+}
+
+// Unmarshal parses the packet
+func (p *RawPacket) Unmarshal(data []byte) error {
+	if err := p.Header.Unmarshal(data); err != nil {
+		return err
+	}
+	p.Body = append([]byte(nil), data[headerLength:]...)
+	return nil
+}
+
+func newPacket(packetType uint8) Packet {
+	switch packetType {
+	case TypeSR:
+		return new(SenderReport)
+	case TypeRR:
+		return new(ReceiverReport)
+	case TypeSDES:
+		return new(SourceDescription)
+	case TypeBYE:
+		return new(Goodbye)
+	case TypeAPP:
+		return new(ApplicationDefined)
+	case TypeRTPFB:
+		return new(TransportLayerFeedback)
+	case TypePSFB:
+		return new(PayloadSpecificFeedback)
+	case TypeXR:
+		return new(ExtendedReport)
+	}
+	return new(RawPacket)
+}
+
+// Marshal returns the compound packet of the packets, the first of which has to be a SR or RR
+func Marshal(packets ...Packet) ([]byte, error) {
+	if err := checkFirstPacket(packets); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for _, packet := range packets {
+		b, err := packet.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return data, nil
+}
+
+// Unmarshal parses a compound packet, validated as in RFC 3550 (appendix A.2):
+// every packet is of version 2, only the last one is padded, their lengths add up to the length
+// of the compound packet, and the first one is a SR or RR. (The packets of unknown types are RawPackets.)
+func Unmarshal(data []byte) ([]Packet, error) {
+	var packets []Packet
+	for len(data) > 0 {
+		var header Header
+		if err := header.Unmarshal(data); err != nil {
+			return nil, err
+		}
+		size := 4 * (int(header.Length) + 1)
+		if size > len(data) {
+			return nil, ErrBadLength
+		}
+
+		body := data[:size]
+		if header.Padding {
+			if size != len(data) {
+				return nil, ErrBadPadding
+			}
+			padding := int(body[size-1])
+			if padding == 0 || padding > size-headerLength {
+				return nil, ErrBadPadding
+			}
+			body = body[:size-padding]
+		}
+
+		packet := newPacket(header.Type)
+		if err := packet.Unmarshal(body); err != nil {
+			return nil, err
+		}
+		packets = append(packets, packet)
+		data = data[size:]
+	}
+
+	if err := checkFirstPacket(packets); err != nil {
+		return nil, err
+	}
+	return packets, nil
+}
+
+func checkFirstPacket(packets []Packet) error {
+	if len(packets) == 0 {
+		return ErrEmptyCompound
+	}
+	switch packets[0].(type) {
+	case *SenderReport, *ReceiverReport:
+		return nil
+	}
+	return ErrFirstPacket
+}
@@ -0,0 +1,192 @@
+package rtcp
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompound(t *testing.T) {
+	packets := []Packet{
+		&SenderReport{
+			SSRC:        0x12345678,
+			NTPTime:     0x83AA7E8080000000,
+			RTPTime:     90000,
+			PacketCount: 10,
+			OctetCount:  10000,
+			Reports: []ReceptionReport{
+				{SSRC: 0x1234, FractionLost: 64, TotalLost: -3, LastSequenceNumber: 0x10010, Jitter: 900},
+			},
+		},
+		&SourceDescription{Chunks: []SDESChunk{{
+			Source: 0x12345678,
+			Items: []SDESItem{
+				{Type: SDESCNAME, Text: "dorsvr@127.0.0.1"},
+				{Type: SDESName, Text: "dorsvr"},
+				{Type: SDESEmail, Text: "dorsvr@example.com"},
+				{Type: SDESPhone, Text: "+1 555 0100"},
+				{Type: SDESLoc, Text: "here"},
+				{Type: SDESTool, Text: "dorsvr"},
+				{Type: SDESNote, Text: "on air"},
+				{Type: SDESPriv, Prefix: "x-dorsvr", Text: "1"},
+			},
+		}}},
+		&ApplicationDefined{Subtype: 1, SSRC: 0x12345678, Name: "DORS", Data: []byte{1, 2, 3, 4}},
+		&TransportLayerFeedback{Format: FormatNACK, SenderSSRC: 0x1234, MediaSSRC: 0x12345678, FCI: []byte{0, 1, 0, 0}},
+		&PayloadSpecificFeedback{Format: FormatPLI, SenderSSRC: 0x1234, MediaSSRC: 0x12345678},
+		&Goodbye{Sources: []uint32{0x12345678}, Reason: "shutdown"},
+	}
+
+	data, err := Marshal(packets...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Unmarshal(data)
+	if err != nil {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+	if !reflect.DeepEqual(parsed, packets) {
+		fmt.Printf("%+v\n", parsed)
+		t.Error("failed")
+		return
+	}
+	if cname := parsed[1].(*SourceDescription).CNAME(0x12345678); cname != "dorsvr@127.0.0.1" {
+		fmt.Println(cname)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestValidation(t *testing.T) {
+	rr, _ := (&ReceiverReport{SSRC: 1}).Marshal()
+	sdes, _ := NewCNAME(1, "a").Marshal()
+
+	// a RR (not only a SR) may come first, but not a SDES
+	if _, err := Unmarshal(append(append([]byte(nil), rr...), sdes...)); err != nil {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+	if _, err := Unmarshal(sdes); err != ErrFirstPacket {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+	if _, err := Marshal(NewCNAME(1, "a")); err != ErrFirstPacket {
+		t.Error("failed")
+		return
+	}
+
+	badVersion := append([]byte(nil), rr...)
+	badVersion[0] = 1<<6 | badVersion[0]&0x3F
+	if _, err := Unmarshal(badVersion); err != ErrBadVersion {
+		t.Error("failed")
+		return
+	}
+
+	// the lengths have to add up
+	if _, err := Unmarshal(append(append([]byte(nil), rr...), sdes[:len(sdes)-4]...)); err != ErrBadLength {
+		t.Error("failed")
+		return
+	}
+
+	// only the last packet may be padded
+	padded := append(append([]byte(nil), rr...), 0, 0, 0, 4)
+	padded[0] |= 0x20
+	padded[3]++
+	if _, err := Unmarshal(padded); err != nil {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+	if _, err := Unmarshal(append(padded, sdes...)); err != ErrBadPadding {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Unix(1700000000, 123456789)
+	ntpTime := NTPTime(now)
+	if ntpTime>>32 != 1700000000+0x83AA7E80 {
+		t.Error("failed")
+		return
+	}
+	if d := now.Sub(Time(ntpTime)); d < 0 || d > time.Nanosecond {
+		fmt.Println(d)
+		t.Error("failed")
+		return
+	}
+	if MiddleNTPTime(0x0001000200030004) != 0x00020003 {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestFeedback(t *testing.T) {
+	lost := []uint16{65530, 65531, 65535, 2, 100}
+	nack := NewNACK(1, 2, lost)
+	// (65530 to 2 fit in a bitmask, across the wrap-around)
+	if len(nack.FCI) != 8 || !reflect.DeepEqual(nack.LostPackets(), lost) {
+		fmt.Println(nack.FCI, nack.LostPackets())
+		t.Error("failed")
+		return
+	}
+
+	if !NewPLI(1, 2).RequestsKeyframe(2) || NewPLI(1, 2).RequestsKeyframe(3) {
+		t.Error("failed")
+		return
+	}
+	fir := NewFIR(1, 2, 7)
+	if !fir.RequestsKeyframe(2) || fir.RequestsKeyframe(0) || fir.FCI[4] != 7 {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestExtendedReport(t *testing.T) {
+	packets := []Packet{
+		&ReceiverReport{SSRC: 0x1234, Reports: []ReceptionReport{{SSRC: 0x5678, LastSequenceNumber: 10}}},
+		&ExtendedReport{SSRC: 0x1234, Blocks: []XRBlock{
+			&ReceiverReferenceTime{NTPTime: 0x83AA7E8080000000},
+			&DLRR{Reports: []DLRRReport{{SSRC: 0x5678, LastReceiverReport: 0x7E808000, Delay: 0x8000}}},
+			&StatisticsSummary{HasLoss: true, HasJitter: true, TTLKind: TTLIPv4, SSRC: 0x5678,
+				BeginSeq: 65530, EndSeq: 10, LostPackets: 2, MinJitter: 1, MaxJitter: 90, MeanJitter: 30, DevJitter: 5, MinTTL: 60},
+			&VoIPMetrics{SSRC: 0x5678, LossRate: 3, RoundTripDelay: 40, SignalLevel: VoIPUnavailable,
+				NoiseLevel: VoIPUnavailable, Gmin: 16, JBNominal: 100, JBMaximum: 100, JBAbsMaximum: 200},
+			&RawXRBlock{Type: 42, TypeSpecific: 1, Body: []byte{1, 2, 3, 4}},
+		}},
+	}
+
+	data, err := Marshal(packets...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Unmarshal(data)
+	if err != nil || !reflect.DeepEqual(parsed, packets) {
+		fmt.Printf("%v %+v\n", err, parsed)
+		t.Error("failed")
+		return
+	}
+
+	// (a report of 1.5 seconds ago, answered 0.5 seconds after it arrived)
+	now := time.Now()
+	lastReport := MiddleNTPTime(NTPTime(now.Add(-1500 * time.Millisecond)))
+	if rtt, ok := RoundTripTime(lastReport, 0x8000, now); !ok || rtt < 999*time.Millisecond || rtt > 1001*time.Millisecond {
+		fmt.Println(rtt, ok)
+		t.Error("failed")
+		return
+	}
+	if _, ok := RoundTripTime(0, 0, now); ok {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -0,0 +1,211 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	receptionReportLength = 24
+	senderInfoLength      = 20
+	// the seconds from 1900 (the NTP epoch) to 1970
+	ntpEpochOffset = 0x83AA7E80
+)
+
+// ReceptionReport is a report block of a SR or RR, about the packets received from a source
+type ReceptionReport struct {
+	SSRC uint32
+	// the fraction of the packets lost since the previous report, in 1/256
+	FractionLost uint8
+	// the cumulative number of packets lost (24 bits, signed)
+	TotalLost int32
+	// the extended highest sequence number received
+	LastSequenceNumber uint32
+	// the interarrival jitter, in timestamp units
+	Jitter uint32
+	// the middle 32 bits of the NTP timestamp of the last SR from the source
+	LastSenderReport uint32
+	// the delay since the last SR, in 1/65536 seconds
+	Delay uint32
+}
+
+func (r *ReceptionReport) marshalTo(data []byte) {
+	totalLost := r.TotalLost
+	// clamp it to a 24-bit signed value
+	if totalLost > 0x7FFFFF {
+		totalLost = 0x7FFFFF
+	} else if totalLost < -0x800000 {
+		totalLost = -0x800000
+	}
+
+	binary.BigEndian.PutUint32(data, r.SSRC)
+	binary.BigEndian.PutUint32(data[4:], uint32(r.FractionLost)<<24|uint32(totalLost)&0xFFFFFF)
+	binary.BigEndian.PutUint32(data[8:], r.LastSequenceNumber)
+	binary.BigEndian.PutUint32(data[12:], r.Jitter)
+	binary.BigEndian.PutUint32(data[16:], r.LastSenderReport)
+	binary.BigEndian.PutUint32(data[20:], r.Delay)
+}
+
+func (r *ReceptionReport) unmarshal(data []byte) {
+	r.SSRC = binary.BigEndian.Uint32(data)
+	lossStats := binary.BigEndian.Uint32(data[4:])
+	r.FractionLost = uint8(lossStats >> 24)
+	// (sign-extend the 24 bits)
+	r.TotalLost = int32(lossStats<<8) >> 8
+	r.LastSequenceNumber = binary.BigEndian.Uint32(data[8:])
+	r.Jitter = binary.BigEndian.Uint32(data[12:])
+	r.LastSenderReport = binary.BigEndian.Uint32(data[16:])
+	r.Delay = binary.BigEndian.Uint32(data[20:])
+}
+
+func marshalReports(data []byte, reports []ReceptionReport, extensions []byte) []byte {
+	for i := range reports {
+		block := make([]byte, receptionReportLength)
+		reports[i].marshalTo(block)
+		data = append(data, block...)
+	}
+	return append(data, extensions...)
+}
+
+func unmarshalReports(data []byte, count uint8) (reports []ReceptionReport, extensions []byte, err error) {
+	if len(data) < int(count)*receptionReportLength {
+		return nil, nil, ErrPacketTooShort
+	}
+	reports = make([]ReceptionReport, count)
+	for i := range reports {
+		reports[i].unmarshal(data[i*receptionReportLength:])
+	}
+	data = data[int(count)*receptionReportLength:]
+	if len(data) > 0 {
+		extensions = append([]byte(nil), data...)
+	}
+	return reports, extensions, nil
+}
+
+func checkReports(reports []ReceptionReport, extensions []byte) error {
+	if len(reports) > maxCount {
+		return ErrTooManyItems
+	}
+	if len(extensions)%4 != 0 {
+		return ErrBadLength
+	}
+	return nil
+}
+
+// SenderReport is a SR, from a source which sent RTP packets since its previous report
+type SenderReport struct {
+	SSRC uint32
+	// the wallclock time of the report, see NTPTime
+	NTPTime uint64
+	// the same time as NTPTime, in the timestamp units of the RTP packets
+	RTPTime     uint32
+	PacketCount uint32
+	OctetCount  uint32
+	Reports     []ReceptionReport
+	// the profile-specific extensions, if any
+	ProfileExtensions []byte
+}
+
+// Marshal returns the packet
+func (p *SenderReport) Marshal() ([]byte, error) {
+	if err := checkReports(p.Reports, p.ProfileExtensions); err != nil {
+		return nil, err
+	}
+
+	bodyLength := 4 + senderInfoLength + len(p.Reports)*receptionReportLength + len(p.ProfileExtensions)
+	data := newHeader(TypeSR, len(p.Reports), bodyLength)
+	data = binary.BigEndian.AppendUint32(data, p.SSRC)
+	data = binary.BigEndian.AppendUint64(data, p.NTPTime)
+	data = binary.BigEndian.AppendUint32(data, p.RTPTime)
+	data = binary.BigEndian.AppendUint32(data, p.PacketCount)
+	data = binary.BigEndian.AppendUint32(data, p.OctetCount)
+	return marshalReports(data, p.Reports, p.ProfileExtensions), nil
+}
+
+// Unmarshal parses the packet
+func (p *SenderReport) Unmarshal(data []byte) error {
+	header, body, err := unmarshalHeader(data, TypeSR)
+	if err != nil {
+		return err
+	}
+	if len(body) < 4+senderInfoLength {
+		return ErrPacketTooShort
+	}
+
+	p.SSRC = binary.BigEndian.Uint32(body)
+	p.NTPTime = binary.BigEndian.Uint64(body[4:])
+	p.RTPTime = binary.BigEndian.Uint32(body[12:])
+	p.PacketCount = binary.BigEndian.Uint32(body[16:])
+	p.OctetCount = binary.BigEndian.Uint32(body[20:])
+	p.Reports, p.ProfileExtensions, err = unmarshalReports(body[4+senderInfoLength:], header.Count)
+	return err
+}
+
+// ReceiverReport is a RR, from a source which didn't send any RTP packet since its previous report
+type ReceiverReport struct {
+	SSRC    uint32
+	Reports []ReceptionReport
+	// the profile-specific extensions, if any
+	ProfileExtensions []byte
+}
+
+// Marshal returns the packet
+func (p *ReceiverReport) Marshal() ([]byte, error) {
+	if err := checkReports(p.Reports, p.ProfileExtensions); err != nil {
+		return nil, err
+	}
+
+	bodyLength := 4 + len(p.Reports)*receptionReportLength + len(p.ProfileExtensions)
+	data := newHeader(TypeRR, len(p.Reports), bodyLength)
+	data = binary.BigEndian.AppendUint32(data, p.SSRC)
+	return marshalReports(data, p.Reports, p.ProfileExtensions), nil
+}
+
+// Unmarshal parses the packet
+func (p *ReceiverReport) Unmarshal(data []byte) error {
+	header, body, err := unmarshalHeader(data, TypeRR)
+	if err != nil {
+		return err
+	}
+	if len(body) < 4 {
+		return ErrPacketTooShort
+	}
+
+	p.SSRC = binary.BigEndian.Uint32(body)
+	p.Reports, p.ProfileExtensions, err = unmarshalReports(body[4:], header.Count)
+	return err
+}
+
+// NTPTime returns the 64-bit NTP timestamp of a time, (the seconds since 1900, in 32.32 fixed point)
+func NTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// Time returns the time of a 64-bit NTP timestamp
+func Time(ntpTime uint64) time.Time {
+	seconds := int64(ntpTime>>32) - ntpEpochOffset
+	nanoseconds := (ntpTime & 0xFFFFFFFF) * uint64(time.Second) >> 32
+	return time.Unix(seconds, int64(nanoseconds))
+}
+
+// MiddleNTPTime returns the middle 32 bits of a NTP timestamp, as in the LastSenderReport of a ReceptionReport
+func MiddleNTPTime(ntpTime uint64) uint32 {
+	return uint32(ntpTime >> 16)
+}
+
+// RoundTripTime returns the round-trip time of the answer to a report, from the middle 32 bits
+// of the NTP timestamp of the report and the delay of the answer (as in the LastSenderReport
+// and Delay of a ReceptionReport), and the time the answer arrived; false if it doesn't tell
+func RoundTripTime(lastReport, delay uint32, arrival time.Time) (time.Duration, bool) {
+	if lastReport == 0 {
+		return 0, false
+	}
+	// (in 1/65536 seconds, which wraps around every 18 hours)
+	rtt := MiddleNTPTime(NTPTime(arrival)) - lastReport - delay
+	if int32(rtt) < 0 {
+		return 0, false
+	}
+	return time.Duration(rtt) * time.Second >> 16, true
+}
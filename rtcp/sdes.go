@@ -0,0 +1,179 @@
+package rtcp
+
+import "encoding/binary"
+
+// the types of the SDES items
+const (
+	SDESEnd   = 0
+	SDESCNAME = 1 // canonical name, e.g. "user@host"
+	SDESName  = 2
+	SDESEmail = 3
+	SDESPhone = 4
+	SDESLoc   = 5
+	SDESTool  = 6
+	SDESNote  = 7
+	SDESPriv  = 8 // private extension, with a prefix
+)
+
+// the maximum length of the text of an item (or of a BYE reason)
+const maxTextLength = 0xFF
+
+// SDESItem is an item of a SDES chunk
+type SDESItem struct {
+	Type uint8
+	Text string
+	// the prefix of a SDESPriv item, (its Text is the value)
+	Prefix string
+}
+
+func (item *SDESItem) marshal(data []byte) ([]byte, error) {
+	if item.Type == SDESEnd {
+		return nil, ErrBadSDES
+	}
+
+	text := []byte(item.Text)
+	if item.Type == SDESPriv {
+		if len(item.Prefix) > maxTextLength {
+			return nil, ErrItemTooLong
+		}
+		text = append(append([]byte{byte(len(item.Prefix))}, item.Prefix...), item.Text...)
+	}
+	if len(text) > maxTextLength {
+		return nil, ErrItemTooLong
+	}
+
+	data = append(data, item.Type, byte(len(text)))
+	return append(data, text...), nil
+}
+
+// SDESChunk is the items of a source
+type SDESChunk struct {
+	Source uint32
+	Items  []SDESItem
+}
+
+func (chunk *SDESChunk) marshal(data []byte) ([]byte, error) {
+	data = binary.BigEndian.AppendUint32(data, chunk.Source)
+	for i := range chunk.Items {
+		var err error
+		if data, err = chunk.Items[i].marshal(data); err != nil {
+			return nil, err
+		}
+	}
+
+	// the END item, (at least one zero byte) up to the next 32-bit boundary
+	data = append(data, SDESEnd)
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	return data, nil
+}
+
+// unmarshal parses a chunk, and returns what follows it
+func (chunk *SDESChunk) unmarshal(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, ErrPacketTooShort
+	}
+	chunk.Source = binary.BigEndian.Uint32(data)
+
+	i := 4
+	for {
+		if i >= len(data) {
+			// (the END item is missing)
+			return nil, ErrBadSDES
+		}
+		itemType := data[i]
+		if itemType == SDESEnd {
+			break
+		}
+		if i+2 > len(data) {
+			return nil, ErrBadSDES
+		}
+		length := int(data[i+1])
+		text := data[i+2:]
+		if length > len(text) {
+			return nil, ErrBadSDES
+		}
+		text = text[:length]
+
+		item := SDESItem{Type: itemType}
+		if itemType == SDESPriv {
+			if length < 1 || int(text[0]) > length-1 {
+				return nil, ErrBadSDES
+			}
+			prefixLength := int(text[0])
+			item.Prefix = string(text[1 : 1+prefixLength])
+			item.Text = string(text[1+prefixLength:])
+		} else {
+			item.Text = string(text)
+		}
+		chunk.Items = append(chunk.Items, item)
+		i += 2 + length
+	}
+
+	// skip the END item, and its padding up to the next 32-bit boundary
+	i = (i + 4) &^ 3
+	if i > len(data) {
+		return nil, ErrBadSDES
+	}
+	return data[i:], nil
+}
+
+// SourceDescription is a SDES packet
+type SourceDescription struct {
+	Chunks []SDESChunk
+}
+
+// NewCNAME returns the SDES packet of the CNAME of a source
+func NewCNAME(source uint32, cname string) *SourceDescription {
+	return &SourceDescription{
+		Chunks: []SDESChunk{{Source: source, Items: []SDESItem{{Type: SDESCNAME, Text: cname}}}},
+	}
+}
+
+// Marshal returns the packet
+func (p *SourceDescription) Marshal() ([]byte, error) {
+	if len(p.Chunks) > maxCount {
+		return nil, ErrTooManyItems
+	}
+
+	var body []byte
+	for i := range p.Chunks {
+		var err error
+		if body, err = p.Chunks[i].marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	return append(newHeader(TypeSDES, len(p.Chunks), len(body)), body...), nil
+}
+
+// Unmarshal parses the packet
+func (p *SourceDescription) Unmarshal(data []byte) error {
+	header, body, err := unmarshalHeader(data, TypeSDES)
+	if err != nil {
+		return err
+	}
+
+	p.Chunks = make([]SDESChunk, header.Count)
+	for i := range p.Chunks {
+		if body, err = p.Chunks[i].unmarshal(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CNAME returns the CNAME of a source, if any
+func (p *SourceDescription) CNAME(source uint32) string {
+	for _, chunk := range p.Chunks {
+		if chunk.Source != source {
+			continue
+		}
+		for _, item := range chunk.Items {
+			if item.Type == SDESCNAME {
+				return item.Text
+			}
+		}
+	}
+	return ""
+}
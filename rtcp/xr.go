@@ -0,0 +1,336 @@
+package rtcp
+
+import "encoding/binary"
+
+// the types of the report blocks of a XR (RFC 3611)
+const (
+	XRTypeReceiverReferenceTime = 4
+	XRTypeDLRR                  = 5
+	XRTypeStatisticsSummary     = 6
+	XRTypeVoIPMetrics           = 7
+)
+
+const (
+	xrBlockHeaderLength = 4
+	dlrrReportLength    = 12
+)
+
+// XRBlock is a report block of a XR
+type XRBlock interface {
+	// BlockType returns the type of the block
+	BlockType() uint8
+	// marshal returns the body of the block, and the type-specific byte of its header
+	marshal() (typeSpecific uint8, body []byte, err error)
+	unmarshal(typeSpecific uint8, body []byte) error
+}
+
+// ExtendedReport is a XR (RFC 3611), of the report blocks of a participant
+type ExtendedReport struct {
+	SSRC   uint32
+	Blocks []XRBlock
+}
+
+// Marshal returns the packet
+func (p *ExtendedReport) Marshal() ([]byte, error) {
+	body := binary.BigEndian.AppendUint32(nil, p.SSRC)
+	for _, block := range p.Blocks {
+		typeSpecific, blockBody, err := block.marshal()
+		if err != nil {
+			return nil, err
+		}
+		if len(blockBody)%4 != 0 {
+			return nil, ErrBadLength
+		}
+		body = append(body, block.BlockType(), typeSpecific)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(blockBody)/4))
+		body = append(body, blockBody...)
+	}
+	return append(newHeader(TypeXR, 0, len(body)), body...), nil
+}
+
+// Unmarshal parses the packet, (the blocks of unknown types are RawXRBlocks)
+func (p *ExtendedReport) Unmarshal(data []byte) error {
+	_, body, err := unmarshalHeader(data, TypeXR)
+	if err != nil {
+		return err
+	}
+	if len(body) < 4 {
+		return ErrPacketTooShort
+	}
+
+	p.SSRC = binary.BigEndian.Uint32(body)
+	p.Blocks = nil
+	for body = body[4:]; len(body) > 0; {
+		if len(body) < xrBlockHeaderLength {
+			return ErrPacketTooShort
+		}
+		blockType, typeSpecific := body[0], body[1]
+		size := xrBlockHeaderLength + 4*int(binary.BigEndian.Uint16(body[2:]))
+		if size > len(body) {
+			return ErrBadLength
+		}
+
+		block := newXRBlock(blockType)
+		if err := block.unmarshal(typeSpecific, body[xrBlockHeaderLength:size]); err != nil {
+			return err
+		}
+		p.Blocks = append(p.Blocks, block)
+		body = body[size:]
+	}
+	return nil
+}
+
+func newXRBlock(blockType uint8) XRBlock {
+	switch blockType {
+	case XRTypeReceiverReferenceTime:
+		return new(ReceiverReferenceTime)
+	case XRTypeDLRR:
+		return new(DLRR)
+	case XRTypeStatisticsSummary:
+		return new(StatisticsSummary)
+	case XRTypeVoIPMetrics:
+		return new(VoIPMetrics)
+	}
+	return &RawXRBlock{Type: blockType}
+}
+
+// RawXRBlock is a report block of a type we don't know, kept as it is
+type RawXRBlock struct {
+	Type         uint8
+	TypeSpecific uint8
+	// the block after its header, a multiple of 4 bytes
+	Body []byte
+}
+
+// BlockType returns the type of the block
+func (b *RawXRBlock) BlockType() uint8 {
+	return b.Type
+}
+
+func (b *RawXRBlock) marshal() (uint8, []byte, error) {
+	return b.TypeSpecific, b.Body, nil
+}
+
+func (b *RawXRBlock) unmarshal(typeSpecific uint8, body []byte) error {
+	b.TypeSpecific = typeSpecific
+	b.Body = append([]byte(nil), body...)
+	return nil
+}
+
+// ReceiverReferenceTime is the wallclock time of a XR from a receiver, which the sender answers
+// with a DLRR, so that the receiver learns its round-trip time too (as a sender does from a RR)
+type ReceiverReferenceTime struct {
+	// the wallclock time of the report, see NTPTime
+	NTPTime uint64
+}
+
+// BlockType returns the type of the block
+func (b *ReceiverReferenceTime) BlockType() uint8 {
+	return XRTypeReceiverReferenceTime
+}
+
+func (b *ReceiverReferenceTime) marshal() (uint8, []byte, error) {
+	return 0, binary.BigEndian.AppendUint64(nil, b.NTPTime), nil
+}
+
+func (b *ReceiverReferenceTime) unmarshal(typeSpecific uint8, body []byte) error {
+	if len(body) < 8 {
+		return ErrPacketTooShort
+	}
+	b.NTPTime = binary.BigEndian.Uint64(body)
+	return nil
+}
+
+// DLRRReport is the answer to the last receiver reference time of a receiver
+type DLRRReport struct {
+	SSRC uint32
+	// the middle 32 bits of the NTP timestamp of the last receiver reference time from the receiver
+	LastReceiverReport uint32
+	// the delay since then, in 1/65536 seconds
+	Delay uint32
+}
+
+// DLRR is the delay since the last receiver reference times of the receivers
+type DLRR struct {
+	Reports []DLRRReport
+}
+
+// BlockType returns the type of the block
+func (b *DLRR) BlockType() uint8 {
+	return XRTypeDLRR
+}
+
+func (b *DLRR) marshal() (uint8, []byte, error) {
+	var body []byte
+	for _, report := range b.Reports {
+		body = binary.BigEndian.AppendUint32(body, report.SSRC)
+		body = binary.BigEndian.AppendUint32(body, report.LastReceiverReport)
+		body = binary.BigEndian.AppendUint32(body, report.Delay)
+	}
+	return 0, body, nil
+}
+
+func (b *DLRR) unmarshal(typeSpecific uint8, body []byte) error {
+	if len(body)%dlrrReportLength != 0 {
+		return ErrBadLength
+	}
+	b.Reports = nil
+	for ; len(body) > 0; body = body[dlrrReportLength:] {
+		b.Reports = append(b.Reports, DLRRReport{
+			SSRC:               binary.BigEndian.Uint32(body),
+			LastReceiverReport: binary.BigEndian.Uint32(body[4:]),
+			Delay:              binary.BigEndian.Uint32(body[8:]),
+		})
+	}
+	return nil
+}
+
+// the kinds of the TTL fields of a StatisticsSummary
+const (
+	TTLNone     = 0
+	TTLIPv4     = 1 // the IPv4 TTL
+	TTLHopLimit = 2 // the IPv6 hop limit
+)
+
+// StatisticsSummary is about the packets received from a source, of the sequence numbers
+// from BeginSeq up to (but not including) EndSeq
+type StatisticsSummary struct {
+	// which of the fields are measured, (the others are 0)
+	HasLoss       bool
+	HasDuplicates bool
+	HasJitter     bool
+	TTLKind       uint8
+
+	SSRC             uint32
+	BeginSeq         uint16
+	EndSeq           uint16
+	LostPackets      uint32
+	DuplicatePackets uint32
+	// the jitters, in timestamp units
+	MinJitter  uint32
+	MaxJitter  uint32
+	MeanJitter uint32
+	DevJitter  uint32
+	MinTTL     uint8
+	MaxTTL     uint8
+	MeanTTL    uint8
+	DevTTL     uint8
+}
+
+// BlockType returns the type of the block
+func (b *StatisticsSummary) BlockType() uint8 {
+	return XRTypeStatisticsSummary
+}
+
+func (b *StatisticsSummary) marshal() (uint8, []byte, error) {
+	typeSpecific := b.TTLKind & 0x3 << 3
+	for i, flag := range []bool{b.HasLoss, b.HasDuplicates, b.HasJitter} {
+		if flag {
+			typeSpecific |= 0x80 >> i
+		}
+	}
+
+	body := binary.BigEndian.AppendUint32(nil, b.SSRC)
+	body = binary.BigEndian.AppendUint16(body, b.BeginSeq)
+	body = binary.BigEndian.AppendUint16(body, b.EndSeq)
+	for _, field := range []uint32{b.LostPackets, b.DuplicatePackets, b.MinJitter, b.MaxJitter, b.MeanJitter, b.DevJitter} {
+		body = binary.BigEndian.AppendUint32(body, field)
+	}
+	return typeSpecific, append(body, b.MinTTL, b.MaxTTL, b.MeanTTL, b.DevTTL), nil
+}
+
+func (b *StatisticsSummary) unmarshal(typeSpecific uint8, body []byte) error {
+	if len(body) < 36 {
+		return ErrPacketTooShort
+	}
+	b.HasLoss = typeSpecific&0x80 != 0
+	b.HasDuplicates = typeSpecific&0x40 != 0
+	b.HasJitter = typeSpecific&0x20 != 0
+	b.TTLKind = typeSpecific >> 3 & 0x3
+
+	b.SSRC = binary.BigEndian.Uint32(body)
+	b.BeginSeq = binary.BigEndian.Uint16(body[4:])
+	b.EndSeq = binary.BigEndian.Uint16(body[6:])
+	b.LostPackets = binary.BigEndian.Uint32(body[8:])
+	b.DuplicatePackets = binary.BigEndian.Uint32(body[12:])
+	b.MinJitter = binary.BigEndian.Uint32(body[16:])
+	b.MaxJitter = binary.BigEndian.Uint32(body[20:])
+	b.MeanJitter = binary.BigEndian.Uint32(body[24:])
+	b.DevJitter = binary.BigEndian.Uint32(body[28:])
+	b.MinTTL, b.MaxTTL, b.MeanTTL, b.DevTTL = body[32], body[33], body[34], body[35]
+	return nil
+}
+
+// VoIPUnavailable is the value of the 8-bit fields of VoIPMetrics that aren't measured,
+// (the signal, noise and echo levels, and the R factors and MOS)
+const VoIPUnavailable = 127
+
+// VoIPMetrics is about the quality of the packets received from a source, (the rates in 1/256)
+type VoIPMetrics struct {
+	SSRC uint32
+	// of the packets lost, and of those discarded as they arrived too late (or early) to be played
+	LossRate    uint8
+	DiscardRate uint8
+	// of the packets lost or discarded during the bursts, and between them
+	BurstDensity uint8
+	GapDensity   uint8
+	// in milliseconds
+	BurstDuration  uint16
+	GapDuration    uint16
+	RoundTripDelay uint16
+	EndSystemDelay uint16
+	// in dBm
+	SignalLevel int8
+	NoiseLevel  int8
+	// the residual echo return loss, in dB
+	RERL uint8
+	// the gap threshold, (the count of packets received in a row that end a burst)
+	Gmin       uint8
+	RFactor    uint8
+	ExtRFactor uint8
+	MOSLQ      uint8
+	MOSCQ      uint8
+	RXConfig   uint8
+	// the jitter buffer delays, in milliseconds
+	JBNominal    uint16
+	JBMaximum    uint16
+	JBAbsMaximum uint16
+}
+
+// BlockType returns the type of the block
+func (b *VoIPMetrics) BlockType() uint8 {
+	return XRTypeVoIPMetrics
+}
+
+func (b *VoIPMetrics) marshal() (uint8, []byte, error) {
+	body := binary.BigEndian.AppendUint32(nil, b.SSRC)
+	body = append(body, b.LossRate, b.DiscardRate, b.BurstDensity, b.GapDensity)
+	for _, field := range []uint16{b.BurstDuration, b.GapDuration, b.RoundTripDelay, b.EndSystemDelay} {
+		body = binary.BigEndian.AppendUint16(body, field)
+	}
+	body = append(body, uint8(b.SignalLevel), uint8(b.NoiseLevel), b.RERL, b.Gmin,
+		b.RFactor, b.ExtRFactor, b.MOSLQ, b.MOSCQ, b.RXConfig, 0)
+	body = binary.BigEndian.AppendUint16(body, b.JBNominal)
+	body = binary.BigEndian.AppendUint16(body, b.JBMaximum)
+	return 0, binary.BigEndian.AppendUint16(body, b.JBAbsMaximum), nil
+}
+
+func (b *VoIPMetrics) unmarshal(typeSpecific uint8, body []byte) error {
+	if len(body) < 32 {
+		return ErrPacketTooShort
+	}
+	b.SSRC = binary.BigEndian.Uint32(body)
+	b.LossRate, b.DiscardRate, b.BurstDensity, b.GapDensity = body[4], body[5], body[6], body[7]
+	b.BurstDuration = binary.BigEndian.Uint16(body[8:])
+	b.GapDuration = binary.BigEndian.Uint16(body[10:])
+	b.RoundTripDelay = binary.BigEndian.Uint16(body[12:])
+	b.EndSystemDelay = binary.BigEndian.Uint16(body[14:])
+	b.SignalLevel, b.NoiseLevel = int8(body[16]), int8(body[17])
+	b.RERL, b.Gmin = body[18], body[19]
+	b.RFactor, b.ExtRFactor, b.MOSLQ, b.MOSCQ = body[20], body[21], body[22], body[23]
+	b.RXConfig = body[24]
+	b.JBNominal = binary.BigEndian.Uint16(body[26:])
+	b.JBMaximum = binary.BigEndian.Uint16(body[28:])
+	b.JBAbsMaximum = binary.BigEndian.Uint16(body[30:])
+	return nil
+}
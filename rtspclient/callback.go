@@ -1,6 +1,8 @@
 package rtspclient
 
 import (
+	"errors"
+
 	"github.com/djwackey/dorsvr/livemedia"
 	"github.com/djwackey/gitea/log"
 )
@@ -31,6 +33,7 @@ func continueAfterDESCRIBE(c *RTSPClient, resultCode int, resultStr string) {
 	}
 
 	// An error occurred with this stream.
+	c.notifyStreamState(false, errors.New("DESCRIBE failed: "+resultStr))
 	shutdownStream(c)
 }
 
@@ -42,7 +45,7 @@ func continueAfterSETUP(c *RTSPClient, resultCode int, resultStr string) {
 		}
 
 		scs := c.scs
-		scs.Subsession.Sink = NewDummySink(scs.Subsession, c.baseURL)
+		scs.Subsession.Sink = NewDummySink(scs.Subsession, c.baseURL, c.frameHandler)
 		if scs.Subsession.Sink == nil {
 			log.Error(4, "Failed to create a data sink for the subsession.")
 			break
@@ -71,10 +74,14 @@ func continueAfterPLAY(c *RTSPClient, resultCode int, resultStr string) {
 		}
 
 		log.Info("Started playing session")
+		c.noteRTPActivity()
+		c.startKeepAlive()
+		c.notifyStreamState(true, nil)
 		return
 	}
 
 	// An unrecoverable error occurred with this stream.
+	c.notifyStreamState(false, errors.New("PLAY failed: "+resultStr))
 	shutdownStream(c)
 }
 
@@ -87,6 +94,7 @@ func subsessionByeHandler(subsession *livemedia.MediaSubsession) {
 
 func subsessionAfterPlaying(subsession *livemedia.MediaSubsession) {
 	rtspClient := subsession.MiscPtr.(*RTSPClient)
+	rtspClient.notifyStreamState(false, errors.New("the subsession has ended"))
 	shutdownStream(rtspClient)
 }
 
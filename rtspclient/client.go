@@ -1,12 +1,17 @@
 package rtspclient
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/djwackey/dorsvr/auth"
 	gs "github.com/djwackey/dorsvr/groupsock"
@@ -16,20 +21,41 @@ import (
 // default value; you can reassign this in your application if you need to
 var responseBufferSize = 20000
 
+var errNoSession = errors.New("no RTSP session is currently in progress")
+
 type RTSPClient struct {
 	baseURL                       string
+	urlQuery                      string
+	urlPath                       string
 	lastSessionID                 string
 	sessionCookie                 string
 	serverAddress                 string
 	userAgentHeaderStr            string
 	responseBuffer                []byte
 	cseq                          int
+	sessionTimeoutParameter       int
+	redirectCount                 int
+	maxRedirects                  int
 	tcpStreamIDCount              uint
 	tunnelOverHTTPPortNum         uint
 	responseBufferBytesLeft       uint
 	responseBytesAlreadySeen      uint
+	httpTunnelingEstablished      bool
+	isSecure                      bool // "rtsps://"
+	srtpOverUDP                   bool
+	serverSupportsGetParameter    atomic.Bool // (also read by the keep-alive timer)
+	lastRTPActivity               int64       // unix nanoseconds, accessed atomically
+	frameHandler                  interface{}
+	streamStateFunc               func(playing bool, err error)
+	keepAliveStop                 chan struct{}
+	cseqMutex                     sync.Mutex
+	keepAliveMutex                sync.Mutex
+	tunnelMutex                   sync.Mutex // guards httpTunnelingEstablished and requestsAwaitingHTTPTunneling
+	mutex                         sync.Mutex // held as we handle what the server sent, or move to another server
 	digest                        *auth.Digest
-	tcpConn                       *net.TCPConn
+	tlsConfig                     *tls.Config
+	tcpConn                       net.Conn
+	outputConn                    *net.TCPConn
 	scs                           *StreamClientState
 	requestsAwaitingResponse      *RequestQueue
 	requestsAwaitingHTTPTunneling *RequestQueue
@@ -37,13 +63,37 @@ type RTSPClient struct {
 
 func New() *RTSPClient {
 	return &RTSPClient{
-		scs:                      newStreamClientState(),
-		digest:                   auth.NewDigest(),
-		responseBuffer:           make([]byte, responseBufferSize),
-		requestsAwaitingResponse: newRequestQueue(),
+		scs:                           newStreamClientState(),
+		digest:                        auth.NewDigest(),
+		responseBuffer:                make([]byte, responseBufferSize),
+		requestsAwaitingResponse:      newRequestQueue(),
+		requestsAwaitingHTTPTunneling: newRequestQueue(),
+		maxRedirects:                  defaultMaxRedirects,
 	}
 }
 
+// SetupTunnelingOverHTTP makes the client carry its RTSP session over a pair of
+// HTTP connections (GET for the server's output, POST for ours) to the given port,
+// as Apple's QuickTime does. It must be called before DialRTSP.
+// An "http://" URL passed to DialRTSP enables it as well, using the URL's port.
+func (c *RTSPClient) SetupTunnelingOverHTTP(portNum uint) {
+	c.tunnelOverHTTPPortNum = portNum
+}
+
+// SetTLSConfig sets the TLS configuration used for "rtsps://" URLs (e.g. to trust
+// a private CA). By default, the server's certificate is verified against the
+// system's roots, for the host name in the URL. It must be called before DialRTSP.
+func (c *RTSPClient) SetTLSConfig(config *tls.Config) {
+	c.tlsConfig = config
+}
+
+// UseSRTPOverUDP makes a "rtsps://" client receive its media as SRTP over UDP,
+// for the subsessions that the server offers as "RTP/SAVP" (with a key in an
+// "a=crypto:" line), instead of interleaved in the TLS connection.
+func (c *RTSPClient) UseSRTPOverUDP(srtpOverUDP bool) {
+	c.srtpOverUDP = srtpOverUDP
+}
+
 func (c *RTSPClient) DialRTSP(rtspURL string) bool {
 	appName := "dorcli"
 	c.init(rtspURL, appName)
@@ -75,6 +125,49 @@ func (c *RTSPClient) Close() {
 	c.sendTeardownCommand(c.scs.Session, nil)
 }
 
+// SetFrameHandler sets the function that receives each frame of each subsession,
+// in place of the default printout:
+//
+//	func(subsession *livemedia.MediaSubsession, frame []byte, presentationTime syscall.Timeval)
+//
+// The frame is only valid until the handler returns. The presentation times are synchronized
+// across the subsessions (e.g. for lip-sync) once subsession.HasBeenSynchronizedUsingRTCP(),
+// i.e. after the first RTCP SR, and subsession.PresentationTime maps any RTP timestamp the same way.
+func (c *RTSPClient) SetFrameHandler(handler interface{}) {
+	c.frameHandler = handler
+}
+
+// noteRTPActivity records that we've just received media data.
+func (c *RTSPClient) noteRTPActivity() {
+	atomic.StoreInt64(&c.lastRTPActivity, time.Now().UnixNano())
+}
+
+// timeSinceRTPActivity returns how long it's been since we last received media data
+// (or since PLAY succeeded, if nothing has arrived yet).
+func (c *RTSPClient) timeSinceRTPActivity() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.lastRTPActivity)))
+}
+
+// notifyStreamState tells whoever supervises us that the stream started playing,
+// or that it ended (with the reason).
+func (c *RTSPClient) notifyStreamState(playing bool, err error) {
+	if c.streamStateFunc != nil {
+		c.streamStateFunc(playing, err)
+	}
+}
+
+// shutdown closes our connection(s) and releases the media session's sockets,
+// without talking to the server any more.
+func (c *RTSPClient) shutdown() {
+	c.stopKeepAlive()
+	if c.tcpConn != nil {
+		c.resetTCPSockets()
+	}
+	if c.scs.Session != nil {
+		c.scs.Session.Close()
+	}
+}
+
 func (c *RTSPClient) init(rtspURL, appName string) {
 	c.baseURL = rtspURL
 	c.cseq = 1
@@ -92,87 +185,180 @@ func (c *RTSPClient) init(rtspURL, appName string) {
 		appName, libPrefix, libName, libVersionStr, libSuffix)
 }
 
-func (c *RTSPClient) sendOptionsCommand(responseHandler interface{}) int {
+// nextCSeq returns the "CSeq:" of a new request; requests may be sent from
+// the keep-alive goroutine as well as from response handlers.
+func (c *RTSPClient) nextCSeq() int {
+	c.cseqMutex.Lock()
+	defer c.cseqMutex.Unlock()
 	c.cseq++
-	return c.sendRequest(newRequestRecord(c.cseq, "OPTIONS", responseHandler))
+	return c.cseq
+}
+
+func (c *RTSPClient) sendOptionsCommand(responseHandler interface{}) int {
+	return c.sendRequest(newRequestRecord(c.nextCSeq(), "OPTIONS", responseHandler))
 }
 
 func (c *RTSPClient) sendAnnounceCommand(responseHandler interface{}) int {
-	c.cseq++
-	return c.sendRequest(newRequestRecord(c.cseq, "ANNOUNCE", responseHandler))
+	return c.sendRequest(newRequestRecord(c.nextCSeq(), "ANNOUNCE", responseHandler))
 }
 
 func (c *RTSPClient) sendDescribeCommand(responseHandler interface{}) int {
-	c.cseq++
-	return c.sendRequest(newRequestRecord(c.cseq, "DESCRIBE", responseHandler))
+	return c.sendRequest(newRequestRecord(c.nextCSeq(), "DESCRIBE", responseHandler))
 }
 
 func (c *RTSPClient) sendSetupCommand(subsession *livemedia.MediaSubsession, responseHandler interface{}) int {
-	c.cseq++
-	record := newRequestRecord(c.cseq, "SETUP", responseHandler)
+	record := newRequestRecord(c.nextCSeq(), "SETUP", responseHandler)
 	record.subsession = subsession
+	if c.tunnelOverHTTPPortNum != 0 {
+		// media can only reach us through the tunnel, interleaved with the responses
+		record.boolFlags |= 0x1
+	} else if c.isSecure {
+		if c.srtpOverUDP && subsession.IsSecure() {
+			record.boolFlags |= 0x4
+		} else {
+			// media is protected by being interleaved in our TLS connection
+			record.boolFlags |= 0x1
+		}
+	}
 	return c.sendRequest(record)
 }
 
 func (c *RTSPClient) sendPlayCommand(session *livemedia.MediaSession, responseHandler interface{}) int {
-	c.cseq++
-	record := newRequestRecord(c.cseq, "PLAY", responseHandler)
+	record := newRequestRecord(c.nextCSeq(), "PLAY", responseHandler)
 	record.session = session
 	return c.sendRequest(record)
 }
 
 func (c *RTSPClient) sendPauseCommand(responseHandler interface{}) int {
-	c.cseq++
-	return c.sendRequest(newRequestRecord(c.cseq, "PAUSE", responseHandler))
+	return c.sendRequest(newRequestRecord(c.nextCSeq(), "PAUSE", responseHandler))
 }
 
 func (c *RTSPClient) sendRecordCommand(responseHandler interface{}) int {
-	c.cseq++
-	return c.sendRequest(newRequestRecord(c.cseq, "RECORD", responseHandler))
+	return c.sendRequest(newRequestRecord(c.nextCSeq(), "RECORD", responseHandler))
 }
 
 func (c *RTSPClient) sendTeardownCommand(session *livemedia.MediaSession, responseHandler interface{}) int {
-	c.cseq++
-	record := newRequestRecord(c.cseq, "TEARDOWN", responseHandler)
+	c.stopKeepAlive()
+
+	record := newRequestRecord(c.nextCSeq(), "TEARDOWN", responseHandler)
 	record.session = session
 	return c.sendRequest(record)
 }
 
 func (c *RTSPClient) sendSetParameterCommand(responseHandler interface{}) int {
-	c.cseq++
-	return c.sendRequest(newRequestRecord(c.cseq, "SET_PARAMETER", responseHandler))
+	return c.sendRequest(newRequestRecord(c.nextCSeq(), "SET_PARAMETER", responseHandler))
 }
 
-func (c *RTSPClient) sendGetParameterCommand(responseHandler interface{}) int {
-	c.cseq++
-	return c.sendRequest(newRequestRecord(c.cseq, "GET_PARAMETER", responseHandler))
+func (c *RTSPClient) sendGetParameterCommand(session *livemedia.MediaSession, responseHandler interface{}) int {
+	record := newRequestRecord(c.nextCSeq(), "GET_PARAMETER", responseHandler)
+	record.session = session
+	return c.sendRequest(record)
 }
 
+// setupHTTPTunneling sends the HTTP "GET" that opens the server-to-client half of the tunnel.
+// Once the server has accepted it, setupHTTPTunneling2 opens the client-to-server half.
 func (c *RTSPClient) setupHTTPTunneling() {
+	// Create a 'session cookie' string, to identify the pair of HTTP connections:
+	c.sessionCookie = fmt.Sprintf("%08x%08x", gs.OurRandom32(), gs.OurRandom32())
+
+	c.sendRequest(newRequestRecord(c.nextCSeq(), "GET", nil))
+}
+
+func (c *RTSPClient) setupHTTPTunneling2() bool {
+	addr := c.tcpConn.RemoteAddr().(*net.TCPAddr)
+
+	var err error
+	c.outputConn, err = net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		fmt.Println("Failed to open the HTTP tunneling POST connection.", err.Error())
+		return false
+	}
+
+	// Send the HTTP "POST" that carries all of our following (base64-encoded) requests:
+	c.sendRequest(newRequestRecord(c.nextCSeq(), "POST", nil))
+
+	// Now that the tunnel is up, send any requests that we queued while setting it up
+	// (under the same lock as the queueing, so that no new request can overtake them):
+	var failedRequests []*RequestRecord
+	c.tunnelMutex.Lock()
+	c.httpTunnelingEstablished = true
+	for {
+		request := c.requestsAwaitingHTTPTunneling.dequeue()
+		if request == nil {
+			break
+		}
+		if _, err := c.writeRequestRecord(request); err != nil {
+			failedRequests = append(failedRequests, request)
+		}
+	}
+	c.tunnelMutex.Unlock()
+
+	// (their handlers may well send requests of their own, so they're called without the lock)
+	for _, request := range failedRequests {
+		c.handleRequestError(request)
+	}
+	return true
 }
 
 func (c *RTSPClient) openConnection() bool {
-	rtspUrl, result := c.parseRTSPURL(c.baseURL)
-	if !result {
+	rtspURL, err := livemedia.ParseRTSPURL(c.baseURL)
+	if err != nil {
+		fmt.Println("Failed to parse the URL.", err.Error())
 		return false
 	}
 
-	if rtspUrl.username != "" || rtspUrl.password != "" {
-		c.digest.Username = rtspUrl.username
-		c.digest.Password = rtspUrl.password
+	switch rtspURL.Scheme {
+	case "rtsp", "http":
+	case "rtsps":
+		if c.tunnelOverHTTPPortNum != 0 {
+			fmt.Println("RTSP-over-HTTP tunneling is not supported for \"rtsps://\" URLs")
+			return false
+		}
+	default:
+		fmt.Println("Unsupported URL scheme:", rtspURL.Scheme)
+		return false
+	}
+	c.isSecure = rtspURL.Scheme == "rtsps"
+
+	// The credentials in the URL are used to answer the server's challenge,
+	// and are not sent in the requests themselves.
+	if rtspURL.Username != "" || rtspURL.Password != "" {
+		c.digest.Username = rtspURL.Username
+		c.digest.Password = rtspURL.Password
 	}
 
-	c.serverAddress = rtspUrl.address
+	c.serverAddress = rtspURL.Host
+	c.urlPath = rtspURL.RequestURI()
+	// (e.g. the token of a signed URL, which the server expects on every request)
+	c.urlQuery = rtspURL.RawQuery
+
+	rtspURL.Username, rtspURL.Password = "", ""
+
+	portNum := rtspURL.Port
+	if rtspURL.Scheme == "http" {
+		// "http://..." is our shorthand for RTSP-over-HTTP to that port;
+		// the requests themselves still use the "rtsp://" form of the URL.
+		c.tunnelOverHTTPPortNum = uint(rtspURL.Port)
+		rtspURL.Scheme = "rtsp"
+	}
+	if c.tunnelOverHTTPPortNum != 0 {
+		portNum = int(c.tunnelOverHTTPPortNum)
+	}
+	c.baseURL = rtspURL.String()
 
-	err := c.connectToServer(rtspUrl.address, rtspUrl.port)
+	err = c.connectToServer(rtspURL.Host, portNum)
 	if err != nil {
 		return false
 	}
+
+	if c.tunnelOverHTTPPortNum != 0 {
+		c.setupHTTPTunneling()
+	}
 	return true
 }
 
 func (c *RTSPClient) connectToServer(host string, port int) error {
-	tcpAddr := fmt.Sprintf("%s:%d", host, port)
+	tcpAddr := net.JoinHostPort(host, strconv.Itoa(port))
 	addr, err := net.ResolveTCPAddr("tcp", tcpAddr)
 	if err != nil {
 		fmt.Printf("Failed to resolve TCP address.%s\n", err.Error())
@@ -181,11 +367,18 @@ func (c *RTSPClient) connectToServer(host string, port int) error {
 
 	fmt.Printf("Opening connection to %s, port %d...\n", host, port)
 
-	c.tcpConn, err = net.DialTCP("tcp", nil, addr)
+	var conn net.Conn
+	if c.isSecure {
+		// (the server's certificate is checked against "host", unless the config names another)
+		conn, err = tls.Dial("tcp", tcpAddr, c.tlsConfig)
+	} else {
+		conn, err = net.DialTCP("tcp", nil, addr)
+	}
 	if err != nil {
 		fmt.Printf("Failed to connect to server.%s\n", err.Error())
 		return err
 	}
+	c.tcpConn = conn
 
 	fmt.Println("...remote connection opened")
 	return nil
@@ -193,16 +386,28 @@ func (c *RTSPClient) connectToServer(host string, port int) error {
 
 func (c *RTSPClient) resetTCPSockets() {
 	c.tcpConn.Close()
+	if c.outputConn != nil {
+		c.outputConn.Close()
+		c.outputConn = nil
+	}
+	c.tunnelMutex.Lock()
+	c.httpTunnelingEstablished = false
+	c.tunnelMutex.Unlock()
+}
+
+// reopenHTTPTunnelingConnection replaces the connection of our HTTP "GET" with a new one,
+// to the same server (and port), e.g. to send the "GET" again with our credentials.
+// (our reader notices that "tcpConn" changed, and carries on with the new connection)
+func (c *RTSPClient) reopenHTTPTunnelingConnection() bool {
+	c.resetTCPSockets()
+	return c.connectToServer(c.serverAddress, int(c.tunnelOverHTTPPortNum)) == nil
 }
 
 func (c *RTSPClient) createAuthenticatorStr(cmd, url string) (s string) {
 	if c.digest.Realm != "" && c.digest.Username != "" && c.digest.Password != "" {
 		var response string
 		if c.digest.Nonce != "" { // digest authentication
-			response = c.digest.ComputeResponse(cmd, url)
-			s = fmt.Sprintf("Authorization: Digest username=\"%s\", "+
-				"realm=\"%s\", nonce=\"%s\", uri=\"%s\", response=\"%s\"\r\n",
-				c.digest.Username, c.digest.Realm, c.digest.Nonce, url, response)
+			s = fmt.Sprintf("Authorization: %s\r\n", c.digest.Credentials(cmd, url))
 		} else { // basic authentication
 			usernamePassword := fmt.Sprintf("%s:%s", c.digest.Username, c.digest.Password)
 			response = base64.StdEncoding.EncodeToString([]byte(usernamePassword))
@@ -213,85 +418,129 @@ func (c *RTSPClient) createAuthenticatorStr(cmd, url string) (s string) {
 	return s
 }
 
-type RTSPURL struct {
-	streamName string
-	username   string
-	password   string
-	address    string
-	port       int
-}
-
-func (c *RTSPClient) parseRTSPURL(url string) (*RTSPURL, bool) {
-	rtspUrl := new(RTSPURL)
-	var result bool
+func (c *RTSPClient) incomingDataHandler() {
+	c.responseBytesAlreadySeen = 0
+	c.responseBufferBytesLeft = uint(len(c.responseBuffer))
 	for {
-		// Parse the URL as "rtsp://[<username>[:<password>]@]<server-address-or-name>[:<port>][/<stream-name>]"
-		prefix := "rtsp://"
-		ret := strings.HasPrefix(url, prefix)
-		if !ret {
-			fmt.Println("URL is not of the form \"" + prefix + "\"")
+		c.mutex.Lock()
+		conn := c.tcpConn
+		c.mutex.Unlock()
+
+		readBytes, err := gs.ReadSocket(conn, c.responseBuffer[c.responseBytesAlreadySeen:])
+
+		c.mutex.Lock()
+		if err != nil {
+			if conn != c.tcpConn {
+				// we were redirected to another server while we waited; carry on reading from it
+				c.responseBytesAlreadySeen = 0
+				c.responseBufferBytesLeft = uint(len(c.responseBuffer))
+				c.mutex.Unlock()
+				continue
+			}
+			c.mutex.Unlock()
+			conn.Close()
+			fmt.Println("Failed to read bytes.", err.Error())
+			c.stopKeepAlive()
+			c.notifyStreamState(false, errors.New("connection closed: "+err.Error()))
 			break
 		}
 
-		// Check whether "<username>[:<password>]@" occurs next.
-		index := strings.Index(url, "@")
-		if index != -1 {
-			// found "@"
-			s := strings.Split(url[7:index], ":")
-			if len(s) <= 1 {
-				fmt.Println("URL is not of the form \"" + url + "\"")
-				break
-			}
+		c.responseBytesAlreadySeen += uint(readBytes)
+		c.responseBufferBytesLeft -= uint(readBytes)
+		c.handleIncomingBytes()
+		c.mutex.Unlock()
+	}
+}
 
-			rtspUrl.username, rtspUrl.password = s[0], s[1]
-			index++
+// handleIncomingBytes splits what we've read so far into complete RTSP (or HTTP) messages
+// and RTP/RTCP packets interleaved with them (RFC 2326, section 10.12),
+// keeping any incomplete tail for the next read.
+func (c *RTSPClient) handleIncomingBytes() {
+	conn := c.tcpConn
+	for c.responseBytesAlreadySeen > 0 {
+		data := c.responseBuffer[:c.responseBytesAlreadySeen]
+
+		var consumed int
+		if data[0] == '$' {
+			consumed = c.handleInterleavedPacket(data)
+		} else if data[0] < 'A' || data[0] > 'Z' {
+			// not the start of a response, a request or a packet; resynchronize
+			consumed = 1
 		} else {
-			index = 7
+			consumed = c.handleIncomingMessage(data)
 		}
 
-		parseBufferSize := 1000
-		if len(url) > parseBufferSize {
-			fmt.Println("URL is too long")
-			break
+		if consumed == 0 {
+			break // we need more data
 		}
 
-		// Next, parse <server-address-or-name>, port, stream-name
-		substrings := strings.Split(url[index:], "/")
-		if len(substrings) <= 1 {
-			fmt.Println("URL is not of the form \"" + url + "\"")
-			break
+		if conn != c.tcpConn {
+			// a redirect moved us to another server; whatever else the old one sent is of no use
+			c.responseBytesAlreadySeen = 0
+			c.responseBufferBytesLeft = uint(len(c.responseBuffer))
+			return
 		}
-		rtspUrl.streamName = substrings[1]
 
-		substrings = strings.Split(substrings[0], ":")
-		if len(substrings) > 1 {
-			rtspUrl.port, _ = strconv.Atoi(substrings[1])
-			if rtspUrl.port < 1 || rtspUrl.port > 65535 {
-				fmt.Println("Bad Port Number")
-				break
-			}
-		} else {
-			rtspUrl.port = 554 // default
-		}
+		copy(c.responseBuffer, data[consumed:])
+		c.responseBytesAlreadySeen -= uint(consumed)
+		c.responseBufferBytesLeft += uint(consumed)
+	}
 
-		rtspUrl.address = substrings[0]
-		result = true
-		break
+	if c.responseBufferBytesLeft == 0 {
+		fmt.Println("Response buffer overflowed; discarding its contents.")
+		c.responseBytesAlreadySeen = 0
+		c.responseBufferBytesLeft = uint(len(c.responseBuffer))
 	}
-	return rtspUrl, result
 }
 
-func (c *RTSPClient) incomingDataHandler() {
-	defer c.tcpConn.Close()
-	for {
-		readBytes, err := gs.ReadSocket(c.tcpConn, c.responseBuffer)
-		if err != nil {
-			fmt.Println("Failed to read bytes.", err.Error())
+// handleInterleavedPacket handles a "$<channel id><2-byte size><packet>" frame,
+// returning the number of bytes used, or 0 if the frame isn't complete yet.
+func (c *RTSPClient) handleInterleavedPacket(data []byte) int {
+	if len(data) < 4 {
+		return 0
+	}
+
+	channelID := uint(data[1])
+	size := int(data[2])<<8 | int(data[3])
+	if len(data) < 4+size {
+		return 0
+	}
+
+	if c.scs.Session != nil {
+		// the packet is handed over to another goroutine, so it can't share our buffer
+		packet := make([]byte, size)
+		copy(packet, data[4:4+size])
+		if !c.scs.Session.DeliverInterleavedPacket(channelID, packet) {
+			fmt.Printf("Discarded interleaved packet for unknown channel %d.\n", channelID)
+		}
+	}
+	return 4 + size
+}
+
+// handleIncomingMessage handles a complete RTSP/HTTP response (or request),
+// returning the number of bytes used, or 0 if the message isn't complete yet.
+func (c *RTSPClient) handleIncomingMessage(data []byte) int {
+	headersEnd := bytes.Index(data, []byte("\r\n\r\n"))
+	if headersEnd == -1 {
+		return 0
+	}
+	headersEnd += 4
+
+	var contentLength int
+	for _, line := range strings.Split(string(data[:headersEnd]), "\r\n") {
+		if len(line) > 15 && strings.EqualFold(line[:15], "Content-Length:") {
+			fmt.Sscanf(strings.TrimSpace(line[15:]), "%d", &contentLength)
 			break
 		}
+	}
 
-		c.handleResponseBytes(c.responseBuffer, readBytes)
+	messageSize := headersEnd + contentLength
+	if len(data) < messageSize {
+		return 0
 	}
+
+	c.handleResponseBytes(data, messageSize)
+	return messageSize
 }
 
 func getLine(startOfLine string) (thisLineStart, nextLineStart string) {
@@ -332,7 +581,8 @@ func (c *RTSPClient) handleResponseBytes(buffer []byte, length int) {
 	var rangeParamsStr, rtpInfoParamsStr string
 	var headerParamsStr, sessionParamsStr string
 	var transportParamsStr, scaleParamsStr string
-	var wwwAuthenticateParamsStr, publicParamsStr string
+	var publicParamsStr, locationParamsStr string
+	var wwwAuthenticate *auth.Challenge
 	var foundRequest *RequestRecord
 	var responseSuccess bool
 
@@ -361,7 +611,10 @@ func (c *RTSPClient) handleResponseBytes(buffer []byte, length int) {
 					// This is the handler that we want. Remove its record, but remember it,
 					// so that we can later call its handler:
 					foundRequest = request
+					break
 				} else {
+					// The response is to an older request; keep this one waiting
+					c.requestsAwaitingResponse.putAtHead(request)
 					break
 				}
 			}
@@ -384,19 +637,24 @@ func (c *RTSPClient) handleResponseBytes(buffer []byte, length int) {
 			rtpInfoParamsStr = headerParamsStr
 		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "WWW-Authenticate:", 17); result {
 			// If we've already seen a "WWW-Authenticate:" header, then we replace it with this new one only if
-			// the new one specifies "Digest" authentication:
-			if wwwAuthenticateParamsStr == "" || headerParamsStr[:6] == "Digest" {
-				wwwAuthenticateParamsStr = headerParamsStr
+			// the new one is stronger, (i.e. "Digest" rather than "Basic", SHA-256 rather than MD5):
+			if challenge := auth.ParseChallenge(headerParamsStr); challenge != nil {
+				if wwwAuthenticate == nil || challenge.Strength() > wwwAuthenticate.Strength() {
+					wwwAuthenticate = challenge
+				}
 			}
 		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Public:", 7); result {
 			publicParamsStr = headerParamsStr
+			c.serverSupportsGetParameter.Store(strings.Contains(headerParamsStr, "GET_PARAMETER"))
 		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Allow:", 6); result {
+			c.serverSupportsGetParameter.Store(strings.Contains(headerParamsStr, "GET_PARAMETER"))
 		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Location:", 9); result {
-			c.baseURL = headerParamsStr
+			locationParamsStr = headerParamsStr
 		}
 	}
 
-	if foundRequest == nil {
+	if foundRequest == nil && cseq == 0 {
+		// There was no "CSeq:" header; assume it's the response to our oldest request
 		foundRequest = c.requestsAwaitingResponse.dequeue()
 	}
 
@@ -415,10 +673,14 @@ func (c *RTSPClient) handleResponseBytes(buffer []byte, length int) {
 	var needToResendCommand bool
 	if foundRequest != nil {
 		if responseCode == 200 {
+			c.redirectCount = 0
+
 			switch foundRequest.commandName {
 			case "SETUP":
+				streamUsingTCP := (foundRequest.boolFlags & 0x1) != 0
+				streamUsingSRTP := (foundRequest.boolFlags & 0x4) != 0
 				if !c.handleSetupResponse(foundRequest.subsession,
-					sessionParamsStr, transportParamsStr, false) {
+					sessionParamsStr, transportParamsStr, streamUsingTCP, streamUsingSRTP) {
 					break
 				}
 			case "PLAY":
@@ -433,19 +695,25 @@ func (c *RTSPClient) handleResponseBytes(buffer []byte, length int) {
 				if !c.handleGetParameterResponse(foundRequest.contentStr) {
 					break
 				}
+			case "GET":
+				// The server accepted the first half of our HTTP tunnel:
+				if !c.setupHTTPTunneling2() {
+					break
+				}
 			default:
 			}
-		} else if responseCode == 401 && c.handleAuthenticationFailure(wwwAuthenticateParamsStr) {
+		} else if responseCode == 401 && c.handleAuthenticationFailure(wwwAuthenticate) {
 			// We need to resend the command, with an "Authorization:" header:
 			needToResendCommand = true
 
 			if foundRequest.commandName == "GET" {
-				c.resetTCPSockets()
+				// The server closes the connection of a "GET" that it refuses,
+				// so the new one goes out on a new connection:
+				needToResendCommand = c.reopenHTTPTunnelingConnection()
 			}
-		} else if responseCode == 301 || responseCode == 302 { // redirect
+		} else if responseCode >= 300 && responseCode < 400 && locationParamsStr != "" { // redirect
 			// because we need to connect somewhere else next
-			c.resetTCPSockets()
-			needToResendCommand = true
+			needToResendCommand = c.handleRedirect(locationParamsStr)
 		}
 	}
 
@@ -484,13 +752,33 @@ func (c *RTSPClient) handleRequestError(request *RequestRecord) {
 }
 
 func (c *RTSPClient) sendRequest(request *RequestRecord) int {
-	if c.tunnelOverHTTPPortNum != 0 {
-		c.setupHTTPTunneling()
-		c.requestsAwaitingHTTPTunneling.enqueue(request)
-		return request.cseq
+	isHTTPRequest := request.commandName == "GET" || request.commandName == "POST"
+	if c.tunnelOverHTTPPortNum != 0 && !isHTTPRequest {
+		c.tunnelMutex.Lock()
+		if !c.httpTunnelingEstablished {
+			// We can't send RTSP requests until the tunnel has been set up:
+			c.requestsAwaitingHTTPTunneling.enqueue(request)
+			c.tunnelMutex.Unlock()
+			return request.cseq
+		}
+		c.tunnelMutex.Unlock()
 	}
 
+	result, err := c.writeRequestRecord(request)
+	if err != nil {
+		c.handleRequestError(request)
+	}
+	return result
+}
+
+// writeRequestRecord formats the request and writes it to the server, without
+// waiting for the HTTP tunnel (if any), or calling its handler if it fails.
+func (c *RTSPClient) writeRequestRecord(request *RequestRecord) (int, error) {
+	isHTTPRequest := request.commandName == "GET" || request.commandName == "POST"
 	protocalStr := "RTSP/1.0"
+	if isHTTPRequest {
+		protocalStr = "HTTP/1.1"
+	}
 	var contentLengthHeader string
 
 	contentStrLen := len(request.contentStr)
@@ -514,9 +802,11 @@ func (c *RTSPClient) sendRequest(request *RequestRecord) int {
 
 		var transportFmt string
 		if subsession.ProtocolName() == "UDP" {
-			transportFmt = "Transport: RAW/RAW/UDP%s%s%s=%d-%d\r\n"
+			transportFmt = "Transport: RAW/RAW/UDP%s%s%s=%d-%d%s\r\n"
+		} else if (request.boolFlags & 0x4) != 0 {
+			transportFmt = "Transport: RTP/SAVP%s%s%s=%d-%d%s\r\n"
 		} else {
-			transportFmt = "Transport: RTP/AVP%s%s%s=%d-%d\r\n"
+			transportFmt = "Transport: RTP/AVP%s%s%s=%d-%d%s\r\n"
 		}
 
 		cmdURL = fmt.Sprintf("%s%s%s", prefix, separator, suffix)
@@ -527,7 +817,7 @@ func (c *RTSPClient) sendRequest(request *RequestRecord) int {
 		}
 
 		var rtpNumber, rtcpNumber uint
-		var transportTypeStr, portTypeStr string
+		var transportTypeStr, portTypeStr, rtcpMuxStr string
 		if streamUsingTCP {
 			transportTypeStr = "/TCP;unicast"
 			portTypeStr = ";interleaved"
@@ -540,10 +830,14 @@ func (c *RTSPClient) sendRequest(request *RequestRecord) int {
 			portTypeStr = ";client_port"
 			rtpNumber = subsession.ClientPortNum()
 			rtcpNumber = rtpNumber + 1
+			// (we still give our RTCP port, in case the server doesn't take RTCP on its RTP port)
+			if subsession.OffersRTCPMux() && subsession.ProtocolName() != "UDP" {
+				rtcpMuxStr = ";rtcp-mux"
+			}
 		}
 
 		transportStr := fmt.Sprintf(transportFmt, transportTypeStr, modeStr,
-			portTypeStr, rtpNumber, rtcpNumber)
+			portTypeStr, rtpNumber, rtcpNumber, rtcpMuxStr)
 
 		sessionStr := c.createSessionString(c.lastSessionID)
 
@@ -551,8 +845,7 @@ func (c *RTSPClient) sendRequest(request *RequestRecord) int {
 	case "PLAY", "PAUSE", "TEARDOWN", "RECORD", "SET_PARAMETER", "GET_PARAMETER":
 		if c.lastSessionID == "" {
 			fmt.Println("No RTSP session is currently in progress")
-			c.handleRequestError(request)
-			return request.cseq
+			return request.cseq, errNoSession
 		}
 
 		var sessionID string
@@ -595,10 +888,17 @@ func (c *RTSPClient) sendRequest(request *RequestRecord) int {
 				"Expires: Sun, 9 Jan 1972 00:00:00 GMT\r\n"
 		}
 		extraHeaders = fmt.Sprintf(extraHeadersFmt, c.sessionCookie)
+		cmdURL = c.urlPath
 	default:
 	}
 
-	authenticatorStr := c.createAuthenticatorStr(request.commandName, c.baseURL)
+	// the "Content-Base:" of the server usually drops the query of our URL, so we add it again:
+	if c.urlQuery != "" && !isHTTPRequest && !strings.Contains(cmdURL, "?") {
+		cmdURL += "?" + c.urlQuery
+	}
+
+	// (the "uri" of the digest response is the URL of this very request)
+	authenticatorStr := c.createAuthenticatorStr(request.commandName, cmdURL)
 
 	cmdFmt := "%s %s %s\r\n" +
 		"CSeq: %d\r\n" +
@@ -619,18 +919,31 @@ func (c *RTSPClient) sendRequest(request *RequestRecord) int {
 		contentLengthHeader,
 		request.contentStr)
 
-	writeBytes, err := c.tcpConn.Write([]byte(cmd))
+	writeBytes, err := c.writeRequest(request.commandName, cmd)
 	if err != nil {
 		fmt.Println("RTSPClient::sendRequst", err, writeBytes)
-		c.handleRequestError(request)
 	}
 
-	if c.tunnelOverHTTPPortNum == 0 {
+	// The server never responds to the "POST" that carries our tunneled requests:
+	if request.commandName != "POST" {
 		c.requestsAwaitingResponse.enqueue(request)
 	}
 
 	fmt.Printf("Sending request:\n%s\n", cmd)
-	return writeBytes
+	return writeBytes, err
+}
+
+// writeRequest writes the request to the server; if we're tunneling over HTTP,
+// RTSP requests are base64-encoded and go out on the "POST" connection.
+func (c *RTSPClient) writeRequest(commandName, cmd string) (int, error) {
+	if c.tunnelOverHTTPPortNum == 0 || commandName == "GET" {
+		return c.tcpConn.Write([]byte(cmd))
+	}
+
+	if commandName == "POST" {
+		return c.outputConn.Write([]byte(cmd))
+	}
+	return c.outputConn.Write([]byte(base64.StdEncoding.EncodeToString([]byte(cmd))))
 }
 
 func (c *RTSPClient) sessionURL(session *livemedia.MediaSession) string {
@@ -725,7 +1038,7 @@ func (c *RTSPClient) parseResponseCode(line string) (responseCode int, responseS
 		}
 
 		n, _ = fmt.Sscanf(line, "HTTP/%s %d", &version, &responseCode)
-		if n != 2 {
+		if n == 2 {
 			result = true
 			break
 		}
@@ -746,7 +1059,7 @@ func (c *RTSPClient) parseResponseCode(line string) (responseCode int, responseS
 }
 
 func (c *RTSPClient) handleSetupResponse(subsession *livemedia.MediaSubsession,
-	sessionParamsStr, transportParamsStr string, streamUsingTCP bool) bool {
+	sessionParamsStr, transportParamsStr string, streamUsingTCP, streamUsingSRTP bool) bool {
 	var success bool
 	for {
 		if sessionParamsStr == "" {
@@ -754,9 +1067,10 @@ func (c *RTSPClient) handleSetupResponse(subsession *livemedia.MediaSubsession,
 			break
 		}
 
-		sessionID := sessionParamsStr
+		sessionID, timeout := c.parseSessionParams(sessionParamsStr)
 		subsession.SetSessionID(sessionID)
 		c.lastSessionID = sessionID
+		c.sessionTimeoutParameter = timeout
 
 		// Parse the "Transport:" header parameters:
 		transportParams, ok := c.parseTransportParams(transportParamsStr)
@@ -771,12 +1085,18 @@ func (c *RTSPClient) handleSetupResponse(subsession *livemedia.MediaSubsession,
 		subsession.SetConnectionEndpointName(transportParams.serverAddressStr)
 
 		if streamUsingTCP {
-			if subsession.RTPSource != nil {
-				subsession.RTPSource.SetStreamSocket()
-			}
+			subsession.SetStreamSocket()
 		} else {
+			if transportParams.rtcpMux {
+				subsession.SetRTCPMux()
+			}
 			destAddress := c.serverAddress
 			subsession.SetDestinations(destAddress)
+			subsession.SendPunchPackets(destAddress)
+		}
+
+		if streamUsingSRTP && !subsession.EnableSRTP() {
+			break
 		}
 
 		success = true
@@ -786,16 +1106,34 @@ func (c *RTSPClient) handleSetupResponse(subsession *livemedia.MediaSubsession,
 	return success
 }
 
+// parseSessionParams splits a "Session:" header, e.g. "47112344;timeout=60",
+// into the session id and the timeout (in seconds) the server will wait between our requests.
+func (c *RTSPClient) parseSessionParams(paramsStr string) (sessionID string, timeout int) {
+	// the default, when the server doesn't tell us (RFC 2326, section 12.37)
+	timeout = 60
+
+	params := strings.Split(paramsStr, ";")
+	sessionID = strings.TrimSpace(params[0])
+	for _, param := range params[1:] {
+		var seconds int
+		if n, _ := fmt.Sscanf(strings.TrimSpace(param), "timeout=%d", &seconds); n == 1 && seconds > 0 {
+			timeout = seconds
+		}
+	}
+	return
+}
+
 type TransportParams struct {
 	serverPortNum    uint
 	rtpChannelID     uint
 	rtcpChannelID    uint
 	serverAddressStr string
+	rtcpMux          bool
 }
 
 func (c *RTSPClient) parseTransportParams(paramsStr string) (*TransportParams, bool) {
 	var serverPortNum, clientPortNum, multicastPortNumRTP, multicastPortNumRTCP uint
-	var foundServerPortNum, foundClientPortNum, foundChannelIDs, foundMulticastPortNum bool
+	var foundServerPortNum, foundClientPortNum, foundChannelIDs, foundMulticastPortNum, foundRTCPMux bool
 	var foundServerAddressStr, foundDestinationStr string
 	var rtpChannelID, rtcpChannelID uint = 0xFF, 0xFF
 	isMulticast := true
@@ -805,6 +1143,8 @@ func (c *RTSPClient) parseTransportParams(paramsStr string) (*TransportParams, b
 	for _, param := range params {
 		if param == "unicast" {
 			isMulticast = false
+		} else if strings.EqualFold(param, "rtcp-mux") {
+			foundRTCPMux = true
 		} else if n, _ = fmt.Sscanf(param, "server_port=%d", &serverPortNum); n == 1 {
 			foundServerPortNum = true
 		} else if n, _ = fmt.Sscanf(param, "client_port=%d", &clientPortNum); n == 1 {
@@ -825,6 +1165,11 @@ func (c *RTSPClient) parseTransportParams(paramsStr string) (*TransportParams, b
 	transportParams := new(TransportParams)
 	transportParams.rtpChannelID = rtpChannelID
 	transportParams.rtcpChannelID = rtcpChannelID
+	transportParams.rtcpMux = foundRTCPMux
+
+	// (an IPv6 address may come in brackets)
+	foundDestinationStr = strings.Trim(foundDestinationStr, "[]")
+	foundServerAddressStr = strings.Trim(foundServerAddressStr, "[]")
 
 	if isMulticast && foundDestinationStr != "" && foundMulticastPortNum {
 		transportParams.serverAddressStr = foundDestinationStr
@@ -833,6 +1178,7 @@ func (c *RTSPClient) parseTransportParams(paramsStr string) (*TransportParams, b
 	}
 
 	if foundChannelIDs || foundServerPortNum || foundClientPortNum {
+		transportParams.serverPortNum = serverPortNum
 		if foundClientPortNum && !foundServerPortNum {
 			transportParams.serverPortNum = clientPortNum
 		}
@@ -867,37 +1213,28 @@ func (c *RTSPClient) handleGetParameterResponse(parameterName string) bool {
 	return true
 }
 
-func (c *RTSPClient) handleAuthenticationFailure(paramsStr string) bool {
-	// There was no "WWW-Authenticate:" header; we can't proceed.
-	if paramsStr == "" {
+func (c *RTSPClient) handleAuthenticationFailure(challenge *auth.Challenge) bool {
+	// There was no (usable) "WWW-Authenticate:" header; we can't proceed.
+	if challenge == nil {
 		return false
 	}
 
-	digest_regex := regexp.MustCompile(`Digest realm="([^"]+)", nonce="([^"]+)"`)
-	basic_regex := regexp.MustCompile(`Basic realm="([^"]+)"`)
-
-	// Fill in "fCurrentAuthenticator" with the information from the "WWW-Authenticate:" header:
-	var matches []string
-	success := true
+	// Fill in "digest" with the information from the "WWW-Authenticate:" header:
 	alreadyHadRealm := c.digest.Realm != ""
-
-	if matches = digest_regex.FindStringSubmatch(paramsStr); len(matches) == 3 {
-		c.digest.Realm = matches[1]
-		c.digest.Nonce = matches[2]
-	} else if matches = basic_regex.FindStringSubmatch(paramsStr); len(matches) == 2 {
-		c.digest.Realm = matches[1]
-		c.digest.RandomNonce()
+	if challenge.Scheme == "Digest" {
+		c.digest.SetChallenge(challenge)
 	} else {
-		success = false // bad "WWW-Authenticate:" header
+		c.digest.Realm = challenge.Realm
+		c.digest.Nonce = "" // (an empty nonce means that we answer with "Basic" credentials)
 	}
 
-	// We already had a 'realm', or don't have a username and/or password,
+	// We don't have a username and/or password, or we already had a 'realm' (unless our nonce just expired),
 	// so the new "WWW-Authenticate:" header information won't help us.  We remain unauthenticated.
-	if alreadyHadRealm || c.digest.Username == "" || c.digest.Password == "" {
-		success = false
+	if (alreadyHadRealm && !challenge.Stale) || c.digest.Username == "" || c.digest.Password == "" {
+		return false
 	}
 
-	return success
+	return true
 }
 
 func (c *RTSPClient) handleIncomingRequest(reqStr string, length int) {
@@ -905,8 +1242,13 @@ func (c *RTSPClient) handleIncomingRequest(reqStr string, length int) {
 	if parseSucceeded {
 		fmt.Printf("Received incoming RTSP request: %s\n", reqStr)
 
+		if requestString.CmdName == "REDIRECT" {
+			c.handleRedirectRequest(reqStr, requestString.Cseq)
+			return
+		}
+
 		buffer := fmt.Sprintf("RTSP/1.0 405 Method Not Allowed\r\nCSeq: %s\r\n\r\n", requestString.Cseq)
-		c.tcpConn.Write([]byte(buffer))
+		c.writeRequest(requestString.CmdName, buffer)
 	}
 }
 
@@ -961,8 +1303,8 @@ func (r *RequestRecord) Handle(rtspClient *RTSPClient, resultCode int, resultStr
 }
 
 type RequestQueue struct {
-	index          int
 	requestRecords []*RequestRecord
+	mutex          sync.Mutex
 }
 
 func newRequestQueue() *RequestQueue {
@@ -971,26 +1313,40 @@ func newRequestQueue() *RequestQueue {
 }
 
 func (q *RequestQueue) enqueue(request *RequestRecord) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
 	q.requestRecords = append(q.requestRecords, request)
 }
 
 func (q *RequestQueue) dequeue() *RequestRecord {
-	if len(q.requestRecords) <= q.index {
-		q.index = 0
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.requestRecords) < 1 {
 		return nil
 	}
 
-	requestRecord := q.requestRecords[q.index]
-	q.index += 1
+	requestRecord := q.requestRecords[0]
+	q.requestRecords = q.requestRecords[1:]
 	return requestRecord
 }
 
+func (q *RequestQueue) clear() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.requestRecords = nil
+}
+
 func (q *RequestQueue) putAtHead(request *RequestRecord) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.requestRecords = append([]*RequestRecord{request}, q.requestRecords...)
 }
 
 func (q *RequestQueue) findByCSeq(cseq uint) {
 }
 
 func (q *RequestQueue) isEmpty() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
 	return len(q.requestRecords) < 1
 }
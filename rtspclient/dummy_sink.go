@@ -11,6 +11,7 @@ type DummySink struct {
 	livemedia.MediaSink
 	streamID      string
 	receiveBuffer []byte
+	frameHandler  interface{}
 	subsession    *livemedia.MediaSubsession
 }
 
@@ -18,10 +19,11 @@ type DummySink struct {
 
 var dummySinkReceiveBufferSize uint = 100000
 
-func NewDummySink(subsession *livemedia.MediaSubsession, streamID string) *DummySink {
+func NewDummySink(subsession *livemedia.MediaSubsession, streamID string, frameHandler interface{}) *DummySink {
 	sink := new(DummySink)
 	sink.streamID = streamID
 	sink.subsession = subsession
+	sink.frameHandler = frameHandler
 	sink.receiveBuffer = make([]byte, dummySinkReceiveBufferSize)
 	sink.InitMediaSink(sink)
 	return sink
@@ -29,7 +31,16 @@ func NewDummySink(subsession *livemedia.MediaSubsession, streamID string) *Dummy
 
 func (s *DummySink) AfterGettingFrame(frameSize, durationInMicroseconds uint,
 	presentationTime sys.Timeval) {
-	//return
+	if client, ok := s.subsession.MiscPtr.(*RTSPClient); ok {
+		client.noteRTPActivity()
+	}
+
+	if s.frameHandler != nil {
+		s.frameHandler.(func(subsession *livemedia.MediaSubsession, frame []byte,
+			presentationTime sys.Timeval))(s.subsession, s.receiveBuffer[:frameSize], presentationTime)
+		return
+	}
+
 	fmt.Printf("Stream \"%s\"; %s/%s:\tReceived %d bytes.\tPresentation Time: %f\n",
 		s.streamID, s.subsession.MediumName(), s.subsession.CodecName(), frameSize,
 		float32(presentationTime.Sec/1000/1000+presentationTime.Usec))
@@ -0,0 +1,83 @@
+package rtspclient
+
+import (
+	"fmt"
+	"time"
+)
+
+// startKeepAlive begins sending "liveness" requests (and RTCP RRs) to the server,
+// often enough that it won't time out our session (see the "timeout=" parameter
+// of the "Session:" header). It's called once PLAY succeeds.
+func (c *RTSPClient) startKeepAlive() {
+	c.keepAliveMutex.Lock()
+	defer c.keepAliveMutex.Unlock()
+
+	if c.keepAliveStop != nil || c.sessionTimeoutParameter <= 0 {
+		return
+	}
+
+	// send our requests at half the timeout, so that a slow or lost one is not fatal
+	interval := time.Duration(c.sessionTimeoutParameter) * time.Second / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	c.keepAliveStop = make(chan struct{})
+	go c.keepAliveLoop(interval, c.keepAliveStop)
+}
+
+// stopKeepAlive stops the liveness requests; it's safe to call more than once.
+func (c *RTSPClient) stopKeepAlive() {
+	c.keepAliveMutex.Lock()
+	defer c.keepAliveMutex.Unlock()
+
+	if c.keepAliveStop != nil {
+		close(c.keepAliveStop)
+		c.keepAliveStop = nil
+	}
+}
+
+func (c *RTSPClient) keepAliveLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.sendLivenessCommand()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sendLivenessCommand sends "GET_PARAMETER" (with no body) if the server supports it,
+// otherwise "OPTIONS", which every server must; and a RTCP RR for each subsession,
+// because some servers watch RTCP rather than the RTSP connection.
+// (It's called from our timer, so it holds the mutex as the handling of the responses does.)
+func (c *RTSPClient) sendLivenessCommand() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	session := c.scs.Session
+	if session == nil {
+		return
+	}
+
+	if c.serverSupportsGetParameter.Load() {
+		c.sendGetParameterCommand(session, continueAfterLIVENESS)
+	} else {
+		c.sendOptionsCommand(continueAfterLIVENESS)
+	}
+
+	session.SendRTCPReports()
+}
+
+func continueAfterLIVENESS(c *RTSPClient, resultCode int, resultStr string) {
+	if resultCode == 405 || resultCode == 501 {
+		// The server advertised "GET_PARAMETER", but doesn't accept it; use "OPTIONS" from now on.
+		c.serverSupportsGetParameter.Store(false)
+	} else if resultCode != 0 {
+		fmt.Println("Liveness command failed:", resultCode, resultStr)
+	}
+}
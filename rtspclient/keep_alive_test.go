@@ -0,0 +1,86 @@
+package rtspclient
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/djwackey/dorsvr/auth"
+	"github.com/djwackey/dorsvr/livemedia"
+)
+
+// (run it with -race)
+func TestKeepAliveWhileResponding(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.listener.Close()
+
+	client := New()
+	if !client.DialRTSP(strings.Replace(server.url(), "rtsp://", "rtsp://alice:secret@", 1)) {
+		t.Fatal("failed to dial")
+	}
+	client.scs.Session, client.lastSessionID = livemedia.NewMediaSession("v=0\r\ns=test\r\nt=0 0\r\n"), "12345678"
+	client.serverSupportsGetParameter.Store(true)
+	client.digest.SetChallenge(&auth.Challenge{Scheme: "Digest", Realm: "dorsvr", Nonce: "nonce0", QOP: "auth"})
+
+	// the server answers every fourth request with a new nonce, which the client sends the request again with,
+	// and the others with a "Content-Base:", which the client takes as its URL
+	const numRequests = 40
+	var counts []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < numRequests*5/4; i++ {
+			r := server.nextRequest(t)
+			authorization := auth.ParseAuthorizationHeader(r.request)
+			if authorization == nil {
+				fmt.Println(r.request)
+				return
+			}
+			counts = append(counts, authorization.Nonce+" "+authorization.NC)
+
+			cseq := headerValue(r.request, "CSeq")
+			if i%4 == 3 {
+				r.conn.Write([]byte(fmt.Sprintf("RTSP/1.0 401 Unauthorized\r\nCSeq: %s\r\n"+
+					"WWW-Authenticate: Digest realm=\"dorsvr\", nonce=\"nonce%d\", stale=true, qop=\"auth\"\r\n\r\n", cseq, i)))
+			} else {
+				r.conn.Write([]byte(fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nContent-Base: %s/\r\n\r\n", cseq, server.url())))
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numRequests/4; j++ {
+				client.sendLivenessCommand()
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+
+	// each nonce counts its requests from 1, without a gap or a repeat
+	next := make(map[string]int)
+	for _, count := range counts {
+		var nonce string
+		var nc int
+		fmt.Sscanf(count, "%s %x", &nonce, &nc)
+		next[nonce]++
+		if nc != next[nonce] {
+			fmt.Println(counts)
+			t.Error("failed")
+			return
+		}
+	}
+	if len(counts) != numRequests*5/4 {
+		fmt.Println(counts)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
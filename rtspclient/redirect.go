@@ -0,0 +1,163 @@
+package rtspclient
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// default value; you can change it for a client with SetMaxRedirects
+const defaultMaxRedirects = 10
+
+// SetMaxRedirects sets how many redirects in a row (3xx responses with a
+// "Location:" header, or REDIRECT requests from the server) we'll follow
+// before giving up. Zero means that redirects aren't followed at all.
+func (c *RTSPClient) SetMaxRedirects(maxRedirects int) {
+	c.maxRedirects = maxRedirects
+}
+
+// noteRedirect checks the hop limit before we follow a redirect to "location".
+func (c *RTSPClient) noteRedirect(location string) bool {
+	if c.redirectCount >= c.maxRedirects {
+		fmt.Printf("Too many redirects (%d); not following the one to \"%s\"\n", c.redirectCount, location)
+		return false
+	}
+
+	c.redirectCount++
+	fmt.Printf("Redirected to \"%s\"\n", location)
+	return true
+}
+
+// handleRedirect connects to the server named by the "Location:" header of a 3xx response,
+// so that the request that got it can be sent again, to the new URL.
+func (c *RTSPClient) handleRedirect(location string) bool {
+	if !c.noteRedirect(location) {
+		return false
+	}
+	return c.switchServer(location)
+}
+
+// switchServer replaces our connection to the current server with one to
+// the server of "url", which becomes our new base URL. The credentials stay
+// the same (unless the new URL has its own), but we'll need a new challenge,
+// and we only tunnel over HTTP if the new URL is a "http://" one.
+// (It's called with our mutex held, see incomingDataHandler.)
+func (c *RTSPClient) switchServer(url string) bool {
+	if !c.isAbsoluteURL(url) {
+		fmt.Println("Can't follow a redirect to a relative URL:", url)
+		return false
+	}
+
+	oldConn, oldOutputConn := c.tcpConn, c.outputConn
+
+	c.baseURL = url
+	c.outputConn = nil
+	c.tunnelOverHTTPPortNum = 0
+	c.digest.Realm, c.digest.Nonce = "", ""
+
+	// the old server won't answer any of our outstanding requests now
+	c.requestsAwaitingResponse.clear()
+	c.tunnelMutex.Lock()
+	c.httpTunnelingEstablished = false
+	c.requestsAwaitingHTTPTunneling.clear()
+	c.tunnelMutex.Unlock()
+
+	// (our reader notices that "tcpConn" changed, and carries on with the new connection)
+	result := c.openConnection()
+
+	oldConn.Close()
+	if oldOutputConn != nil {
+		oldOutputConn.Close()
+	}
+	return result
+}
+
+// handleRedirectRequest handles a server's "REDIRECT" request (RFC 2326, section 10.10):
+// we acknowledge it, then at the time given by its "Range:" header (or right away)
+// we tear down our session and set it up again at its "Location:".
+func (c *RTSPClient) handleRedirectRequest(reqStr, cseq string) {
+	var location, rangeStr string
+
+	nextLineStart, thisLineStart := getLine(reqStr)
+	for {
+		nextLineStart, thisLineStart = getLine(nextLineStart)
+		if thisLineStart == "" {
+			break
+		}
+
+		if headerParamsStr, result := c.checkForHeader(thisLineStart, "Location:", 9); result {
+			location = headerParamsStr
+		} else if headerParamsStr, result := c.checkForHeader(thisLineStart, "Range:", 6); result {
+			rangeStr = headerParamsStr
+		}
+	}
+
+	if location == "" {
+		buffer := fmt.Sprintf("RTSP/1.0 400 Bad Request\r\nCSeq: %s\r\n\r\n", cseq)
+		c.writeRequest("REDIRECT", buffer)
+		return
+	}
+
+	buffer := fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\n\r\n", cseq)
+	c.writeRequest("REDIRECT", buffer)
+
+	delay := c.parseRedirectRange(rangeStr)
+	if delay <= 0 {
+		c.followServerRedirect(location)
+		return
+	}
+
+	fmt.Printf("Will follow the redirect to \"%s\" in %v\n", location, delay)
+	time.AfterFunc(delay, func() {
+		// (our reader is blocked on the old connection meanwhile, or waits for us to be done)
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.followServerRedirect(location)
+	})
+}
+
+// parseRedirectRange returns how long to wait before following a REDIRECT, from
+// its "Range:" header, e.g. "clock=19960213T143205Z-". Any other kind of range
+// (or none) means now.
+func (c *RTSPClient) parseRedirectRange(rangeStr string) time.Duration {
+	if !strings.HasPrefix(rangeStr, "clock=") {
+		return 0
+	}
+
+	clockStr := rangeStr[6:]
+	if index := strings.Index(clockStr, "-"); index != -1 {
+		clockStr = clockStr[:index]
+	}
+
+	redirectTime, err := time.Parse("20060102T150405Z", clockStr)
+	if err != nil {
+		fmt.Println("Bad \"Range:\" header in REDIRECT:", rangeStr)
+		return 0
+	}
+	return time.Until(redirectTime)
+}
+
+// followServerRedirect moves our session to the server at "location",
+// going through DESCRIBE, SETUP and PLAY again there.
+func (c *RTSPClient) followServerRedirect(location string) {
+	if !c.noteRedirect(location) {
+		c.notifyStreamState(false, errors.New("too many redirects"))
+		return
+	}
+
+	if c.scs.Session != nil {
+		c.sendTeardownCommand(c.scs.Session, nil)
+		c.scs.Session.Close()
+	}
+	c.scs = newStreamClientState()
+	c.lastSessionID = ""
+	c.tcpStreamIDCount = 0
+
+	if !c.switchServer(location) {
+		c.notifyStreamState(false, errors.New("failed to follow the redirect to "+location))
+		return
+	}
+
+	c.sendDescribeCommand(continueAfterDESCRIBE)
+}
@@ -0,0 +1,156 @@
+package rtspclient
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRequest is a request that a fakeServer received, on conn
+type fakeRequest struct {
+	conn    net.Conn
+	request string
+}
+
+// fakeServer passes on every request it receives, for the test to answer.
+type fakeServer struct {
+	listener net.Listener
+	requests chan fakeRequest
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeServer{listener: listener, requests: make(chan fakeRequest, 16)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	var request string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		request += line
+		if line == "\r\n" {
+			s.requests <- fakeRequest{conn, request}
+			request = ""
+		}
+	}
+}
+
+func (s *fakeServer) url() string {
+	return fmt.Sprintf("rtsp://%s/test", s.listener.Addr())
+}
+
+func (s *fakeServer) nextRequest(t *testing.T) fakeRequest {
+	select {
+	case r := <-s.requests:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request")
+	}
+	return fakeRequest{}
+}
+
+func TestRedirect(t *testing.T) {
+	oldServer, newServer := newFakeServer(t), newFakeServer(t)
+	defer oldServer.listener.Close()
+	defer newServer.listener.Close()
+
+	// the old server is reached through a HTTP tunnel, but the new one isn't
+	oldURL := strings.Replace(oldServer.url(), "rtsp://", "http://", 1)
+	client := New()
+	if !client.DialRTSP(oldURL) {
+		t.Fatal("failed to dial")
+	}
+	results := make(chan string, 4)
+	cseq := client.sendOptionsCommand(func(rtspClient *RTSPClient, resultCode int, resultStr string) {
+		results <- fmt.Sprintf("%d %s", resultCode, resultStr)
+	})
+
+	r := oldServer.nextRequest(t)
+	if !strings.HasPrefix(r.request, "GET /test HTTP/1.1\r\n") {
+		fmt.Println(r.request)
+		t.Error("failed")
+		return
+	}
+	getConn := r.conn
+	getConn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: application/x-rtsp-tunnelled\r\n\r\n"))
+	if r = oldServer.nextRequest(t); !strings.HasPrefix(r.request, "POST /test HTTP/1.1\r\n") {
+		fmt.Println(r.request)
+		t.Error("failed")
+		return
+	}
+
+	// (we answer the tunneled OPTIONS without reading it)
+	getConn.Write([]byte(fmt.Sprintf("RTSP/1.0 301 Moved Permanently\r\nCSeq: %d\r\nLocation: %s\r\n\r\n",
+		cseq, newServer.url())))
+
+	r = newServer.nextRequest(t)
+	if !strings.HasPrefix(r.request, "OPTIONS "+newServer.url()+" RTSP/1.0\r\n") ||
+		headerValue(r.request, "CSeq") != fmt.Sprint(cseq) {
+		fmt.Println(r.request)
+		t.Error("failed")
+		return
+	}
+	r.conn.Write([]byte(fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %d\r\nPublic: OPTIONS, DESCRIBE\r\n\r\n", cseq)))
+
+	select {
+	case result := <-results:
+		if result != "0 OPTIONS, DESCRIBE" {
+			fmt.Println(result)
+			t.Error("failed")
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestRedirectRequest(t *testing.T) {
+	oldServer, newServer := newFakeServer(t), newFakeServer(t)
+	defer oldServer.listener.Close()
+	defer newServer.listener.Close()
+
+	client := New()
+	if !client.DialRTSP(oldServer.url()) {
+		t.Fatal("failed to dial")
+	}
+	client.sendOptionsCommand(nil)
+	r := oldServer.nextRequest(t)
+
+	// the server moves us to the new server in a second or so, (which we follow from a timer)
+	at := time.Now().UTC().Add(time.Second).Format("20060102T150405Z")
+	r.conn.Write([]byte(fmt.Sprintf("REDIRECT %s RTSP/1.0\r\nCSeq: 1\r\nLocation: %s\r\nRange: clock=%s-\r\n\r\n",
+		oldServer.url(), newServer.url(), at)))
+
+	r = newServer.nextRequest(t)
+	if !strings.HasPrefix(r.request, "DESCRIBE "+newServer.url()+" RTSP/1.0\r\n") {
+		fmt.Println(r.request)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -0,0 +1,14 @@
+package rtspclient
+
+import (
+	"time"
+
+	"github.com/djwackey/dorsvr/auth"
+)
+
+// SignURL returns the RTSP URL with a token which lets a client read the stream until expires,
+// from a server using the same key (see RTSPServer.SetTokenSigner).
+// With a non-empty clientIP, only the client with that address can use the URL.
+func SignURL(rtspURL string, key []byte, clientIP string, expires time.Time) (string, error) {
+	return auth.NewTokenSigner(key).SignURL(rtspURL, clientIP, expires)
+}
@@ -0,0 +1,249 @@
+package rtspclient
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SupervisorState is the state of a supervised RTSP session.
+type SupervisorState int
+
+const (
+	// StateConnecting means we're running DIALRTSP, DESCRIBE, SETUP and PLAY.
+	StateConnecting SupervisorState = iota
+	// StatePlaying means the server has accepted PLAY and media is flowing.
+	StatePlaying
+	// StateReconnecting means the session was lost, and we're waiting before retrying.
+	StateReconnecting
+	// StateStopped means Stop was called; the supervisor won't do anything more.
+	StateStopped
+)
+
+func (s SupervisorState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StatePlaying:
+		return "playing"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("SupervisorState(%d)", int(s))
+}
+
+// default values; you can reassign these on a Supervisor before calling Start
+const (
+	defaultMinBackoff           = time.Second
+	defaultMaxBackoff           = 30 * time.Second
+	defaultRTPInactivityTimeout = 10 * time.Second
+	defaultSetupTimeout         = 10 * time.Second
+)
+
+// Supervisor keeps a RTSP session playing: whenever the control connection dies,
+// the server ends the stream, or no media arrives for RTPInactivityTimeout, it
+// tears the session down and runs DIALRTSP -> DESCRIBE -> SETUP -> PLAY again,
+// waiting an exponentially growing (and jittered) time between attempts.
+// An attempt that isn't playing within SetupTimeout (e.g. the server doesn't answer) fails too,
+// unless it's zero.
+// The frame handler stays attached to every new session.
+type Supervisor struct {
+	MinBackoff           time.Duration
+	MaxBackoff           time.Duration
+	RTPInactivityTimeout time.Duration
+	SetupTimeout         time.Duration
+
+	rtspURL            string
+	client             *RTSPClient
+	state              SupervisorState
+	frameHandler       interface{}
+	stateHandler       interface{}
+	clientSetupHandler interface{}
+	stop               chan struct{}
+	stopOnce           sync.Once
+	wg                 sync.WaitGroup
+	mutex              sync.Mutex
+}
+
+// NewSupervisor returns a supervisor for the stream at rtspURL; call Start to begin playing it.
+func NewSupervisor(rtspURL string) *Supervisor {
+	return &Supervisor{
+		MinBackoff:           defaultMinBackoff,
+		MaxBackoff:           defaultMaxBackoff,
+		RTPInactivityTimeout: defaultRTPInactivityTimeout,
+		SetupTimeout:         defaultSetupTimeout,
+		rtspURL:              rtspURL,
+		state:                StateStopped,
+		stop:                 make(chan struct{}),
+	}
+}
+
+// SetFrameHandler sets the function that receives the frames of every session we open
+// (see RTSPClient.SetFrameHandler).
+func (s *Supervisor) SetFrameHandler(handler interface{}) {
+	s.frameHandler = handler
+}
+
+// SetStateHandler sets the function that's told about each state transition:
+//
+//	func(state SupervisorState, err error)
+//
+// err is the reason for leaving the previous state, if it was a failure.
+func (s *Supervisor) SetStateHandler(handler interface{}) {
+	s.stateHandler = handler
+}
+
+// SetClientSetupHandler sets a function that's called with each new RTSPClient
+// before it dials, so that it can be configured (e.g. SetupTunnelingOverHTTP):
+//
+//	func(client *RTSPClient)
+func (s *Supervisor) SetClientSetupHandler(handler interface{}) {
+	s.clientSetupHandler = handler
+}
+
+// State returns the current state of the supervised session.
+func (s *Supervisor) State() SupervisorState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+// Start begins playing the stream, in the background.
+func (s *Supervisor) Start() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Stop tears down the current session (if any) and stops reconnecting.
+func (s *Supervisor) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+	s.setState(StateStopped, nil)
+}
+
+func (s *Supervisor) run() {
+	defer s.wg.Done()
+
+	backoff := s.MinBackoff
+	for {
+		s.setState(StateConnecting, nil)
+
+		err := s.playOnce(&backoff)
+		if err == nil {
+			return // we were stopped
+		}
+
+		s.setState(StateReconnecting, err)
+
+		select {
+		case <-time.After(s.jitter(backoff)):
+		case <-s.stop:
+			return
+		}
+
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// playOnce opens one session and plays it until it fails (returning why),
+// or until we're stopped (returning nil). Once the session is playing, the
+// backoff is reset, so that the next failure is retried quickly again.
+func (s *Supervisor) playOnce(backoff *time.Duration) error {
+	events := make(chan error, 8)
+
+	client := New()
+	client.SetFrameHandler(s.frameHandler)
+	client.streamStateFunc = func(playing bool, err error) {
+		if playing {
+			err = nil
+		} else if err == nil {
+			err = errors.New("the session has ended")
+		}
+		select {
+		case events <- err:
+		default:
+		}
+	}
+	if s.clientSetupHandler != nil {
+		s.clientSetupHandler.(func(client *RTSPClient))(client)
+	}
+	defer client.shutdown()
+
+	if !client.DialRTSP(s.rtspURL) {
+		return errors.New("failed to connect to " + s.rtspURL)
+	}
+	if !client.SendRequest() {
+		return errors.New("failed to send DESCRIBE")
+	}
+
+	s.mutex.Lock()
+	s.client = client
+	s.mutex.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// (it's off once we're playing)
+	var setupDeadline <-chan time.Time
+	if s.SetupTimeout > 0 {
+		setupTimer := time.NewTimer(s.SetupTimeout)
+		defer setupTimer.Stop()
+		setupDeadline = setupTimer.C
+	}
+
+	var playing bool
+	for {
+		select {
+		case err := <-events:
+			if err != nil {
+				return err
+			}
+			if !playing {
+				playing = true
+				setupDeadline = nil
+				*backoff = s.MinBackoff
+				s.setState(StatePlaying, nil)
+			}
+		case <-setupDeadline:
+			return fmt.Errorf("the session wasn't set up within %v", s.SetupTimeout)
+		case <-ticker.C:
+			if playing && client.timeSinceRTPActivity() > s.RTPInactivityTimeout {
+				return fmt.Errorf("no media received for %v", s.RTPInactivityTimeout)
+			}
+		case <-s.stop:
+			if playing {
+				client.Close()
+			}
+			return nil
+		}
+	}
+}
+
+// jitter spreads retries of many clients (e.g. after a camera reboots) over [d/2, d).
+func (s *Supervisor) jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+func (s *Supervisor) setState(state SupervisorState, err error) {
+	s.mutex.Lock()
+	changed := s.state != state
+	s.state = state
+	s.mutex.Unlock()
+
+	if changed && s.stateHandler != nil {
+		s.stateHandler.(func(state SupervisorState, err error))(state, err)
+	}
+}
@@ -0,0 +1,136 @@
+package rtspclient
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// silentServer accepts connections, and never answers on them.
+type silentServer struct {
+	listener net.Listener
+	conns    chan net.Conn
+}
+
+func newSilentServer(t *testing.T) *silentServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &silentServer{listener: listener, conns: make(chan net.Conn, 16)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+		}
+	}()
+	return s
+}
+
+func (s *silentServer) close() {
+	s.listener.Close()
+	for {
+		select {
+		case conn := <-s.conns:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+type stateChange struct {
+	state SupervisorState
+	err   error
+}
+
+func TestSupervisorSetupTimeout(t *testing.T) {
+	server := newSilentServer(t)
+	defer server.close()
+
+	changes := make(chan stateChange, 64)
+	supervisor := NewSupervisor(fmt.Sprintf("rtsp://%s/test", server.listener.Addr()))
+	supervisor.MinBackoff, supervisor.MaxBackoff = 50*time.Millisecond, 100*time.Millisecond
+	supervisor.SetupTimeout = 300 * time.Millisecond
+	supervisor.SetStateHandler(func(state SupervisorState, err error) {
+		changes <- stateChange{state, err}
+	})
+	supervisor.Start()
+
+	// the server never answers DESCRIBE, so the attempt fails, and the supervisor connects again
+	var failed bool
+	deadline := time.After(5 * time.Second)
+	for !failed {
+		select {
+		case change := <-changes:
+			if change.state == StatePlaying {
+				t.Error("failed")
+				return
+			}
+			if change.state == StateReconnecting {
+				if change.err == nil || !strings.Contains(change.err.Error(), "wasn't set up") {
+					fmt.Println(change.err)
+					t.Error("failed")
+					return
+				}
+				failed = true
+			}
+		case <-deadline:
+			t.Error("failed")
+			return
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case conn := <-server.conns:
+			defer conn.Close()
+		case <-deadline:
+			t.Error("failed")
+			return
+		}
+	}
+
+	supervisor.Stop()
+	if supervisor.State() != StateStopped {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestSupervisorStop(t *testing.T) {
+	server := newSilentServer(t)
+	defer server.close()
+
+	// (it's fine to stop a supervisor that was never started)
+	NewSupervisor("rtsp://127.0.0.1/test").Stop()
+
+	supervisor := NewSupervisor(fmt.Sprintf("rtsp://%s/test", server.listener.Addr()))
+	supervisor.Start()
+
+	// it can be stopped more than once, and from several goroutines at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			supervisor.Stop()
+		}()
+	}
+	wg.Wait()
+	supervisor.Stop()
+
+	if supervisor.State() != StateStopped {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
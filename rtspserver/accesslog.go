@@ -0,0 +1,101 @@
+package rtspserver
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djwackey/dorsvr/logging"
+)
+
+var (
+	rtspLog = logging.Logger(logging.RTSP)
+	authLog = logging.Logger(logging.Auth)
+)
+
+// requestURL returns the URL of the request line, without its query (e.g. the token of a signed URL)
+func requestURL(request string) string {
+	line := request
+	if i := strings.IndexAny(request, "\r\n"); i >= 0 {
+		line = request[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return ""
+	}
+	url := fields[1]
+	if i := strings.IndexByte(url, '?'); i >= 0 {
+		url = url[:i]
+	}
+	return url
+}
+
+// responseStatus returns the status code of a response (e.g. "RTSP/1.0 200 OK\r\n..."), or 0
+func responseStatus(response string) int {
+	fields := strings.Fields(response)
+	if len(fields) < 2 {
+		return 0
+	}
+	status, _ := strconv.Atoi(fields[1])
+	return status
+}
+
+// accessLogEntry is a request, written to the access log once it's answered
+type accessLogEntry struct {
+	id        uint64
+	method    string
+	url       string
+	cseq      string
+	sessionID string
+	start     time.Time
+}
+
+func (c *RTSPClientConnection) logRequest(entry *accessLogEntry) {
+	status := responseStatus(c.responseBuffer)
+	level := slog.LevelInfo
+	if status == 0 || status >= 500 {
+		level = slog.LevelWarn
+	}
+	rtspLog.LogAttrs(context.Background(), level, "request",
+		slog.Uint64("request_id", entry.id),
+		slog.String("method", entry.method),
+		slog.String("url", entry.url),
+		slog.String("cseq", entry.cseq),
+		slog.String("session_id", entry.sessionID),
+		slog.Int("status", status),
+		slog.String("remote_addr", net.JoinHostPort(c.remoteAddr, c.remotePort)),
+		slog.String("user", c.user()),
+		slog.Duration("duration", time.Since(entry.start)))
+}
+
+// logSummary writes the summary of a client session, (of its state as it ends) when it ends for the reason
+// ("teardown", "timeout", "disconnect", "kicked" or "shutdown")
+func (s *RTSPClientSession) logSummary(state sessionState, reason string) {
+	key, streamState := state.key, state.streamState
+	attrs := []slog.Attr{
+		slog.String("session_id", s.sessionID),
+		slog.String("stream", key.streamName),
+		slog.String("track", key.trackID),
+		slog.String("remote_addr", net.JoinHostPort(s.connection.remoteAddr, s.connection.remotePort)),
+		slog.String("user", s.connection.user()),
+		slog.String("transport", state.transport),
+		slog.String("reason", reason),
+		slog.Duration("duration", time.Since(s.startTime)),
+	}
+	if streamState != nil {
+		packets, octets := streamState.SentCounts()
+		attrs = append(attrs, slog.Uint64("packets_sent", uint64(packets)), slog.Uint64("bytes_sent", uint64(octets)))
+		// the quality of the stream, as last reported by the client
+		if report, ok := s.receiverReport(streamState); ok {
+			attrs = append(attrs,
+				slog.Float64("fraction_lost", report.FractionLost),
+				slog.Int64("packets_lost", int64(report.PacketsLost)),
+				slog.Duration("jitter", report.Jitter),
+				slog.Duration("rtt", report.RTT))
+		}
+	}
+	rtspLog.LogAttrs(context.Background(), slog.LevelInfo, "session", attrs...)
+}
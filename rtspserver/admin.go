@@ -0,0 +1,468 @@
+package rtspserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/djwackey/dorsvr/livemedia"
+	lg "github.com/djwackey/gitea/log"
+)
+
+var (
+	errStreamNotFound  = errors.New("stream not found")
+	errSessionNotFound = errors.New("session not found")
+	errUnsupportedFile = errors.New("unsupported media file, (only .264 and .ts)")
+)
+
+// StreamInfo is a stream of the server, either registered or requested by a client at least once
+type StreamInfo struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	File        string           `json:"file,omitempty"`
+	Registered  bool             `json:"registered"`
+	Subsessions []SubsessionInfo `json:"subsessions"`
+	Viewers     int              `json:"viewers"`
+}
+
+// SubsessionInfo is a track of a stream
+type SubsessionInfo struct {
+	TrackID string `json:"track_id"`
+	Codec   string `json:"codec"`
+}
+
+// SessionInfo is a client session
+type SessionInfo struct {
+	ID          string    `json:"id"`
+	Stream      string    `json:"stream"`
+	TrackID     string    `json:"track_id,omitempty"`
+	ClientAddr  string    `json:"client_addr"`
+	Transport   string    `json:"transport,omitempty"`
+	StartTime   time.Time `json:"start_time"`
+	PacketsSent uint      `json:"packets_sent"`
+	BytesSent   uint      `json:"bytes_sent"`
+	// from the last RTCP receiver report of the client, if any
+	RTCP *RTCPInfo `json:"rtcp,omitempty"`
+}
+
+// RTCPInfo is the last RTCP receiver report of a client
+type RTCPInfo struct {
+	SSRC          uint32    `json:"ssrc"`
+	FractionLost  float64   `json:"fraction_lost"`
+	PacketsLost   uint32    `json:"packets_lost"`
+	JitterSeconds float64   `json:"jitter_seconds"`
+	RTTSeconds    float64   `json:"rtt_seconds,omitempty"`
+	ReceivedAt    time.Time `json:"received_at"`
+	// from the last RTCP XR of the client, if it sends them
+	XR *XRInfo `json:"xr,omitempty"`
+}
+
+// XRInfo is the last RTCP XR of a client, (of the packets since its previous one)
+type XRInfo struct {
+	LostPackets       uint32    `json:"lost_packets"`
+	DuplicatePackets  uint32    `json:"duplicate_packets"`
+	MeanJitterSeconds float64   `json:"mean_jitter_seconds"`
+	MaxJitterSeconds  float64   `json:"max_jitter_seconds"`
+	LossRate          float64   `json:"loss_rate"`
+	DiscardRate       float64   `json:"discard_rate"`
+	RTTSeconds        float64   `json:"rtt_seconds,omitempty"`
+	ReceivedAt        time.Time `json:"received_at"`
+}
+
+// QualityInfo is the quality of the stream of a client, as of one of its RTCP receiver reports
+type QualityInfo struct {
+	Time          time.Time `json:"time"`
+	FractionLost  float64   `json:"fraction_lost"`
+	JitterSeconds float64   `json:"jitter_seconds"`
+	RTTSeconds    float64   `json:"rtt_seconds,omitempty"`
+}
+
+// ConnectionInfo is a RTSP (or RTSPS) connection
+type ConnectionInfo struct {
+	ClientAddr  string    `json:"client_addr"`
+	LocalAddr   string    `json:"local_addr"`
+	Protocol    string    `json:"protocol"`
+	SessionID   string    `json:"session_id,omitempty"`
+	ConnectTime time.Time `json:"connect_time"`
+}
+
+// Streams returns the streams of the server, sorted by name
+func (s *RTSPServer) Streams() []StreamInfo {
+	viewers := make(map[string]int)
+	for _, clientSession := range s.clientSessionList() {
+		if key, _ := clientSession.streamTrack(); key.streamName != "" {
+			viewers[key.streamName]++
+		}
+	}
+
+	s.smsMutex.Lock()
+	streams := make(map[string]*StreamInfo)
+	for name, sms := range s.serverMediaSessions {
+		stream := &StreamInfo{Name: name, Description: sms.Description()}
+		for i := 0; i < sms.SubsessionCounter; i++ {
+			subsession := sms.Subsessions[i]
+			stream.Subsessions = append(stream.Subsessions, SubsessionInfo{
+				TrackID: subsession.TrackID(),
+				Codec:   subsession.Codec(),
+			})
+		}
+		streams[name] = stream
+	}
+	for name, fileName := range s.registeredStreams {
+		stream, existed := streams[name]
+		if !existed {
+			stream = &StreamInfo{Name: name}
+			streams[name] = stream
+		}
+		stream.File, stream.Registered = fileName, true
+	}
+	s.smsMutex.Unlock()
+
+	// (the streams of the sessions are kept while they're watched)
+	for name := range viewers {
+		if _, existed := streams[name]; !existed {
+			streams[name] = &StreamInfo{Name: name}
+		}
+	}
+
+	list := make([]StreamInfo, 0, len(streams))
+	for name, stream := range streams {
+		stream.Viewers = viewers[name]
+		if stream.Subsessions == nil {
+			stream.Subsessions = []SubsessionInfo{}
+		}
+		list = append(list, *stream)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Sessions returns the client sessions, by start time
+func (s *RTSPServer) Sessions() []SessionInfo {
+	clientSessions := s.clientSessionList()
+	list := make([]SessionInfo, 0, len(clientSessions))
+	for _, clientSession := range clientSessions {
+		state := clientSession.state()
+		c := clientSession.connection
+		session := SessionInfo{
+			ID:         clientSession.sessionID,
+			Stream:     state.key.streamName,
+			TrackID:    state.key.trackID,
+			ClientAddr: net.JoinHostPort(c.remoteAddr, c.remotePort),
+			Transport:  state.transport,
+			StartTime:  clientSession.startTime,
+		}
+		if streamState := state.streamState; streamState != nil {
+			session.PacketsSent, session.BytesSent = streamState.SentCounts()
+			if report, ok := clientSession.receiverReport(streamState); ok {
+				session.RTCP = newRTCPInfo(report)
+			}
+		}
+		list = append(list, session)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartTime.Before(list[j].StartTime) })
+	return list
+}
+
+func newRTCPInfo(report livemedia.ReceiverReport) *RTCPInfo {
+	info := &RTCPInfo{
+		SSRC:          report.SSRC,
+		FractionLost:  report.FractionLost,
+		PacketsLost:   report.PacketsLost,
+		JitterSeconds: report.Jitter.Seconds(),
+		RTTSeconds:    report.RTT.Seconds(),
+		ReceivedAt:    report.ReceivedAt,
+	}
+	if xr := report.XR; xr != nil {
+		info.XR = &XRInfo{
+			LostPackets:       xr.LostPackets,
+			DuplicatePackets:  xr.DuplicatePackets,
+			MeanJitterSeconds: xr.MeanJitter.Seconds(),
+			MaxJitterSeconds:  xr.MaxJitter.Seconds(),
+			LossRate:          xr.LossRate,
+			DiscardRate:       xr.DiscardRate,
+			RTTSeconds:        xr.RoundTripDelay.Seconds(),
+			ReceivedAt:        xr.ReceivedAt,
+		}
+	}
+	return info
+}
+
+// SessionQuality returns the quality of the stream of a client session, as of its last
+// RTCP receiver reports (the oldest first), to tell how it has been lately
+func (s *RTSPServer) SessionQuality(sessionID string) ([]QualityInfo, error) {
+	clientSession, existed := s.getClientSession(sessionID)
+	if !existed {
+		return nil, errSessionNotFound
+	}
+
+	list := []QualityInfo{}
+	_, streamState := clientSession.streamTrack()
+	if streamState == nil {
+		return list, nil
+	}
+	report, ok := clientSession.receiverReport(streamState)
+	if !ok {
+		return list, nil
+	}
+	for _, sample := range streamState.QualityHistory(report.SSRC) {
+		list = append(list, QualityInfo{
+			Time:          sample.Time,
+			FractionLost:  sample.FractionLost,
+			JitterSeconds: sample.Jitter.Seconds(),
+			RTTSeconds:    sample.RTT.Seconds(),
+		})
+	}
+	return list, nil
+}
+
+// Connections returns the RTSP connections, by connect time
+func (s *RTSPServer) Connections() []ConnectionInfo {
+	s.rtspConnectionMutex.Lock()
+	list := make([]ConnectionInfo, 0, len(s.rtspConnections))
+	for c := range s.rtspConnections {
+		connection := ConnectionInfo{
+			ClientAddr:  net.JoinHostPort(c.remoteAddr, c.remotePort),
+			LocalAddr:   net.JoinHostPort(c.localAddr, c.localPort),
+			Protocol:    "rtsp",
+			ConnectTime: c.connectTime,
+		}
+		if c.isSecure {
+			connection.Protocol = "rtsps"
+		}
+		if clientSession := c.session(); clientSession != nil {
+			connection.SessionID = clientSession.sessionID
+		}
+		list = append(list, connection)
+	}
+	s.rtspConnectionMutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ConnectTime.Before(list[j].ConnectTime) })
+	return list
+}
+
+// KickSession tears down a client session, (which sends a RTCP BYE) and closes its connection
+func (s *RTSPServer) KickSession(sessionID string) error {
+	clientSession, existed := s.getClientSession(sessionID)
+	if !existed {
+		return errSessionNotFound
+	}
+
+	lg.Info("kicked the session %s of %s", sessionID, clientSession.connection.remoteAddr)
+	clientSession.destroy("kicked")
+	clientSession.connection.destroy()
+	return nil
+}
+
+// RegisterStream makes a media file (.264 or .ts) available as a stream, whatever its name,
+// e.g. "live/cam1" for "/var/media/cameras/cam1.264"; it replaces a previous registration of the name
+func (s *RTSPServer) RegisterStream(streamName, fileName string) error {
+	streamName = strings.Trim(streamName, "/")
+	if streamName == "" {
+		return errors.New("empty stream name")
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".264", ".ts":
+	default:
+		return errUnsupportedFile
+	}
+	if _, err := os.Stat(fileName); err != nil {
+		return err
+	}
+
+	s.smsMutex.Lock()
+	s.registeredStreams[streamName] = fileName
+	// (its next client gets the new file)
+	delete(s.serverMediaSessions, streamName)
+	s.smsMutex.Unlock()
+
+	lg.Info("registered the stream \"%s\" (%s)", streamName, fileName)
+	return nil
+}
+
+// UnregisterStream forgets the registration of a stream; its clients keep watching it
+func (s *RTSPServer) UnregisterStream(streamName string) error {
+	streamName = strings.Trim(streamName, "/")
+
+	s.smsMutex.Lock()
+	defer s.smsMutex.Unlock()
+	if _, registered := s.registeredStreams[streamName]; !registered {
+		return errStreamNotFound
+	}
+	delete(s.registeredStreams, streamName)
+	delete(s.serverMediaSessions, streamName)
+	return nil
+}
+
+// RemoveStream kicks the clients of a stream (see KickSession), and forgets it, with its registration if any
+func (s *RTSPServer) RemoveStream(streamName string) error {
+	streamName = strings.Trim(streamName, "/")
+
+	s.smsMutex.Lock()
+	_, registered := s.registeredStreams[streamName]
+	_, existed := s.serverMediaSessions[streamName]
+	delete(s.registeredStreams, streamName)
+	delete(s.serverMediaSessions, streamName)
+	s.smsMutex.Unlock()
+
+	for _, clientSession := range s.clientSessionList() {
+		if key, _ := clientSession.streamTrack(); key.streamName == streamName {
+			s.KickSession(clientSession.sessionID)
+			existed = true
+		}
+	}
+
+	if !registered && !existed {
+		return errStreamNotFound
+	}
+	lg.Info("removed the stream \"%s\"", streamName)
+	return nil
+}
+
+// startAdmin serves the admin API on the admin address, if any, see adminHandler
+func (s *RTSPServer) startAdmin() {
+	options := s.currentOptions()
+	if options.adminAddr == "" {
+		return
+	}
+	if options.adminUsername == "" || options.adminPassword == "" {
+		lg.Error(0, "the admin API needs credentials, it's disabled.")
+		return
+	}
+
+	s.adminServer = s.startHTTPServer(options.adminAddr, s.adminHandler(), "the admin API")
+}
+
+// adminHandler returns the handler of the admin API, with "Basic" authentication:
+//
+//	GET    /streams                the streams
+//	POST   /streams                registers a stream: {"name": "live/cam1", "file": "/var/media/cam1.264"}
+//	DELETE /streams/{name}         removes a stream, kicking its clients
+//	                               (with "?keep_viewers=true", only unregisters it)
+//	GET    /sessions               the client sessions
+//	DELETE /sessions/{id}          kicks a client session
+//	GET    /sessions/{id}/quality  the quality of the stream of a client session, as of its last reports
+//	GET    /connections            the connections
+func (s *RTSPServer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/streams", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.Streams())
+		case http.MethodPost:
+			var request struct {
+				Name string `json:"name"`
+				File string `json:"file"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if err := s.RegisterStream(request.Name, request.File); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, request)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, nil)
+		}
+	})
+	mux.HandleFunc("/streams/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+		streamName := strings.TrimPrefix(r.URL.Path, "/streams/")
+		remove := s.RemoveStream
+		if r.URL.Query().Get("keep_viewers") == "true" {
+			remove = s.UnregisterStream
+		}
+		if err := remove(streamName); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Sessions())
+	})
+	mux.HandleFunc("/sessions/", func(w http.ResponseWriter, r *http.Request) {
+		sessionID := strings.TrimPrefix(r.URL.Path, "/sessions/")
+		if sessionID, isQuality := strings.CutSuffix(sessionID, "/quality"); isQuality {
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusMethodNotAllowed, nil)
+				return
+			}
+			quality, err := s.SessionQuality(sessionID)
+			if err != nil {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, quality)
+			return
+		}
+
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+		if err := s.KickSession(sessionID); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/connections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Connections())
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.adminAuthenticated(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dorsvr admin"`)
+			writeError(w, http.StatusUnauthorized, nil)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *RTSPServer) adminAuthenticated(r *http.Request) bool {
+	options := s.currentOptions()
+	username, password, ok := r.BasicAuth()
+	if !ok || options.adminUsername == "" {
+		return false
+	}
+	// (compare both, whatever the first gives)
+	usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(options.adminUsername)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(options.adminPassword)) == 1
+	return usernameOK && passwordOK
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	message := http.StatusText(status)
+	if err != nil {
+		message = err.Error()
+	}
+	writeJSON(w, status, map[string]string{"error": message})
+}
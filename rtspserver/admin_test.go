@@ -0,0 +1,149 @@
+package rtspserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(handler http.Handler, method, path, body string, withCredentials bool) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if withCredentials {
+		request.SetBasicAuth("admin", "secret")
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestAdminAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dorsvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "cam1.264")
+	ioutil.WriteFile(fileName, []byte{0, 0, 0, 1}, 0644)
+
+	server := New(WithAdmin("127.0.0.1:0", "admin", "secret"))
+	handler := server.adminHandler()
+
+	if recorder := adminRequest(handler, "GET", "/streams", "", false); recorder.Code != http.StatusUnauthorized {
+		fmt.Println(recorder.Code)
+		t.Error("failed")
+		return
+	}
+
+	recorder := adminRequest(handler, "POST", "/streams", `{"name": "live/cam1", "file": "`+fileName+`"}`, true)
+	if recorder.Code != http.StatusCreated {
+		fmt.Println(recorder.Code, recorder.Body.String())
+		t.Error("failed")
+		return
+	}
+	if recorder := adminRequest(handler, "POST", "/streams", `{"name": "cam2", "file": "cam2.mp4"}`, true); recorder.Code != http.StatusBadRequest {
+		fmt.Println(recorder.Code, recorder.Body.String())
+		t.Error("failed")
+		return
+	}
+
+	var streams []StreamInfo
+	recorder = adminRequest(handler, "GET", "/streams", "", true)
+	json.Unmarshal(recorder.Body.Bytes(), &streams)
+	if len(streams) != 1 || streams[0].Name != "live/cam1" || !streams[0].Registered || streams[0].File != fileName {
+		fmt.Println(recorder.Body.String())
+		t.Error("failed")
+		return
+	}
+	// the RTSP clients find it by its name
+	if sms := server.lookupServerMediaSession("live/cam1"); sms == nil || sms.Subsessions[0].Codec() != "H264" {
+		t.Error("failed")
+		return
+	}
+
+	if recorder := adminRequest(handler, "DELETE", "/sessions/12345678", "", true); recorder.Code != http.StatusNotFound {
+		fmt.Println(recorder.Code)
+		t.Error("failed")
+		return
+	}
+	if recorder := adminRequest(handler, "DELETE", "/streams/live/cam1", "", true); recorder.Code != http.StatusNoContent {
+		fmt.Println(recorder.Code, recorder.Body.String())
+		t.Error("failed")
+		return
+	}
+	if recorder := adminRequest(handler, "GET", "/streams", "", true); strings.TrimSpace(recorder.Body.String()) != "[]" {
+		fmt.Println(recorder.Body.String())
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+// (run it with -race)
+func TestAdminWhilePlaying(t *testing.T) {
+	server := New(WithAdmin("127.0.0.1:0", "admin", "secret"), WithMediaRoot("../examples"))
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Shutdown(context.Background())
+	handler := server.adminHandler()
+
+	// a client plays the stream, again and again, while the sessions, the connections and the metrics are read
+	done := make(chan error)
+	go func() {
+		client := dialTestClient(t, server)
+		defer client.conn.Close()
+		url := "rtsp://" + server.rtspListen.Addr().String() + "/test.264"
+		for i := 0; i < 5; i++ {
+			status, response, _, err := client.request("SETUP", url+"/track1",
+				"Transport: RTP/AVP;unicast;client_port=50000-50001\r\n")
+			session := strings.Split(testHeaderValue(response, "Session"), ";")[0]
+			if err != nil || status != 200 {
+				done <- fmt.Errorf("SETUP: %d %v", status, err)
+				return
+			}
+			if status, _, _, err = client.request("PLAY", url, "Session: "+session+"\r\n"); err != nil || status != 200 {
+				done <- fmt.Errorf("PLAY: %d %v", status, err)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+			if status, _, _, err = client.request("TEARDOWN", url, "Session: "+session+"\r\n"); err != nil || status != 200 {
+				done <- fmt.Errorf("TEARDOWN: %d %v", status, err)
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	var played bool
+	for {
+		select {
+		case err := <-done:
+			if err != nil || !played {
+				fmt.Println(err)
+				t.Error("failed")
+				return
+			}
+			t.Log("success")
+			return
+		default:
+		}
+
+		var sessions []SessionInfo
+		json.Unmarshal(adminRequest(handler, "GET", "/sessions", "", true).Body.Bytes(), &sessions)
+		if len(sessions) == 1 && sessions[0].Stream == "test.264" {
+			played = true
+		}
+		adminRequest(handler, "GET", "/connections", "", true)
+		recorder := httptest.NewRecorder()
+		server.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	}
+}
@@ -0,0 +1,389 @@
+package rtspserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/djwackey/dorsvr/auth"
+	"github.com/djwackey/dorsvr/livemedia"
+	"github.com/djwackey/dorsvr/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration file of the server, in YAML, JSON or TOML
+// (by the extension of the file), e.g. in YAML:
+//
+//	listen:
+//	  rtsp_port: 8554
+//	  http_ports: [80, 8000, 8080]
+//	media_root: /var/media
+//	rtp_port_range: {min: 6970, max: 7970}
+//	timeouts:
+//	  session: 65s
+//	  shutdown: 10s
+//	auth:
+//	  realm: dorsvr
+//	  htpasswd_file: /etc/dorsvr/users.htpasswd
+//	  acl:
+//	    default: deny
+//	    rules:
+//	      - {action: allow, cidr: 10.0.0.0/8, stream: "*"}
+//	log:
+//	  mode: console
+//	  level: 1
+//	  format: json
+//	  levels: {rtsp: info, rtcp: debug}
+//	metrics_addr: 127.0.0.1:9554
+//	admin: {addr: 127.0.0.1:9555, username: admin, password: secret}
+//	webhook: {url: "http://127.0.0.1:8080/dorsvr/events", timeout: 2s}
+//	rtcp_feedback: {nack: true, rtx: false, keyframe_requests: true}
+//	fec: {scheme: flexfec, columns: 10, rows: 5}
+//	symmetric_rtp: 10s
+type Config struct {
+	Listen                 ListenConfig   `json:"listen" yaml:"listen" toml:"listen"`
+	MediaRoot              string         `json:"media_root" yaml:"media_root" toml:"media_root"`
+	RTPPortRange           PortRange      `json:"rtp_port_range" yaml:"rtp_port_range" toml:"rtp_port_range"`
+	Timeouts               TimeoutConfig  `json:"timeouts" yaml:"timeouts" toml:"timeouts"`
+	Auth                   AuthConfig     `json:"auth" yaml:"auth" toml:"auth"`
+	Log                    LogConfig      `json:"log" yaml:"log" toml:"log"`
+	OutPacketBufferMaxSize uint           `json:"out_packet_buffer_max_size" yaml:"out_packet_buffer_max_size" toml:"out_packet_buffer_max_size"`
+	PprofAddr              string         `json:"pprof_addr" yaml:"pprof_addr" toml:"pprof_addr"`
+	MetricsAddr            string         `json:"metrics_addr" yaml:"metrics_addr" toml:"metrics_addr"`
+	Admin                  AdminConfig    `json:"admin" yaml:"admin" toml:"admin"`
+	Webhook                WebhookConfig  `json:"webhook" yaml:"webhook" toml:"webhook"`
+	RTCPFeedback           FeedbackConfig `json:"rtcp_feedback" yaml:"rtcp_feedback" toml:"rtcp_feedback"`
+	FEC                    FECConfig      `json:"fec" yaml:"fec" toml:"fec"`
+	// the window in which the server latches to the ports of the first packets of a client, off if 0
+	SymmetricRTP Duration `json:"symmetric_rtp" yaml:"symmetric_rtp" toml:"symmetric_rtp"`
+}
+
+// FECConfig is the forward error correction of the streams, see livemedia.FEC
+type FECConfig struct {
+	// "ulpfec" or "flexfec", no FEC if empty
+	Scheme  string `json:"scheme" yaml:"scheme" toml:"scheme"`
+	Columns int    `json:"columns" yaml:"columns" toml:"columns"`
+	Rows    int    `json:"rows" yaml:"rows" toml:"rows"`
+}
+
+// FeedbackConfig is the RTCP feedback that the video streams offer, see livemedia.RTCPFeedback
+type FeedbackConfig struct {
+	NACK             bool `json:"nack" yaml:"nack" toml:"nack"`
+	RTX              bool `json:"rtx" yaml:"rtx" toml:"rtx"`
+	KeyframeRequests bool `json:"keyframe_requests" yaml:"keyframe_requests" toml:"keyframe_requests"`
+}
+
+// AdminConfig is the admin HTTP/JSON API, it needs credentials of its own
+type AdminConfig struct {
+	Addr     string `json:"addr" yaml:"addr" toml:"addr"`
+	Username string `json:"username" yaml:"username" toml:"username"`
+	Password string `json:"password" yaml:"password" toml:"password"`
+}
+
+// WebhookConfig is the URL the events of the server are posted to, see Webhook
+type WebhookConfig struct {
+	URL      string   `json:"url" yaml:"url" toml:"url"`
+	Timeout  Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	FailOpen bool     `json:"fail_open" yaml:"fail_open" toml:"fail_open"`
+}
+
+// ListenConfig are the listeners of the server, they can't be changed by a reload
+type ListenConfig struct {
+	RTSPPort int `json:"rtsp_port" yaml:"rtsp_port" toml:"rtsp_port"`
+	// the ports tried in turn for RTSP-over-HTTP tunneling
+	HTTPPorts []int  `json:"http_ports" yaml:"http_ports" toml:"http_ports"`
+	RTSPSPort int    `json:"rtsps_port" yaml:"rtsps_port" toml:"rtsps_port"`
+	TLSCert   string `json:"tls_cert" yaml:"tls_cert" toml:"tls_cert"`
+	TLSKey    string `json:"tls_key" yaml:"tls_key" toml:"tls_key"`
+}
+
+// PortRange is a range of UDP ports, a zero max means no upper bound
+type PortRange struct {
+	Min uint `json:"min" yaml:"min" toml:"min"`
+	Max uint `json:"max" yaml:"max" toml:"max"`
+}
+
+// TimeoutConfig are the timeouts of the server
+type TimeoutConfig struct {
+	Session Duration `json:"session" yaml:"session" toml:"session"`
+	// how long the server waits for its sessions to end, when it's shut down
+	Shutdown Duration `json:"shutdown" yaml:"shutdown" toml:"shutdown"`
+}
+
+// AuthConfig is the access control of the server, without any user (or password file) there is none
+type AuthConfig struct {
+	Realm          string       `json:"realm" yaml:"realm" toml:"realm"`
+	Users          []UserConfig `json:"users" yaml:"users" toml:"users"`
+	HtpasswdFile   string       `json:"htpasswd_file" yaml:"htpasswd_file" toml:"htpasswd_file"`
+	ReloadInterval Duration     `json:"reload_interval" yaml:"reload_interval" toml:"reload_interval"`
+	BasicAuth      bool         `json:"basic_auth" yaml:"basic_auth" toml:"basic_auth"`
+	TokenKey       string       `json:"token_key" yaml:"token_key" toml:"token_key"`
+	ACL            ACLConfig    `json:"acl" yaml:"acl" toml:"acl"`
+}
+
+// UserConfig is a user, with the permissions of a htpasswd file (e.g. "read,publish=live/*")
+type UserConfig struct {
+	Username    string `json:"username" yaml:"username" toml:"username"`
+	Password    string `json:"password" yaml:"password" toml:"password"`
+	Permissions string `json:"permissions" yaml:"permissions" toml:"permissions"`
+}
+
+// ACLConfig is an ACL, its default is "allow" or "deny"
+type ACLConfig struct {
+	Default string          `json:"default" yaml:"default" toml:"default"`
+	Rules   []ACLRuleConfig `json:"rules" yaml:"rules" toml:"rules"`
+}
+
+// ACLRuleConfig is a rule of an ACL, its action is "allow" or "deny"
+type ACLRuleConfig struct {
+	Action string `json:"action" yaml:"action" toml:"action"`
+	CIDR   string `json:"cidr" yaml:"cidr" toml:"cidr"`
+	Stream string `json:"stream" yaml:"stream" toml:"stream"`
+}
+
+// LogConfig is the logger of the server, for log.NewLogger, and its structured logs (the access log,
+// the summaries of the sessions, RTP, RTCP and auth), see the logging package
+type LogConfig struct {
+	Mode     string `json:"mode" yaml:"mode" toml:"mode"`
+	Level    int    `json:"level" yaml:"level" toml:"level"`
+	Filename string `json:"filename" yaml:"filename" toml:"filename"`
+	// "logfmt" (the default) or "json"
+	Format string `json:"format" yaml:"format" toml:"format"`
+	// the file of the structured logs, stderr by default
+	Output string `json:"output" yaml:"output" toml:"output"`
+	// the level of each subsystem ("rtsp", "rtp", "rtcp", "auth"), "info" by default
+	Levels map[string]string `json:"levels" yaml:"levels" toml:"levels"`
+}
+
+// Duration is a time.Duration written like "65s" or "2m", (or a number of seconds)
+type Duration time.Duration
+
+// UnmarshalText parses the duration
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		var seconds int
+		if _, scanErr := fmt.Sscanf(s, "%d", &seconds); scanErr != nil {
+			return err
+		}
+		duration = time.Duration(seconds) * time.Second
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// UnmarshalJSON parses the duration, as a string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	return d.UnmarshalText([]byte(strings.Trim(string(data), "\"")))
+}
+
+// DefaultConfig returns the configuration of a server without any configuration file
+func DefaultConfig() *Config {
+	return &Config{
+		Listen: ListenConfig{
+			RTSPPort:  8554,
+			HTTPPorts: []int{80, 8000, 8080},
+		},
+		MediaRoot:              ".",
+		RTPPortRange:           PortRange{Min: 6970},
+		Timeouts:               TimeoutConfig{Session: Duration(65 * time.Second), Shutdown: Duration(10 * time.Second)},
+		Webhook:                WebhookConfig{Timeout: Duration(2 * time.Second)},
+		RTCPFeedback:           FeedbackConfig{NACK: true, KeyframeRequests: true},
+		OutPacketBufferMaxSize: 2000000,
+		Log: LogConfig{
+			Mode:     "console",
+			Level:    1,
+			Filename: "test.log",
+		},
+	}
+}
+
+// LoadConfig reads the configuration file, in YAML (".yaml", ".yml"), JSON (".json") or TOML (".toml"),
+// the settings it leaves out keep their default value
+func LoadConfig(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultConfig()
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, config)
+	case ".json":
+		err = json.Unmarshal(data, config)
+	case ".toml":
+		err = toml.Unmarshal(data, config)
+	default:
+		return nil, fmt.Errorf("unknown format of the configuration file: %s", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return config, nil
+}
+
+// LoggerConfig returns the configuration of log.NewLogger
+func (c *Config) LoggerConfig() string {
+	config, _ := json.Marshal(struct {
+		Level    int    `json:"level"`
+		Filename string `json:"filename,omitempty"`
+	}{c.Log.Level, c.Log.Filename})
+	return string(config)
+}
+
+// SetupLogging sets the output, format and levels of the structured logs;
+// it returns the file of the output, to be closed once it's replaced (nil for stderr)
+func (c *Config) SetupLogging() (io.Closer, error) {
+	levels := make(map[string]slog.Level)
+	for _, subsystem := range logging.Subsystems {
+		levels[subsystem] = slog.LevelInfo
+	}
+	for subsystem, name := range c.Log.Levels {
+		if _, ok := levels[subsystem]; !ok {
+			return nil, fmt.Errorf("unknown log subsystem: %s", subsystem)
+		}
+		level, err := logging.ParseLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("log level of %s: %v", subsystem, err)
+		}
+		levels[subsystem] = level
+	}
+
+	var w io.Writer = os.Stderr
+	var file *os.File
+	if c.Log.Output != "" {
+		var err error
+		file, err = os.OpenFile(c.Log.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = file
+	}
+	if err := logging.SetOutput(w, c.Log.Format); err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, err
+	}
+
+	for subsystem, level := range levels {
+		logging.SetLevel(subsystem, level)
+	}
+	if file == nil {
+		return nil, nil
+	}
+	return file, nil
+}
+
+// Options returns the options of the server, (all but the listeners)
+func (c *Config) Options() ([]Option, error) {
+	acl, err := c.Auth.ACL.acl()
+	if err != nil {
+		return nil, err
+	}
+
+	fec := livemedia.FEC(c.FEC)
+	if err := fec.Validate(); err != nil {
+		return nil, err
+	}
+
+	var tokenSigner *auth.TokenSigner
+	if c.Auth.TokenKey != "" {
+		tokenSigner = auth.NewTokenSigner([]byte(c.Auth.TokenKey))
+	}
+
+	// (last, as an *auth.HtpasswdFile watches its file until it's closed)
+	authenticator, err := c.Auth.authenticator()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []Option{
+		WithAuthenticator(authenticator),
+		WithBasicAuth(c.Auth.BasicAuth),
+		WithTokenSigner(tokenSigner),
+		WithACL(acl),
+		WithSessionTimeout(time.Duration(c.Timeouts.Session)),
+		WithShutdownTimeout(time.Duration(c.Timeouts.Shutdown)),
+		WithMediaRoot(c.MediaRoot),
+		WithRTPPortRange(c.RTPPortRange.Min, c.RTPPortRange.Max),
+		WithOutPacketBufferMaxSize(c.OutPacketBufferMaxSize),
+		WithMetricsAddr(c.MetricsAddr),
+		WithAdmin(c.Admin.Addr, c.Admin.Username, c.Admin.Password),
+		WithRTCPFeedback(livemedia.RTCPFeedback(c.RTCPFeedback)),
+		WithFEC(fec),
+		WithSymmetricRTP(time.Duration(c.SymmetricRTP)),
+	}
+	if c.PprofAddr != "" {
+		opts = append(opts, WithPprofAddr(c.PprofAddr))
+	}
+
+	var eventHandler EventHandler
+	if c.Webhook.URL != "" {
+		webhook := NewWebhook(c.Webhook.URL, time.Duration(c.Webhook.Timeout))
+		webhook.FailOpen = c.Webhook.FailOpen
+		eventHandler = webhook
+	}
+	opts = append(opts, WithEventHandler(eventHandler))
+	return opts, nil
+}
+
+func (c *AuthConfig) authenticator() (auth.Authenticator, error) {
+	if c.HtpasswdFile != "" {
+		return auth.NewHtpasswdFile(c.Realm, c.HtpasswdFile, time.Duration(c.ReloadInterval))
+	}
+	if len(c.Users) == 0 {
+		return nil, nil
+	}
+
+	db := auth.NewAuthDatabase(c.Realm)
+	for _, user := range c.Users {
+		db.InsertUserRecord(user.Username, user.Password)
+		if user.Permissions != "" {
+			if err := db.SetPermissions(user.Username, user.Permissions); err != nil {
+				return nil, fmt.Errorf("user %s: %v", user.Username, err)
+			}
+		}
+	}
+	return db, nil
+}
+
+func (c *ACLConfig) acl() (*auth.ACL, error) {
+	if len(c.Rules) == 0 && c.Default == "" {
+		return nil, nil
+	}
+
+	acl := auth.NewACL()
+	switch c.Default {
+	case "", "allow":
+	case "deny":
+		acl.SetDefault(false)
+	default:
+		return nil, fmt.Errorf("bad default of the ACL: %s", c.Default)
+	}
+
+	for _, rule := range c.Rules {
+		var err error
+		switch rule.Action {
+		case "allow":
+			err = acl.Allow(rule.CIDR, rule.Stream)
+		case "deny":
+			err = acl.Deny(rule.CIDR, rule.Stream)
+		default:
+			err = fmt.Errorf("bad action of an ACL rule: %s", rule.Action)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return acl, nil
+}
@@ -0,0 +1,147 @@
+package rtspserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/djwackey/dorsvr/auth"
+)
+
+var configFiles = map[string]string{
+	"dorsvr.yaml": `
+listen:
+  rtsp_port: 18554
+  http_ports: [18000]
+media_root: /var/media
+rtp_port_range: {min: 20000, max: 20100}
+timeouts:
+  session: 2m
+auth:
+  realm: test
+  users:
+    - {username: alice, password: secret, permissions: "read,publish=live/*"}
+  acl:
+    default: deny
+    rules:
+      - {action: allow, cidr: 10.0.0.0/8, stream: "*"}
+log:
+  level: 2
+symmetric_rtp: 10s
+`,
+	"dorsvr.json": `{
+  "listen": {"rtsp_port": 18554, "http_ports": [18000]},
+  "media_root": "/var/media",
+  "rtp_port_range": {"min": 20000, "max": 20100},
+  "timeouts": {"session": "2m"},
+  "auth": {
+    "realm": "test",
+    "users": [{"username": "alice", "password": "secret", "permissions": "read,publish=live/*"}],
+    "acl": {"default": "deny", "rules": [{"action": "allow", "cidr": "10.0.0.0/8", "stream": "*"}]}
+  },
+  "log": {"level": 2},
+  "symmetric_rtp": "10s"
+}`,
+	"dorsvr.toml": `
+media_root = "/var/media"
+symmetric_rtp = "10s"
+
+[listen]
+rtsp_port = 18554
+http_ports = [18000]
+
+[rtp_port_range]
+min = 20000
+max = 20100
+
+[timeouts]
+session = "2m"
+
+[auth]
+realm = "test"
+
+[[auth.users]]
+username = "alice"
+password = "secret"
+permissions = "read,publish=live/*"
+
+[auth.acl]
+default = "deny"
+
+[[auth.acl.rules]]
+action = "allow"
+cidr = "10.0.0.0/8"
+stream = "*"
+
+[log]
+level = 2
+`,
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "config")
+	defer os.RemoveAll(dir)
+
+	for name, content := range configFiles {
+		filename := filepath.Join(dir, name)
+		ioutil.WriteFile(filename, []byte(content), 0600)
+
+		config, err := LoadConfig(filename)
+		if err != nil {
+			fmt.Println(err)
+			t.Error("failed")
+			return
+		}
+
+		// the settings left out keep their default value
+		if config.Listen.RTSPPort != 18554 || len(config.Listen.HTTPPorts) != 1 ||
+			config.MediaRoot != "/var/media" || config.RTPPortRange.Max != 20100 ||
+			time.Duration(config.Timeouts.Session) != 2*time.Minute ||
+			config.Log.Level != 2 || config.Log.Mode != "console" || config.OutPacketBufferMaxSize != 2000000 {
+			fmt.Printf("%s: %+v\n", name, config)
+			t.Error("failed")
+			return
+		}
+
+		opts, err := config.Options()
+		if err != nil {
+			fmt.Println(err)
+			t.Error("failed")
+			return
+		}
+
+		s := New(opts...)
+		options := s.currentOptions()
+		if options.authenticator == nil || options.acl == nil || options.reclamationTestSeconds != 120 ||
+			!options.authenticator.Authorize("alice", "live/cam1", auth.PermissionPublish) || options.rtpPortMin != 20000 ||
+			options.symmetricRTPWindow != 10*time.Second {
+			fmt.Printf("%s: %+v\n", name, options)
+			t.Error("failed")
+			return
+		}
+	}
+	t.Log("success")
+}
+
+// closingAuthenticator records that it was closed
+type closingAuthenticator struct {
+	*auth.Database
+	closed bool
+}
+
+func (a *closingAuthenticator) Close() {
+	a.closed = true
+}
+
+func TestDiscardOptions(t *testing.T) {
+	authenticator := &closingAuthenticator{Database: auth.NewAuthDatabase("test")}
+	DiscardOptions(WithMediaRoot("/var/media"), WithAuthenticator(authenticator), nil)
+	if !authenticator.closed {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
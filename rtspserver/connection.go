@@ -1,10 +1,14 @@
 package rtspserver
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
-	"strings"
+	"sync"
+	sys "syscall"
+	"time"
 
 	"github.com/djwackey/dorsvr/auth"
 	gs "github.com/djwackey/dorsvr/groupsock"
@@ -22,26 +26,61 @@ type RTSPClientConnection struct {
 	remoteAddr     string
 	currentCSeq    string
 	sessionIDStr   string
+	urlQuery       string
 	responseBuffer string
 	clientSession  *RTSPClientSession
 	server         *RTSPServer
-	digest         *auth.Digest
+	isSecure       bool // RTSPS
+	connectTime    time.Time
+	username       string     // the user authenticated on the connection, if any
+	mutex          sync.Mutex // guards clientSession and username, which the admin API and the events read too
 }
 
 func newRTSPClientConnection(server *RTSPServer, socket net.Conn) *RTSPClientConnection {
-	localAddr := strings.Split(socket.LocalAddr().String(), ":")
-	remoteAddr := strings.Split(socket.RemoteAddr().String(), ":")
+	// (an IPv6 address contains ':' itself, so it can't just be split on it)
+	localAddr, localPort, _ := net.SplitHostPort(socket.LocalAddr().String())
+	remoteAddr, remotePort, _ := net.SplitHostPort(socket.RemoteAddr().String())
+	_, isSecure := socket.(*tls.Conn)
 	return &RTSPClientConnection{
-		server:     server,
-		socket:     socket,
-		localAddr:  localAddr[0],
-		localPort:  localAddr[1],
-		remoteAddr: remoteAddr[0],
-		remotePort: remoteAddr[1],
-		digest:     auth.NewDigest(),
+		server:      server,
+		socket:      socket,
+		localAddr:   localAddr,
+		localPort:   localPort,
+		remoteAddr:  remoteAddr,
+		remotePort:  remotePort,
+		isSecure:    isSecure,
+		connectTime: time.Now(),
 	}
 }
 
+// addressFamily returns sys.AF_INET6 if the client reached us over IPv6, otherwise sys.AF_INET.
+func (c *RTSPClientConnection) addressFamily() int {
+	if gs.IsIPv6Address(c.localAddr) {
+		return sys.AF_INET6
+	}
+	return sys.AF_INET
+}
+
+// session returns the session of the last request of the connection, if any
+func (c *RTSPClientConnection) session() *RTSPClientSession {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.clientSession
+}
+
+func (c *RTSPClientConnection) setSession(clientSession *RTSPClientSession) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.clientSession = clientSession
+}
+
+// user returns the user authenticated on the connection, if any
+func (c *RTSPClientConnection) user() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.username
+}
+
 func (c *RTSPClientConnection) destroy() error {
 	return c.socket.Close()
 }
@@ -62,10 +101,9 @@ func (c *RTSPClientConnection) incomingRequestHandler() {
 				isclose = true
 			}
 		default:
+			// (EOF, or the connection was closed, e.g. by Shutdown)
 			log.Info("default: %v", err)
-			if err.Error() == "EOF" {
-				isclose = true
-			}
+			isclose = true
 		}
 
 		if isclose {
@@ -75,7 +113,7 @@ func (c *RTSPClientConnection) incomingRequestHandler() {
 
 	log.Info("disconnected the connection[%s:%s].", c.remoteAddr, c.remotePort)
 	if c.clientSession != nil {
-		c.clientSession.destroy()
+		c.clientSession.destroy("disconnect")
 	}
 }
 
@@ -86,16 +124,25 @@ func (c *RTSPClientConnection) handleRequestBytes(buffer []byte, length int) err
 
 	reqStr := string(buffer[:length])
 
-	log.Info("Received %d new bytes of request data.", length)
+	log.Trace("Received %d new bytes of request data.", length)
+
+	entry := &accessLogEntry{
+		id:    c.server.requestCount.Add(1),
+		url:   requestURL(reqStr),
+		start: time.Now(),
+	}
+	defer c.logRequest(entry)
 
 	var existed bool
 	//var clientSession *RTSPClientSession
 	requestString, parseSucceeded := livemedia.ParseRTSPRequestString(reqStr, length)
 	if parseSucceeded {
-		log.Info("Received a complete %s request:\n%s", requestString.CmdName, reqStr)
+		log.Trace("Received a complete %s request:\n%s", requestString.CmdName, reqStr)
+		entry.method, entry.cseq = requestString.CmdName, requestString.Cseq
 
 		c.currentCSeq = requestString.Cseq
 		c.sessionIDStr = requestString.SessionIDStr
+		c.urlQuery = requestString.UrlQuery
 		switch requestString.CmdName {
 		case "OPTIONS":
 			c.handleCommandOptions()
@@ -104,16 +151,27 @@ func (c *RTSPClientConnection) handleRequestBytes(buffer []byte, length int) err
 		case "SETUP":
 			{
 				if c.sessionIDStr == "" {
+					// make sure that we're authenticated to create a new session:
+					urlTotalSuffix := requestString.UrlSuffix
+					if requestString.UrlPreSuffix != "" {
+						urlTotalSuffix = requestString.UrlPreSuffix
+					}
+					if !c.authenticationOK("SETUP", urlTotalSuffix, reqStr, auth.PermissionRead) {
+						break
+					}
+
 					for {
 						c.sessionIDStr = fmt.Sprintf("%08X", gs.OurRandom32())
 						if _, existed = c.server.getClientSession(c.sessionIDStr); !existed {
 							break
 						}
 					}
-					c.clientSession = c.newClientSession(c.sessionIDStr)
+					c.setSession(c.newClientSession(c.sessionIDStr))
 					c.server.addClientSession(c.sessionIDStr, c.clientSession)
 				} else {
-					if c.clientSession, existed = c.server.getClientSession(c.sessionIDStr); !existed {
+					clientSession, existed := c.server.getClientSession(c.sessionIDStr)
+					c.setSession(clientSession)
+					if !existed {
 						c.handleCommandSessionNotFound()
 					}
 				}
@@ -124,7 +182,9 @@ func (c *RTSPClientConnection) handleRequestBytes(buffer []byte, length int) err
 			}
 		case "PLAY", "PAUSE", "TEARDOWN", "GET_PARAMETER", "SET_PARAMETER":
 			{
-				if c.clientSession, existed = c.server.getClientSession(c.sessionIDStr); existed {
+				clientSession, existed := c.server.getClientSession(c.sessionIDStr)
+				c.setSession(clientSession)
+				if existed {
 					c.clientSession.handleCommandWithinSession(requestString.CmdName,
 						requestString.UrlPreSuffix, requestString.UrlSuffix, reqStr)
 				} else {
@@ -135,9 +195,12 @@ func (c *RTSPClientConnection) handleRequestBytes(buffer []byte, length int) err
 		default:
 			c.handleCommandNotSupported()
 		}
+		c.server.metrics.noteRequest(requestString.CmdName, c.responseBuffer)
+		entry.sessionID = c.sessionIDStr
 	} else {
 		requestString, parseSucceeded := livemedia.ParseHTTPRequestString(reqStr, length)
 		if parseSucceeded {
+			entry.method = requestString.CmdName
 			switch requestString.CmdName {
 			case "GET":
 				c.handleHTTPCommandTunnelingGET(requestString.SessionCookie)
@@ -158,7 +221,7 @@ func (c *RTSPClientConnection) handleRequestBytes(buffer []byte, length int) err
 		log.Error(4, "failed to send response buffer.%d", sendBytes)
 		return err
 	}
-	log.Info("send response:\n%s", c.responseBuffer)
+	log.Trace("send response:\n%s", c.responseBuffer)
 	return nil
 }
 
@@ -204,18 +267,25 @@ func (c *RTSPClientConnection) handleCommandDescribe(urlPreSuffix, urlSuffix, fu
 		urlTotalSuffix = fmt.Sprintf("%s/%s", urlPreSuffix, urlSuffix)
 	}
 
-	if ok := c.authenticationOK("DESCRIPE", urlTotalSuffix, fullRequestStr); !ok {
+	if ok := c.authenticationOK("DESCRIBE", urlTotalSuffix, fullRequestStr, auth.PermissionRead); !ok {
 		return
 	}
 
 	var sms *livemedia.ServerMediaSession
 	sms = c.server.lookupServerMediaSession(urlTotalSuffix)
+	if sms == nil {
+		// the event handler may create the stream, e.g. register its file:
+		event := c.newEvent(EventStreamNotFound)
+		event.Stream = urlTotalSuffix
+		c.server.notifyEvent(event)
+		sms = c.server.lookupServerMediaSession(urlTotalSuffix)
+	}
 	if sms == nil {
 		c.handleCommandNotFound()
 		return
 	}
 
-	sdpDescription := sms.GenerateSDPDescription()
+	sdpDescription := sms.GenerateSDPDescription(c.addressFamily(), c.isSecure)
 	sdpDescriptionSize := len(sdpDescription)
 	if sdpDescriptionSize <= 0 {
 		c.setRTSPResponse("404 File Not Found, Or In Incorrect Format")
@@ -223,7 +293,7 @@ func (c *RTSPClientConnection) handleCommandDescribe(urlPreSuffix, urlSuffix, fu
 	}
 
 	streamName := sms.StreamName()
-	rtspURL := c.server.RtspURL(streamName)
+	rtspURL := c.server.rtspURL(c.localAddr, streamName, c.isSecure)
 	c.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
 		"CSeq: %s\r\n"+
 		"%s"+
@@ -290,61 +360,103 @@ func (c *RTSPClientConnection) setRTSPResponseWithSessionID(responseStr string,
 		responseStr, c.currentCSeq, livemedia.DateHeader(), sessionID)
 }
 
-func (c *RTSPClientConnection) authenticationOK(cmdName, urlSuffix, fullRequestStr string) bool {
+func (c *RTSPClientConnection) authenticationOK(cmdName, urlSuffix, fullRequestStr string, permission auth.Permission) bool {
 	if !c.server.specialClientAccessCheck(c.socket, c.remoteAddr, urlSuffix) {
-		c.setRTSPResponse("401 Unauthorized")
+		authLog.Info("refused by the ACL", "remote_addr", c.remoteAddr, "stream", urlSuffix)
+		c.setRTSPResponse("403 Forbidden")
 		return false
 	}
 
-	authDatabase := c.server.authDatabase
-	// dont enable authentication control, pass it
-	if authDatabase == nil {
-		return true
-	}
-
-	for {
-		// To authenticate, we first need to have a nonce set up
-		// from a previous attempt:
-		if c.digest.Nonce == "" {
-			break
-		}
+	options := c.server.currentOptions()
 
-		// Next, the request needs to contain an "Authorization:" header,
-		// containing a username, (our) realm, (our) nonce, uri,
-		// and response string:
-		header := auth.ParseAuthorizationHeader(fullRequestStr)
-		if header == nil {
-			break
+	// a signed URL allows to read the stream, without any other credentials:
+	tokenSigner := options.tokenSigner
+	if tokenSigner != nil && c.urlQuery != "" && permission == auth.PermissionRead {
+		err := tokenSigner.Verify(urlSuffix, c.remoteAddr, c.urlQuery)
+		if err == nil {
+			return true
 		}
+		authLog.Info("refused the token", "remote_addr", c.remoteAddr, "stream", urlSuffix, "err", err)
+	}
 
-		// Next, the username has to be known to us:
-		c.digest.Password = authDatabase.LookupPassword(header.Username)
-		if c.digest.Password == "" {
-			break
+	authenticator := options.authenticator
+	if authenticator == nil {
+		// dont enable authentication control, pass it
+		if tokenSigner == nil {
+			return true
 		}
-		c.digest.Username = header.Username
+		c.setRTSPResponse("401 Unauthorized")
+		return false
+	}
 
-		// Finally, compute a digest response from the information that we have,
-		// and compare it to the one that we were given:
-		response := c.digest.ComputeResponse(cmdName, header.URI)
-		if response == header.Response {
-			return true
+	username, stale := c.authenticatedUser(authenticator, options.basicAuthAllowed, cmdName, fullRequestStr)
+	if username != "" {
+		// the user also has to be allowed to access the stream:
+		if !authenticator.Authorize(username, urlSuffix, permission) {
+			authLog.Info("refused the access", "remote_addr", c.remoteAddr, "user", username,
+				"stream", urlSuffix, "permission", permission)
+			c.setRTSPResponse("403 Forbidden")
+			return false
 		}
-		break
+		c.mutex.Lock()
+		c.username = username
+		c.mutex.Unlock()
+		authLog.Debug("authenticated", "remote_addr", c.remoteAddr, "user", username, "stream", urlSuffix)
+		return true
 	}
 
-	c.digest.Realm = authDatabase.Realm
-	c.digest.RandomNonce()
+	authLog.Debug("challenged", "remote_addr", c.remoteAddr, "stream", urlSuffix, "stale", stale)
+	challenge := c.server.digestVerifier.Challenge(authenticator.Realm(), stale)
+	if options.basicAuthAllowed {
+		challenge += fmt.Sprintf("WWW-Authenticate: Basic realm=\"%s\"\r\n", authenticator.Realm())
+	}
 	c.responseBuffer = fmt.Sprintf("RTSP/1.0 401 Unauthorized\r\n"+
 		"CSeq: %s\r\n"+
 		"%s"+
-		"WWW-Authenticate: Digest realm=\"%s\", nonce=\"%s\"\r\n\r\n",
+		"%s\r\n",
 		c.currentCSeq,
 		livemedia.DateHeader(),
-		c.digest.Realm, c.digest.Nonce)
+		challenge)
 	return false
 }
 
+// authenticatedUser returns the user authenticated by the "Authorization:" header of the request,
+// or an empty string; stale is true if the digest response was right but its nonce expired.
+func (c *RTSPClientConnection) authenticatedUser(authenticator auth.Authenticator, basicAuthAllowed bool,
+	cmdName, fullRequestStr string) (username string, stale bool) {
+	if basicAuthAllowed {
+		if username, password, ok := auth.ParseBasicCredentials(fullRequestStr); ok {
+			expected, ok := authenticator.LookupPassword(username)
+			if ok && expected != "" && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1 {
+				return username, false
+			}
+			return "", false
+		}
+	}
+
+	// The request needs to contain an "Authorization:" header,
+	// containing a username, (our) realm, (our) nonce, uri,
+	// and response string:
+	header := auth.ParseAuthorizationHeader(fullRequestStr)
+	if header == nil || header.Realm != authenticator.Realm() {
+		return "", false
+	}
+
+	// Next, the username has to be known to us:
+	password, ok := authenticator.LookupPassword(header.Username)
+	if !ok || password == "" {
+		return "", false
+	}
+
+	// Finally, the digest response has to match the one computed from the information that we have,
+	// for this request's URL, with a nonce that we issued and that didn't expire yet:
+	conn := net.JoinHostPort(c.remoteAddr, c.remotePort)
+	if ok, stale = c.server.digestVerifier.Verify(header, cmdName, requestURL(fullRequestStr), password, conn); !ok {
+		return "", stale
+	}
+	return header.Username, false
+}
+
 func (c *RTSPClientConnection) newClientSession(sessionID string) *RTSPClientSession {
 	return newRTSPClientSession(c, sessionID)
 }
@@ -0,0 +1,118 @@
+package rtspserver
+
+import (
+	"time"
+
+	"github.com/djwackey/gitea/log"
+)
+
+// EventType is the kind of an Event
+type EventType int
+
+const (
+	EventConnectionOpen EventType = iota
+	EventConnectionClose
+	// a DESCRIBE of a stream which we don't know (yet), see EventHandler
+	EventStreamNotFound
+	EventSetup
+	EventPlay
+	EventPause
+	EventTeardown
+	// a client session without any request (or RTCP report) from its client for too long, see WithSessionTimeout
+	EventSessionTimeout
+)
+
+var eventTypeNames = []string{
+	"connection_open", "connection_close", "stream_not_found", "setup", "play", "pause", "teardown",
+	"session_timeout",
+}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return "unknown"
+	}
+	return eventTypeNames[t]
+}
+
+// MarshalText marshals the event type as its name, e.g. "play"
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// vetoable is true for the events which an EventHandler can refuse
+func (t EventType) vetoable() bool {
+	return t == EventPlay
+}
+
+// Event is something which happened on the server, for an EventHandler
+type Event struct {
+	Type       EventType `json:"type"`
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Secure     bool      `json:"secure"`
+	// the user authenticated on the connection, if any
+	Username  string `json:"username,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	Stream    string `json:"stream,omitempty"`
+	Track     string `json:"track,omitempty"`
+}
+
+// EventHandler is notified of the events of the server, in the goroutine of the connection,
+// so it has to return quickly.
+// An error refuses the PLAY with "403 Forbidden", it's only logged for the other events.
+// On EventStreamNotFound, the handler may create the stream (e.g. with RTSPServer.RegisterStream),
+// which the server looks up again afterwards.
+type EventHandler interface {
+	HandleEvent(server *RTSPServer, event *Event) error
+}
+
+// eventDrainer is an EventHandler which handles some events in the background, e.g. a *Webhook:
+// Shutdown waits until it's done with them
+type eventDrainer interface {
+	drain()
+}
+
+// EventHandlerFunc is a func used as an EventHandler
+type EventHandlerFunc func(server *RTSPServer, event *Event) error
+
+// HandleEvent calls f(server, event)
+func (f EventHandlerFunc) HandleEvent(server *RTSPServer, event *Event) error {
+	return f(server, event)
+}
+
+func (c *RTSPClientConnection) newEvent(eventType EventType) *Event {
+	return &Event{
+		Type:       eventType,
+		Time:       time.Now(),
+		RemoteAddr: c.remoteAddr,
+		Secure:     c.isSecure,
+		Username:   c.user(),
+	}
+}
+
+func (s *RTSPClientSession) newEvent(eventType EventType) *Event {
+	event := s.connection.newEvent(eventType)
+	event.SessionID = s.sessionID
+	key, _ := s.streamTrack()
+	event.Stream, event.Track = key.streamName, key.trackID
+	return event
+}
+
+// notifyEvent calls the event handler, if any, and returns its veto (always nil if the event isn't vetoable)
+func (s *RTSPServer) notifyEvent(event *Event) error {
+	handler := s.currentOptions().eventHandler
+	if handler == nil {
+		return nil
+	}
+
+	err := handler.HandleEvent(s, event)
+	if err == nil {
+		return nil
+	}
+	if event.Type.vetoable() {
+		log.Info("refused the %s of %s for \"%s\": %v", event.Type, event.RemoteAddr, event.Stream, err)
+		return err
+	}
+	log.Warn("failed to handle the %s event: %v", event.Type, err)
+	return nil
+}
@@ -0,0 +1,160 @@
+package rtspserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	var mutex sync.Mutex
+	var events []EventType
+	handler := EventHandlerFunc(func(server *RTSPServer, event *Event) error {
+		mutex.Lock()
+		events = append(events, event.Type)
+		mutex.Unlock()
+		return nil
+	})
+
+	server := New(WithEventHandler(handler))
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+
+	conn, err := net.Dial("tcp", server.rtspListen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("DESCRIBE rtsp://127.0.0.1/unknown.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n"))
+	conn.Read(make([]byte, 1024))
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	fmt.Println(events)
+	if len(events) != 3 || events[0] != EventConnectionOpen || events[1] != EventStreamNotFound ||
+		events[2] != EventConnectionClose {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestWebhook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dorsvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "cam1.264")
+	ioutil.WriteFile(fileName, []byte{0, 0, 0, 1}, 0644)
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event struct {
+			Type   string `json:"type"`
+			Stream string `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&event)
+		switch {
+		case event.Type == "stream_not_found" && event.Stream == "live/cam1":
+			fmt.Fprintf(w, `{"file": %q}`, fileName)
+		case event.Type == "play" && event.Stream != "live/cam1":
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+
+	server := New()
+	webhook := NewWebhook(hook.URL, time.Second)
+
+	if err := webhook.HandleEvent(server, &Event{Type: EventPlay, Stream: "live/cam1"}); err != nil {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+	if err := webhook.HandleEvent(server, &Event{Type: EventPlay, Stream: "live/cam2"}); err == nil {
+		t.Error("failed")
+		return
+	}
+
+	webhook.HandleEvent(server, &Event{Type: EventStreamNotFound, Stream: "live/cam1"})
+	if sms := server.lookupServerMediaSession("live/cam1"); sms == nil {
+		t.Error("failed")
+		return
+	}
+
+	// the PLAY is refused when the webhook can't be reached, unless it fails open
+	hook.Close()
+	if err := webhook.HandleEvent(server, &Event{Type: EventPlay, Stream: "live/cam1"}); err == nil {
+		t.Error("failed")
+		return
+	}
+	webhook.FailOpen = true
+	if err := webhook.HandleEvent(server, &Event{Type: EventPlay, Stream: "live/cam1"}); err != nil {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestWebhookQueue(t *testing.T) {
+	var mutex sync.Mutex
+	var sessionIDs []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		json.NewDecoder(r.Body).Decode(&event)
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		sessionIDs = append(sessionIDs, event.SessionID)
+		mutex.Unlock()
+	}))
+	defer hook.Close()
+
+	webhook := NewWebhook(hook.URL, time.Second)
+	server := New(WithEventHandler(webhook))
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+
+	// the events are posted in the background, in their order, before the shutdown returns
+	const numEvents = 20
+	for i := 0; i < numEvents; i++ {
+		webhook.HandleEvent(server, &Event{Type: EventTeardown, SessionID: strconv.Itoa(i)})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(sessionIDs) != numEvents {
+		fmt.Println(sessionIDs)
+		t.Error("failed")
+		return
+	}
+	for i, sessionID := range sessionIDs {
+		if sessionID != strconv.Itoa(i) {
+			fmt.Println(sessionIDs)
+			t.Error("failed")
+			return
+		}
+	}
+	t.Log("success")
+}
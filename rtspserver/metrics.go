@@ -0,0 +1,180 @@
+package rtspserver
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/djwackey/dorsvr/livemedia"
+	"github.com/djwackey/dorsvr/metrics"
+)
+
+// the methods counted by their name, the others are counted as "OTHER"
+var countedMethods = map[string]bool{
+	"OPTIONS": true, "DESCRIBE": true, "SETUP": true, "PLAY": true, "PAUSE": true,
+	"TEARDOWN": true, "GET_PARAMETER": true, "SET_PARAMETER": true, "RECORD": true,
+}
+
+type streamTrack struct {
+	streamName string
+	trackID    string
+}
+
+type sentCounts struct {
+	packets uint
+	octets  uint
+}
+
+type serverMetrics struct {
+	registry         *metrics.Registry
+	connectionsTotal *metrics.Family
+	connections      *metrics.Family
+	requests         *metrics.Family
+	sessions         *metrics.Family
+	rtpPackets       *metrics.Family
+	rtpOctets        *metrics.Family
+	fractionLost     *metrics.Family
+	packetsLost      *metrics.Family
+	jitter           *metrics.Family
+	rtt              *metrics.Family
+	// the RTP counts of the sessions which ended, so that the counters don't go down
+	endedCounts map[streamTrack]sentCounts
+	endedMutex  sync.Mutex
+}
+
+func newServerMetrics(s *RTSPServer) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		connectionsTotal: r.NewCounter("dorsvr_connections_total",
+			"The connections accepted, by protocol (rtsp or rtsps).", "protocol"),
+		connections: r.NewGauge("dorsvr_connections",
+			"The open connections, by protocol (rtsp or rtsps).", "protocol"),
+		requests: r.NewCounter("dorsvr_rtsp_requests_total",
+			"The RTSP requests, by method and status code of the response.", "method", "status"),
+		sessions: r.NewGauge("dorsvr_sessions",
+			"The client sessions, by stream.", "stream"),
+		rtpPackets: r.NewCounter("dorsvr_rtp_packets_sent_total",
+			"The RTP packets sent, by stream and track.", "stream", "track"),
+		rtpOctets: r.NewCounter("dorsvr_rtp_bytes_sent_total",
+			"The payload bytes of the RTP packets sent, by stream and track.", "stream", "track"),
+		fractionLost: r.NewGauge("dorsvr_rtcp_fraction_lost",
+			"The fraction of the packets lost, in the last RTCP receiver report of each client session.",
+			"stream", "track", "session"),
+		packetsLost: r.NewGauge("dorsvr_rtcp_packets_lost",
+			"The cumulative number of packets lost, in the last RTCP receiver report of each client session.",
+			"stream", "track", "session"),
+		jitter: r.NewGauge("dorsvr_rtcp_jitter_seconds",
+			"The interarrival jitter, in the last RTCP receiver report of each client session.",
+			"stream", "track", "session"),
+		rtt: r.NewGauge("dorsvr_rtcp_rtt_seconds",
+			"The round-trip time, as of the last RTCP receiver report of each client session (which tells it).",
+			"stream", "track", "session"),
+		endedCounts: make(map[streamTrack]sentCounts),
+	}
+	r.OnCollect(func() {
+		m.collectSessions(s)
+	})
+	return m
+}
+
+// MetricsHandler returns the handler of the metrics of the server, in the text format of Prometheus,
+// e.g. to serve them on a HTTP server of our own, instead of the metrics address (see WithMetricsAddr)
+func (s *RTSPServer) MetricsHandler() http.Handler {
+	return s.metrics.registry
+}
+
+// startMetrics serves the metrics on "/metrics" of the metrics address, if any
+func (s *RTSPServer) startMetrics() {
+	metricsAddr := s.currentOptions().metricsAddr
+	if metricsAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.registry)
+	s.metricsServer = s.startHTTPServer(metricsAddr, mux, "the metrics")
+}
+
+func (m *serverMetrics) noteConnection(c *RTSPClientConnection, delta float64) {
+	protocol := "rtsp"
+	if c.isSecure {
+		protocol = "rtsps"
+	}
+	if delta > 0 {
+		m.connectionsTotal.Inc(protocol)
+	}
+	m.connections.Add(delta, protocol)
+}
+
+// noteRequest counts a RTSP request, with the status code of our response
+func (m *serverMetrics) noteRequest(method, response string) {
+	if !countedMethods[method] {
+		method = "OTHER"
+	}
+
+	status := ""
+	if code := responseStatus(response); code != 0 {
+		status = strconv.Itoa(code)
+	}
+	m.requests.Inc(method, status)
+}
+
+// noteSessionEnded keeps the RTP counts of a session which ended
+func (m *serverMetrics) noteSessionEnded(key streamTrack, streamState *livemedia.StreamState) {
+	packets, octets := streamState.SentCounts()
+
+	m.endedMutex.Lock()
+	counts := m.endedCounts[key]
+	counts.packets += packets
+	counts.octets += octets
+	m.endedCounts[key] = counts
+	m.endedMutex.Unlock()
+}
+
+// collectSessions sets the metrics computed from the client sessions, before they're written
+func (m *serverMetrics) collectSessions(s *RTSPServer) {
+	m.sessions.Reset()
+	m.fractionLost.Reset()
+	m.packetsLost.Reset()
+	m.jitter.Reset()
+	m.rtt.Reset()
+
+	m.endedMutex.Lock()
+	counts := make(map[streamTrack]sentCounts, len(m.endedCounts))
+	for key, ended := range m.endedCounts {
+		counts[key] = ended
+	}
+	m.endedMutex.Unlock()
+
+	for _, clientSession := range s.clientSessionList() {
+		key, streamState := clientSession.streamTrack()
+		if key.streamName == "" {
+			continue
+		}
+		m.sessions.Inc(key.streamName)
+		if streamState == nil {
+			continue
+		}
+
+		packets, octets := streamState.SentCounts()
+		sent := counts[key]
+		sent.packets += packets
+		sent.octets += octets
+		counts[key] = sent
+
+		if report, ok := clientSession.receiverReport(streamState); ok {
+			m.fractionLost.Set(report.FractionLost, key.streamName, key.trackID, clientSession.sessionID)
+			m.packetsLost.Set(float64(report.PacketsLost), key.streamName, key.trackID, clientSession.sessionID)
+			m.jitter.Set(report.Jitter.Seconds(), key.streamName, key.trackID, clientSession.sessionID)
+			if report.RTT > 0 {
+				m.rtt.Set(report.RTT.Seconds(), key.streamName, key.trackID, clientSession.sessionID)
+			}
+		}
+	}
+
+	for key, sent := range counts {
+		m.rtpPackets.Set(float64(sent.packets), key.streamName, key.trackID)
+		m.rtpOctets.Set(float64(sent.octets), key.streamName, key.trackID)
+	}
+}
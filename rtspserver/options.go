@@ -0,0 +1,233 @@
+package rtspserver
+
+import (
+	"time"
+
+	"github.com/djwackey/dorsvr/auth"
+	"github.com/djwackey/dorsvr/livemedia"
+)
+
+// Option configures a RTSPServer, see New and ApplyOptions
+type Option func(*serverOptions)
+
+type serverOptions struct {
+	authenticator          auth.Authenticator
+	tokenSigner            *auth.TokenSigner
+	acl                    *auth.ACL
+	basicAuthAllowed       bool
+	reclamationTestSeconds time.Duration
+	mediaRoot              string
+	rtpPortMin             uint
+	rtpPortMax             uint
+	outPacketBufferMaxSize uint
+	pprofAddr              string
+	metricsAddr            string
+	adminAddr              string
+	adminUsername          string
+	adminPassword          string
+	shutdownTimeout        time.Duration
+	eventHandler           EventHandler
+	rtcpFeedback           livemedia.RTCPFeedback
+	fec                    livemedia.FEC
+	symmetricRTPWindow     time.Duration
+}
+
+func defaultServerOptions() serverOptions {
+	return serverOptions{
+		reclamationTestSeconds: 65,
+		mediaRoot:              ".",
+		rtpPortMin:             6970,
+		outPacketBufferMaxSize: 2000000,
+		shutdownTimeout:        10 * time.Second,
+		rtcpFeedback:           livemedia.RTCPFeedback{NACK: true, KeyframeRequests: true},
+	}
+}
+
+// WithAuthenticator enables the access control, e.g. with an *auth.Database
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(o *serverOptions) {
+		o.authenticator = authenticator
+	}
+}
+
+// WithBasicAuth also accepts "Authorization: Basic" credentials, see AllowBasicAuth
+func WithBasicAuth(allow bool) Option {
+	return func(o *serverOptions) {
+		o.basicAuthAllowed = allow
+	}
+}
+
+// WithTokenSigner accepts the URLs signed by signer, see SetTokenSigner
+func WithTokenSigner(signer *auth.TokenSigner) Option {
+	return func(o *serverOptions) {
+		o.tokenSigner = signer
+	}
+}
+
+// WithACL restricts the addresses of the clients, see SetACL
+func WithACL(acl *auth.ACL) Option {
+	return func(o *serverOptions) {
+		o.acl = acl
+	}
+}
+
+// WithSessionTimeout sets how long a client session lives without any request (or RTCP report) from its client,
+// 65 seconds by default
+func WithSessionTimeout(timeout time.Duration) Option {
+	return func(o *serverOptions) {
+		if timeout > 0 {
+			o.reclamationTestSeconds = timeout / time.Second
+		}
+	}
+}
+
+// WithMediaRoot sets the directory of the media files, the current directory by default
+func WithMediaRoot(dir string) Option {
+	return func(o *serverOptions) {
+		if dir != "" {
+			o.mediaRoot = dir
+		}
+	}
+}
+
+// WithRTPPortRange sets the range of the server's UDP ports for RTP and RTCP, from 6970 by default,
+// a zero max means no upper bound
+func WithRTPPortRange(min, max uint) Option {
+	return func(o *serverOptions) {
+		if min > 0 {
+			o.rtpPortMin, o.rtpPortMax = min, max
+		}
+	}
+}
+
+// WithOutPacketBufferMaxSize sets the size of the buffer of the outgoing frames,
+// (large enough for the biggest H.264 frames of the media)
+func WithOutPacketBufferMaxSize(size uint) Option {
+	return func(o *serverOptions) {
+		if size > 0 {
+			o.outPacketBufferMaxSize = size
+		}
+	}
+}
+
+// WithPprofAddr serves the pprof profiles of the process on "/debug/pprof/" of the address;
+// there are none by default. (It only applies before Listen.)
+func WithPprofAddr(addr string) Option {
+	return func(o *serverOptions) {
+		o.pprofAddr = addr
+	}
+}
+
+// WithMetricsAddr serves the metrics of the server on "/metrics" of the address, in the text format of Prometheus;
+// there are none by default. (It only applies before Listen, see also MetricsHandler.)
+func WithMetricsAddr(addr string) Option {
+	return func(o *serverOptions) {
+		o.metricsAddr = addr
+	}
+}
+
+// WithAdmin serves the admin HTTP/JSON API on the address, for the clients with the credentials
+// ("Authorization: Basic"); there is none by default. (The address only applies before Listen.)
+func WithAdmin(addr, username, password string) Option {
+	return func(o *serverOptions) {
+		o.adminAddr, o.adminUsername, o.adminPassword = addr, username, password
+	}
+}
+
+// WithShutdownTimeout sets how long Serve waits for the shutdown of the server, 10 seconds by default
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(o *serverOptions) {
+		if timeout > 0 {
+			o.shutdownTimeout = timeout
+		}
+	}
+}
+
+// WithEventHandler notifies handler of the events of the server, e.g. a *Webhook; there is none by default
+func WithEventHandler(handler EventHandler) Option {
+	return func(o *serverOptions) {
+		o.eventHandler = handler
+	}
+}
+
+// WithRTCPFeedback sets the RTCP feedback (RFC 4585) that the video streams offer,
+// NACK retransmission and keyframe requests (PLI and FIR) by default
+func WithRTCPFeedback(feedback livemedia.RTCPFeedback) Option {
+	return func(o *serverOptions) {
+		o.rtcpFeedback = feedback
+	}
+}
+
+// WithFEC makes the streams send the parity packets of the FEC, (see livemedia.FEC.Validate); there is none by default
+func WithFEC(fec livemedia.FEC) Option {
+	return func(o *serverOptions) {
+		o.fec = fec
+	}
+}
+
+// WithSymmetricRTP makes the UDP streams of a client go to the ports its first packets come from,
+// within the window after its SETUP, (see livemedia.StreamSettings); it's off by default
+func WithSymmetricRTP(window time.Duration) Option {
+	return func(o *serverOptions) {
+		o.symmetricRTPWindow = window
+	}
+}
+
+// ApplyOptions changes the settings of a running server, e.g. after reloading its configuration;
+// the new settings apply to the next requests. (The pprof address only applies before Listen.)
+func (s *RTSPServer) ApplyOptions(opts ...Option) {
+	s.optionsMutex.Lock()
+	oldAuthenticator := s.options.authenticator
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&s.options)
+		}
+	}
+	options := s.options
+	s.optionsMutex.Unlock()
+
+	// (the streams of our sessions take the new settings as they're created)
+	s.smsMutex.Lock()
+	for _, sms := range s.serverMediaSessions {
+		sms.SetStreamSettings(options.streamSettings())
+	}
+	s.smsMutex.Unlock()
+
+	// e.g. stop watching the password file of an *auth.HtpasswdFile we replaced
+	if closer, ok := oldAuthenticator.(interface{ Close() }); ok && oldAuthenticator != options.authenticator {
+		closer.Close()
+	}
+}
+
+// DiscardOptions releases what options that won't be applied hold,
+// e.g. the watcher of the password file of an *auth.HtpasswdFile.
+func DiscardOptions(opts ...Option) {
+	options := defaultServerOptions()
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&options)
+		}
+	}
+	if closer, ok := options.authenticator.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// streamSettings returns the settings of the streams of our sessions
+func (o serverOptions) streamSettings() livemedia.StreamSettings {
+	return livemedia.StreamSettings{
+		RTPPortMin:             o.rtpPortMin,
+		RTPPortMax:             o.rtpPortMax,
+		OutPacketBufferMaxSize: o.outPacketBufferMaxSize,
+		RTCPFeedback:           o.rtcpFeedback,
+		FEC:                    o.fec,
+		SymmetricRTPWindow:     o.symmetricRTPWindow,
+	}
+}
+
+// currentOptions returns a copy of the current settings
+func (s *RTSPServer) currentOptions() serverOptions {
+	s.optionsMutex.RLock()
+	defer s.optionsMutex.RUnlock()
+	return s.options
+}
@@ -1,15 +1,19 @@
 package rtspserver
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
-	_ "net/http/pprof"
+	"net/http/pprof"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/djwackey/dorsvr/auth"
@@ -18,38 +22,173 @@ import (
 	lg "github.com/djwackey/gitea/log"
 )
 
+// the delay before accepting again, after a failure
+const acceptRetryDelay = 100 * time.Millisecond
+
+// how long a connection that the ACL refuses has to send its first request
+const refusedRequestTimeout = 5 * time.Second
+
+// how many refused connections we answer at a time, the others are closed right away
+const maxRefusedConnections = 64
+
 type RTSPServer struct {
-	urlPrefix              string
-	rtspPort               int
-	httpPort               int
-	rtspListen             *net.TCPListener
-	httpListen             *net.TCPListener
-	clientSessions         map[string]*RTSPClientSession
-	clientHTTPConnections  map[string]*RTSPClientConnection
-	serverMediaSessions    map[string]*livemedia.ServerMediaSession
-	reclamationTestSeconds time.Duration
-	authDatabase           *auth.Database
-	smsMutex               sync.Mutex
-	sessionMutex           sync.Mutex
-	httpConnectionMutex    sync.Mutex
-	rtspConnectionMutex    sync.Mutex
-}
-
-func New(authDatabase *auth.Database) *RTSPServer {
+	urlPrefix             string
+	rtspPort              int
+	httpPort              int
+	tlsPort               int
+	rtspListen            *net.TCPListener
+	httpListen            *net.TCPListener
+	tlsListen             *net.TCPListener
+	tlsConfig             *tls.Config
+	clientSessions        map[string]*RTSPClientSession
+	clientHTTPConnections map[string]*RTSPClientConnection
+	rtspConnections       map[*RTSPClientConnection]bool
+	refusedConnections    map[net.Conn]bool // the connections the ACL refused, until their first request is answered
+	serverMediaSessions   map[string]*livemedia.ServerMediaSession
+	registeredStreams     map[string]string // the media files of the streams registered at runtime
+	digestVerifier        *auth.DigestVerifier
+	metrics               *serverMetrics
+	pprofServer           *http.Server
+	metricsServer         *http.Server
+	adminServer           *http.Server
+	options               serverOptions
+	optionsMutex          sync.RWMutex
+	smsMutex              sync.Mutex
+	sessionMutex          sync.Mutex
+	httpConnectionMutex   sync.Mutex
+	rtspConnectionMutex   sync.Mutex
+	goroutines            sync.WaitGroup // the accept loops, the connections (and the refused ones) and the liveness checks
+	closing               chan struct{}
+	closeOnce             sync.Once
+	requestCount          atomic.Uint64 // (the IDs of the requests in the access log)
+}
+
+// New returns a new RTSP server configured by the options, e.g.
+//
+//	server := rtspserver.New(rtspserver.WithAuthenticator(authdb), rtspserver.WithMediaRoot("/var/media"))
+//
+// Without any option, there is no access control.
+func New(opts ...Option) *RTSPServer {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	return &RTSPServer{
-		authDatabase:           authDatabase,
-		reclamationTestSeconds: 65,
-		clientSessions:         make(map[string]*RTSPClientSession),
-		clientHTTPConnections:  make(map[string]*RTSPClientConnection),
-		serverMediaSessions:    make(map[string]*livemedia.ServerMediaSession),
+	s := &RTSPServer{
+		digestVerifier:        auth.NewDigestVerifier(auth.DefaultNonceTTL),
+		options:               defaultServerOptions(),
+		clientSessions:        make(map[string]*RTSPClientSession),
+		clientHTTPConnections: make(map[string]*RTSPClientConnection),
+		rtspConnections:       make(map[*RTSPClientConnection]bool),
+		refusedConnections:    make(map[net.Conn]bool),
+		closing:               make(chan struct{}),
+		serverMediaSessions:   make(map[string]*livemedia.ServerMediaSession),
+		registeredStreams:     make(map[string]string),
 	}
+	s.metrics = newServerMetrics(s)
+	s.ApplyOptions(opts...)
+	return s
 }
 
+// Destroy shuts the server down without waiting for its goroutines, see Shutdown
 func (s *RTSPServer) Destroy() {
-	s.rtspListen.Close()
-	s.httpListen.Close()
+	s.close()
+}
+
+// Shutdown stops accepting connections, tears down every client session (sending a RTCP BYE
+// for each stream), closes the connections, and waits until all the goroutines of the server
+// (and of its streams) have returned, and its event handler has posted the events it queued (see Webhook),
+// or until ctx is done, in which case it returns ctx.Err().
+// The server can't be started again.
+func (s *RTSPServer) Shutdown(ctx context.Context) error {
+	streamStates := s.close()
+
+	done := make(chan struct{})
+	go func() {
+		s.goroutines.Wait()
+		for _, streamState := range streamStates {
+			streamState.Wait()
+		}
+		if drainer, ok := s.currentOptions().eventHandler.(eventDrainer); ok {
+			drainer.drain()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		lg.Info("the server has shut down.")
+		return nil
+	case <-ctx.Done():
+		lg.Warn("the server didn't shut down in time: %v", ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// Serve starts accepting connections, (after Listen) and blocks until ctx is done,
+// then shuts the server down, waiting at most the shutdown timeout (see WithShutdownTimeout).
+// It returns nil, or the error of Shutdown.
+func (s *RTSPServer) Serve(ctx context.Context) error {
+	if s.rtspListen == nil {
+		return fmt.Errorf("the server isn't listening, call Listen first")
+	}
+	s.Start()
+
+	select {
+	case <-ctx.Done():
+	case <-s.closing:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.currentOptions().shutdownTimeout)
+	defer cancel()
+	return s.Shutdown(shutdownCtx)
+}
+
+// close closes the listeners, the client sessions and the connections, once;
+// it returns the streams of the sessions, to wait for.
+func (s *RTSPServer) close() (streamStates []*livemedia.StreamState) {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+
+		for _, l := range []*net.TCPListener{s.rtspListen, s.httpListen, s.tlsListen} {
+			if l != nil {
+				l.Close()
+			}
+		}
+		for _, server := range []*http.Server{s.pprofServer, s.metricsServer, s.adminServer} {
+			if server != nil {
+				server.Close()
+			}
+		}
+
+		for _, clientSession := range s.clientSessionList() {
+			if streamState := clientSession.streamState(); streamState != nil {
+				streamStates = append(streamStates, streamState)
+			}
+			clientSession.destroy("shutdown")
+		}
+
+		s.rtspConnectionMutex.Lock()
+		for c := range s.rtspConnections {
+			c.destroy()
+		}
+		for conn := range s.refusedConnections {
+			conn.Close()
+		}
+		s.rtspConnectionMutex.Unlock()
+
+		// e.g. stop watching the password file of an *auth.HtpasswdFile
+		if closer, ok := s.currentOptions().authenticator.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	})
+	return
+}
+
+func (s *RTSPServer) isClosing() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
 }
 
 func (s *RTSPServer) Listen(portNum int) error {
@@ -59,25 +198,59 @@ func (s *RTSPServer) Listen(portNum int) error {
 	s.rtspListen, err = s.setupOurSocket(portNum)
 	if err == nil {
 		s.startMonitor()
+		s.startMetrics()
+		s.startAdmin()
 	}
 
 	return err
 }
 
 func (s *RTSPServer) Start() {
-	go s.incomingConnectionHandler(s.rtspListen)
+	s.serveListener(s.rtspListen, nil)
+}
+
+// serveListener runs the accept loop of "l", tracked for Shutdown
+func (s *RTSPServer) serveListener(l *net.TCPListener, tlsConfig *tls.Config) {
+	s.goroutines.Add(1)
+	go func() {
+		defer s.goroutines.Done()
+		s.incomingConnectionHandler(l, tlsConfig)
+	}()
 }
 
 func (s *RTSPServer) startMonitor() {
-	go s.monitorServe()
+	pprofAddr := s.currentOptions().pprofAddr
+	if pprofAddr == "" {
+		return
+	}
+
+	// (not on http.DefaultServeMux, which anything in the process may add to)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.pprofServer = s.startHTTPServer(pprofAddr, mux, "pprof")
 }
 
-func (s *RTSPServer) monitorServe() {
-	log.Println(http.ListenAndServe("0.0.0.0:6060", nil))
+// startHTTPServer serves handler on addr, until the server is closed
+func (s *RTSPServer) startHTTPServer(addr string, handler http.Handler, what string) *http.Server {
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	s.goroutines.Add(1)
+	go func() {
+		defer s.goroutines.Done()
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			lg.Error(0, "failed to serve %s on %s: %v", what, addr, err)
+		}
+	}()
+	return server
 }
 
 func (s *RTSPServer) setupOurSocket(portNum int) (*net.TCPListener, error) {
-	tcpAddr := fmt.Sprintf("0.0.0.0:%d", portNum)
+	// (with no address, we listen on all of our IPv4 and IPv6 addresses)
+	tcpAddr := fmt.Sprintf(":%d", portNum)
 	addr, _ := net.ResolveTCPAddr("tcp", tcpAddr)
 
 	return net.ListenTCP("tcp", addr)
@@ -92,7 +265,7 @@ func (s *RTSPServer) SetupTunnelingOverHTTP(httpPort int) bool {
 		return false
 	}
 
-	go s.incomingConnectionHandler(s.httpListen)
+	s.serveListener(s.httpListen, nil)
 	return true
 }
 
@@ -100,6 +273,52 @@ func (s *RTSPServer) HTTPServerPortNum() int {
 	return s.httpPort
 }
 
+// SetupTLS makes the server also accept RTSPS ("rtsps://") connections, which
+// are RTSP over TLS (using config, which must have a certificate), on a port
+// of their own. Clients on these connections get their media either
+// interleaved in the TLS connection, or as SRTP over UDP.
+func (s *RTSPServer) SetupTLS(tlsPort int, config *tls.Config) bool {
+	if config == nil || (len(config.Certificates) == 0 && config.GetCertificate == nil) {
+		lg.Error(0, "RTSPS needs a TLS configuration with a certificate.")
+		return false
+	}
+
+	s.tlsPort = tlsPort
+	s.tlsConfig = config
+
+	var err error
+	s.tlsListen, err = s.setupOurSocket(tlsPort)
+	if err != nil {
+		return false
+	}
+
+	s.serveListener(s.tlsListen, s.tlsConfig)
+	return true
+}
+
+// AllowBasicAuth makes the server also accept "Authorization: Basic" credentials,
+// for the players which don't support Digest. The password is sent in the clear,
+// so it's best used on RTSPS connections only.
+func (s *RTSPServer) AllowBasicAuth(allow bool) {
+	s.ApplyOptions(WithBasicAuth(allow))
+}
+
+// SetTokenSigner makes the server accept the URLs signed by signer (with "?token=...&expires=...")
+// to read the streams, without any other credentials.
+func (s *RTSPServer) SetTokenSigner(signer *auth.TokenSigner) {
+	s.ApplyOptions(WithTokenSigner(signer))
+}
+
+// SetACL restricts the addresses of the clients, on every listener (RTSP, RTSPS and HTTP tunneling),
+// and per stream. A client whose address is denied gets a "403 Forbidden".
+func (s *RTSPServer) SetACL(acl *auth.ACL) {
+	s.ApplyOptions(WithACL(acl))
+}
+
+func (s *RTSPServer) TLSServerPortNum() int {
+	return s.tlsPort
+}
+
 func (s *RTSPServer) RtspURL(streamName string) string {
 	urlPrefix := s.RtspURLPrefix()
 	return fmt.Sprintf("%s%s", urlPrefix, streamName)
@@ -107,29 +326,104 @@ func (s *RTSPServer) RtspURL(streamName string) string {
 
 func (s *RTSPServer) RtspURLPrefix() string {
 	s.urlPrefix, _ = gs.OurIPAddress()
-	return fmt.Sprintf("rtsp://%s:%d/", s.urlPrefix, s.rtspPort)
+	return s.rtspURLPrefix(s.urlPrefix, false)
 }
 
-func (s *RTSPServer) incomingConnectionHandler(l *net.TCPListener) {
+// RtspsURLPrefix returns our "rtsps://" URL prefix, once SetupTLS has succeeded.
+func (s *RTSPServer) RtspsURLPrefix() string {
+	s.urlPrefix, _ = gs.OurIPAddress()
+	return s.rtspURLPrefix(s.urlPrefix, true)
+}
+
+// rtspURL returns the URL of a stream as a client that reached us at localAddr
+// (IPv4 or IPv6), over TLS or not, should see it.
+func (s *RTSPServer) rtspURL(localAddr, streamName string, isSecure bool) string {
+	return fmt.Sprintf("%s%s", s.rtspURLPrefix(localAddr, isSecure), streamName)
+}
+
+func (s *RTSPServer) rtspURLPrefix(host string, isSecure bool) string {
+	rtspURL := &livemedia.RTSPURL{
+		Scheme:  "rtsp",
+		Host:    host,
+		Port:    s.rtspPort,
+		RawPath: "/",
+	}
+	if isSecure {
+		rtspURL.Scheme, rtspURL.Port = "rtsps", s.tlsPort
+	}
+	return rtspURL.String()
+}
+
+// incomingConnectionHandler accepts connections on "l"; with a TLS configuration, they're RTSPS.
+// It returns once "l" is closed.
+func (s *RTSPServer) incomingConnectionHandler(l *net.TCPListener, tlsConfig *tls.Config) {
 	for {
 		tcpConn, err := l.AcceptTCP()
 		if err != nil {
+			if s.isClosing() || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			lg.Error(0, "failed to accept client.%s", err.Error())
+			// (e.g. too many open files: don't spin)
+			time.Sleep(acceptRetryDelay)
+			continue
+		}
+
+		// (before any TLS handshake, which a refused client isn't worth)
+		if !s.connectionAccessCheck(tcpConn) {
+			s.refuseConnection(tcpConn, tlsConfig != nil)
 			continue
 		}
 
+		var conn net.Conn = tcpConn
+		if tlsConfig != nil {
+			// (the handshake happens on the connection's first read)
+			conn = tls.Server(tcpConn, tlsConfig)
+		}
+
 		tcpConn.SetReadBuffer(50 * 1024)
 
 		// Create a new object for handling server RTSP connection:
-		go s.newClientConnection(tcpConn)
+		s.goroutines.Add(1)
+		go s.newClientConnection(conn)
 	}
 }
 
 func (s *RTSPServer) newClientConnection(conn net.Conn) {
+	defer s.goroutines.Done()
+
 	c := newRTSPClientConnection(s, conn)
-	if c != nil {
-		c.incomingRequestHandler()
+	if c == nil {
+		return
+	}
+	if !s.addClientConnection(c) {
+		c.destroy()
+		return
 	}
+	defer s.removeClientConnection(c)
+
+	s.notifyEvent(c.newEvent(EventConnectionOpen))
+	c.incomingRequestHandler()
+	s.notifyEvent(c.newEvent(EventConnectionClose))
+}
+
+// addClientConnection tracks a connection, to be closed by Shutdown; it fails once the server is closing
+func (s *RTSPServer) addClientConnection(c *RTSPClientConnection) bool {
+	s.rtspConnectionMutex.Lock()
+	defer s.rtspConnectionMutex.Unlock()
+	if s.isClosing() {
+		return false
+	}
+	s.rtspConnections[c] = true
+	s.metrics.noteConnection(c, 1)
+	return true
+}
+
+func (s *RTSPServer) removeClientConnection(c *RTSPClientConnection) {
+	s.rtspConnectionMutex.Lock()
+	defer s.rtspConnectionMutex.Unlock()
+	delete(s.rtspConnections, c)
+	s.metrics.noteConnection(c, -1)
 }
 
 func (s *RTSPServer) getServerMediaSession(streamName string) (sms *livemedia.ServerMediaSession, existed bool) {
@@ -143,7 +437,8 @@ func (s *RTSPServer) lookupServerMediaSession(streamName string) *livemedia.Serv
 	// Next, check whether we already have a "ServerMediaSession" for server file:
 	sms, existed := s.getServerMediaSession(streamName)
 
-	fid, err := os.Open(streamName)
+	fileName := s.streamFileName(streamName)
+	fid, err := os.Open(fileName)
 	if err != nil {
 		if existed {
 			s.removeServerMediaSession(streamName)
@@ -153,7 +448,10 @@ func (s *RTSPServer) lookupServerMediaSession(streamName string) *livemedia.Serv
 	defer fid.Close()
 
 	if !existed {
-		sms = s.createNewSMS(streamName)
+		sms = s.createNewSMS(streamName, fileName)
+		if sms == nil {
+			return nil
+		}
 		s.addServerMediaSession(sms)
 	}
 
@@ -165,6 +463,8 @@ func (s *RTSPServer) addServerMediaSession(sms *livemedia.ServerMediaSession) {
 
 	s.smsMutex.Lock()
 	defer s.smsMutex.Unlock()
+	// (under the lock, so that ApplyOptions can't miss it)
+	sms.SetStreamSettings(s.currentOptions().streamSettings())
 	s.serverMediaSessions[sessionName] = sms
 }
 
@@ -193,29 +493,138 @@ func (s *RTSPServer) removeClientSession(sessionID string) {
 	delete(s.clientSessions, sessionID)
 }
 
-func (s *RTSPServer) createNewSMS(fileName string) (sms *livemedia.ServerMediaSession) {
+// clientSessionList returns a copy of the client sessions
+func (s *RTSPServer) clientSessionList() []*RTSPClientSession {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+
+	clientSessions := make([]*RTSPClientSession, 0, len(s.clientSessions))
+	for _, clientSession := range s.clientSessions {
+		clientSessions = append(clientSessions, clientSession)
+	}
+	return clientSessions
+}
+
+func (s *RTSPServer) createNewSMS(streamName, fileName string) (sms *livemedia.ServerMediaSession) {
 	array := strings.Split(fileName, ".")
 	if len(array) < 2 {
 		return
 	}
 
-	extension := array[1]
+	extension := array[len(array)-1]
 	switch extension {
 	case "264":
 		// Assumed to be a H.264 Video Elementary Stream file:
-		sms = livemedia.NewServerMediaSession("H.264 Video", fileName)
-		// allow for some possibly large H.264 frames
-		livemedia.OutPacketBufferMaxSize = 2000000
+		sms = livemedia.NewServerMediaSession("H.264 Video", streamName)
 		sms.AddSubsession(livemedia.NewH264FileMediaSubsession(fileName))
 	case "ts":
 		//indexFileName := fmt.Sprintf("%sx", fileName)
-		sms = livemedia.NewServerMediaSession("MPEG Transport Stream", fileName)
+		sms = livemedia.NewServerMediaSession("MPEG Transport Stream", streamName)
 		sms.AddSubsession(livemedia.NewM2TSFileMediaSubsession(fileName))
 	default:
 	}
 	return
 }
 
+// streamFileName returns the file of the stream, registered or in our media root
+func (s *RTSPServer) streamFileName(streamName string) string {
+	s.smsMutex.Lock()
+	fileName, registered := s.registeredStreams[streamName]
+	s.smsMutex.Unlock()
+	if registered {
+		return fileName
+	}
+	return s.mediaFileName(streamName)
+}
+
+// mediaFileName returns the file of the stream, in our media root;
+// (the stream name can't escape from it with "..")
+func (s *RTSPServer) mediaFileName(streamName string) string {
+	return filepath.Join(s.currentOptions().mediaRoot, filepath.Clean("/"+streamName))
+}
+
+// connectionAccessCheck checks the address of a new connection against our ACL, if any.
+func (s *RTSPServer) connectionAccessCheck(conn net.Conn) bool {
+	acl := s.currentOptions().acl
+	if acl == nil {
+		return true
+	}
+
+	clientAddr, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if !acl.CheckAddress(net.ParseIP(clientAddr)) {
+		lg.Warn("refused the connection from %s (by the ACL)", clientAddr)
+		return false
+	}
+	return true
+}
+
+// refuseConnection closes a connection that our ACL denies; a RTSP (or RTSP-over-HTTP) one is
+// first answered, unless we're answering too many others already, (see answerRefusedConnection)
+// but not a RTSPS one, which would need a TLS handshake.
+func (s *RTSPServer) refuseConnection(conn net.Conn, isSecure bool) {
+	s.rtspConnectionMutex.Lock()
+	if isSecure || s.isClosing() || len(s.refusedConnections) >= maxRefusedConnections {
+		s.rtspConnectionMutex.Unlock()
+		conn.Close()
+		return
+	}
+	s.refusedConnections[conn] = true
+	s.rtspConnectionMutex.Unlock()
+
+	s.goroutines.Add(1)
+	go func() {
+		defer s.goroutines.Done()
+		answerRefusedConnection(conn)
+
+		s.rtspConnectionMutex.Lock()
+		delete(s.refusedConnections, conn)
+		s.rtspConnectionMutex.Unlock()
+	}()
+}
+
+// answerRefusedConnection answers the first request of a connection that our ACL denies with
+// "403 Forbidden", (in HTTP for a RTSP-over-HTTP tunnel) and closes it.
+func answerRefusedConnection(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(refusedRequestTimeout))
+
+	var reqStr string
+	buffer := make([]byte, rtspBufferSize)
+	for !strings.Contains(reqStr, "\r\n\r\n") && len(reqStr) < rtspBufferSize {
+		length, err := conn.Read(buffer)
+		if err != nil {
+			return
+		}
+		reqStr += string(buffer[:length])
+	}
+
+	requestLine := reqStr
+	if n := strings.IndexAny(requestLine, "\r\n"); n != -1 {
+		requestLine = requestLine[:n]
+	}
+	if strings.HasSuffix(requestLine, " HTTP/1.0") || strings.HasSuffix(requestLine, " HTTP/1.1") {
+		fmt.Fprintf(conn, "HTTP/1.1 403 Forbidden\r\n%sConnection: close\r\n\r\n", livemedia.DateHeader())
+		return
+	}
+
+	var cseq string
+	if requestString, ok := livemedia.ParseRTSPRequestString(reqStr, len(reqStr)); ok {
+		cseq = requestString.Cseq
+	}
+	fmt.Fprintf(conn, "RTSP/1.0 403 Forbidden\r\nCSeq: %s\r\n%s\r\n", cseq, livemedia.DateHeader())
+}
+
+// specialClientAccessCheck checks the address of the client against our ACL, for the stream.
 func (s *RTSPServer) specialClientAccessCheck(clientSocket net.Conn, clientAddr, urlSuffix string) bool {
+	acl := s.currentOptions().acl
+	if acl == nil {
+		return true
+	}
+
+	if !acl.Check(net.ParseIP(clientAddr), urlSuffix) {
+		lg.Warn("refused %s access to \"%s\" (by the ACL)", clientAddr, urlSuffix)
+		return false
+	}
+	lg.Trace("allowed %s access to \"%s\" (by the ACL)", clientAddr, urlSuffix)
 	return true
 }
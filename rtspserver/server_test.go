@@ -0,0 +1,430 @@
+package rtspserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djwackey/dorsvr/auth"
+	"github.com/djwackey/dorsvr/livemedia"
+	"github.com/djwackey/dorsvr/logging"
+)
+
+func TestShutdown(t *testing.T) {
+	// (it must not panic without any listener)
+	New().Destroy()
+
+	server := New()
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+
+	conn, err := net.Dial("tcp", server.rtspListen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("OPTIONS rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = server.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+
+	// the connection was closed by the server
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 1024)
+	for err == nil {
+		_, err = conn.Read(buffer)
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestServe(t *testing.T) {
+	server := New(WithShutdownTimeout(5 * time.Second))
+	if err := server.Serve(context.Background()); err == nil {
+		t.Error("failed")
+		return
+	}
+
+	server = New(WithShutdownTimeout(5 * time.Second))
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			fmt.Println(err)
+			t.Error("failed")
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestPprof(t *testing.T) {
+	server := New()
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	if server.pprofServer != nil {
+		t.Error("failed")
+		return
+	}
+	server.Destroy()
+
+	// (a free port)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server = New(WithPprofAddr(addr))
+	if err = server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	var response *http.Response
+	for i := 0; i < 50; i++ {
+		if response, err = http.Get("http://" + addr + "/debug/pprof/"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil || response.StatusCode != http.StatusOK {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+	response.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = server.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+	if _, err = http.Get("http://" + addr + "/debug/pprof/"); err == nil {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestStreamSettings(t *testing.T) {
+	fec := livemedia.FEC{Scheme: livemedia.FECSchemeULPFEC, Columns: 10}
+	server := New(WithRTPPortRange(20000, 20100), WithFEC(fec))
+	other := New()
+
+	sms := livemedia.NewServerMediaSession("H.264 Video", "test.264")
+	server.addServerMediaSession(sms)
+	otherSMS := livemedia.NewServerMediaSession("H.264 Video", "test.264")
+	other.addServerMediaSession(otherSMS)
+
+	settings := sms.StreamSettings()
+	if settings.RTPPortMin != 20000 || settings.RTPPortMax != 20100 || settings.FEC != fec ||
+		otherSMS.StreamSettings() != livemedia.DefaultStreamSettings() {
+		fmt.Printf("%+v %+v\n", settings, otherSMS.StreamSettings())
+		t.Error("failed")
+		return
+	}
+
+	// the sessions of a server take its new settings, (not those of another server)
+	server.ApplyOptions(WithSymmetricRTP(10 * time.Second))
+	if sms.StreamSettings().SymmetricRTPWindow != 10*time.Second || otherSMS.StreamSettings().SymmetricRTPWindow != 0 {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestMetrics(t *testing.T) {
+	server := New()
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", server.rtspListen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("OPTIONS rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n"))
+	conn.Read(make([]byte, 1024))
+
+	recorder := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	if !strings.Contains(body, `dorsvr_rtsp_requests_total{method="OPTIONS",status="200"} 1`) ||
+		!strings.Contains(body, `dorsvr_connections{protocol="rtsp"} 1`) {
+		fmt.Println(body)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestAccessLog(t *testing.T) {
+	var buffer bytes.Buffer
+	logging.SetOutput(&buffer, "json")
+	defer logging.SetOutput(os.Stderr, "logfmt")
+
+	server := New()
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+
+	conn, err := net.Dial("tcp", server.rtspListen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("DESCRIBE rtsp://127.0.0.1/unknown.264?token=secret RTSP/1.0\r\nCSeq: 7\r\n\r\n"))
+	conn.Read(make([]byte, 1024))
+	conn.Close()
+	// (the connection has ended once the server is shut down)
+	server.Shutdown(context.Background())
+
+	var record map[string]interface{}
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		if strings.Contains(line, `"msg":"request"`) {
+			json.Unmarshal([]byte(line), &record)
+		}
+	}
+	fmt.Println(record)
+	if record["subsystem"] != "rtsp" || record["method"] != "DESCRIBE" || record["cseq"] != "7" ||
+		record["status"] != 404.0 || record["url"] != "rtsp://127.0.0.1/unknown.264" {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestACLRefusesConnection(t *testing.T) {
+	acl := auth.NewACL()
+	acl.SetDefault(false)
+	server := New(WithACL(acl))
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Destroy()
+
+	conn, err := net.Dial("tcp", server.rtspListen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("OPTIONS rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 7\r\n\r\n"))
+
+	// the request is answered, (rather than the connection just closed) and then the connection is closed
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var response []byte
+	buffer := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buffer)
+		response = append(response, buffer[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if !strings.HasPrefix(string(response), "RTSP/1.0 403 Forbidden\r\nCSeq: 7\r\n") {
+		fmt.Printf("%q\n", response)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestACLRefusesManyConnections(t *testing.T) {
+	acl := auth.NewACL()
+	acl.SetDefault(false)
+	server := New(WithACL(acl))
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+
+	// the refused connections beyond those we answer are closed right away
+	var conns []net.Conn
+	for i := 0; i <= maxRefusedConnections; i++ {
+		conn, err := net.Dial("tcp", server.rtspListen.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	last := conns[maxRefusedConnections]
+	last.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := last.Read(make([]byte, 1)); err != io.EOF {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+
+	// and the others don't hold up the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+// testClient sends RTSP requests on a connection, one at a time, and reads their responses
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	cseq   int
+}
+
+func dialTestClient(t *testing.T, server *RTSPServer) *testClient {
+	conn, err := net.Dial("tcp", server.rtspListen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &testClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// request returns the status code of the response, its headers and its body
+func (c *testClient) request(method, url, headers string) (int, string, string, error) {
+	c.cseq++
+	if _, err := fmt.Fprintf(c.conn, "%s %s RTSP/1.0\r\nCSeq: %d\r\n%s\r\n", method, url, c.cseq, headers); err != nil {
+		return 0, "", "", err
+	}
+
+	var response string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return 0, "", "", err
+		}
+		if line == "\r\n" {
+			break
+		}
+		response += line
+	}
+
+	var body []byte
+	if contentLength, _ := strconv.Atoi(testHeaderValue(response, "Content-Length")); contentLength > 0 {
+		body = make([]byte, contentLength)
+		if _, err := io.ReadFull(c.reader, body); err != nil {
+			return 0, "", "", err
+		}
+	}
+
+	var status int
+	fmt.Sscanf(response, "RTSP/1.0 %d", &status)
+	return status, response, string(body), nil
+}
+
+func testHeaderValue(response, name string) string {
+	for _, line := range strings.Split(response, "\r\n") {
+		if strings.HasPrefix(strings.ToLower(line), strings.ToLower(name)+":") {
+			return strings.TrimSpace(line[len(name)+1:])
+		}
+	}
+	return ""
+}
+
+func TestDigestInterop(t *testing.T) {
+	db := auth.NewAuthDatabase("dorsvr")
+	db.InsertUserRecord("alice", "secret")
+	server := New(WithAuthenticator(db), WithMediaRoot("../examples"))
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Destroy()
+
+	client := dialTestClient(t, server)
+	defer client.conn.Close()
+	url := "rtsp://" + server.rtspListen.Addr().String() + "/test.264"
+
+	status, response, _, err := client.request("DESCRIBE", url, "")
+	challenge := auth.ParseChallenge(testHeaderValue(response, "WWW-Authenticate"))
+	if err != nil || status != 401 || challenge == nil {
+		fmt.Println(status, response, err)
+		t.Error("failed")
+		return
+	}
+
+	// like live555's clients, VLC or ffmpeg: a digest without qop, with the same nonce, and the URL of
+	// the session, for all of the requests of the session
+	d := auth.NewDigest()
+	d.Username, d.Password, d.Realm, d.Nonce, d.Algorithm = "alice", "secret", challenge.Realm, challenge.Nonce, challenge.Algorithm
+	authorization := func(method string) string {
+		return "Authorization: " + d.Credentials(method, url) + "\r\n"
+	}
+
+	status, response, _, err = client.request("DESCRIBE", url, authorization("DESCRIBE"))
+	if err != nil || status != 200 {
+		fmt.Println(status, response, err)
+		t.Error("failed")
+		return
+	}
+	status, response, _, err = client.request("SETUP", url+"/track1",
+		authorization("SETUP")+"Transport: RTP/AVP;unicast;client_port=50000-50001\r\n")
+	session := strings.Split(testHeaderValue(response, "Session"), ";")[0]
+	if err != nil || status != 200 || session == "" {
+		fmt.Println(status, response, err)
+		t.Error("failed")
+		return
+	}
+	status, response, _, err = client.request("PLAY", url, authorization("PLAY")+"Session: "+session+"\r\n")
+	if err != nil || status != 200 {
+		fmt.Println(status, response, err)
+		t.Error("failed")
+		return
+	}
+
+	// but the nonce isn't good on another connection
+	other := dialTestClient(t, server)
+	defer other.conn.Close()
+	status, response, _, err = other.request("DESCRIBE", url, authorization("DESCRIBE"))
+	if err != nil || status != 401 || !strings.Contains(response, "stale=true") {
+		fmt.Println(status, response, err)
+		t.Error("failed")
+		return
+	}
+	client.request("TEARDOWN", url, "Session: "+session+"\r\n")
+	t.Log("success")
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/djwackey/dorsvr/auth"
 	"github.com/djwackey/dorsvr/livemedia"
 )
 
@@ -16,16 +18,33 @@ type RTSPClientSession struct {
 	numStreamStates      int
 	TCPStreamIDCount     uint
 	sessionID            string
+	transport            string // the "Transport:" of our SETUP response
+	startTime            time.Time
 	streamStates         *StreamServerState
 	connection           *RTSPClientConnection
 	serverMediaSession   *livemedia.ServerMediaSession
 	livenessTimeoutTimer *time.Timer
+	stopped              chan struct{}
+	destroyOnce          sync.Once
+	mutex                sync.Mutex // guards the liveness timer, and what SETUP sets, see state
+}
+
+// sessionState is a copy of what the SETUP of a session sets, for the other goroutines that read it:
+// the admin API, the metrics, the events, and the destroy by a kick, a liveness timeout or the shutdown
+type sessionState struct {
+	key                streamTrack
+	subsession         livemedia.IServerMediaSubsession
+	streamState        *livemedia.StreamState
+	serverMediaSession *livemedia.ServerMediaSession
+	transport          string
 }
 
 func newRTSPClientSession(connection *RTSPClientConnection, sessionID string) *RTSPClientSession {
 	s := &RTSPClientSession{
 		sessionID:  sessionID,
 		connection: connection,
+		startTime:  time.Now(),
+		stopped:    make(chan struct{}),
 	}
 	s.noteLiveness()
 	return s
@@ -35,16 +54,74 @@ func (s *RTSPClientSession) server() *RTSPServer {
 	return s.connection.server
 }
 
-func (s *RTSPClientSession) destroy() {
-	// turn off any liveness check:
-	s.livenessTimeoutTimer.Stop()
+// destroy deletes the stream of the session (which sends a RTCP BYE), once,
+// whether by a TEARDOWN, the end of the connection, a liveness timeout or the shutdown of the server,
+// which is the reason in the summary of the session
+func (s *RTSPClientSession) destroy(reason string) {
+	s.destroyOnce.Do(func() {
+		// turn off any liveness check:
+		close(s.stopped)
+		state := s.state()
+		s.logSummary(state, reason)
+
+		if state.streamState != nil {
+			state.subsession.DeleteStream(s.sessionID, state.streamState)
+			s.server().metrics.noteSessionEnded(state.key, state.streamState)
+		}
+
+		s.server().removeClientSession(s.sessionID)
 
-	s.server().removeClientSession(s.sessionID)
+		if state.serverMediaSession != nil {
+			streamName := state.serverMediaSession.StreamName()
+			s.server().removeServerMediaSession(streamName)
+		}
+	})
+}
 
+// state copies what the SETUP of the session set, under its mutex
+func (s *RTSPClientSession) state() (state sessionState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state.serverMediaSession, state.transport = s.serverMediaSession, s.transport
 	if s.serverMediaSession != nil {
-		streamName := s.serverMediaSession.StreamName()
-		s.server().removeServerMediaSession(streamName)
+		state.key.streamName = s.serverMediaSession.StreamName()
+	}
+	if s.streamStates != nil && s.streamStates.subsession != nil {
+		state.subsession, state.streamState = s.streamStates.subsession, s.streamStates.streamToken
+		if state.streamState != nil {
+			state.key.trackID = state.subsession.TrackID()
+		}
+	}
+	return
+}
+
+// streamState returns the stream of the session, once it's SETUP
+func (s *RTSPClientSession) streamState() *livemedia.StreamState {
+	return s.state().streamState
+}
+
+// streamTrack returns the stream name and the track of the session, with its stream, once it's SETUP
+func (s *RTSPClientSession) streamTrack() (key streamTrack, streamState *livemedia.StreamState) {
+	state := s.state()
+	return state.key, state.streamState
+}
+
+// receiverReport returns the last RTCP receiver report of the client, from its address,
+// (or the last report of its stream of an unknown address, e.g. interleaved in a RTSP connection)
+func (s *RTSPClientSession) receiverReport(streamState *livemedia.StreamState) (report livemedia.ReceiverReport, ok bool) {
+	for _, r := range streamState.ReceiverReports() {
+		if r.FromAddress == "" {
+			if !ok || r.ReceivedAt.After(report.ReceivedAt) {
+				report, ok = r, true
+			}
+			continue
+		}
+		if host, _, _ := net.SplitHostPort(r.FromAddress); host == s.connection.remoteAddr {
+			return r, true
+		}
 	}
+	return
 }
 
 func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix, reqStr string) {
@@ -61,7 +138,9 @@ func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix, reqStr s
 	}
 
 	if s.serverMediaSession == nil {
+		s.mutex.Lock()
 		s.serverMediaSession = sms
+		s.mutex.Unlock()
 	} else if sms != s.serverMediaSession {
 		s.connection.handleCommandBad()
 		return
@@ -70,10 +149,13 @@ func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix, reqStr s
 	if s.streamStates == nil {
 		s.numStreamStates = s.serverMediaSession.SubsessionCounter
 
-		s.streamStates = new(StreamServerState)
+		streamStates := new(StreamServerState)
 		for i := 0; i < s.numStreamStates; i++ {
-			s.streamStates.subsession = s.serverMediaSession.Subsessions[i]
+			streamStates.subsession = s.serverMediaSession.Subsessions[i]
 		}
+		s.mutex.Lock()
+		s.streamStates = streamStates
+		s.mutex.Unlock()
 	}
 
 	// Look up information for the specified subsession (track):
@@ -101,6 +183,22 @@ func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix, reqStr s
 	clientRTPPort := transportHeader.ClientRTPPortNum
 	clientRTCPPort := transportHeader.ClientRTCPPortNum
 	streamingModeStr := transportHeader.StreamingModeStr
+	// (RTCP on the RTP port only makes a difference to unicast UDP)
+	rtcpMux := transportHeader.RTCPMux && streamingMode == livemedia.RTP_UDP && !s.isMulticast
+
+	// SRTP keys are only given (in the SDP) to clients on a RTSPS connection, and
+	// those clients mustn't get their media in the clear, over UDP:
+	profile := "RTP/AVP"
+	if transportHeader.IsSecure {
+		if !s.connection.isSecure || s.isMulticast {
+			s.connection.handleCommandUnsupportedTransport()
+			return
+		}
+		profile = "RTP/SAVP"
+	} else if s.connection.isSecure && streamingMode != livemedia.RTP_TCP {
+		s.connection.handleCommandUnsupportedTransport()
+		return
+	}
 
 	if streamingMode == livemedia.RTP_TCP && rtpChannelID == 0xFF {
 		rtpChannelID = s.TCPStreamIDCount
@@ -133,11 +231,19 @@ func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix, reqStr s
 		clientRTPPort,
 		clientRTCPPort,
 		rtpChannelID,
-		rtcpChannelID)
+		rtcpChannelID,
+		transportHeader.IsSecure,
+		rtcpMux)
+	if streamParameter == nil {
+		s.connection.setRTSPResponse("500 Internal Server Error")
+		return
+	}
 	serverRTPPort := streamParameter.ServerRTPPort
 	serverRTCPPort := streamParameter.ServerRTCPPort
 
+	s.mutex.Lock()
 	s.streamStates.streamToken = streamParameter.StreamToken
+	s.mutex.Unlock()
 
 	if s.isMulticast {
 		switch streamingMode {
@@ -161,13 +267,13 @@ func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix, reqStr s
 			s.connection.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
 				"CSeq: %s\r\n"+
 				"%s"+
-				"Transport: %s;multicast;destination=%s;source=%d;port=%d;ttl=%d\r\n"+
+				"Transport: %s;multicast;destination=%s;source=%s;port=%d;ttl=%d\r\n"+
 				"Session: %s\r\n\r\n", s.connection.currentCSeq,
 				livemedia.DateHeader(),
+				streamingModeStr,
 				destAddrStr,
 				sourceAddrStr,
 				serverRTPPort,
-				serverRTCPPort,
 				transportHeader.DestinationTTL,
 				s.sessionID)
 		default:
@@ -175,26 +281,33 @@ func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix, reqStr s
 	} else {
 		switch streamingMode {
 		case livemedia.RTP_UDP:
+			var rtcpMuxStr string
+			if rtcpMux {
+				rtcpMuxStr = ";rtcp-mux"
+			}
 			s.connection.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
 				"CSeq: %s\r\n"+
 				"%s"+
-				"Transport: RTP/AVP;unicast;destination=%s;source=%s;client_port=%d-%d;server_port=%d-%d\r\n"+
+				"Transport: %s;unicast;destination=%s;source=%s;client_port=%d-%d;server_port=%d-%d%s\r\n"+
 				"Session: %s\r\n\r\n", s.connection.currentCSeq,
 				livemedia.DateHeader(),
+				profile,
 				destAddrStr,
 				sourceAddrStr,
 				clientRTPPort,
 				clientRTCPPort,
 				serverRTPPort,
 				serverRTCPPort,
+				rtcpMuxStr,
 				s.sessionID)
 		case livemedia.RTP_TCP:
 			s.connection.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
 				"CSeq: %s\r\n"+
 				"%s"+
-				"Transport: RTP/AVP/TCP;unicast;destination=%s;source=%s;interleaved=%d-%d\r\n"+
+				"Transport: %s/TCP;unicast;destination=%s;source=%s;interleaved=%d-%d\r\n"+
 				"Session: %s\r\n\r\n", s.connection.currentCSeq,
 				livemedia.DateHeader(),
+				profile,
 				destAddrStr,
 				sourceAddrStr,
 				rtpChannelID,
@@ -215,6 +328,23 @@ func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix, reqStr s
 				s.sessionID)
 		}
 	}
+	transport := responseHeader(s.connection.responseBuffer, "Transport")
+	s.mutex.Lock()
+	s.transport = transport
+	s.mutex.Unlock()
+	if transport != "" {
+		s.server().notifyEvent(s.newEvent(EventSetup))
+	}
+}
+
+// responseHeader returns the value of a header of a response
+func responseHeader(response, name string) string {
+	for _, line := range strings.Split(response, "\r\n") {
+		if i := strings.Index(line, ":"); i > 0 && strings.EqualFold(line[:i], name) {
+			return strings.TrimSpace(line[i+1:])
+		}
+	}
+	return ""
 }
 
 func (s *RTSPClientSession) handleCommandWithinSession(cmdName, urlPreSuffix, urlSuffix, fullRequestStr string) {
@@ -258,6 +388,16 @@ func (s *RTSPClientSession) handleCommandWithinSession(cmdName, urlPreSuffix, ur
 		return
 	}
 
+	// make sure that we're (still) allowed to play the stream:
+	if cmdName == "PLAY" &&
+		!s.connection.authenticationOK(cmdName, s.serverMediaSession.StreamName(), fullRequestStr, auth.PermissionRead) {
+		return
+	}
+	if cmdName == "PLAY" && s.server().notifyEvent(s.newEvent(EventPlay)) != nil {
+		s.connection.setRTSPResponse("403 Forbidden")
+		return
+	}
+
 	switch cmdName {
 	case "TEARDOWN":
 		s.handleCommandTearDown()
@@ -273,7 +413,7 @@ func (s *RTSPClientSession) handleCommandWithinSession(cmdName, urlPreSuffix, ur
 }
 
 func (s *RTSPClientSession) handleCommandPlay(subsession livemedia.IServerMediaSubsession, fullRequestStr string) {
-	rtspURL := s.server().RtspURL(s.serverMediaSession.StreamName())
+	rtspURL := s.server().rtspURL(s.connection.localAddr, s.serverMediaSession.StreamName(), s.connection.isSecure)
 
 	// Parse the client's "Scale:" header, if any:
 	scale, sawScaleHeader := livemedia.ParseScaleHeader(fullRequestStr)
@@ -410,6 +550,7 @@ func (s *RTSPClientSession) handleCommandPause() {
 	//}
 
 	s.connection.setRTSPResponseWithSessionID("200 OK", s.sessionID)
+	s.server().notifyEvent(s.newEvent(EventPause))
 }
 
 func (s *RTSPClientSession) handleCommandGetParameter() {
@@ -421,34 +562,40 @@ func (s *RTSPClientSession) handleCommandSetParameter() {
 }
 
 func (s *RTSPClientSession) handleCommandTearDown() {
-	s.streamStates.subsession.DeleteStream(s.sessionID, s.streamStates.streamToken)
-
 	//for i := 0; i < s.numStreamStates; i++ {
 	//	s.streamStates[i].subsession.DeleteStream()
 	//}
 
 	s.connection.setRTSPResponse("200 OK")
-	s.destroy()
+	s.server().notifyEvent(s.newEvent(EventTeardown))
+	s.destroy("teardown")
 }
 
 func (s *RTSPClientSession) noteLiveness() {
+	timeout := time.Second * s.server().currentOptions().reclamationTestSeconds
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if !s.isTimerRunning {
-		go s.livenessTimeoutTask(time.Second * s.server().reclamationTestSeconds)
+		// (the timer is created here, so that destroy never finds it missing)
+		s.livenessTimeoutTimer = time.NewTimer(timeout)
 		s.isTimerRunning = true
+		s.server().goroutines.Add(1)
+		go s.livenessTimeoutTask(s.livenessTimeoutTimer)
 	} else {
-		//fmt.Println("noteLiveness", s.livenessTimeoutTimer)
-		s.livenessTimeoutTimer.Reset(time.Second * s.server().reclamationTestSeconds)
+		s.livenessTimeoutTimer.Reset(timeout)
 	}
 }
 
-func (s *RTSPClientSession) livenessTimeoutTask(d time.Duration) {
-	s.livenessTimeoutTimer = time.NewTimer(d)
+func (s *RTSPClientSession) livenessTimeoutTask(timer *time.Timer) {
+	defer s.server().goroutines.Done()
+	defer timer.Stop()
 
-	for {
-		select {
-		case <-s.livenessTimeoutTimer.C:
-			fmt.Println("livenessTimeoutTask")
-			s.destroy()
-		}
+	select {
+	case <-timer.C:
+		s.server().notifyEvent(s.newEvent(EventSessionTimeout))
+		s.destroy("timeout")
+	case <-s.stopped:
 	}
 }
@@ -0,0 +1,134 @@
+package rtspserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/djwackey/gitea/log"
+)
+
+// webhookQueueSize is the number of the events a Webhook queues for the background, it drops the others
+const webhookQueueSize = 1024
+
+// Webhook is an EventHandler which POSTs the events, in JSON, to a HTTP URL.
+// The PLAY is refused unless the webhook answers with a 2xx status,
+// and a stream not found is registered if the webhook answers with its file, e.g. {"file": "cam1.264"}.
+// The other events are posted in the background, one at a time and in their order,
+// their response is ignored; the Shutdown of the server waits until they're posted.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+	// FailOpen allows the PLAY when the webhook can't be reached
+	FailOpen bool
+
+	queueOnce sync.Once
+	queue     chan queuedEvent // of the events posted in the background
+	queued    sync.WaitGroup   // (see drain)
+}
+
+// NewWebhook returns a Webhook posting to url, waiting at most timeout for each response
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{
+		URL:    url,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+// queuedEvent is an event which a Webhook posts in the background
+type queuedEvent struct {
+	eventType EventType
+	body      []byte
+}
+
+// webhookStream is the response of the webhook to a stream not found
+type webhookStream struct {
+	File string `json:"file"`
+}
+
+// HandleEvent posts the event
+func (w *Webhook) HandleEvent(server *RTSPServer, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case event.Type.vetoable():
+		status, _, err := w.post(body)
+		if err != nil {
+			if w.FailOpen {
+				log.Warn("allowed the %s without the webhook: %v", event.Type, err)
+				return nil
+			}
+			return err
+		}
+		if status < 200 || status > 299 {
+			return fmt.Errorf("refused by the webhook (%d)", status)
+		}
+	case event.Type == EventStreamNotFound:
+		status, response, err := w.post(body)
+		if err != nil || status != http.StatusOK {
+			return err
+		}
+		var stream webhookStream
+		if json.Unmarshal(response, &stream) != nil || stream.File == "" {
+			return nil
+		}
+		return server.RegisterStream(event.Stream, stream.File)
+	default:
+		w.enqueue(event.Type, body)
+	}
+	return nil
+}
+
+// enqueue posts the event in the background, after the events queued before it
+func (w *Webhook) enqueue(eventType EventType, body []byte) {
+	w.queueOnce.Do(func() {
+		w.queue = make(chan queuedEvent, webhookQueueSize)
+		go w.postQueue()
+	})
+
+	w.queued.Add(1)
+	select {
+	case w.queue <- queuedEvent{eventType, body}:
+	default:
+		w.queued.Done()
+		log.Warn("dropped the %s event, the webhook is too far behind", eventType)
+	}
+}
+
+func (w *Webhook) postQueue() {
+	for event := range w.queue {
+		if _, _, err := w.post(event.body); err != nil {
+			log.Warn("failed to post the %s event: %v", event.eventType, err)
+		}
+		w.queued.Done()
+	}
+}
+
+// drain waits until the events queued for the background are posted
+func (w *Webhook) drain() {
+	w.queued.Wait()
+}
+
+func (w *Webhook) post(body []byte) (status int, response []byte, err error) {
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	// (the response of a stream not found is small, don't read more)
+	response, err = io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	return resp.StatusCode, response, err
+}